@@ -1,3 +1,6 @@
+-- The base library schema. It is version 1 of the schema and must not be changed.
+-- All further changes are migrations, found in src/library/migrations.go.
+
 create table `albums` (
     `id` integer not null primary key, 
     `name` text,
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// apiTokenSize is the size of the random API tokens in bytes.
+const apiTokenSize = 32
+
+// NewAPIToken generates a random API token. Returns the token, which is given to the
+// client once, and its hash which is stored in the library.
+func NewAPIToken() (string, string, error) {
+	tokenBytes := make([]byte, apiTokenSize)
+
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", fmt.Errorf("generating API token: %s", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken returns the hash under which token is stored in the library. API
+// tokens are long and random so unlike passwords they do not need a slow hash.
+func HashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// AuthenticateToken returns the owner of an API token and the token itself. It
+// returns false when there is no such token or its owner has been removed.
+func (a *Authenticator) AuthenticateToken(token string) (
+	*library.User,
+	*library.APIToken,
+	bool,
+) {
+	if a.library == nil || token == "" {
+		return nil, nil, false
+	}
+
+	found, err := a.library.UseAPIToken(HashAPIToken(token))
+
+	if err != nil {
+		return nil, nil, false
+	}
+
+	if found.UserID == 0 {
+		if a.configUser.User == "" {
+			return nil, nil, false
+		}
+
+		return &library.User{Name: a.configUser.User, Role: library.RoleAdmin},
+			found, true
+	}
+
+	user, err := a.library.GetUser(found.UserName)
+
+	if err != nil || user.ID != found.UserID {
+		return nil, nil, false
+	}
+
+	return user, found, true
+}
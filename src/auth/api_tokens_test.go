@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestAPITokens(t *testing.T) {
+	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer lib.Truncate()
+
+	alice, err := lib.CreateUser("alice", "hash", library.RoleListener)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, hash, err := NewAPIToken()
+
+	if err != nil {
+		t.Fatalf("Generating API token: %s", err)
+	}
+
+	if token == hash || HashAPIToken(token) != hash {
+		t.Fatalf("Unexpected hash %q of token %q", hash, token)
+	}
+
+	if other, _, _ := NewAPIToken(); other == token {
+		t.Fatalf("Expected different API tokens")
+	}
+
+	if _, err := lib.CreateAPIToken(alice.ID, "Phone", hash, true); err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, adminHash, _ := NewAPIToken()
+
+	if _, err := lib.CreateAPIToken(0, "Car", adminHash, false); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := NewAuthenticator(config.Auth{
+		User:     "admin",
+		Password: "adminpass",
+	}, lib)
+
+	user, found, ok := authenticator.AuthenticateToken(token)
+
+	if !ok || user.Name != "alice" || user.IsAdmin() || !found.ReadOnly ||
+		found.Label != "Phone" {
+		t.Errorf("Expected alice's read-only token but got %+v, %+v", user, found)
+	}
+
+	user, _, ok = authenticator.AuthenticateToken(adminToken)
+
+	if !ok || user.Name != "admin" || !user.IsAdmin() {
+		t.Errorf("Expected the configuration user but got %+v", user)
+	}
+
+	for _, wrong := range []string{"", hash, "wrong"} {
+		if _, _, ok := authenticator.AuthenticateToken(wrong); ok {
+			t.Errorf("Expected token %q to be rejected", wrong)
+		}
+	}
+
+	noConfigUser := NewAuthenticator(config.Auth{}, lib)
+
+	if _, _, ok := noConfigUser.AuthenticateToken(adminToken); ok {
+		t.Errorf("Expected the token of a missing configuration user to be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// maxVerifiedPasswords is the number of successful password verifications which
+// are remembered by an Authenticator.
+const maxVerifiedPasswords = 1024
+
+// dummyHash is compared with the passwords of users who do not exist so that
+// checking them takes as much time as checking existing users.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("httpms"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash of password which could be stored in the
+// library.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Authenticator checks the credentials of users. These are the user from the
+// configuration, who is always an admin, and the users stored in the library.
+//
+// Clients with basic authentication send their credentials with every request and
+// bcrypt is slow on purpose. So successful verifications are remembered by the
+// hash of the password and its bcrypt hash. Changing the password of a user
+// changes the bcrypt hash as well so the old password stops working immediately.
+type Authenticator struct {
+	configUser config.Auth
+	library    library.Library
+
+	verified     map[[sha256.Size]byte]struct{}
+	verifiedLock sync.Mutex
+}
+
+// NewAuthenticator returns an authenticator for the configuration user and the
+// users in lib.
+func NewAuthenticator(configUser config.Auth, lib library.Library) *Authenticator {
+	a := new(Authenticator)
+	a.configUser = configUser
+	a.library = lib
+	a.verified = make(map[[sha256.Size]byte]struct{})
+	return a
+}
+
+// Authenticate returns the user with these name and password. It returns false
+// when the credentials are wrong.
+func (a *Authenticator) Authenticate(name, password string) (*library.User, bool) {
+	if a.configUser.User != "" && constantTimeEqual(name, a.configUser.User) {
+		if !constantTimeEqual(password, a.configUser.Password) {
+			return nil, false
+		}
+
+		return &library.User{Name: name, Role: library.RoleAdmin}, true
+	}
+
+	var user *library.User
+	var err error
+
+	if a.library != nil {
+		user, err = a.library.GetUser(name)
+	}
+
+	if user == nil || err != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, false
+	}
+
+	if !a.verify(user.PasswordHash, password) {
+		return nil, false
+	}
+
+	return user, true
+}
+
+// AuthenticateSalted returns the user whose password, followed by salt, has the MD5
+// hash token. This is the token authentication of the Subsonic API. It works only
+// for the user from the configuration since the library keeps just the bcrypt
+// hashes of the passwords of its users.
+func (a *Authenticator) AuthenticateSalted(name, token, salt string) (
+	*library.User,
+	bool,
+) {
+	expected := md5.Sum([]byte(a.configUser.Password + salt))
+
+	correctToken := constantTimeEqual(strings.ToLower(token),
+		hex.EncodeToString(expected[:]))
+	correctName := constantTimeEqual(name, a.configUser.User)
+
+	if a.configUser.User == "" || !correctToken || !correctName {
+		return nil, false
+	}
+
+	return &library.User{Name: name, Role: library.RoleAdmin}, true
+}
+
+// Revalidate returns the current version of a user who has been authenticated
+// before, for example when their session was started. It returns false when the
+// user has been deleted or their password has been changed since then.
+func (a *Authenticator) Revalidate(user library.User) (*library.User, bool) {
+	if user.ID == 0 {
+		if a.configUser.User == "" || user.Name != a.configUser.User {
+			return nil, false
+		}
+
+		return &library.User{Name: user.Name, Role: library.RoleAdmin}, true
+	}
+
+	if a.library == nil {
+		return nil, false
+	}
+
+	current, err := a.library.GetUser(user.Name)
+
+	if err != nil || current.ID != user.ID || current.PasswordHash != user.PasswordHash {
+		return nil, false
+	}
+
+	return current, true
+}
+
+// verify returns true when password matches its bcrypt hash.
+func (a *Authenticator) verify(hash, password string) bool {
+	key := sha256.Sum256([]byte(hash + "\x00" + password))
+
+	a.verifiedLock.Lock()
+	_, ok := a.verified[key]
+	a.verifiedLock.Unlock()
+
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false
+	}
+
+	a.verifiedLock.Lock()
+	defer a.verifiedLock.Unlock()
+
+	if len(a.verified) >= maxVerifiedPasswords {
+		a.verified = make(map[[sha256.Size]byte]struct{})
+	}
+	a.verified[key] = struct{}{}
+
+	return true
+}
+
+// constantTimeEqual compares two strings in time which does not depend on their
+// contents.
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestAuthenticator(t *testing.T) {
+	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer lib.Truncate()
+
+	hash, err := HashPassword("secret")
+
+	if err != nil {
+		t.Fatalf("Hashing password: %s", err)
+	}
+
+	if hash == "secret" {
+		t.Fatalf("The password was not hashed")
+	}
+
+	if _, err := lib.CreateUser("alice", hash, library.RoleListener); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := NewAuthenticator(config.Auth{
+		User:     "admin",
+		Password: "adminpass",
+	}, lib)
+
+	user, ok := authenticator.Authenticate("admin", "adminpass")
+
+	if !ok || !user.IsAdmin() || user.Name != "admin" {
+		t.Errorf("Expected the configuration user to be an admin but got %+v", user)
+	}
+
+	// The second time the password is checked with the remembered verification.
+	for i := 0; i < 2; i++ {
+		user, ok = authenticator.Authenticate("alice", "secret")
+
+		if !ok || user.IsAdmin() || user.Name != "alice" {
+			t.Errorf("Expected alice to be authenticated as listener but got %+v", user)
+		}
+	}
+
+	wrong := [][2]string{
+		{"admin", "secret"},
+		{"alice", "adminpass"},
+		{"alice", ""},
+		{"nobody", "secret"},
+		{"", ""},
+	}
+
+	for _, credentials := range wrong {
+		if _, ok := authenticator.Authenticate(credentials[0], credentials[1]); ok {
+			t.Errorf("Expected %q to be rejected", credentials)
+		}
+	}
+
+	newHash, _ := HashPassword("changed")
+
+	if err := lib.SetUserPassword("alice", newHash); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := authenticator.Authenticate("alice", "secret"); ok {
+		t.Errorf("Expected the old password to stop working after it was changed")
+	}
+
+	if _, ok := authenticator.Authenticate("alice", "changed"); !ok {
+		t.Errorf("Expected the new password to work")
+	}
+}
+
+func TestAuthenticateSalted(t *testing.T) {
+	authenticator := NewAuthenticator(config.Auth{
+		User:     "admin",
+		Password: "adminpass",
+	}, nil)
+
+	salt := "c19b2d"
+	sum := md5.Sum([]byte("adminpass" + salt))
+	token := hex.EncodeToString(sum[:])
+
+	user, ok := authenticator.AuthenticateSalted("admin", token, salt)
+
+	if !ok || !user.IsAdmin() || user.Name != "admin" {
+		t.Errorf("Expected the configuration user to be an admin but got %+v", user)
+	}
+
+	if _, ok := authenticator.AuthenticateSalted("admin", strings.ToUpper(token),
+		salt); !ok {
+		t.Errorf("Expected tokens to be case insensitive")
+	}
+
+	wrong := [][3]string{
+		{"admin", token, "other"},
+		{"alice", token, salt},
+		{"admin", "", salt},
+	}
+
+	for _, credentials := range wrong {
+		_, ok := authenticator.AuthenticateSalted(credentials[0], credentials[1],
+			credentials[2])
+
+		if ok {
+			t.Errorf("Expected %q to be rejected", credentials)
+		}
+	}
+
+	// Without a user in the configuration nobody could use tokens.
+	empty := NewAuthenticator(config.Auth{}, nil)
+	emptySum := md5.Sum([]byte(salt))
+
+	if _, ok := empty.AuthenticateSalted("", hex.EncodeToString(emptySum[:]),
+		salt); ok {
+		t.Errorf("Expected tokens to be rejected without a configuration user")
+	}
+}
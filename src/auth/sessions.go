@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// sessionTokenSize is the size of the random session tokens in bytes.
+const sessionTokenSize = 32
+
+// Sessions keeps the sessions of logged in users in memory. Every session has a
+// random token which is given to the client, usually in a cookie. Sessions are lost
+// when HTTPMS is restarted.
+type Sessions struct {
+	lifetime time.Duration
+
+	sessions map[string]session
+	lock     sync.Mutex
+}
+
+// session is the user who logged in and the time at which their session expires.
+type session struct {
+	user    library.User
+	expires time.Time
+}
+
+// NewSessions returns an empty sessions store. Sessions in it expire after lifetime.
+func NewSessions(lifetime time.Duration) *Sessions {
+	s := new(Sessions)
+	s.lifetime = lifetime
+	s.sessions = make(map[string]session)
+	return s
+}
+
+// Create starts a session for user. Returns its token and the time at which it
+// expires.
+func (s *Sessions) Create(user library.User) (string, time.Time, error) {
+	tokenBytes := make([]byte, sessionTokenSize)
+
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", time.Time{}, fmt.Errorf("generating session token: %s", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	expires := time.Now().Add(s.lifetime)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removeExpired()
+	s.sessions[token] = session{user: user, expires: expires}
+
+	return token, expires, nil
+}
+
+// Get returns the user of the session with this token. Returns false when there is
+// no such session or it has expired.
+func (s *Sessions) Get(token string) (library.User, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	found, ok := s.sessions[token]
+
+	if !ok || time.Now().After(found.expires) {
+		return library.User{}, false
+	}
+
+	return found.user, true
+}
+
+// Delete ends the session with this token.
+func (s *Sessions) Delete(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, token)
+}
+
+// removeExpired forgets all expired sessions. It must be called with the lock held.
+func (s *Sessions) removeExpired() {
+	now := time.Now()
+
+	for token, found := range s.sessions {
+		if now.After(found.expires) {
+			delete(s.sessions, token)
+		}
+	}
+}
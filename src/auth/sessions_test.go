@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestSessions(t *testing.T) {
+	sessions := NewSessions(time.Hour)
+	user := library.User{ID: 3, Name: "alice", Role: library.RoleListener}
+
+	token, expires, err := sessions.Create(user)
+
+	if err != nil {
+		t.Fatalf("Creating session: %s", err)
+	}
+
+	if token == "" || time.Until(expires) > time.Hour || time.Until(expires) < 59*time.Minute {
+		t.Errorf("Unexpected session token %q expiring at %s", token, expires)
+	}
+
+	other, _, err := sessions.Create(user)
+
+	if err != nil || other == token {
+		t.Errorf("Expected a different token for the second session but got %q", other)
+	}
+
+	if found, ok := sessions.Get(token); !ok || found != user {
+		t.Errorf("Expected the session user but got %+v", found)
+	}
+
+	if _, ok := sessions.Get("wrong"); ok {
+		t.Errorf("Expected no session for a wrong token")
+	}
+
+	sessions.Delete(token)
+
+	if _, ok := sessions.Get(token); ok {
+		t.Errorf("Expected the deleted session to be gone")
+	}
+
+	if _, ok := sessions.Get(other); !ok {
+		t.Errorf("Expected the other session to be kept")
+	}
+
+	short := NewSessions(time.Millisecond)
+	token, _, _ = short.Create(user)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := short.Get(token); ok {
+		t.Errorf("Expected the session to expire")
+	}
+}
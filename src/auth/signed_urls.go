@@ -0,0 +1,117 @@
+// Package auth contains the means for authenticating requests which are used by
+// the webserver and the other servers of HTTPMS.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SigningKeyFile is the name of the file in the user path in which the key for
+// signing URLs is kept. This way signed URLs keep working after restarts.
+const SigningKeyFile = "url_signing.key"
+
+// DefaultSignedURLLifetime is the time for which signed URLs are valid unless
+// something else is requested.
+const DefaultSignedURLLifetime = 7 * 24 * time.Hour
+
+// signingKeySize is the size of the generated signing keys in bytes.
+const signingKeySize = 32
+
+// URLSigner signs URL paths so that they could be requested without any other
+// authentication until they expire. This is useful for players and <audio>
+// elements which cannot send an Authorization header.
+//
+// Signed URLs have two query parameters: "exp" is the Unix time at which the URL
+// expires and "sig" is the HMAC-SHA256 of the path and the expiration time.
+type URLSigner struct {
+	key []byte
+}
+
+// NewURLSigner returns a signer which uses key for its signatures.
+func NewURLSigner(key []byte) *URLSigner {
+	signer := new(URLSigner)
+	signer.key = key
+	return signer
+}
+
+// LoadURLSigner returns a signer with the key stored in keyFile. When the file does
+// not exist a new random key is generated and written in it. An empty keyFile
+// means that the random key is not stored anywhere.
+func LoadURLSigner(keyFile string) (*URLSigner, error) {
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+
+		if err == nil && len(key) > 0 {
+			return NewURLSigner(key), nil
+		}
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	key := make([]byte, signingKeySize)
+
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating signing key: %s", err)
+	}
+
+	if keyFile != "" {
+		if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewURLSigner(key), nil
+}
+
+// Sign returns the path together with the query which makes it valid until
+// expires. The path must be without a query.
+func (s *URLSigner) Sign(path string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	query := url.Values{}
+	query.Set("exp", exp)
+	query.Set("sig", s.signature(path, exp))
+
+	return path + "?" + query.Encode()
+}
+
+// Valid returns true when the URL has a correct signature for its path and it has
+// not expired yet.
+func (s *URLSigner) Valid(u *url.URL) bool {
+	query := u.Query()
+	exp := query.Get("exp")
+
+	expires, err := strconv.ParseInt(exp, 10, 64)
+
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(query.Get("sig"))
+
+	if err != nil {
+		return false
+	}
+
+	expected, _ := base64.RawURLEncoding.DecodeString(s.signature(u.Path, exp))
+
+	return hmac.Equal(sig, expected)
+}
+
+// signature returns the encoded HMAC of path and exp.
+func (s *URLSigner) signature(path, exp string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "\n" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
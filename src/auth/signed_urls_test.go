@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigningURLs(t *testing.T) {
+	signer := NewURLSigner([]byte("secret"))
+	signed := signer.Sign("/file/42", time.Now().Add(time.Hour))
+
+	if !strings.HasPrefix(signed, "/file/42?") {
+		t.Fatalf("Expected the signed URL to keep its path but it was %s", signed)
+	}
+
+	valid := func(rawURL string) bool {
+		u, err := url.Parse(rawURL)
+
+		if err != nil {
+			t.Fatalf("Parsing %s: %s", rawURL, err)
+		}
+
+		return signer.Valid(u)
+	}
+
+	if !valid(signed) {
+		t.Errorf("Expected %s to be valid", signed)
+	}
+
+	invalid := []string{
+		strings.Replace(signed, "/file/42", "/file/43", 1),
+		strings.Replace(signed, "exp=", "exp=1", 1),
+		signed[:len(signed)-2],
+		"/file/42",
+		"/file/42?exp=9999999999",
+		signer.Sign("/file/42", time.Now().Add(-time.Minute)),
+		NewURLSigner([]byte("other")).Sign("/file/42", time.Now().Add(time.Hour)),
+	}
+
+	for _, rawURL := range invalid {
+		if valid(rawURL) {
+			t.Errorf("Expected %s to be invalid", rawURL)
+		}
+	}
+}
+
+func TestLoadingURLSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpms_signing_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, SigningKeyFile)
+	first, err := LoadURLSigner(keyFile)
+
+	if err != nil {
+		t.Fatalf("Creating signer: %s", err)
+	}
+
+	second, err := LoadURLSigner(keyFile)
+
+	if err != nil {
+		t.Fatalf("Loading signer: %s", err)
+	}
+
+	u, _ := url.Parse(first.Sign("/file/1", time.Now().Add(time.Hour)))
+
+	if !second.Valid(u) {
+		t.Errorf("Expected the loaded signer to accept URLs of the created one")
+	}
+
+	random, err := LoadURLSigner("")
+
+	if err != nil {
+		t.Fatalf("Creating signer without a key file: %s", err)
+	}
+
+	if random.Valid(u) {
+		t.Errorf("Expected a signer with a random key to reject the URL")
+	}
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ironsmile/httpms/src/config"
+)
+
+// maxThrottledKeys is the maximum number of client IPs and the maximum number of
+// user names for which failures are remembered. User names are chosen by the clients
+// so without a limit they could fill the memory with random ones.
+const maxThrottledKeys = 10000
+
+// Throttle protects against guessing of passwords and API tokens. It counts the
+// failed authentications for every client IP and every user name. Clients have to
+// wait for an exponentially growing delay after every failure and are locked out
+// after too many of them. Every failure is logged with a line such as
+//
+//	Authentication failure: ip=192.0.2.10 user="alice"
+//
+// which could be matched by tools like fail2ban.
+//
+// A nil Throttle does not block anyone.
+type Throttle struct {
+	cfg     config.Throttling
+	proxies []*net.IPNet
+
+	ips   map[string]*failures
+	users map[string]*failures
+	lock  sync.Mutex
+
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+}
+
+// failures are the failed authentications of a single client IP or user name.
+type failures struct {
+	count        int
+	last         time.Time
+	blockedUntil time.Time
+}
+
+// NewThrottle returns a throttle configured with cfg.
+func NewThrottle(cfg config.Throttling) *Throttle {
+	t := new(Throttle)
+	t.cfg = cfg
+	t.ips = make(map[string]*failures)
+	t.users = make(map[string]*failures)
+	t.now = time.Now
+
+	for _, proxy := range cfg.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+
+		if err != nil {
+			log.Printf("Ignoring wrong trusted proxy %s: %s\n", proxy, err)
+			continue
+		}
+
+		t.proxies = append(t.proxies, network)
+	}
+
+	return t
+}
+
+// ClientIP returns the IP address of the client who made req. For requests which
+// come from a trusted proxy it is the last address in X-Forwarded-For which is not
+// a trusted proxy itself. Addresses further to the left could have been sent by the
+// client and are not trusted.
+func (t *Throttle) ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	if t == nil || !t.trusted(host) {
+		return host
+	}
+
+	var forwarded []string
+
+	for _, header := range req.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	client := host
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+
+		if ip == nil {
+			break
+		}
+
+		client = ip.String()
+
+		if !t.trusted(client) {
+			break
+		}
+	}
+
+	return client
+}
+
+// Blocked returns true when clients from ip or trying to authenticate as user must
+// wait before trying again. It returns the time for which they have to wait as
+// well. user could be empty for credentials without a user name, such as API tokens.
+func (t *Throttle) Blocked(ip, user string) (time.Duration, bool) {
+	if t == nil || !t.cfg.Enabled {
+		return 0, false
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := t.now()
+	var until time.Time
+
+	if found, ok := t.ips[ip]; ok && found.blockedUntil.After(until) {
+		until = found.blockedUntil
+	}
+
+	if found, ok := t.users[user]; ok && user != "" && found.blockedUntil.After(until) {
+		until = found.blockedUntil
+	}
+
+	if !until.After(now) {
+		return 0, false
+	}
+
+	log.Printf("Authentication blocked: ip=%s user=%q\n", ip, user)
+
+	return until.Sub(now), true
+}
+
+// Failed records a failed authentication from ip with the name user.
+func (t *Throttle) Failed(ip, user string) {
+	log.Printf("Authentication failure: ip=%s user=%q\n", ip, user)
+
+	if t == nil || !t.cfg.Enabled {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.fail(t.ips, ip) {
+		log.Printf("Authentication lockout: ip=%s for %s\n", ip, t.cfg.Lockout)
+	}
+
+	if user != "" && t.fail(t.users, user) {
+		log.Printf("Authentication lockout: user=%q for %s\n", user, t.cfg.Lockout)
+	}
+}
+
+// Succeeded records a successful authentication with the name user. It forgets the
+// failures for user. The failures from ip are kept since otherwise everyone with
+// valid credentials could guess the passwords of other users without limits.
+func (t *Throttle) Succeeded(ip, user string) {
+	if t == nil || user == "" {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.users, user)
+}
+
+// fail counts a failure for key in records and blocks it for an exponentially
+// growing delay. Returns true when key is locked out. It must be called with the
+// lock held.
+func (t *Throttle) fail(records map[string]*failures, key string) bool {
+	now := t.now()
+	found, ok := records[key]
+
+	if ok && now.Sub(found.last) > t.cfg.ResetAfter {
+		ok = false
+	}
+
+	if !ok {
+		if len(records) >= maxThrottledKeys {
+			t.forget(records)
+		}
+
+		if len(records) >= maxThrottledKeys {
+			return false
+		}
+
+		found = &failures{}
+		records[key] = found
+	}
+
+	found.count++
+	found.last = now
+
+	if found.count >= t.cfg.MaxFailures {
+		found.blockedUntil = now.Add(t.cfg.Lockout)
+		return true
+	}
+
+	delay := t.cfg.BaseDelay
+
+	for i := 1; i < found.count && delay < t.cfg.Lockout; i++ {
+		delay *= 2
+	}
+
+	if delay > t.cfg.Lockout {
+		delay = t.cfg.Lockout
+	}
+
+	found.blockedUntil = now.Add(delay)
+
+	return false
+}
+
+// forget removes the records which are not blocked and have been without failures
+// for longer than the reset period. It must be called with the lock held.
+func (t *Throttle) forget(records map[string]*failures) {
+	now := t.now()
+
+	for key, found := range records {
+		if now.Sub(found.last) > t.cfg.ResetAfter && !found.blockedUntil.After(now) {
+			delete(records, key)
+		}
+	}
+}
+
+// trusted returns true when ip is the address of a trusted proxy.
+func (t *Throttle) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range t.proxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
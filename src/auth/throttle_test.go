@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/config"
+)
+
+func TestThrottle(t *testing.T) {
+	throttle := NewThrottle(config.Throttling{
+		Enabled:     true,
+		MaxFailures: 4,
+		BaseDelay:   time.Second,
+		Lockout:     time.Minute,
+		ResetAfter:  time.Hour,
+	})
+
+	now := time.Now()
+	throttle.now = func() time.Time {
+		return now
+	}
+
+	expectBlocked := func(ip, user string, expected time.Duration) {
+		t.Helper()
+
+		retryAfter, blocked := throttle.Blocked(ip, user)
+
+		if expected == 0 && blocked {
+			t.Errorf("Expected %s and %q not to be blocked but they were for %s",
+				ip, user, retryAfter)
+		}
+
+		if expected != 0 && (!blocked || retryAfter != expected) {
+			t.Errorf("Expected %s and %q to be blocked for %s but got %s, %t",
+				ip, user, expected, retryAfter, blocked)
+		}
+	}
+
+	expectBlocked("192.0.2.1", "alice", 0)
+
+	// The delays double with every failure until the lockout.
+	for _, expected := range []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		time.Minute,
+	} {
+		throttle.Failed("192.0.2.1", "alice")
+		expectBlocked("192.0.2.1", "alice", expected)
+		now = now.Add(expected)
+		expectBlocked("192.0.2.1", "alice", 0)
+	}
+
+	throttle.Failed("192.0.2.1", "alice")
+	expectBlocked("192.0.2.2", "alice", time.Minute)
+	expectBlocked("192.0.2.1", "bob", time.Minute)
+	expectBlocked("192.0.2.2", "bob", 0)
+	expectBlocked("192.0.2.2", "", 0)
+
+	// Successful authentication forgets the failures of the user but not of the IP.
+	throttle.Succeeded("192.0.2.1", "alice")
+	expectBlocked("192.0.2.2", "alice", 0)
+	expectBlocked("192.0.2.1", "", time.Minute)
+
+	// Failures are forgotten after the reset period.
+	now = now.Add(2 * time.Hour)
+	throttle.Failed("192.0.2.1", "")
+	expectBlocked("192.0.2.1", "", time.Second)
+
+	disabled := NewThrottle(config.Throttling{
+		MaxFailures: 1,
+		BaseDelay:   time.Second,
+		Lockout:     time.Minute,
+		ResetAfter:  time.Hour,
+	})
+	disabled.Failed("192.0.2.1", "alice")
+
+	if _, blocked := disabled.Blocked("192.0.2.1", "alice"); blocked {
+		t.Errorf("Expected a disabled throttle not to block anyone")
+	}
+
+	var nilThrottle *Throttle
+	nilThrottle.Failed("192.0.2.1", "alice")
+
+	if _, blocked := nilThrottle.Blocked("192.0.2.1", "alice"); blocked {
+		t.Errorf("Expected a nil throttle not to block anyone")
+	}
+}
+
+func TestThrottleClientIP(t *testing.T) {
+	throttle := NewThrottle(config.Throttling{
+		TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8", "::1"},
+	})
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"192.0.2.1:4000", nil, "192.0.2.1"},
+		{"192.0.2.1:4000", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"127.0.0.1:4000", nil, "127.0.0.1"},
+		{"127.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"[::1]:4000", []string{"2001:db8::1"}, "2001:db8::1"},
+		{"127.0.0.1:4000", []string{"203.0.113.1, 198.51.100.1, 10.0.0.2"},
+			"198.51.100.1"},
+		{"127.0.0.1:4000", []string{"203.0.113.1", "198.51.100.1"}, "198.51.100.1"},
+		{"127.0.0.1:4000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"127.0.0.1:4000", []string{"198.51.100.1, garbage, 10.0.0.2"}, "10.0.0.2"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		req.RemoteAddr = test.remoteAddr
+
+		for _, forwarded := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", forwarded)
+		}
+
+		if found := throttle.ClientIP(req); found != test.expected {
+			t.Errorf("Expected client IP %s for %s with %q but got %s",
+				test.expected, test.remoteAddr, test.forwarded, found)
+		}
+	}
+}
+
+// Without trusted proxies X-Forwarded-For must be ignored for all clients, even the
+// ones on the loopback interface.
+func TestThrottleClientIPWithoutProxies(t *testing.T) {
+	for _, throttle := range []*Throttle{nil, NewThrottle(config.Throttling{})} {
+		for _, remoteAddr := range []string{"127.0.0.1:4000", "192.0.2.1:4000"} {
+			req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+			req.RemoteAddr = remoteAddr
+			req.Header.Add("X-Forwarded-For", "198.51.100.1")
+
+			expected := strings.Split(remoteAddr, ":")[0]
+
+			if found := throttle.ClientIP(req); found != expected {
+				t.Errorf("Expected X-Forwarded-For to be ignored for %s but got %s",
+					remoteAddr, found)
+			}
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -46,6 +47,16 @@ type Config struct {
 	WriteTimeout   int         `json:"write_timeout"`
 	MaxHeadersSize int         `json:"max_header_bytes"`
 	HTTPRoot       string      `json:"http_root"`
+	Transcoding    Transcoding `json:"transcoding"`
+	HLS            HLS         `json:"hls"`
+	DLNA           DLNA        `json:"dlna"`
+	MPD            MPD         `json:"mpd"`
+	Sessions       Sessions    `json:"sessions"`
+	AuthThrottling Throttling  `json:"auth_throttling"`
+
+	// IndexTranscodedFormats adds files in formats which browsers cannot play, such
+	// as Opus or WMA, to the library. They could be played only with transcoding.
+	IndexTranscodedFormats bool `json:"index_transcoded_formats"`
 }
 
 // MergedConfig is used for merging one config over the other. I need the zero value
@@ -71,6 +82,14 @@ type MergedConfig struct {
 	WriteTimeout   *int         `json:"write_timeout"`
 	MaxHeadersSize *int         `json:"max_header_bytes"`
 	HTTPRoot       *string      `json:"http_root"`
+	Transcoding    *Transcoding `json:"transcoding"`
+	HLS            *HLS         `json:"hls"`
+	DLNA           *DLNA        `json:"dlna"`
+	MPD            *MPD         `json:"mpd"`
+	Sessions       *Sessions    `json:"sessions"`
+	AuthThrottling *Throttling  `json:"auth_throttling"`
+
+	IndexTranscodedFormats *bool `json:"index_transcoded_formats"`
 }
 
 // ScanSection is used for merging the two configs. Its purpose is to essentially
@@ -119,6 +138,267 @@ func (ss *ScanSection) UnmarshalJSON(input []byte) error {
 	return nil
 }
 
+// Transcoding contains the transcoding profiles by the name of the format they are
+// producing, e.g. "mp3" or "ogg".
+type Transcoding map[string]TranscodingProfile
+
+// TranscodingProfile describes how media files are converted to a particular format
+// by an external encoder command.
+type TranscodingProfile struct {
+
+	// Command is the encoder executable followed by its arguments. The encoder must
+	// write the converted file to its standard output. In the arguments "{input}" is
+	// replaced by the path to the original file and "{bitrate}" by the requested
+	// bitrate in kbps.
+	Command []string `json:"command"`
+
+	// ContentType is the MIME type of the encoder's output.
+	ContentType string `json:"content_type"`
+
+	// DefaultBitrate in kbps is used when no bitrate has been requested.
+	DefaultBitrate int `json:"default_bitrate"`
+}
+
+// UnmarshalJSON parses a JSON and populates its TranscodingProfile. Satisfies the
+// Unmarshaler interface.
+func (tp *TranscodingProfile) UnmarshalJSON(input []byte) error {
+	type profileProxy TranscodingProfile
+	proxy := (*profileProxy)(tp)
+
+	if err := json.Unmarshal(input, proxy); err != nil {
+		return err
+	}
+
+	if len(tp.Command) == 0 || tp.Command[0] == "" {
+		return errors.New("transcoding profiles must have a command")
+	}
+
+	if tp.ContentType == "" {
+		return errors.New("transcoding profiles must have a content_type")
+	}
+
+	if tp.DefaultBitrate <= 0 {
+		return errors.New("default_bitrate of transcoding profiles must be a " +
+			"positive integer")
+	}
+
+	return nil
+}
+
+// HLS configures the HTTP Live Streaming of tracks. Its segments are cut with the
+// encoder from a transcoding profile.
+type HLS struct {
+
+	// Profile is the name of the transcoding profile used for cutting segments. Its
+	// command must produce MPEG-TS. Besides the usual placeholders "{start}" and
+	// "{duration}" in its arguments are replaced by the position of the segment in
+	// the track in seconds. HLS is disabled when there is no such profile.
+	Profile string `json:"profile"`
+
+	// SegmentDuration is the length of a single segment.
+	SegmentDuration time.Duration `json:"segment_duration"`
+
+	// CacheMaxAge is the time after which segments which have not been requested
+	// are removed from the disk cache.
+	CacheMaxAge time.Duration `json:"cache_max_age"`
+}
+
+// UnmarshalJSON parses a JSON and populates its HLS. Satisfies the Unmarshaler
+// interface.
+func (h *HLS) UnmarshalJSON(input []byte) error {
+	hlsProxy := &struct {
+		Profile         string `json:"profile"`
+		SegmentDuration string `json:"segment_duration"`
+		CacheMaxAge     string `json:"cache_max_age"`
+	}{}
+
+	if err := json.Unmarshal(input, hlsProxy); err != nil {
+		return err
+	}
+
+	h.Profile = hlsProxy.Profile
+
+	if hlsProxy.SegmentDuration != "" {
+		sd, err := time.ParseDuration(hlsProxy.SegmentDuration)
+		if err != nil {
+			return err
+		}
+		h.SegmentDuration = sd
+	}
+
+	if hlsProxy.CacheMaxAge != "" {
+		cma, err := time.ParseDuration(hlsProxy.CacheMaxAge)
+		if err != nil {
+			return err
+		}
+		h.CacheMaxAge = cma
+	}
+
+	if h.SegmentDuration < time.Second {
+		return errors.New("segment_duration must be at least one second")
+	}
+
+	if h.CacheMaxAge <= 0 {
+		return errors.New("cache_max_age must be a positive duration")
+	}
+
+	return nil
+}
+
+// DLNA configures the UPnP media server which makes it possible for TVs, receivers
+// and other devices on the local network to find and browse the library.
+type DLNA struct {
+
+	// Enabled turns on the media server and its SSDP discovery.
+	Enabled bool `json:"enabled"`
+
+	// FriendlyName is the name under which the media server is seen by devices.
+	FriendlyName string `json:"friendly_name"`
+
+	// UUID identifies the media server. When empty it is derived from the host name
+	// and the friendly name so that it does not change between restarts.
+	UUID string `json:"uuid"`
+}
+
+// MPD configures the server for the Music Player Daemon protocol. It makes it
+// possible for MPD clients to use the library as a read-only database.
+type MPD struct {
+
+	// Enabled turns on the MPD server.
+	Enabled bool `json:"enabled"`
+
+	// Listen is the TCP address on which the MPD server accepts connections.
+	Listen string `json:"listen"`
+}
+
+// Sessions configures the sessions of users who log in with the login form of the
+// web UI.
+type Sessions struct {
+
+	// Lifetime is the time after which users have to log in again.
+	Lifetime time.Duration `json:"lifetime"`
+
+	// SecureCookie makes the session cookies Secure even when SSL is not used, for
+	// example when HTTPMS is behind a reverse proxy with HTTPS. The cookies are
+	// always Secure when SSL is used.
+	SecureCookie bool `json:"secure_cookie"`
+}
+
+// UnmarshalJSON parses a JSON and populates its Sessions. Satisfies the Unmarshaler
+// interface.
+func (s *Sessions) UnmarshalJSON(input []byte) error {
+	sessionsProxy := &struct {
+		Lifetime     string `json:"lifetime"`
+		SecureCookie bool   `json:"secure_cookie"`
+	}{}
+
+	if err := json.Unmarshal(input, sessionsProxy); err != nil {
+		return err
+	}
+
+	s.SecureCookie = sessionsProxy.SecureCookie
+
+	if sessionsProxy.Lifetime != "" {
+		lifetime, err := time.ParseDuration(sessionsProxy.Lifetime)
+		if err != nil {
+			return err
+		}
+		s.Lifetime = lifetime
+	}
+
+	if s.Lifetime <= 0 {
+		return errors.New("lifetime must be a positive duration")
+	}
+
+	return nil
+}
+
+// Throttling configures the protection against guessing passwords. Failed
+// authentications are counted for every client IP and every user name. After a
+// failure clients have to wait BaseDelay before trying again and the delay doubles
+// with every next failure. After MaxFailures they are locked out for Lockout.
+type Throttling struct {
+
+	// Enabled turns on the delays and lockouts. Failures are logged either way.
+	Enabled bool `json:"enabled"`
+
+	// MaxFailures is the number of failures after which clients are locked out.
+	MaxFailures int `json:"max_failures"`
+
+	// BaseDelay is the time for which clients have to wait after the first failure.
+	BaseDelay time.Duration `json:"base_delay"`
+
+	// Lockout is the time for which clients are locked out.
+	Lockout time.Duration `json:"lockout"`
+
+	// ResetAfter is the time without failures after which they are forgotten.
+	ResetAfter time.Duration `json:"reset_after"`
+
+	// TrustedProxies are the IP addresses or CIDR networks of the reverse proxies
+	// in front of HTTPMS. The client IP of requests coming from them is taken from
+	// the X-Forwarded-For header.
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// UnmarshalJSON parses a JSON and populates its Throttling. Satisfies the
+// Unmarshaler interface.
+func (t *Throttling) UnmarshalJSON(input []byte) error {
+	throttlingProxy := &struct {
+		Enabled        bool     `json:"enabled"`
+		MaxFailures    int      `json:"max_failures"`
+		BaseDelay      string   `json:"base_delay"`
+		Lockout        string   `json:"lockout"`
+		ResetAfter     string   `json:"reset_after"`
+		TrustedProxies []string `json:"trusted_proxies"`
+	}{}
+
+	if err := json.Unmarshal(input, throttlingProxy); err != nil {
+		return err
+	}
+
+	t.Enabled = throttlingProxy.Enabled
+	t.MaxFailures = throttlingProxy.MaxFailures
+	t.TrustedProxies = throttlingProxy.TrustedProxies
+
+	durations := []struct {
+		name  string
+		value string
+		field *time.Duration
+	}{
+		{"base_delay", throttlingProxy.BaseDelay, &t.BaseDelay},
+		{"lockout", throttlingProxy.Lockout, &t.Lockout},
+		{"reset_after", throttlingProxy.ResetAfter, &t.ResetAfter},
+	}
+
+	for _, duration := range durations {
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", duration.name, err)
+		}
+		if parsed <= 0 {
+			return fmt.Errorf("%s must be a positive duration", duration.name)
+		}
+		*duration.field = parsed
+	}
+
+	if t.MaxFailures <= 0 {
+		return errors.New("max_failures must be a positive number")
+	}
+
+	if t.Lockout < t.BaseDelay || t.ResetAfter < t.Lockout {
+		return errors.New("base_delay, lockout and reset_after must be increasing")
+	}
+
+	for _, proxy := range t.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("trusted proxy %q is neither IP address nor network",
+				proxy)
+		}
+	}
+
+	return nil
+}
+
 // Cert represents a configuration for TLS certificate
 type Cert struct {
 	Crt string `json:"crt"`
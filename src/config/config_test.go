@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -207,3 +208,219 @@ func TestMergedConfigHasTheSameFieldsAsConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestTranscodingProfiles(t *testing.T) {
+	cfg := getDefaultCfg()
+	testJSON := `
+		{
+			"transcoding": {
+				"mp3": {
+					"command": ["ffmpeg", "-i", "{input}", "-b:a", "{bitrate}k", "-"],
+					"content_type": "audio/mpeg",
+					"default_bitrate": 192
+				}
+			}
+		}
+	`
+
+	if err := cfg.mergeJSON([]byte(testJSON)); err != nil {
+		t.Fatalf("Parsing test json failed: %s", err)
+	}
+
+	expected := Transcoding{
+		"mp3": {
+			Command:        []string{"ffmpeg", "-i", "{input}", "-b:a", "{bitrate}k", "-"},
+			ContentType:    "audio/mpeg",
+			DefaultBitrate: 192,
+		},
+	}
+
+	if !reflect.DeepEqual(cfg.Transcoding, expected) {
+		t.Errorf("Transcoding was not as expected: It was: %#v, expected: %#v",
+			cfg.Transcoding, expected)
+	}
+
+	invalid := []string{
+		`{"content_type": "audio/mpeg", "default_bitrate": 192}`,
+		`{"command": [""], "content_type": "audio/mpeg", "default_bitrate": 192}`,
+		`{"command": ["ffmpeg"], "default_bitrate": 192}`,
+		`{"command": ["ffmpeg"], "content_type": "audio/mpeg"}`,
+		`{"command": ["ffmpeg"], "content_type": "audio/mpeg", "default_bitrate": -1}`,
+	}
+
+	for _, profile := range invalid {
+		profileJSON := fmt.Sprintf(`{"transcoding": {"mp3": %s}}`, profile)
+
+		if err := cfg.mergeJSON([]byte(profileJSON)); err == nil {
+			t.Errorf("Expected an error for transcoding profile %s", profile)
+		}
+	}
+}
+
+func TestHLSSection(t *testing.T) {
+	cfg := getDefaultCfg()
+	testJSON := `
+		{
+			"hls": {
+				"profile": "hls",
+				"segment_duration": "6s",
+				"cache_max_age": "12h"
+			}
+		}
+	`
+
+	if err := cfg.mergeJSON([]byte(testJSON)); err != nil {
+		t.Fatalf("Parsing test json failed: %s", err)
+	}
+
+	expected := HLS{
+		Profile:         "hls",
+		SegmentDuration: 6 * time.Second,
+		CacheMaxAge:     12 * time.Hour,
+	}
+
+	if cfg.HLS != expected {
+		t.Errorf("HLS was not as expected: It was: %#v, expected: %#v", cfg.HLS,
+			expected)
+	}
+
+	invalid := []string{
+		`{"profile": "hls", "segment_duration": "10", "cache_max_age": "1h"}`,
+		`{"profile": "hls", "segment_duration": "10ms", "cache_max_age": "1h"}`,
+		`{"profile": "hls", "segment_duration": "10s"}`,
+	}
+
+	for _, section := range invalid {
+		sectionJSON := fmt.Sprintf(`{"hls": %s}`, section)
+
+		if err := cfg.mergeJSON([]byte(sectionJSON)); err == nil {
+			t.Errorf("Expected an error for HLS section %s", section)
+		}
+	}
+}
+
+func TestDLNASection(t *testing.T) {
+	cfg := getDefaultCfg()
+
+	if cfg.DLNA.Enabled {
+		t.Errorf("DLNA should be disabled by default")
+	}
+
+	testJSON := `
+		{
+			"dlna": {
+				"enabled": true,
+				"friendly_name": "Living Room Music",
+				"uuid": "2fac1234-31f8-11b4-a222-08002b34c003"
+			}
+		}
+	`
+
+	if err := cfg.mergeJSON([]byte(testJSON)); err != nil {
+		t.Fatalf("Parsing test json failed: %s", err)
+	}
+
+	expected := DLNA{
+		Enabled:      true,
+		FriendlyName: "Living Room Music",
+		UUID:         "2fac1234-31f8-11b4-a222-08002b34c003",
+	}
+
+	if cfg.DLNA != expected {
+		t.Errorf("DLNA was not as expected: It was: %#v, expected: %#v", cfg.DLNA,
+			expected)
+	}
+}
+
+func TestMPDSection(t *testing.T) {
+	cfg := getDefaultCfg()
+
+	if cfg.MPD.Enabled {
+		t.Errorf("MPD should be disabled by default")
+	}
+
+	testJSON := `{"mpd": {"enabled": true, "listen": "127.0.0.1:6601"}}`
+
+	if err := cfg.mergeJSON([]byte(testJSON)); err != nil {
+		t.Fatalf("Parsing test json failed: %s", err)
+	}
+
+	expected := MPD{Enabled: true, Listen: "127.0.0.1:6601"}
+
+	if cfg.MPD != expected {
+		t.Errorf("MPD was not as expected: It was: %#v, expected: %#v", cfg.MPD,
+			expected)
+	}
+}
+
+func TestSessionsSection(t *testing.T) {
+	cfg := getDefaultCfg()
+	testJSON := `{"sessions": {"lifetime": "12h", "secure_cookie": true}}`
+
+	if err := cfg.mergeJSON([]byte(testJSON)); err != nil {
+		t.Fatalf("Parsing test json failed: %s", err)
+	}
+
+	expected := Sessions{Lifetime: 12 * time.Hour, SecureCookie: true}
+
+	if cfg.Sessions != expected {
+		t.Errorf("Sessions were not as expected: It was: %#v, expected: %#v",
+			cfg.Sessions, expected)
+	}
+
+	for _, section := range []string{`{"lifetime": "12"}`, `{"lifetime": "-1h"}`, `{}`} {
+		sectionJSON := fmt.Sprintf(`{"sessions": %s}`, section)
+
+		if err := cfg.mergeJSON([]byte(sectionJSON)); err == nil {
+			t.Errorf("Expected an error for sessions section %s", section)
+		}
+	}
+}
+
+func TestAuthThrottlingSection(t *testing.T) {
+	cfg := getDefaultCfg()
+	testJSON := `{"auth_throttling": {
+		"enabled": true,
+		"max_failures": 3,
+		"base_delay": "2s",
+		"lockout": "10m",
+		"reset_after": "2h",
+		"trusted_proxies": ["127.0.0.1", "10.0.0.0/8", "::1"]
+	}}`
+
+	if err := cfg.mergeJSON([]byte(testJSON)); err != nil {
+		t.Fatalf("Parsing test json failed: %s", err)
+	}
+
+	expected := Throttling{
+		Enabled:        true,
+		MaxFailures:    3,
+		BaseDelay:      2 * time.Second,
+		Lockout:        10 * time.Minute,
+		ResetAfter:     2 * time.Hour,
+		TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8", "::1"},
+	}
+
+	if !reflect.DeepEqual(cfg.AuthThrottling, expected) {
+		t.Errorf("Auth throttling was not as expected: It was: %#v, expected: %#v",
+			cfg.AuthThrottling, expected)
+	}
+
+	wrongSections := []string{
+		`{"max_failures": 0, "base_delay": "1s", "lockout": "1m", "reset_after": "1h"}`,
+		`{"max_failures": 5, "base_delay": "1", "lockout": "1m", "reset_after": "1h"}`,
+		`{"max_failures": 5, "base_delay": "1s", "lockout": "-1m", "reset_after": "1h"}`,
+		`{"max_failures": 5, "base_delay": "1s", "lockout": "2h", "reset_after": "1h"}`,
+		`{"max_failures": 5, "base_delay": "1s", "lockout": "1m", "reset_after": "1h",
+			"trusted_proxies": ["proxy.example.com"]}`,
+		`{}`,
+	}
+
+	for _, section := range wrongSections {
+		sectionJSON := fmt.Sprintf(`{"auth_throttling": %s}`, section)
+
+		if err := cfg.mergeJSON([]byte(sectionJSON)); err == nil {
+			t.Errorf("Expected an error for auth_throttling section %s", section)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package dlna
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// audioMIMETypes are the types of the media files which are usually found in the
+// library. Not every system knows about all of them so they are not left to the mime
+// package.
+var audioMIMETypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".wav":  "audio/wav",
+}
+
+// mimeType returns the MIME type of a media file by its extension.
+func mimeType(filePath string) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+
+	if mimeType, ok := audioMIMETypes[ext]; ok {
+		return mimeType
+	}
+
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+
+	return "application/octet-stream"
+}
+
+// connectionManagerAction runs an action of the ConnectionManager service. The media
+// server does not support making connections. Devices just fetch the resources with
+// HTTP GET requests on the only connection with ID "0".
+func (ms *MediaServer) connectionManagerAction(
+	req *http.Request,
+	action string,
+	args map[string]string,
+) ([]soapArg, error) {
+	switch action {
+	case "GetProtocolInfo":
+		return []soapArg{
+			outArg("Source", sourceProtocolInfo()),
+			outArg("Sink", ""),
+		}, nil
+	case "GetCurrentConnectionIDs":
+		return []soapArg{outArg("ConnectionIDs", "0")}, nil
+	case "GetCurrentConnectionInfo":
+		if args["ConnectionID"] != "0" {
+			return nil, &upnpError{upnpErrInvalidConnection,
+				"Invalid connection reference"}
+		}
+		return []soapArg{
+			outArg("RcsID", "-1"),
+			outArg("AVTransportID", "-1"),
+			outArg("ProtocolInfo", ""),
+			outArg("PeerConnectionManager", ""),
+			outArg("PeerConnectionID", "-1"),
+			outArg("Direction", "Output"),
+			outArg("Status", "OK"),
+		}, nil
+	default:
+		return nil, &upnpError{upnpErrInvalidAction, "Invalid Action"}
+	}
+}
+
+// sourceProtocolInfo returns the protocols and formats in which the media server
+// serves files.
+func sourceProtocolInfo() string {
+	seen := make(map[string]bool)
+	var protocols []string
+
+	for _, mimeType := range audioMIMETypes {
+		if seen[mimeType] {
+			continue
+		}
+		seen[mimeType] = true
+		protocols = append(protocols, fmt.Sprintf("http-get:*:%s:*", mimeType))
+	}
+
+	sort.Strings(protocols)
+
+	return strings.Join(protocols, ",")
+}
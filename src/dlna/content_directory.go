@@ -0,0 +1,478 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// IDs of the containers at the top of the ContentDirectory. Artists, albums and
+// tracks have IDs such as "artist-12" which contain their library IDs.
+const (
+	rootID    = "0"
+	artistsID = "artists"
+	albumsID  = "albums"
+
+	artistPrefix = "artist-"
+	albumPrefix  = "album-"
+	trackPrefix  = "track-"
+)
+
+// systemUpdateID is the version of the ContentDirectory. It is always the same since
+// there is no way to get notified for changes in the library.
+const systemUpdateID = "1"
+
+// Classes of the ContentDirectory objects.
+const (
+	classContainer   = "object.container"
+	classArtist      = "object.container.person.musicArtist"
+	classAlbum       = "object.container.album.musicAlbum"
+	classMusicTrack  = "object.item.audioItem.musicTrack"
+	classStorageRoot = "object.container.storageFolder"
+)
+
+type didlLite struct {
+	XMLName    xml.Name        `xml:"DIDL-Lite"`
+	XMLNS      string          `xml:"xmlns,attr"`
+	XMLNSDC    string          `xml:"xmlns:dc,attr"`
+	XMLNSUPnP  string          `xml:"xmlns:upnp,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+}
+
+type didlContainer struct {
+	ID          string `xml:"id,attr"`
+	ParentID    string `xml:"parentID,attr"`
+	Restricted  int    `xml:"restricted,attr"`
+	Searchable  int    `xml:"searchable,attr"`
+	ChildCount  *int64 `xml:"childCount,attr,omitempty"`
+	Title       string `xml:"dc:title"`
+	Class       string `xml:"upnp:class"`
+	Artist      string `xml:"upnp:artist,omitempty"`
+	AlbumArtURI string `xml:"upnp:albumArtURI,omitempty"`
+}
+
+type didlItem struct {
+	ID          string  `xml:"id,attr"`
+	ParentID    string  `xml:"parentID,attr"`
+	Restricted  int     `xml:"restricted,attr"`
+	Title       string  `xml:"dc:title"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	Date        string  `xml:"dc:date,omitempty"`
+	Class       string  `xml:"upnp:class"`
+	Artist      string  `xml:"upnp:artist,omitempty"`
+	Album       string  `xml:"upnp:album,omitempty"`
+	Genre       string  `xml:"upnp:genre,omitempty"`
+	TrackNumber int64   `xml:"upnp:originalTrackNumber,omitempty"`
+	AlbumArtURI string  `xml:"upnp:albumArtURI,omitempty"`
+	Res         didlRes `xml:"res"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+// contentDirectoryAction runs an action of the ContentDirectory service.
+func (ms *MediaServer) contentDirectoryAction(
+	req *http.Request,
+	action string,
+	args map[string]string,
+) ([]soapArg, error) {
+	switch action {
+	case "Browse":
+		return ms.browse(req, args)
+	case "GetSearchCapabilities":
+		return []soapArg{outArg("SearchCaps", "")}, nil
+	case "GetSortCapabilities":
+		return []soapArg{outArg("SortCaps", "")}, nil
+	case "GetSystemUpdateID":
+		return []soapArg{outArg("Id", systemUpdateID)}, nil
+	default:
+		return nil, &upnpError{upnpErrInvalidAction, "Invalid Action"}
+	}
+}
+
+// browse implements the Browse action. With BrowseMetadata it returns the object
+// itself and with BrowseDirectChildren its children between StartingIndex and
+// RequestedCount. Zero RequestedCount means all of them.
+func (ms *MediaServer) browse(
+	req *http.Request,
+	args map[string]string,
+) ([]soapArg, error) {
+	start, errStart := strconv.ParseUint(args["StartingIndex"], 10, 32)
+	count, errCount := strconv.ParseUint(args["RequestedCount"], 10, 32)
+
+	if errStart != nil || errCount != nil {
+		return nil, &upnpError{upnpErrInvalidArgs, "Invalid Args"}
+	}
+
+	result := didlLite{
+		XMLNS:     "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		XMLNSDC:   "http://purl.org/dc/elements/1.1/",
+		XMLNSUPnP: "urn:schemas-upnp-org:metadata-1-0/upnp/",
+	}
+
+	var total int
+	var err error
+	objectID := args["ObjectID"]
+
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		total = 1
+		err = ms.browseMetadata(req, objectID, &result)
+	case "BrowseDirectChildren":
+		total, err = ms.browseChildren(req, objectID, uint(start), uint(count), &result)
+	default:
+		return nil, &upnpError{upnpErrInvalidArgs, "Invalid Args"}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	didl, err := xml.Marshal(result)
+
+	if err != nil {
+		return nil, fmt.Errorf("encoding DIDL-Lite: %s", err)
+	}
+
+	returned := len(result.Containers) + len(result.Items)
+
+	return []soapArg{
+		outArg("Result", string(didl)),
+		outArg("NumberReturned", strconv.Itoa(returned)),
+		outArg("TotalMatches", strconv.Itoa(total)),
+		outArg("UpdateID", systemUpdateID),
+	}, nil
+}
+
+// browseMetadata adds the object with this ID to result.
+func (ms *MediaServer) browseMetadata(
+	req *http.Request,
+	objectID string,
+	result *didlLite,
+) error {
+	switch objectID {
+	case rootID:
+		result.Containers = append(result.Containers, ms.rootContainer())
+		return nil
+	case artistsID, albumsID:
+		result.Containers = append(result.Containers, ms.topContainer(objectID))
+		return nil
+	}
+
+	id, prefix, ok := parseObjectID(objectID)
+
+	if !ok {
+		return &upnpError{upnpErrNoSuchObject, "No such object"}
+	}
+
+	switch prefix {
+	case artistPrefix:
+		artist, err := ms.library.GetArtist(id)
+		if err != nil {
+			return objectError(err)
+		}
+		result.Containers = append(result.Containers, artistContainer(*artist))
+	case albumPrefix:
+		album, err := ms.library.GetAlbum(id)
+		if err != nil {
+			return objectError(err)
+		}
+		result.Containers = append(result.Containers,
+			albumContainer(req, albumsID, *album))
+	case trackPrefix:
+		track, err := ms.library.GetTrack(id)
+		if err != nil {
+			return objectError(err)
+		}
+		result.Items = append(result.Items, ms.trackItem(req, *track))
+	}
+
+	return nil
+}
+
+// browseChildren adds the children of the object with this ID to the result. Only
+// the children from start onwards are added and no more than count of them. Returns
+// the number of all children.
+func (ms *MediaServer) browseChildren(
+	req *http.Request,
+	objectID string,
+	start, count uint,
+	result *didlLite,
+) (int, error) {
+	switch objectID {
+	case rootID:
+		children := []didlContainer{
+			ms.topContainer(artistsID),
+			ms.topContainer(albumsID),
+		}
+		from, to := window(start, count, len(children))
+		result.Containers = append(result.Containers, children[from:to]...)
+		return len(children), nil
+	case artistsID:
+		artists, total := ms.library.BrowseArtists(browseArgs(start, count))
+		from, to := window(start, count, len(artists))
+		for _, artist := range artists[from:to] {
+			result.Containers = append(result.Containers, artistContainer(artist))
+		}
+		return total, nil
+	case albumsID:
+		albums, total := ms.library.BrowseAlbums(browseArgs(start, count))
+		from, to := window(start, count, len(albums))
+		for _, album := range albums[from:to] {
+			result.Containers = append(result.Containers,
+				albumContainer(req, albumsID, album))
+		}
+		return total, nil
+	}
+
+	id, prefix, ok := parseObjectID(objectID)
+
+	if !ok {
+		return 0, &upnpError{upnpErrNoSuchObject, "No such object"}
+	}
+
+	switch prefix {
+	case artistPrefix:
+		if _, err := ms.library.GetArtist(id); err != nil {
+			return 0, objectError(err)
+		}
+		albums := ms.library.GetArtistAlbums(id)
+		from, to := window(start, count, len(albums))
+		for _, album := range albums[from:to] {
+			result.Containers = append(result.Containers,
+				albumContainer(req, objectID, album))
+		}
+		return len(albums), nil
+	case albumPrefix:
+		if _, err := ms.library.GetAlbum(id); err != nil {
+			return 0, objectError(err)
+		}
+		tracks := ms.library.GetAlbumFiles(id)
+		from, to := window(start, count, len(tracks))
+		for _, track := range tracks[from:to] {
+			result.Items = append(result.Items, ms.trackItem(req, track))
+		}
+		return len(tracks), nil
+	default:
+		// Tracks are items and they do not have any children.
+		if _, err := ms.library.GetTrack(id); err != nil {
+			return 0, objectError(err)
+		}
+		return 0, nil
+	}
+}
+
+// rootContainer returns the container at the top of the ContentDirectory.
+func (ms *MediaServer) rootContainer() didlContainer {
+	childCount := int64(2)
+	return didlContainer{
+		ID:         rootID,
+		ParentID:   "-1",
+		Restricted: 1,
+		ChildCount: &childCount,
+		Title:      ms.opts.FriendlyName,
+		Class:      classStorageRoot,
+	}
+}
+
+// topContainer returns one of the containers directly under the root one.
+func (ms *MediaServer) topContainer(objectID string) didlContainer {
+	var title string
+	var childCount int64
+
+	if objectID == artistsID {
+		title = "Artists"
+		_, total := ms.library.BrowseArtists(library.BrowseArgs{PerPage: 1})
+		childCount = int64(total)
+	} else {
+		title = "Albums"
+		_, total := ms.library.BrowseAlbums(library.BrowseArgs{PerPage: 1})
+		childCount = int64(total)
+	}
+
+	return didlContainer{
+		ID:         objectID,
+		ParentID:   rootID,
+		Restricted: 1,
+		ChildCount: &childCount,
+		Title:      title,
+		Class:      classContainer,
+	}
+}
+
+func artistContainer(artist library.Artist) didlContainer {
+	return didlContainer{
+		ID:         artistPrefix + strconv.FormatInt(artist.ID, 10),
+		ParentID:   artistsID,
+		Restricted: 1,
+		Title:      artist.Name,
+		Class:      classArtist,
+		Artist:     artist.Name,
+	}
+}
+
+// albumContainer returns the container of an album which is under the container
+// with parentID.
+func albumContainer(
+	req *http.Request,
+	parentID string,
+	album library.Album,
+) didlContainer {
+	songCount := album.SongCount
+	return didlContainer{
+		ID:          albumPrefix + strconv.FormatInt(album.ID, 10),
+		ParentID:    parentID,
+		Restricted:  1,
+		ChildCount:  &songCount,
+		Title:       album.Name,
+		Class:       classAlbum,
+		Artist:      album.Artist,
+		AlbumArtURI: absoluteURL(req, "/cover/%d", album.ID),
+	}
+}
+
+// trackItem returns the item of a track. Its resource is the track's file from the
+// /file/ handler of the webserver.
+func (ms *MediaServer) trackItem(req *http.Request, track library.SearchResult) didlItem {
+	item := didlItem{
+		ID:          trackPrefix + strconv.FormatInt(track.ID, 10),
+		ParentID:    albumPrefix + strconv.FormatInt(track.AlbumID, 10),
+		Restricted:  1,
+		Title:       track.Title,
+		Creator:     track.Artist,
+		Class:       classMusicTrack,
+		Artist:      track.Artist,
+		Album:       track.Album,
+		Genre:       track.Genre,
+		TrackNumber: track.TrackNumber,
+		AlbumArtURI: absoluteURL(req, "/cover/%d", track.AlbumID),
+	}
+
+	if track.Year > 0 {
+		item.Date = fmt.Sprintf("%04d-01-01", track.Year)
+	}
+
+	filePath := ms.library.GetFilePath(track.ID)
+	item.Res = didlRes{
+		ProtocolInfo: fmt.Sprintf("http-get:*:%s:*", mimeType(filePath)),
+		URL:          absoluteURL(req, "%s", ms.filePath(track.ID)),
+	}
+
+	if track.Duration > 0 {
+		item.Res.Duration = formatDuration(track.Duration)
+	}
+
+	if st, err := os.Stat(filePath); err == nil {
+		item.Res.Size = st.Size()
+	}
+
+	return item
+}
+
+// filePath returns the path from which a track is played. It is signed when the
+// media server has a signer.
+func (ms *MediaServer) filePath(trackID int64) string {
+	path := fmt.Sprintf("/file/%d", trackID)
+
+	if ms.opts.URLSigner != nil {
+		path = ms.opts.URLSigner.Sign(path,
+			time.Now().Add(auth.DefaultSignedURLLifetime))
+	}
+
+	return path
+}
+
+// parseObjectID returns the library ID and the prefix from the ID of an artist,
+// album or track object.
+func parseObjectID(objectID string) (id int64, prefix string, ok bool) {
+	for _, prefix := range []string{artistPrefix, albumPrefix, trackPrefix} {
+		if !strings.HasPrefix(objectID, prefix) {
+			continue
+		}
+
+		id, err := strconv.ParseInt(objectID[len(prefix):], 10, 64)
+
+		if err != nil || id < 0 {
+			return 0, "", false
+		}
+
+		return id, prefix, true
+	}
+
+	return 0, "", false
+}
+
+// objectError converts the errors for missing library objects to the UPnP error for
+// missing ContentDirectory objects. All other errors are returned as they are.
+func objectError(err error) error {
+	switch err {
+	case library.ErrArtistNotFound, library.ErrAlbumNotFound, library.ErrTrackNotFound:
+		return &upnpError{upnpErrNoSuchObject, "No such object"}
+	default:
+		return err
+	}
+}
+
+// browseArgs returns the arguments for browsing the library so that the results
+// contain everything up to start+count. Zero count means everything.
+func browseArgs(start, count uint) library.BrowseArgs {
+	perPage := start + count
+	if count == 0 {
+		perPage = math.MaxInt32
+	}
+
+	return library.BrowseArgs{
+		PerPage: perPage,
+		Order:   library.OrderAsc,
+		OrderBy: library.OrderByName,
+	}
+}
+
+// window returns the bounds of the part of a slice with this length which starts
+// at start and has no more than count elements. Zero count means to the end.
+func window(start, count uint, length int) (from, to int) {
+	if start >= uint(length) {
+		return length, length
+	}
+
+	from = int(start)
+	to = length
+
+	if count > 0 && count < uint(length-from) {
+		to = from + int(count)
+	}
+
+	return from, to
+}
+
+// absoluteURL returns an URL to the webserver with the path from format and args.
+// Devices play the tracks from it so its host is the one which they used for
+// reaching the media server.
+func absoluteURL(req *http.Request, format string, args ...interface{}) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, req.Host, fmt.Sprintf(format, args...))
+}
+
+// formatDuration returns a duration in milliseconds in the H:MM:SS.mmm format of
+// DIDL-Lite resources.
+func formatDuration(ms int64) string {
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60,
+		ms%1000)
+}
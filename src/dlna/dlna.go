@@ -0,0 +1,284 @@
+// Package dlna contains an UPnP AV media server. It makes it possible for TVs, AV
+// receivers and other DLNA devices on the local network to find the library with
+// SSDP and to browse it with the ContentDirectory service. The media files
+// themselves are played from the /file/ handler of the webserver.
+package dlna
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// Types of the device and services of the media server.
+const (
+	mediaServerType       = "urn:schemas-upnp-org:device:MediaServer:1"
+	contentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// Paths of the HTTP endpoints of the media server. They are relative to the path
+// under which the MediaServer is mounted.
+const (
+	descriptionPath = "/device.xml"
+
+	contentDirectorySCPDPath    = "/ContentDirectory.xml"
+	contentDirectoryControlPath = "/control/ContentDirectory"
+	contentDirectoryEventPath   = "/events/ContentDirectory"
+
+	connectionManagerSCPDPath    = "/ConnectionManager.xml"
+	connectionManagerControlPath = "/control/ConnectionManager"
+	connectionManagerEventPath   = "/events/ConnectionManager"
+)
+
+// serverName is sent in the SERVER header of SSDP and HTTP responses as required by
+// the UPnP device architecture.
+var serverName = fmt.Sprintf("%s/%s UPnP/1.0 HTTPMS/1.0", runtime.GOOS,
+	runtime.Version())
+
+// Options configure a MediaServer.
+type Options struct {
+
+	// FriendlyName is the name under which the media server is seen by devices.
+	FriendlyName string
+
+	// UUID identifies the media server. Use DefaultUUID when there is no configured
+	// one.
+	UUID string
+
+	// BasePath is the path under which the MediaServer is mounted in the
+	// webserver, e.g. "/dlna". URLs in the device description start with it.
+	BasePath string
+
+	// HTTPPort is the port of the webserver. It is used in the device description
+	// location announced with SSDP.
+	HTTPPort int
+
+	// URLSigner signs the URLs of the tracks so that devices could play them without
+	// authentication. They are not signed when it is nil.
+	URLSigner *auth.URLSigner
+}
+
+// MediaServer is a http.Handler which serves the device description and the
+// ContentDirectory and ConnectionManager services of an UPnP media server. Devices
+// find it with the SSDP announcements made by Advertise.
+type MediaServer struct {
+	library library.Library
+	opts    Options
+}
+
+// NewMediaServer returns a media server which presents the library.
+func NewMediaServer(lib library.Library, opts Options) *MediaServer {
+	ms := new(MediaServer)
+	ms.library = lib
+	ms.opts = opts
+	ms.opts.BasePath = strings.TrimSuffix(opts.BasePath, "/")
+	return ms
+}
+
+// DefaultUUID returns an UUID for a media server with this friendly name on this
+// machine. It is the same every time so that devices recognize the server after
+// restarts.
+func DefaultUUID(friendlyName string) string {
+	hostname, _ := os.Hostname()
+	sum := md5.Sum([]byte(hostname + "\x00" + friendlyName))
+
+	// Version 3 (name based) UUID in the RFC 4122 variant.
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10],
+		sum[10:16])
+}
+
+// ServeHTTP is required by the http.Handler's interface. The MediaServer expects
+// to be mounted with http.StripPrefix for its BasePath. It answers only to requests
+// from the local network since it works without authentication and gives out
+// signed URLs of the tracks.
+func (ms *MediaServer) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	writer.Header().Set("Server", serverName)
+
+	if !localRequest(req) {
+		http.Error(writer, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch req.URL.Path {
+	case descriptionPath:
+		ms.serveXML(writer, ms.deviceDescription())
+	case contentDirectorySCPDPath:
+		ms.serveXML(writer, []byte(contentDirectorySCPD))
+	case connectionManagerSCPDPath:
+		ms.serveXML(writer, []byte(connectionManagerSCPD))
+	case contentDirectoryControlPath:
+		serveSOAP(writer, req, contentDirectoryType, ms.contentDirectoryAction)
+	case connectionManagerControlPath:
+		serveSOAP(writer, req, connectionManagerType, ms.connectionManagerAction)
+	case contentDirectoryEventPath, connectionManagerEventPath:
+		serveEventSubscription(writer, req)
+	default:
+		http.NotFound(writer, req)
+	}
+}
+
+// localRequest returns true when req comes from a loopback, link-local or private
+// address. Requests which went through proxies must have only local addresses in
+// their X-Forwarded-For header as well. Otherwise a reverse proxy on the same
+// machine would make requests from everywhere look local.
+func localRequest(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	addresses := []string{host}
+
+	for _, header := range req.Header["X-Forwarded-For"] {
+		addresses = append(addresses, strings.Split(header, ",")...)
+	}
+
+	for _, address := range addresses {
+		ip := net.ParseIP(strings.TrimSpace(address))
+
+		if ip == nil || !localIP(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// localNetworks are the private IPv4 networks from RFC 1918 and the unique local
+// IPv6 addresses from RFC 4193.
+var localNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+
+	for _, cidr := range []string{
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"fc00::/7",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(fmt.Sprintf("parsing local network %s: %s", cidr, err))
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}()
+
+// localIP returns true for loopback, link-local and private addresses.
+func localIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return true
+	}
+
+	for _, network := range localNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveXML writes an XML document.
+func (ms *MediaServer) serveXML(writer http.ResponseWriter, document []byte) {
+	writer.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+
+	if _, err := writer.Write(document); err != nil {
+		log.Printf("Error writing DLNA XML document: %s\n", err)
+	}
+}
+
+type deviceDescription struct {
+	XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	SpecVersion struct {
+		Major int `xml:"major"`
+		Minor int `xml:"minor"`
+	} `xml:"specVersion"`
+	Device struct {
+		DeviceType   string    `xml:"deviceType"`
+		FriendlyName string    `xml:"friendlyName"`
+		Manufacturer string    `xml:"manufacturer"`
+		ModelName    string    `xml:"modelName"`
+		UDN          string    `xml:"UDN"`
+		Services     []service `xml:"serviceList>service"`
+	} `xml:"device"`
+}
+
+type service struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// deviceDescription returns the UPnP device description of the media server.
+func (ms *MediaServer) deviceDescription() []byte {
+	var desc deviceDescription
+	desc.SpecVersion.Major = 1
+	desc.Device.DeviceType = mediaServerType
+	desc.Device.FriendlyName = ms.opts.FriendlyName
+	desc.Device.Manufacturer = "HTTPMS"
+	desc.Device.ModelName = "HTTPMS"
+	desc.Device.UDN = "uuid:" + ms.opts.UUID
+	desc.Device.Services = []service{
+		{
+			ServiceType: contentDirectoryType,
+			ServiceID:   "urn:upnp-org:serviceId:ContentDirectory",
+			SCPDURL:     ms.opts.BasePath + contentDirectorySCPDPath,
+			ControlURL:  ms.opts.BasePath + contentDirectoryControlPath,
+			EventSubURL: ms.opts.BasePath + contentDirectoryEventPath,
+		},
+		{
+			ServiceType: connectionManagerType,
+			ServiceID:   "urn:upnp-org:serviceId:ConnectionManager",
+			SCPDURL:     ms.opts.BasePath + connectionManagerSCPDPath,
+			ControlURL:  ms.opts.BasePath + connectionManagerControlPath,
+			EventSubURL: ms.opts.BasePath + connectionManagerEventPath,
+		},
+	}
+
+	out, err := xml.Marshal(desc)
+
+	if err != nil {
+		// It is always the same struct with strings in it.
+		panic(fmt.Sprintf("encoding DLNA device description: %s", err))
+	}
+
+	return append([]byte(xml.Header), out...)
+}
+
+// serveEventSubscription accepts subscriptions for events of the services. None of
+// their state variables ever change so there are no events to be sent. But some
+// devices refuse to use services which they cannot subscribe to.
+func serveEventSubscription(writer http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "SUBSCRIBE":
+		sid := req.Header.Get("SID")
+		if sid == "" {
+			sid = "uuid:" + DefaultUUID(req.RemoteAddr+req.Header.Get("CALLBACK"))
+		}
+		writer.Header().Set("SID", sid)
+		writer.Header().Set("TIMEOUT", "Second-1800")
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
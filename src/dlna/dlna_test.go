@@ -0,0 +1,605 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/helpers"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+const testUUID = "2fac1234-31f8-11b4-a222-08002b34c003"
+
+// didlResult is a DIDL-Lite document as seen by devices. The element names are
+// without their namespace prefixes since this is how they are decoded.
+type didlResult struct {
+	Containers []struct {
+		ID         string `xml:"id,attr"`
+		ParentID   string `xml:"parentID,attr"`
+		ChildCount int    `xml:"childCount,attr"`
+		Title      string `xml:"title"`
+		Class      string `xml:"class"`
+	} `xml:"container"`
+	Items []struct {
+		ID     string `xml:"id,attr"`
+		Title  string `xml:"title"`
+		Artist string `xml:"artist"`
+		Album  string `xml:"album"`
+		Class  string `xml:"class"`
+		Res    struct {
+			ProtocolInfo string `xml:"protocolInfo,attr"`
+			Duration     string `xml:"duration,attr"`
+			Size         int64  `xml:"size,attr"`
+			URL          string `xml:",chardata"`
+		} `xml:"res"`
+	} `xml:"item"`
+}
+
+// getMediaServer returns a media server for the test library which is mounted
+// under /dlna/ of a test HTTP server.
+func getMediaServer(t *testing.T) (*MediaServer, *httptest.Server, library.Library) {
+	projRoot, err := helpers.ProjectRoot()
+
+	if err != nil {
+		t.Fatalf("Was not able to find test_files directory: %s", err)
+	}
+
+	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatalf("Initializing library: %s", err)
+	}
+
+	lib.AddLibraryPath(filepath.Join(projRoot, "test_files", "library"))
+	lib.Scan()
+
+	ms := NewMediaServer(lib, Options{
+		FriendlyName: "Test Server",
+		UUID:         testUUID,
+		BasePath:     "/dlna/",
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/dlna/", http.StripPrefix("/dlna", ms))
+	ts := httptest.NewServer(mux)
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	ms.opts.HTTPPort, _ = strconv.Atoi(port)
+
+	return ms, ts, lib
+}
+
+// soapCall calls an action of a service at controlURL and returns the HTTP status
+// code and the body of the response.
+func soapCall(
+	t *testing.T,
+	controlURL string,
+	serviceType string,
+	action string,
+	args [][2]string,
+) (int, []byte) {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `<?xml version="1.0"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" `+
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`+
+		`<u:%s xmlns:u="%s">`, action, serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, "</u:%s></s:Body></s:Envelope>", action)
+
+	req, err := http.NewRequest(http.MethodPost, controlURL, &body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, serviceType, action))
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, respBody
+}
+
+// browse calls the Browse action of the ContentDirectory and returns the decoded
+// result and the number of all matches.
+func browse(
+	t *testing.T,
+	serverURL string,
+	objectID string,
+	flag string,
+	start, count int,
+) (didlResult, int) {
+	status, body := soapCall(t, serverURL+"/dlna/control/ContentDirectory",
+		contentDirectoryType, "Browse", [][2]string{
+			{"ObjectID", objectID},
+			{"BrowseFlag", flag},
+			{"Filter", "*"},
+			{"StartingIndex", strconv.Itoa(start)},
+			{"RequestedCount", strconv.Itoa(count)},
+			{"SortCriteria", ""},
+		})
+
+	if status != http.StatusOK {
+		t.Fatalf("Browsing %s returned status %d: %s", objectID, status, body)
+	}
+
+	var envelope struct {
+		Response struct {
+			Result         string `xml:"Result"`
+			NumberReturned int    `xml:"NumberReturned"`
+			TotalMatches   int    `xml:"TotalMatches"`
+		} `xml:"Body>BrowseResponse"`
+	}
+
+	if err := xml.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("Decoding Browse response: %s", err)
+	}
+
+	var result didlResult
+	if err := xml.Unmarshal([]byte(envelope.Response.Result), &result); err != nil {
+		t.Fatalf("Decoding DIDL-Lite: %s", err)
+	}
+
+	returned := len(result.Containers) + len(result.Items)
+	if returned != envelope.Response.NumberReturned {
+		t.Errorf("NumberReturned is %d but there were %d objects",
+			envelope.Response.NumberReturned, returned)
+	}
+
+	return result, envelope.Response.TotalMatches
+}
+
+// Tests that the media server is found with SSDP and that its device description is
+// at the announced location.
+func TestSSDPDiscovery(t *testing.T) {
+	ms, ts, lib := getMediaServer(t)
+	defer lib.Truncate()
+	defer ts.Close()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Announcements go to this socket instead of the multicast group.
+	notifications, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifications.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	advertiseErr := make(chan error)
+	go func() {
+		advertiseErr <- ms.advertise(ctx, conn, notifications.LocalAddr())
+	}()
+
+	readMessage := func(pc net.PacketConn) []byte {
+		buf := make([]byte, 2048)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Reading SSDP message: %s", err)
+		}
+		return buf[:n]
+	}
+
+	expectedLocation := ts.URL + "/dlna/device.xml"
+	alive := make(map[string]bool)
+
+	for i := 0; i < len(ms.ssdpTargets()); i++ {
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(
+			readMessage(notifications))))
+
+		if err != nil {
+			t.Fatalf("Malformed NOTIFY: %s", err)
+		}
+
+		if req.Method != "NOTIFY" || req.Header.Get("NTS") != "ssdp:alive" {
+			t.Errorf("Expected alive notification but got %s %s", req.Method,
+				req.Header.Get("NTS"))
+		}
+
+		if location := req.Header.Get("LOCATION"); location != expectedLocation {
+			t.Errorf("Expected location %s but it was %s", expectedLocation, location)
+		}
+
+		alive[req.Header.Get("NT")] = true
+	}
+
+	if !alive[mediaServerType] || !alive["upnp:rootdevice"] {
+		t.Errorf("Media server was not announced. Notifications for: %v", alive)
+	}
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + mediaServerType + "\r\n" +
+		"\r\n"
+
+	if _, err := client.WriteTo([]byte(search), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(
+		readMessage(client))), nil)
+
+	if err != nil {
+		t.Fatalf("Malformed M-SEARCH response: %s", err)
+	}
+
+	if resp.Header.Get("ST") != mediaServerType {
+		t.Errorf("Wrong search target in response: %s", resp.Header.Get("ST"))
+	}
+
+	expectedUSN := "uuid:" + testUUID + "::" + mediaServerType
+	if resp.Header.Get("USN") != expectedUSN {
+		t.Errorf("Expected USN %s but it was %s", expectedUSN, resp.Header.Get("USN"))
+	}
+
+	location := resp.Header.Get("LOCATION")
+	if location != expectedLocation {
+		t.Fatalf("Expected location %s but it was %s", expectedLocation, location)
+	}
+
+	descResp, err := http.Get(location)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer descResp.Body.Close()
+
+	var desc deviceDescription
+	if err := xml.NewDecoder(descResp.Body).Decode(&desc); err != nil {
+		t.Fatalf("Decoding device description: %s", err)
+	}
+
+	if desc.Device.UDN != "uuid:"+testUUID {
+		t.Errorf("Wrong UDN in device description: %s", desc.Device.UDN)
+	}
+
+	if desc.Device.FriendlyName != "Test Server" {
+		t.Errorf("Wrong friendly name: %s", desc.Device.FriendlyName)
+	}
+
+	for _, srv := range desc.Device.Services {
+		scpdURL, _ := url.Parse(location)
+		scpdURL.Path = srv.SCPDURL
+
+		scpdResp, err := http.Get(scpdURL.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		scpdResp.Body.Close()
+
+		if scpdResp.StatusCode != http.StatusOK {
+			t.Errorf("SCPD of %s returned %d", srv.ServiceType, scpdResp.StatusCode)
+		}
+	}
+
+	cancel()
+
+	byebye, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(
+		readMessage(notifications))))
+
+	if err != nil || byebye.Header.Get("NTS") != "ssdp:byebye" {
+		t.Errorf("Expected byebye notification after stopping")
+	}
+
+	select {
+	case err := <-advertiseErr:
+		if err != nil {
+			t.Errorf("Advertising returned error after stopping: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Advertising did not stop on time")
+	}
+}
+
+// Tests browsing the ContentDirectory from its root down to a track which is
+// played from the /file/ handler.
+func TestBrowsingContentDirectory(t *testing.T) {
+	_, ts, lib := getMediaServer(t)
+	defer lib.Truncate()
+	defer ts.Close()
+
+	root, total := browse(t, ts.URL, rootID, "BrowseDirectChildren", 0, 0)
+
+	if total != 2 || len(root.Containers) != 2 {
+		t.Fatalf("Expected artists and albums in the root but got %+v", root)
+	}
+
+	if root.Containers[0].ID != artistsID || root.Containers[1].ID != albumsID {
+		t.Errorf("Unexpected root containers %+v", root.Containers)
+	}
+
+	artists, total := browse(t, ts.URL, artistsID, "BrowseDirectChildren", 0, 0)
+
+	if total != len(artists.Containers) {
+		t.Errorf("Expected %d artists but there were %d", total,
+			len(artists.Containers))
+	}
+
+	var artistID string
+	for _, artist := range artists.Containers {
+		if artist.Title == "Buggy Bugoff" {
+			artistID = artist.ID
+		}
+	}
+
+	if artistID == "" {
+		t.Fatalf("Artist Buggy Bugoff was not found in %+v", artists.Containers)
+	}
+
+	albums, _ := browse(t, ts.URL, artistID, "BrowseDirectChildren", 0, 0)
+
+	if len(albums.Containers) != 1 || albums.Containers[0].Title != "Return Of The Bugs" {
+		t.Fatalf("Unexpected albums of Buggy Bugoff: %+v", albums.Containers)
+	}
+
+	album := albums.Containers[0]
+	if album.Class != classAlbum || album.ParentID != artistID {
+		t.Errorf("Unexpected album container %+v", album)
+	}
+
+	tracks, total := browse(t, ts.URL, album.ID, "BrowseDirectChildren", 0, 0)
+
+	if total != 1 || len(tracks.Items) != 1 {
+		t.Fatalf("Expected one track in %s but got %+v", album.ID, tracks)
+	}
+
+	track := tracks.Items[0]
+
+	if track.Title != "Payback" || track.Artist != "Buggy Bugoff" ||
+		track.Class != classMusicTrack {
+		t.Errorf("Unexpected track item %+v", track)
+	}
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one track for Payback but got %d", len(found))
+	}
+
+	expectedURL := fmt.Sprintf("%s/file/%d", ts.URL, found[0].ID)
+	if track.Res.URL != expectedURL {
+		t.Errorf("Expected resource URL %s but it was %s", expectedURL, track.Res.URL)
+	}
+
+	if track.Res.ProtocolInfo != "http-get:*:audio/mpeg:*" {
+		t.Errorf("Unexpected protocol info %s", track.Res.ProtocolInfo)
+	}
+
+	if track.Res.Size != 17314 {
+		t.Errorf("Expected resource size 17314 but it was %d", track.Res.Size)
+	}
+
+	metadata, _ := browse(t, ts.URL, track.ID, "BrowseMetadata", 0, 0)
+
+	if len(metadata.Items) != 1 || metadata.Items[0].Title != "Payback" {
+		t.Errorf("Unexpected metadata of %s: %+v", track.ID, metadata)
+	}
+
+	metadata, _ = browse(t, ts.URL, album.ID, "BrowseMetadata", 0, 0)
+
+	if len(metadata.Containers) != 1 || metadata.Containers[0].ChildCount != 1 {
+		t.Errorf("Unexpected metadata of %s: %+v", album.ID, metadata)
+	}
+}
+
+// The media server works without authentication so it must not be available and
+// must not give out signed URLs of the tracks outside of the local network.
+func TestMediaServerOnlyForLocalNetwork(t *testing.T) {
+	ms, ts, lib := getMediaServer(t)
+	defer lib.Truncate()
+	defer ts.Close()
+
+	ms.opts.URLSigner = auth.NewURLSigner([]byte("secret"))
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one track for Payback but got %d", len(found))
+	}
+
+	browseTrack := func(remoteAddr string, forwarded ...string) (int, string) {
+		body := fmt.Sprintf(`<?xml version="1.0"?>`+
+			`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">`+
+			`<s:Body><u:Browse xmlns:u="%s"><ObjectID>%s%d</ObjectID>`+
+			`<BrowseFlag>BrowseMetadata</BrowseFlag><Filter>*</Filter>`+
+			`<StartingIndex>0</StartingIndex><RequestedCount>0</RequestedCount>`+
+			`<SortCriteria></SortCriteria></u:Browse></s:Body></s:Envelope>`,
+			contentDirectoryType, trackPrefix, found[0].ID)
+
+		req := httptest.NewRequest(http.MethodPost, contentDirectoryControlPath,
+			strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#Browse"`, contentDirectoryType))
+
+		for _, address := range forwarded {
+			req.Header.Add("X-Forwarded-For", address)
+		}
+
+		rec := httptest.NewRecorder()
+		ms.ServeHTTP(rec, req)
+
+		return rec.Code, rec.Body.String()
+	}
+
+	for _, remoteAddr := range []string{
+		"127.0.0.1:4000",
+		"192.168.1.20:4000",
+		"10.1.2.3:4000",
+		"172.20.0.5:4000",
+		"[fe80::1]:4000",
+		"[fd12:3456::1]:4000",
+	} {
+		status, body := browseTrack(remoteAddr)
+
+		if status != http.StatusOK || !strings.Contains(body, "sig=") {
+			t.Errorf("Expected a signed track URL for %s but got %d: %s", remoteAddr,
+				status, body)
+		}
+	}
+
+	for _, test := range []struct {
+		remoteAddr string
+		forwarded  []string
+	}{
+		{"198.51.100.7:4000", nil},
+		{"[2001:db8::1]:4000", nil},
+		{"172.32.0.1:4000", nil},
+		{"127.0.0.1:4000", []string{"198.51.100.7"}},
+		{"127.0.0.1:4000", []string{"192.168.1.20, 198.51.100.7"}},
+	} {
+		status, body := browseTrack(test.remoteAddr, test.forwarded...)
+
+		if status != http.StatusForbidden {
+			t.Errorf("Expected status %d for %s with %q but got %d",
+				http.StatusForbidden, test.remoteAddr, test.forwarded, status)
+		}
+
+		if strings.Contains(body, "/file/") || strings.Contains(body, "sig=") {
+			t.Errorf("Track URL was given to %s with %q: %s", test.remoteAddr,
+				test.forwarded, body)
+		}
+	}
+}
+
+// Tests that StartingIndex and RequestedCount of Browse select part of the children.
+func TestBrowsingPages(t *testing.T) {
+	_, ts, lib := getMediaServer(t)
+	defer lib.Truncate()
+	defer ts.Close()
+
+	all, total := browse(t, ts.URL, albumsID, "BrowseDirectChildren", 0, 0)
+
+	if total < 2 || len(all.Containers) != total {
+		t.Fatalf("Expected all of the albums but got %d of %d", len(all.Containers),
+			total)
+	}
+
+	page, pageTotal := browse(t, ts.URL, albumsID, "BrowseDirectChildren", 1, 1)
+
+	if pageTotal != total {
+		t.Errorf("Expected TotalMatches %d for a page but it was %d", total,
+			pageTotal)
+	}
+
+	if len(page.Containers) != 1 {
+		t.Fatalf("Expected 1 album in the page but got %d", len(page.Containers))
+	}
+
+	for i, album := range page.Containers {
+		if album.ID != all.Containers[i+1].ID {
+			t.Errorf("Album %d of the page was %s instead of %s", i, album.ID,
+				all.Containers[i+1].ID)
+		}
+	}
+
+	past, _ := browse(t, ts.URL, albumsID, "BrowseDirectChildren", total+5, 2)
+
+	if len(past.Containers) != 0 {
+		t.Errorf("Expected no albums past the end but got %d", len(past.Containers))
+	}
+}
+
+// Tests the errors of the ContentDirectory and the ConnectionManager services.
+func TestActionErrors(t *testing.T) {
+	_, ts, lib := getMediaServer(t)
+	defer lib.Truncate()
+	defer ts.Close()
+
+	expectUPnPError := func(controlURL, serviceType, action string, args [][2]string,
+		code int) {
+		status, body := soapCall(t, ts.URL+controlURL, serviceType, action, args)
+
+		if status != http.StatusInternalServerError {
+			t.Errorf("Expected status 500 for %s but it was %d", action, status)
+		}
+
+		expected := fmt.Sprintf("<errorCode>%d</errorCode>", code)
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected error code %d for %s but got %s", code, action, body)
+		}
+	}
+
+	browseArgs := func(objectID string) [][2]string {
+		return [][2]string{
+			{"ObjectID", objectID},
+			{"BrowseFlag", "BrowseDirectChildren"},
+			{"StartingIndex", "0"},
+			{"RequestedCount", "0"},
+		}
+	}
+
+	cdURL := "/dlna/control/ContentDirectory"
+	cmURL := "/dlna/control/ConnectionManager"
+
+	expectUPnPError(cdURL, contentDirectoryType, "Browse", browseArgs("album-31337"),
+		upnpErrNoSuchObject)
+	expectUPnPError(cdURL, contentDirectoryType, "Browse", browseArgs("no-such"),
+		upnpErrNoSuchObject)
+	expectUPnPError(cdURL, contentDirectoryType, "Browse", [][2]string{
+		{"ObjectID", rootID},
+		{"BrowseFlag", "BrowseEverything"},
+		{"StartingIndex", "0"},
+		{"RequestedCount", "0"},
+	}, upnpErrInvalidArgs)
+	expectUPnPError(cdURL, contentDirectoryType, "DestroyObject", nil,
+		upnpErrInvalidAction)
+	expectUPnPError(cdURL, connectionManagerType, "GetProtocolInfo", nil,
+		upnpErrInvalidAction)
+	expectUPnPError(cmURL, connectionManagerType, "GetCurrentConnectionInfo",
+		[][2]string{{"ConnectionID", "5"}}, upnpErrInvalidConnection)
+
+	status, body := soapCall(t, ts.URL+cmURL, connectionManagerType,
+		"GetProtocolInfo", nil)
+
+	if status != http.StatusOK || !strings.Contains(string(body), "audio/mpeg") {
+		t.Errorf("Unexpected GetProtocolInfo response %d: %s", status, body)
+	}
+}
@@ -0,0 +1,160 @@
+package dlna
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// maxSOAPRequestSize is the maximum size of the body of SOAP requests. Actions of the
+// media server have only a few short arguments.
+const maxSOAPRequestSize = 64 * 1024
+
+// Error codes of UPnP actions. The first ones are from the UPnP device architecture
+// and the rest are specific for the ContentDirectory and ConnectionManager services.
+const (
+	upnpErrInvalidAction     = 401
+	upnpErrInvalidArgs       = 402
+	upnpErrActionFailed      = 501
+	upnpErrNoSuchObject      = 701
+	upnpErrInvalidConnection = 706
+)
+
+// upnpError is an error returned by the actions of the services. It is sent to the
+// device as a SOAP fault.
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+// Error satisfies the error interface.
+func (ue *upnpError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", ue.Code, ue.Description)
+}
+
+// soapArg is a single argument of an action or its response.
+type soapArg struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []soapArg `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// actionHandler runs a single action of a service. It receives the HTTP request, the
+// action's name and its arguments and returns the output arguments in the order in
+// which they have to be sent.
+type actionHandler func(
+	req *http.Request,
+	action string,
+	args map[string]string,
+) ([]soapArg, error)
+
+// serveSOAP decodes a SOAP action request for the service of this type, runs it with
+// handler and writes its response or a SOAP fault.
+func serveSOAP(
+	writer http.ResponseWriter,
+	req *http.Request,
+	serviceType string,
+	handler actionHandler,
+) {
+	if req.Method != http.MethodPost {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var envelope soapEnvelope
+	body := io.LimitReader(req.Body, maxSOAPRequestSize)
+
+	if err := xml.NewDecoder(body).Decode(&envelope); err != nil {
+		http.Error(writer, fmt.Sprintf("Malformed SOAP request: %s", err),
+			http.StatusBadRequest)
+		return
+	}
+
+	action := envelope.Body.Action
+	args := make(map[string]string)
+	for _, arg := range action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+
+	var out []soapArg
+	var err error
+
+	if action.XMLName.Space != serviceType {
+		err = &upnpError{upnpErrInvalidAction, "Invalid Action"}
+	} else {
+		out, err = handler(req, action.XMLName.Local, args)
+	}
+
+	writer.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	writer.Header().Set("Ext", "")
+
+	if err != nil {
+		writeSOAPFault(writer, err)
+		return
+	}
+
+	var response bytes.Buffer
+	response.WriteString(xml.Header)
+	response.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"` +
+		` s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&response, `<u:%sResponse xmlns:u="%s">`, action.XMLName.Local,
+		serviceType)
+
+	for _, arg := range out {
+		fmt.Fprintf(&response, "<%s>", arg.XMLName.Local)
+		xml.EscapeText(&response, []byte(arg.Value))
+		fmt.Fprintf(&response, "</%s>", arg.XMLName.Local)
+	}
+
+	fmt.Fprintf(&response, `</u:%sResponse></s:Body></s:Envelope>`,
+		action.XMLName.Local)
+
+	if _, err := writer.Write(response.Bytes()); err != nil {
+		log.Printf("Error writing DLNA SOAP response: %s\n", err)
+	}
+}
+
+// writeSOAPFault writes the SOAP fault for an error returned by an action. Errors
+// which are not upnpError are logged and reported as failed actions.
+func writeSOAPFault(writer http.ResponseWriter, err error) {
+	upnpErr, ok := err.(*upnpError)
+
+	if !ok {
+		log.Printf("Error in DLNA action: %s\n", err)
+		upnpErr = &upnpError{upnpErrActionFailed, "Action Failed"}
+	}
+
+	var response bytes.Buffer
+	response.WriteString(xml.Header)
+	response.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"` +
+		` s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>` +
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`)
+	fmt.Fprintf(&response, "<errorCode>%d</errorCode><errorDescription>",
+		upnpErr.Code)
+	xml.EscapeText(&response, []byte(upnpErr.Description))
+	response.WriteString(`</errorDescription></UPnPError></detail></s:Fault>` +
+		`</s:Body></s:Envelope>`)
+
+	writer.WriteHeader(http.StatusInternalServerError)
+
+	if _, err := writer.Write(response.Bytes()); err != nil {
+		log.Printf("Error writing DLNA SOAP fault: %s\n", err)
+	}
+}
+
+// outArg returns an output argument of an action.
+func outArg(name, value string) soapArg {
+	return soapArg{XMLName: xml.Name{Local: name}, Value: value}
+}
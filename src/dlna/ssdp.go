@@ -0,0 +1,226 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ssdpAddr is the multicast group and port of the Simple Service Discovery Protocol.
+const ssdpAddr = "239.255.255.250:1900"
+
+// ssdpMaxAge is the number of seconds for which devices may remember the
+// announcements of the media server. They are repeated twice as often.
+const ssdpMaxAge = 1800
+
+// ssdpMaxResponseDelay caps the random delay before answering M-SEARCH requests. It
+// is the maximum of the MX header which is respected.
+const ssdpMaxResponseDelay = 5 * time.Second
+
+// Advertise announces the media server on the local network and answers the
+// searches of devices until ctx is done. Then the media server says goodbye so that
+// devices forget about it. It always returns a non-nil error unless ctx is done.
+func (ms *MediaServer) Advertise(ctx context.Context) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+
+	if err != nil {
+		return fmt.Errorf("joining the SSDP multicast group: %s", err)
+	}
+
+	return ms.advertise(ctx, conn, group)
+}
+
+// advertise reads the SSDP searches from conn and answers them. The announcements
+// of the media server are sent to notifyAddr. conn is closed when ctx is done.
+func (ms *MediaServer) advertise(
+	ctx context.Context,
+	conn net.PacketConn,
+	notifyAddr net.Addr,
+) error {
+	go func() {
+		ticker := time.NewTicker(ssdpMaxAge / 2 * time.Second)
+		defer ticker.Stop()
+
+		ms.notify(conn, notifyAddr, "ssdp:alive")
+
+		for {
+			select {
+			case <-ticker.C:
+				ms.notify(conn, notifyAddr, "ssdp:alive")
+			case <-ctx.Done():
+				ms.notify(conn, notifyAddr, "ssdp:byebye")
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 2048)
+
+	for {
+		n, remote, err := conn.ReadFrom(buf)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("reading SSDP requests: %s", err)
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+
+		if err != nil || req.Method != "M-SEARCH" ||
+			req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+
+		go ms.answerSearch(ctx, conn, remote, req)
+	}
+}
+
+// answerSearch answers a M-SEARCH request with all of the matching targets of the
+// media server. The answer is delayed up to the number of seconds in the MX header
+// so that devices are not flooded with answers.
+func (ms *MediaServer) answerSearch(
+	ctx context.Context,
+	conn net.PacketConn,
+	remote net.Addr,
+	req *http.Request,
+) {
+	var targets []string
+	searchTarget := req.Header.Get("ST")
+
+	for _, target := range ms.ssdpTargets() {
+		if searchTarget == "ssdp:all" || searchTarget == target {
+			targets = append(targets, target)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+
+	var delay time.Duration
+	if mx, err := strconv.Atoi(req.Header.Get("MX")); err == nil && mx > 0 {
+		maxDelay := time.Duration(mx) * time.Second
+		if maxDelay > ssdpMaxResponseDelay {
+			maxDelay = ssdpMaxResponseDelay
+		}
+		delay = time.Duration(rand.Int63n(int64(maxDelay)))
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return
+	}
+
+	location := ms.location(remote)
+
+	for _, target := range targets {
+		msg := fmt.Sprintf("HTTP/1.1 200 OK\r\n"+
+			"CACHE-CONTROL: max-age=%d\r\n"+
+			"DATE: %s\r\n"+
+			"EXT:\r\n"+
+			"LOCATION: %s\r\n"+
+			"SERVER: %s\r\n"+
+			"ST: %s\r\n"+
+			"USN: %s\r\n"+
+			"\r\n",
+			ssdpMaxAge, time.Now().UTC().Format(http.TimeFormat), location,
+			serverName, target, ms.usn(target))
+
+		if _, err := conn.WriteTo([]byte(msg), remote); err != nil {
+			log.Printf("Error answering SSDP search: %s\n", err)
+			return
+		}
+	}
+}
+
+// notify sends the SSDP announcements of all targets of the media server with this
+// notification sub type. It is either "ssdp:alive" or "ssdp:byebye".
+func (ms *MediaServer) notify(conn net.PacketConn, addr net.Addr, nts string) {
+	location := ms.location(addr)
+
+	for _, target := range ms.ssdpTargets() {
+		var msg string
+
+		if nts == "ssdp:alive" {
+			msg = fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+				"HOST: %s\r\n"+
+				"CACHE-CONTROL: max-age=%d\r\n"+
+				"LOCATION: %s\r\n"+
+				"NT: %s\r\n"+
+				"NTS: %s\r\n"+
+				"SERVER: %s\r\n"+
+				"USN: %s\r\n"+
+				"\r\n",
+				ssdpAddr, ssdpMaxAge, location, target, nts, serverName,
+				ms.usn(target))
+		} else {
+			msg = fmt.Sprintf("NOTIFY * HTTP/1.1\r\n"+
+				"HOST: %s\r\n"+
+				"NT: %s\r\n"+
+				"NTS: %s\r\n"+
+				"USN: %s\r\n"+
+				"\r\n",
+				ssdpAddr, target, nts, ms.usn(target))
+		}
+
+		if _, err := conn.WriteTo([]byte(msg), addr); err != nil {
+			log.Printf("Error sending SSDP notification: %s\n", err)
+			return
+		}
+	}
+}
+
+// ssdpTargets returns all notification and search targets of the media server.
+func (ms *MediaServer) ssdpTargets() []string {
+	return []string{
+		"upnp:rootdevice",
+		"uuid:" + ms.opts.UUID,
+		mediaServerType,
+		contentDirectoryType,
+		connectionManagerType,
+	}
+}
+
+// usn returns the unique service name of a target of the media server.
+func (ms *MediaServer) usn(target string) string {
+	udn := "uuid:" + ms.opts.UUID
+
+	if target == udn {
+		return udn
+	}
+
+	return udn + "::" + target
+}
+
+// location returns the URL of the device description for a device at this address.
+// Its host is the local address from which the device is reachable.
+func (ms *MediaServer) location(remote net.Addr) string {
+	host := "127.0.0.1"
+
+	if conn, err := net.Dial("udp4", remote.String()); err == nil {
+		host, _, _ = net.SplitHostPort(conn.LocalAddr().String())
+		conn.Close()
+	}
+
+	return fmt.Sprintf("http://%s%s%s",
+		net.JoinHostPort(host, strconv.Itoa(ms.opts.HTTPPort)),
+		ms.opts.BasePath, descriptionPath)
+}
@@ -224,6 +224,22 @@ func GuessTrackNumber(trackFilePath string) int64 {
 	return 0
 }
 
+// discDirectoryMatcher matches directory names used for the separate discs of
+// multi-disc albums. Such as "CD1", "Disc 2" or "disk_3 - Live".
+var discDirectoryMatcher = regexp.MustCompile(`(?i)^(cd|dis[ck])[ _\-\.]*\d+(\W.*)?$`)
+
+// AlbumDirectory returns the directory of the album which has its media files in
+// the directory fileDir. For multi-disc albums which have separate directories for
+// every disc this is their parent directory. For everything else it is fileDir
+// itself.
+func AlbumDirectory(fileDir string) string {
+	if !discDirectoryMatcher.MatchString(filepath.Base(fileDir)) {
+		return fileDir
+	}
+
+	return filepath.Dir(fileDir)
+}
+
 func stringToInt64OrZero(str string) int64 {
 	num, err := strconv.ParseInt(str, 10, 64)
 	if err != nil {
@@ -100,3 +100,35 @@ func TestTrackNumberGuessing(t *testing.T) {
 		}
 	}
 }
+
+func TestAlbumDirectory(t *testing.T) {
+	var tests = []struct {
+		path     string
+		expected string
+	}{
+		{`/music/Pink Floyd/The Wall/CD1`, `/music/Pink Floyd/The Wall`},
+		{`/music/Pink Floyd/The Wall/cd 2`, `/music/Pink Floyd/The Wall`},
+		{`/music/Pink Floyd/The Wall/Disc 1`, `/music/Pink Floyd/The Wall`},
+		{`/music/Pink Floyd/The Wall/disk_02`, `/music/Pink Floyd/The Wall`},
+		{`/music/Pink Floyd/Pulse/CD2 - Dark Side`, `/music/Pink Floyd/Pulse`},
+		{`/music/Pink Floyd/Pulse/Disc.1`, `/music/Pink Floyd/Pulse`},
+
+		// Those are not disc directories
+		{`/music/Pink Floyd/The Wall`, `/music/Pink Floyd/The Wall`},
+		{`/music/Discharge/Hear Nothing`, `/music/Discharge/Hear Nothing`},
+		{`/music/CDs/Rock`, `/music/CDs/Rock`},
+		{`/music/Discs`, `/music/Discs`},
+		{`/music/CD Collection`, `/music/CD Collection`},
+		{`/music/Disco 2000`, `/music/Disco 2000`},
+	}
+
+	for _, test := range tests {
+		found := AlbumDirectory(filepath.FromSlash(test.path))
+		expected := filepath.FromSlash(test.expected)
+
+		if found != expected {
+			t.Errorf("Album directory for `%s`. Expected `%s` but got `%s`.",
+				test.path, expected, found)
+		}
+	}
+}
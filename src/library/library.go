@@ -5,6 +5,55 @@
 // way the real location of the file is never revealed to the interface.
 package library
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrCoverNotFound is returned when an album does not have a cover.
+var ErrCoverNotFound = errors.New("Cover not found")
+
+// ErrTrackNotFound is returned when there is no track with a particular ID.
+var ErrTrackNotFound = errors.New("Track not found")
+
+// ErrAlbumNotFound is returned when there is no album with a particular ID.
+var ErrAlbumNotFound = errors.New("Album not found")
+
+// ErrArtistNotFound is returned when there is no artist with a particular ID.
+var ErrArtistNotFound = errors.New("Artist not found")
+
+// ErrPlaylistNotFound is returned when there is no playlist with a particular ID.
+var ErrPlaylistNotFound = errors.New("Playlist not found")
+
+// ErrPlaylistReadOnly is returned when trying to change the tracks of a smart
+// playlist. They are always found by the playlist's rules.
+var ErrPlaylistReadOnly = errors.New("Smart playlists cannot be changed")
+
+// ErrPlaylistEntryNotFound is returned when a playlist does not have an entry at a
+// particular position.
+var ErrPlaylistEntryNotFound = errors.New("Playlist entry not found")
+
+// ErrUserNotFound is returned when there is no user with a particular name.
+var ErrUserNotFound = errors.New("User not found")
+
+// ErrUserExists is returned when creating a user with a name which is already taken.
+var ErrUserExists = errors.New("User already exists")
+
+// ErrUnknownRole is returned when creating a user with a role other than RoleAdmin
+// and RoleListener.
+var ErrUnknownRole = errors.New("Unknown user role")
+
+// ErrAPITokenNotFound is returned when there is no API token with a particular ID
+// or hash.
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// The roles of users. Admins could do everything while listeners could only change
+// their own playlists.
+const (
+	RoleAdmin    = "admin"
+	RoleListener = "listener"
+)
+
 // SearchResult contains a result for a search term. Contains all the neccessery
 // information to uniquely identify a media in the library.
 type SearchResult struct {
@@ -15,6 +64,9 @@ type SearchResult struct {
 	// Meta info: Artist
 	Artist string `json:"artist"`
 
+	// Meta info: Artist ID
+	ArtistID int64 `json:"artist_id"`
+
 	// Meta info: Album ID
 	AlbumID int64 `json:"album_id"`
 
@@ -26,6 +78,24 @@ type SearchResult struct {
 
 	// Meta info: track number for music
 	TrackNumber int64 `json:"track"`
+
+	// Meta info: the length of this media file in milliseconds
+	Duration int64 `json:"duration"`
+
+	// Meta info: the year in which this media was released
+	Year int64 `json:"year"`
+
+	// Meta info: genre of this media file
+	Genre string `json:"genre"`
+
+	// Meta info: number of the disc from the album on which this track is
+	DiscNumber int64 `json:"disc"`
+
+	// Meta info: the artist responsible for the whole album
+	AlbumArtist string `json:"album_artist"`
+
+	// Meta info: composer of this media file
+	Composer string `json:"composer"`
 }
 
 // Artist represents an artist from the database
@@ -36,9 +106,76 @@ type Artist struct {
 
 // Album represents an album from the database
 type Album struct {
-	ID     int64  `json:"album_id"`
-	Name   string `json:"album"`
-	Artist string `json:"artist"`
+	ID       int64  `json:"album_id"`
+	Name     string `json:"album"`
+	Artist   string `json:"artist"`
+	Duration int64  `json:"duration"` // The sum of all track durations in milliseconds
+
+	// SongCount is the number of tracks in the album.
+	SongCount int64 `json:"song_count"`
+
+	// Year is the latest year of the album's tracks.
+	Year int64 `json:"year"`
+}
+
+// Playlist is a named list of tracks which is stored in the library.
+type Playlist struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Duration int64  `json:"duration"` // The sum of all track durations in milliseconds
+
+	// TrackCount is the number of entries in the playlist.
+	TrackCount int64 `json:"track_count"`
+
+	// Rules are set only for smart playlists. Their tracks are the ones matched by
+	// the rules at the moment and cannot be changed.
+	Rules *SmartRules `json:"rules,omitempty"`
+
+	// OwnerID is the ID of the user who created the playlist. It is zero for
+	// playlists without owner such as the imported ones.
+	OwnerID int64 `json:"owner_id"`
+}
+
+// User is an account with which HTTPMS could be used.
+type User struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"` // RoleAdmin or RoleListener
+
+	// PasswordHash is the hash of the user's password as created by
+	// auth.HashPassword.
+	PasswordHash string `json:"-"`
+}
+
+// IsAdmin returns true when the user has the admin role.
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// APIToken is a long-lived credential of a user for a single client. Only the hash
+// of the token is stored. Times are Unix timestamps.
+type APIToken struct {
+	ID       int64  `json:"id"`
+	Label    string `json:"label"`
+	ReadOnly bool   `json:"read_only"`
+
+	CreatedAt int64 `json:"created_at"`
+	LastUsed  int64 `json:"last_used"` // Zero when the token has not been used
+
+	// UserID is the ID of the user to whom the token belongs. It is zero for the
+	// user from the configuration.
+	UserID int64 `json:"-"`
+
+	// UserName is the name of the user to whom the token belongs. It is empty for
+	// the user from the configuration.
+	UserName string `json:"-"`
+}
+
+// Cover is an image with the artwork of an album.
+type Cover struct {
+	Data     []byte
+	MIMEType string
+	ModTime  time.Time // The last time the image has been changed
 }
 
 // BrowseOrder represents different strategies which can be made with respect to the
@@ -81,6 +218,19 @@ type BrowseArgs struct {
 	OrderBy BrowseOrderBy
 }
 
+// SearchArgs defines all arguments one can pass to the Search method.
+type SearchArgs struct {
+
+	// Query is the search query. Its syntax is described in ParseSearchQuery.
+	Query string
+
+	// Offset is the number of results which will be skipped from the beginning.
+	Offset uint
+
+	// Limit is the maximum number of returned results. Zero means no limit.
+	Limit uint
+}
+
 // Library represents the media library which is played using the HTTPMS.
 // It is responsible for scaning the library directories, watching for new files,
 // actually searching for a media by a search term and finding the exact file path
@@ -91,10 +241,22 @@ type Library interface {
 	// will be started.
 	AddLibraryPath(string)
 
-	// Search the library using a search string. It will match against Artist, Album
-	// and Title. Will OR the results. So it is "return anything which Artist matches or
-	// Album matches or Title matches"
-	Search(string) []SearchResult
+	// Search the library using a search query. Its syntax is described in
+	// ParseSearchQuery. Words without qualifiers will match against Artist, Album
+	// and Title. Malformed queries match nothing so they should be checked with
+	// ParseSearchQuery first. Returns the results between the offset and limit from
+	// the arguments and the number of all results for the query.
+	Search(SearchArgs) ([]SearchResult, int)
+
+	// SearchArtists finds the artists of the tracks matching the search query. Words
+	// without qualifiers match only against the artist. Returns the artists between
+	// the offset and limit from the arguments and the number of all found artists.
+	SearchArtists(SearchArgs) ([]Artist, int)
+
+	// SearchAlbums finds the albums of the tracks matching the search query. Words
+	// without qualifiers match only against the album. Returns the albums between
+	// the offset and limit from the arguments and the number of all found albums.
+	SearchAlbums(SearchArgs) ([]Album, int)
 
 	// BrowseArtists makes it possible to browse through the library artists page by page.
 	// Returns a list of artists for particular page and the number of all artists in the
@@ -106,12 +268,112 @@ type Library interface {
 	// library.
 	BrowseAlbums(BrowseArgs) ([]Album, int)
 
+	// Returns the artist with this ID. When there is no such artist ErrArtistNotFound
+	// is returned.
+	GetArtist(int64) (*Artist, error)
+
+	// Returns all albums which have tracks by this artist. Requires the artist ID.
+	GetArtistAlbums(int64) []Album
+
+	// Returns the album with this ID. When there is no such album ErrAlbumNotFound
+	// is returned.
+	GetAlbum(int64) (*Album, error)
+
 	// Returns the real filesystem path. Requires the media ID.
 	GetFilePath(int64) string
 
 	// Returns search result will all the files of this album
 	GetAlbumFiles(int64) []SearchResult
 
+	// Returns the track with this ID. When there is no such track ErrTrackNotFound
+	// is returned.
+	GetTrack(int64) (*SearchResult, error)
+
+	// Returns the cover image of an album. Requires the album ID. When the album
+	// has no cover ErrCoverNotFound is returned.
+	GetAlbumCover(int64) (*Cover, error)
+
+	// Returns all playlists ordered by their names.
+	GetPlaylists() []Playlist
+
+	// Returns the playlist with this ID. When there is no such playlist
+	// ErrPlaylistNotFound is returned.
+	GetPlaylist(int64) (*Playlist, error)
+
+	// Creates an empty playlist with this name and returns it. The ownerID is the ID
+	// of the user who creates it or zero.
+	CreatePlaylist(name string, ownerID int64) (*Playlist, error)
+
+	// Creates a smart playlist with this name and rules and returns it. The ownerID
+	// is the ID of the user who creates it or zero.
+	CreateSmartPlaylist(name string, rules SmartRules, ownerID int64) (*Playlist, error)
+
+	// Changes the rules of the smart playlist with this ID. ErrPlaylistNotFound is
+	// returned when there is no smart playlist with this ID.
+	SetSmartPlaylistRules(id int64, rules SmartRules) error
+
+	// Changes the name of the playlist with this ID.
+	RenamePlaylist(id int64, name string) error
+
+	// Deletes the playlist with this ID together with all of its entries.
+	DeletePlaylist(int64) error
+
+	// Returns the tracks of the playlist with this ID in their order in the
+	// playlist. For smart playlists these are the tracks matched by their rules.
+	GetPlaylistTracks(int64) ([]SearchResult, error)
+
+	// The following methods change the tracks of static playlists. They return
+	// ErrPlaylistReadOnly for smart playlists.
+
+	// Adds the tracks with these IDs to the playlist with ID id. They are inserted
+	// before the entry at position. Negative position or one after the last entry
+	// means the tracks are added at the end. ErrTrackNotFound is returned when any
+	// of the tracks is not in the library.
+	AddPlaylistTracks(id int64, trackIDs []int64, position int) error
+
+	// Removes the entry at this position from the playlist with ID id. Positions
+	// start from zero.
+	RemovePlaylistTrack(id int64, position int) error
+
+	// Moves the entry at position from in the playlist with ID id so that its
+	// position becomes to.
+	MovePlaylistTrack(id int64, from, to int) error
+
+	// Increases the play count of the track with this ID and sets the time it was
+	// last played to now.
+	RecordPlay(trackID int64) error
+
+	// Returns all users ordered by their names.
+	GetUsers() []User
+
+	// Returns the user with this name. When there is no such user ErrUserNotFound
+	// is returned.
+	GetUser(name string) (*User, error)
+
+	// Creates a user with this name, password hash and role and returns it.
+	// ErrUserExists is returned when the name is already taken.
+	CreateUser(name, passwordHash, role string) (*User, error)
+
+	// Deletes the user with this name. Their playlists are kept without owner.
+	DeleteUser(name string) error
+
+	// Changes the password hash of the user with this name.
+	SetUserPassword(name, passwordHash string) error
+
+	// Returns the API tokens of the user with this ID ordered by their creation.
+	GetAPITokens(userID int64) []APIToken
+
+	// Stores an API token with this hash for the user with this ID and returns it.
+	CreateAPIToken(userID int64, label, tokenHash string, readOnly bool) (
+		*APIToken, error)
+
+	// Returns the API token with this hash and marks it as used now. When there is
+	// no such token ErrAPITokenNotFound is returned.
+	UseAPIToken(tokenHash string) (*APIToken, error)
+
+	// Deletes the API token with this ID which belongs to the user with userID.
+	DeleteAPIToken(userID, id int64) error
+
 	// Starts a full library scan. Will scan all paths if
 	// they are not scanned already.
 	Scan()
@@ -222,7 +222,7 @@ func TestSearch(t *testing.T) {
 	lib := getLibrary(t)
 	defer lib.Truncate()
 
-	found := lib.Search("Buggy")
+	found, _ := lib.Search(SearchArgs{Query: "Buggy"})
 
 	if len(found) != 1 {
 		t.Fatalf("Expected 1 result but got %d", len(found))
@@ -318,7 +318,7 @@ func TestAddingNewFiles(t *testing.T) {
 		t.Errorf("Expected to find 3 tracks but found %d", tracks)
 	}
 
-	found := library.Search("Tittled Track")
+	found, _ := library.Search(SearchArgs{Query: "Tittled Track"})
 
 	if len(found) != 1 {
 		t.Fatalf("Expected to find one track but found %d", len(found))
@@ -442,6 +442,56 @@ func TestGettingAFile(t *testing.T) {
 	if !strings.HasSuffix(filePath, filepath.FromSlash(suffix)) {
 		t.Errorf("Returned track file Another One did not have the proper file path")
 	}
+
+	track, err := library.GetTrack(trackID)
+
+	if err != nil {
+		t.Fatalf("Getting track Another One failed: %s", err)
+	}
+
+	if track.ID != trackID || track.Title != "Another One" ||
+		track.Album != "Album Of Tests" || track.Artist != "Artist Testoff" {
+		t.Errorf("Wrong track returned for Another One: %#v", track)
+	}
+
+	if _, err := library.GetTrack(trackID + 1000); err != ErrTrackNotFound {
+		t.Errorf("Expected ErrTrackNotFound for missing track but got %v", err)
+	}
+}
+
+// Formats which have to be transcoded before playing are added to the library only
+// when this is explicitly configured.
+func TestSupportedFormats(t *testing.T) {
+	lib := &LocalLibrary{}
+
+	for path, expected := range map[string]bool{
+		"/music/song.mp3":   true,
+		"/music/song.flac":  true,
+		"/music/song.opus":  false,
+		"/music/song.wma":   false,
+		"/music/cover.jpg":  false,
+		"/music/song.mp3.a": false,
+	} {
+		if supported := lib.isSupportedFormat(path); supported != expected {
+			t.Errorf("Expected support for %s to be %t by default", path, expected)
+		}
+	}
+
+	lib.IndexTranscodedFormats = true
+
+	for path, expected := range map[string]bool{
+		"/music/song.mp3":  true,
+		"/music/song.opus": true,
+		"/music/song.wma":  true,
+		"/music/song.ape":  true,
+		"/music/song.hls":  false,
+		"/music/cover.jpg": false,
+	} {
+		if supported := lib.isSupportedFormat(path); supported != expected {
+			t.Errorf("Expected support for %s to be %t with transcoded formats",
+				path, expected)
+		}
+	}
 }
 
 func TestAddingLibraryPaths(t *testing.T) {
@@ -472,7 +522,7 @@ func TestScaning(t *testing.T) {
 	ch <- 42
 
 	for _, track := range []string{"Another One", "Payback", "Tittled Track"} {
-		found := lib.Search(track)
+		found, _ := lib.Search(SearchArgs{Query: track})
 
 		if len(found) != 1 {
 			t.Errorf("%s was not found after the scan", track)
@@ -484,7 +534,7 @@ func TestSQLInjections(t *testing.T) {
 	lib := getScannedLibrary(t)
 	defer lib.Truncate()
 
-	found := lib.Search(`not-such-thing" OR 1=1 OR t.name="kleopatra`)
+	found, _ := lib.Search(SearchArgs{Query: `not-such-thing" OR 1=1 OR t.name="kleopatra`})
 
 	if len(found) != 0 {
 		t.Errorf("Successful sql injection in a single query")
@@ -539,7 +589,7 @@ func TestRemoveFileFunction(t *testing.T) {
 	lib := getScannedLibrary(t)
 	defer lib.Truncate()
 
-	found := lib.Search("Another One")
+	found, _ := lib.Search(SearchArgs{Query: "Another One"})
 
 	if len(found) != 1 {
 		t.Fatalf(`Expected searching for 'Another One' to return one `+
@@ -550,7 +600,7 @@ func TestRemoveFileFunction(t *testing.T) {
 
 	lib.removeFile(fsPath)
 
-	found = lib.Search("Another One")
+	found, _ = lib.Search(SearchArgs{Query: "Another One"})
 
 	if len(found) != 0 {
 		t.Error(`Did not expect to find Another One but it was there.`)
@@ -558,7 +608,7 @@ func TestRemoveFileFunction(t *testing.T) {
 }
 
 func checkAddedSong(lib *LocalLibrary, t *testing.T) {
-	found := lib.Search("Added Song")
+	found, _ := lib.Search(SearchArgs{Query: "Added Song"})
 
 	if len(found) != 1 {
 		filePaths := []string{}
@@ -590,7 +640,7 @@ func checkAddedSong(lib *LocalLibrary, t *testing.T) {
 }
 
 func checkSong(lib *LocalLibrary, song MediaFile, t *testing.T) {
-	found := lib.Search(song.Title())
+	found, _ := lib.Search(SearchArgs{Query: song.Title()})
 
 	if len(found) != 1 {
 		t.Fatalf("Expected one result, got %d for %s: %+v", len(found), song.Title(), found)
@@ -613,6 +663,11 @@ func checkSong(lib *LocalLibrary, song MediaFile, t *testing.T) {
 	if track.TrackNumber != int64(song.Track()) {
 		t.Errorf("Wrong track: %d when expecting %d", track.TrackNumber, song.Track())
 	}
+
+	expectedDuration := int64(song.Length() / time.Millisecond)
+	if track.Duration != expectedDuration {
+		t.Errorf("Wrong duration: %d when expecting %d", track.Duration, expectedDuration)
+	}
 }
 
 func TestAddingManyFilesSimultaniously(t *testing.T) {
@@ -695,7 +750,7 @@ func TestAlbumsWithDifferentArtists(t *testing.T) {
 
 	lib.stop()
 
-	found := lib.Search("Return Of The Bugs")
+	found, _ := lib.Search(SearchArgs{Query: "Return Of The Bugs"})
 
 	if len(found) != 3 {
 		t.Errorf("Expected to find 3 tracks but found %d", len(found))
@@ -771,7 +826,7 @@ func TestDifferentAlbumsWithTheSameName(t *testing.T) {
 
 	lib.stop()
 
-	found := lib.Search("Return Of The Bugs")
+	found, _ := lib.Search(SearchArgs{Query: "Return Of The Bugs"})
 
 	if len(found) != 3 {
 		t.Errorf("Expected to find 3 tracks but found %d", len(found))
@@ -793,3 +848,388 @@ func TestDifferentAlbumsWithTheSameName(t *testing.T) {
 		)
 	}
 }
+
+// Removing the only track of an album and artist should remove them as well.
+func TestRemovingFileRemovesEmptyAlbumsAndArtists(t *testing.T) {
+	lib := getScannedLibrary(t)
+	defer lib.Truncate()
+
+	found, _ := lib.Search(SearchArgs{Query: "Payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected to find one 'Payback' track but found %d", len(found))
+	}
+
+	lib.removeFile(lib.GetFilePath(found[0].ID))
+
+	if _, err := lib.GetArtistID("Buggy Bugoff"); err == nil {
+		t.Errorf("Artist without tracks was not removed from the library")
+	}
+
+	if _, err := lib.GetAlbumFSPathByName("Return Of The Bugs"); err == nil {
+		t.Errorf("Album without tracks was not removed from the library")
+	}
+
+	if _, err := lib.GetArtistID("Artist Testoff"); err != nil {
+		t.Errorf("Artist with tracks was removed from the library: %s", err)
+	}
+}
+
+func TestScanPrunesDeletedFiles(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	ghost := &MockMedia{
+		artist: "Ghost Artist",
+		album:  "Ghost Album",
+		title:  "Not There Anymore",
+		track:  1,
+		length: 123 * time.Second,
+	}
+
+	err := lib.insertMediaIntoDatabase(ghost, "/hopefully/not/existing/file.mp3")
+
+	if err != nil {
+		t.Fatalf("Error adding media into the database: %s", err)
+	}
+
+	ch := testErrorAfter(10, "Scanning library took too long")
+	lib.Scan()
+	lib.stop()
+	ch <- 42
+
+	if found, _ := lib.Search(SearchArgs{Query: "Not There Anymore"}); len(found) != 0 {
+		t.Errorf("Deleted file was still in the library after scan: %+v", found)
+	}
+
+	if _, err := lib.GetArtistID("Ghost Artist"); err == nil {
+		t.Errorf("Artist of the deleted file was still in the library")
+	}
+
+	if _, err := lib.GetAlbumFSPathByName("Ghost Album"); err == nil {
+		t.Errorf("Album of the deleted file was still in the library")
+	}
+
+	if found, _ := lib.Search(SearchArgs{Query: ""}); len(found) != 3 {
+		t.Errorf("Expected 3 tracks after the scan but found %d", len(found))
+	}
+}
+
+func TestPruningSkipsMissingLibraryPaths(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	missingRoot := filepath.FromSlash("/hopefully/not/existing/library")
+	lib.paths = append(lib.paths, missingRoot)
+
+	unmounted := &MockMedia{
+		artist: "Unmounted Artist",
+		album:  "Unmounted Album",
+		title:  "Unmounted Track",
+		track:  1,
+		length: 123 * time.Second,
+	}
+
+	err := lib.insertMediaIntoDatabase(
+		unmounted,
+		filepath.Join(missingRoot, "track.mp3"),
+	)
+
+	if err != nil {
+		t.Fatalf("Error adding media into the database: %s", err)
+	}
+
+	lib.stop()
+
+	stats, err := lib.pruneLibrary()
+
+	if err != nil {
+		t.Fatalf("Pruning the library failed: %s", err)
+	}
+
+	if stats.tracks != 0 {
+		t.Errorf("Expected no pruned tracks but %d were pruned", stats.tracks)
+	}
+
+	if found, _ := lib.Search(SearchArgs{Query: "Unmounted Track"}); len(found) != 1 {
+		t.Errorf("Track in a missing library path was pruned")
+	}
+}
+
+// Files which are already in the library must be read again only when they have
+// been changed. Their track IDs must not change when updated.
+func TestRescanningChangedFiles(t *testing.T) {
+	projRoot, err := helpers.ProjectRoot()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	libraryDir, err := ioutil.TempDir("", "httpms_rescan_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(libraryDir)
+
+	testMp3 := filepath.Join(projRoot, "test_files", "more_mp3s", "test_file_added.mp3")
+	mediaPath := filepath.Join(libraryDir, "test_file_added.mp3")
+
+	if err := helpers.Copy(testMp3, mediaPath); err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := NewLocalLibrary(context.TODO(), SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lib.Truncate()
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatalf("Initializing library: %s", err)
+	}
+
+	lib.AddLibraryPath(libraryDir)
+
+	stale := &MockMedia{
+		artist: "Stale Artist",
+		album:  "Stale Album",
+		title:  "Stale Title",
+		track:  1,
+		length: 123 * time.Second,
+	}
+
+	if err := lib.insertMediaIntoDatabase(stale, mediaPath); err != nil {
+		t.Fatalf("Error adding media into the database: %s", err)
+	}
+
+	found, _ := lib.Search(SearchArgs{Query: "Stale Title"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one 'Stale Title' track but found %d", len(found))
+	}
+
+	trackID := found[0].ID
+
+	ch := testErrorAfter(10, "Scanning library took too long")
+	lib.Scan()
+	ch <- 42
+
+	if found, _ := lib.Search(SearchArgs{Query: "Stale Title"}); len(found) != 1 {
+		t.Errorf("Unchanged file was read again during scan")
+	}
+
+	if err := lib.AddMedia(mediaPath); err != nil {
+		t.Fatalf("Adding unchanged media failed: %s", err)
+	}
+
+	if found, _ := lib.Search(SearchArgs{Query: "Stale Title"}); len(found) != 1 {
+		t.Errorf("Unchanged file was read again by AddMedia")
+	}
+
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(mediaPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tracks which do not know when they were added get it on the next update.
+	if _, err := lib.db.Exec("UPDATE tracks SET added_at = 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Albums and artists left without tracks are removed at the end of the scan.
+	ch = testErrorAfter(10, "Scanning library took too long")
+	lib.Scan()
+	ch <- 42
+
+	lib.stop()
+
+	if found, _ := lib.Search(SearchArgs{Query: "Stale Title"}); len(found) != 0 {
+		t.Errorf("Changed file was not read again: %+v", found)
+	}
+
+	checkAddedSong(lib, t)
+
+	if found, _ := lib.Search(SearchArgs{Query: "Added Song"}); len(found) == 1 && found[0].ID != trackID {
+		t.Errorf("Track ID changed from %d to %d after update", trackID, found[0].ID)
+	}
+
+	if _, err := lib.GetArtistID("Stale Artist"); err == nil {
+		t.Errorf("Artist without tracks was not removed after the update")
+	}
+
+	var addedAt int64
+	err = lib.db.QueryRow("SELECT added_at FROM tracks WHERE id = ?", trackID).
+		Scan(&addedAt)
+
+	if err != nil || addedAt != modTime.Unix() {
+		t.Errorf("Expected added time %d after the update but got %d, %v",
+			modTime.Unix(), addedAt, err)
+	}
+}
+
+// Tracks of multi-disc albums must be ordered by disc first and then by their
+// track number.
+func TestMultiDiscAlbumFiles(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	tracks := []MockMedia{
+		{
+			artist:      "Guest Star",
+			album:       "The Box Set",
+			title:       "Second Disc Opener",
+			track:       1,
+			length:      200 * time.Second,
+			year:        1994,
+			genre:       "Progressive Rock",
+			disc:        2,
+			albumArtist: "The Band",
+			composer:    "Someone Else",
+		},
+		{
+			artist:      "The Band",
+			album:       "The Box Set",
+			title:       "First Disc Closer",
+			track:       2,
+			length:      180 * time.Second,
+			year:        1994,
+			genre:       "Progressive Rock",
+			disc:        1,
+			albumArtist: "The Band",
+		},
+		{
+			artist:      "The Band",
+			album:       "The Box Set",
+			title:       "First Disc Opener",
+			track:       1,
+			length:      190 * time.Second,
+			year:        1994,
+			genre:       "Progressive Rock",
+			disc:        1,
+			albumArtist: "The Band",
+		},
+	}
+
+	for ind, track := range tracks {
+		track := track
+		err := lib.insertMediaIntoDatabase(
+			&track,
+			fmt.Sprintf("/media/the-box-set/track-%d.mp3", ind),
+		)
+
+		if err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", track.Title(), err)
+		}
+	}
+
+	lib.stop()
+
+	found, _ := lib.Search(SearchArgs{Query: "Second Disc Opener"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one result but found %d", len(found))
+	}
+
+	track := found[0]
+
+	if track.Year != 1994 || track.Genre != "Progressive Rock" || track.DiscNumber != 2 ||
+		track.AlbumArtist != "The Band" || track.Composer != "Someone Else" {
+		t.Errorf("Extended tags were not stored correctly: %+v", track)
+	}
+
+	albumFiles := lib.GetAlbumFiles(track.AlbumID)
+	expectedOrder := []string{"First Disc Opener", "First Disc Closer", "Second Disc Opener"}
+
+	if len(albumFiles) != len(expectedOrder) {
+		t.Fatalf("Expected %d album files but found %d", len(expectedOrder),
+			len(albumFiles))
+	}
+
+	for ind, title := range expectedOrder {
+		if albumFiles[ind].Title != title {
+			t.Errorf("Expected track %d to be `%s` but it was `%s`", ind, title,
+				albumFiles[ind].Title)
+		}
+	}
+}
+
+// Releases split into many directories must be a single album. Either because of
+// their album artist or because of directories named after the discs.
+func TestMultiFolderAlbumsAreMerged(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	tracks := []struct {
+		track MockMedia
+		path  string
+	}{
+		{
+			MockMedia{
+				artist:      "The Band",
+				album:       "Live Forever",
+				title:       "Tagged First",
+				track:       1,
+				disc:        1,
+				albumArtist: "The Band",
+			},
+			"/media/live-forever/first-night/track-1.mp3",
+		},
+		{
+			MockMedia{
+				artist:      "The Band feat. Guest",
+				album:       "Live Forever",
+				title:       "Tagged Second",
+				track:       1,
+				disc:        2,
+				albumArtist: "The Band",
+			},
+			"/media/live-forever/second-night/track-1.mp3",
+		},
+		{
+			MockMedia{
+				artist: "Other Band",
+				album:  "Two Discs",
+				title:  "Untagged First",
+				track:  1,
+			},
+			"/media/two-discs/CD1/track-1.mp3",
+		},
+		{
+			MockMedia{
+				artist: "Other Band",
+				album:  "Two Discs",
+				title:  "Untagged Second",
+				track:  1,
+			},
+			"/media/two-discs/CD2/track-1.mp3",
+		},
+	}
+
+	for _, trackData := range tracks {
+		err := lib.insertMediaIntoDatabase(&trackData.track, trackData.path)
+
+		if err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", trackData.track.Title(), err)
+		}
+	}
+
+	lib.stop()
+
+	for _, album := range []string{"Live Forever", "Two Discs"} {
+		found, _ := lib.Search(SearchArgs{Query: album})
+
+		if len(found) != 2 {
+			t.Errorf("Expected two tracks for %s but found %d", album, len(found))
+			continue
+		}
+
+		if found[0].AlbumID != found[1].AlbumID {
+			t.Errorf("Tracks of %s were in different albums: %d and %d", album,
+				found[0].AlbumID, found[1].AlbumID)
+		}
+	}
+}
@@ -60,7 +60,7 @@ func TestRemovingFile(t *testing.T) {
 	lib := getScannedLibrary(t)
 	defer lib.Truncate()
 
-	results := lib.Search("")
+	results, _ := lib.Search(SearchArgs{Query: ""})
 
 	if len(results) != 4 {
 		t.Errorf("Expected 4 files in the result set but found %d", len(results))
@@ -72,7 +72,7 @@ func TestRemovingFile(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 
-	results = lib.Search("")
+	results, _ = lib.Search(SearchArgs{Query: ""})
 	if len(results) != 3 {
 		t.Errorf("Expected 3 files in the result set but found %d", len(results))
 	}
@@ -126,7 +126,7 @@ func TestAddingAndRemovingDirectory(t *testing.T) {
 
 	time.Sleep(100 * time.Millisecond)
 
-	results := lib.Search("")
+	results, _ := lib.Search(SearchArgs{Query: ""})
 
 	if len(results) != 3 {
 		t.Errorf("Expected 3 songs but found %d", len(results))
@@ -183,13 +183,13 @@ func TestMovingDirectory(t *testing.T) {
 
 	checkAddedSong(lib, t)
 
-	found := lib.Search("")
+	found, _ := lib.Search(SearchArgs{Query: ""})
 
 	if len(found) != 4 {
 		t.Errorf("Expected to find 4 tracks but found %d", len(found))
 	}
 
-	found = lib.Search("Added Song")
+	found, _ = lib.Search(SearchArgs{Query: "Added Song"})
 
 	if len(found) != 1 {
 		t.Fatalf("Did not find exactly one 'Added Song'. Found %d files", len(found))
@@ -245,7 +245,7 @@ func TestAddingNonRelatedFile(t *testing.T) {
 	newFile := filepath.Join(testFiles, "library", "not_related")
 
 	testLibFiles := func() {
-		results := lib.Search("")
+		results, _ := lib.Search(SearchArgs{Query: ""})
 		if len(results) != 3 {
 			t.Errorf("Expected 3 files in the library but found %d", len(results))
 		}
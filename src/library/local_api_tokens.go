@@ -0,0 +1,168 @@
+package library
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// apiTokenUseInterval is the period in which the last use of an API token is
+// recorded only once. Clients make many requests in a row and there is no need to
+// write in the database for every one of them.
+const apiTokenUseInterval = time.Minute
+
+// GetAPITokens satisfies the Library interface
+func (lib *LocalLibrary) GetAPITokens(userID int64) []APIToken {
+	var tokens []APIToken
+
+	rows, err := lib.db.Query(`
+		SELECT
+			t.id, t.label, t.read_only, t.created_at, t.last_used, t.user_id,
+			IFNULL(u.name, '')
+		FROM
+			api_tokens as t
+			LEFT JOIN users as u ON u.id = t.user_id
+		WHERE
+			t.user_id = ?
+		ORDER BY
+			t.id
+	`, userID)
+
+	if err != nil {
+		log.Printf("Query not successful: %s\n", err.Error())
+		return tokens
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var token APIToken
+		err := rows.Scan(&token.ID, &token.Label, &token.ReadOnly, &token.CreatedAt,
+			&token.LastUsed, &token.UserID, &token.UserName)
+		if err != nil {
+			log.Printf("Error scanning API token row: %s\n", err)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+// CreateAPIToken satisfies the Library interface
+func (lib *LocalLibrary) CreateAPIToken(
+	userID int64,
+	label, tokenHash string,
+	readOnly bool,
+) (*APIToken, error) {
+	var userName string
+
+	if userID != 0 {
+		err := lib.db.QueryRow(`SELECT name FROM users WHERE id = ?`, userID).
+			Scan(&userName)
+
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	createdAt := time.Now().Unix()
+
+	res, err := lib.db.Exec(`
+		INSERT INTO
+			api_tokens (user_id, label, token_hash, read_only, created_at)
+		VALUES
+			(?, ?, ?, ?, ?)
+	`, userID, label, tokenHash, readOnly, createdAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIToken{
+		ID:        id,
+		Label:     label,
+		ReadOnly:  readOnly,
+		CreatedAt: createdAt,
+		UserID:    userID,
+		UserName:  userName,
+	}, nil
+}
+
+// UseAPIToken satisfies the Library interface
+func (lib *LocalLibrary) UseAPIToken(tokenHash string) (*APIToken, error) {
+	var token APIToken
+
+	err := lib.db.QueryRow(`
+		SELECT
+			t.id, t.label, t.read_only, t.created_at, t.last_used, t.user_id,
+			IFNULL(u.name, '')
+		FROM
+			api_tokens as t
+			LEFT JOIN users as u ON u.id = t.user_id
+		WHERE
+			t.token_hash = ?
+	`, tokenHash).Scan(&token.ID, &token.Label, &token.ReadOnly, &token.CreatedAt,
+		&token.LastUsed, &token.UserID, &token.UserName)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrAPITokenNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if now.Sub(time.Unix(token.LastUsed, 0)) < apiTokenUseInterval {
+		return &token, nil
+	}
+
+	_, err = lib.db.Exec(`
+		UPDATE
+			api_tokens
+		SET
+			last_used = ?
+		WHERE
+			id = ?
+	`, now.Unix(), token.ID)
+
+	if err != nil {
+		log.Printf("Error recording the use of API token %d: %s\n", token.ID, err)
+	} else {
+		token.LastUsed = now.Unix()
+	}
+
+	return &token, nil
+}
+
+// DeleteAPIToken satisfies the Library interface
+func (lib *LocalLibrary) DeleteAPIToken(userID, id int64) error {
+	res, err := lib.db.Exec(`
+		DELETE FROM
+			api_tokens
+		WHERE
+			id = ? AND user_id = ?
+	`, id, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	return err
+}
@@ -0,0 +1,96 @@
+package library
+
+import (
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAPITokens(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	alice, err := lib.CreateUser("alice", "hash", RoleListener)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tokens := lib.GetAPITokens(alice.ID); len(tokens) != 0 {
+		t.Fatalf("Expected no API tokens for a new user but found %+v", tokens)
+	}
+
+	if _, err := lib.CreateAPIToken(alice.ID+100, "Phone", "hash0", false); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound for missing user but got %v", err)
+	}
+
+	phone, err := lib.CreateAPIToken(alice.ID, "Phone", "hash1", false)
+
+	if err != nil {
+		t.Fatalf("Creating API token: %s", err)
+	}
+
+	if phone.ID == 0 || phone.UserName != "alice" || phone.CreatedAt == 0 ||
+		phone.LastUsed != 0 {
+		t.Errorf("Unexpected created API token: %+v", phone)
+	}
+
+	if _, err := lib.CreateAPIToken(alice.ID, "Speaker", "hash2", true); err != nil {
+		t.Fatalf("Creating API token: %s", err)
+	}
+
+	if _, err := lib.CreateAPIToken(0, "Car", "hash3", false); err != nil {
+		t.Fatalf("Creating API token for the configuration user: %s", err)
+	}
+
+	tokens := lib.GetAPITokens(alice.ID)
+
+	if len(tokens) != 2 || tokens[0].Label != "Phone" || tokens[0].ReadOnly ||
+		tokens[1].Label != "Speaker" || !tokens[1].ReadOnly {
+		t.Errorf("Expected the phone and the speaker tokens but got %+v", tokens)
+	}
+
+	used, err := lib.UseAPIToken("hash1")
+
+	if err != nil {
+		t.Fatalf("Using API token: %s", err)
+	}
+
+	if used.ID != phone.ID || used.UserID != alice.ID || used.UserName != "alice" ||
+		used.LastUsed == 0 {
+		t.Errorf("Unexpected used API token: %+v", used)
+	}
+
+	if tokens := lib.GetAPITokens(alice.ID); tokens[0].LastUsed != used.LastUsed {
+		t.Errorf("Expected the last use to be stored but got %+v", tokens[0])
+	}
+
+	if used, err := lib.UseAPIToken("hash3"); err != nil || used.UserID != 0 ||
+		used.UserName != "" {
+		t.Errorf("Unexpected token of the configuration user: %+v, %v", used, err)
+	}
+
+	if _, err := lib.UseAPIToken("wrong"); err != ErrAPITokenNotFound {
+		t.Errorf("Expected ErrAPITokenNotFound for wrong hash but got %v", err)
+	}
+
+	if err := lib.DeleteAPIToken(0, phone.ID); err != ErrAPITokenNotFound {
+		t.Errorf("Expected only the owner to be able to delete a token but got %v", err)
+	}
+
+	if err := lib.DeleteAPIToken(alice.ID, phone.ID); err != nil {
+		t.Fatalf("Deleting API token: %s", err)
+	}
+
+	if _, err := lib.UseAPIToken("hash1"); err != ErrAPITokenNotFound {
+		t.Errorf("Expected deleted token to stop working but got %v", err)
+	}
+
+	if err := lib.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lib.UseAPIToken("hash2"); err != ErrAPITokenNotFound {
+		t.Errorf("Expected the tokens of deleted users to be removed but got %v", err)
+	}
+}
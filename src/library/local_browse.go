@@ -1,6 +1,7 @@
 package library
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 )
@@ -54,7 +55,9 @@ func (lib *LocalLibrary) BrowseArtists(args BrowseArgs) ([]Artist, int) {
 }
 
 // BrowseAlbums implements the Library interface for the local library by getting
-// albums from the database ordered by their name.
+// albums from the database ordered by their name. The artist of an album is its album
+// artist. Compilations without one are by "Various Artists". For all other albums
+// the artist with the most tracks on the album is used.
 func (lib *LocalLibrary) BrowseAlbums(args BrowseArgs) ([]Album, int) {
 	page := args.Page
 	perPage := args.PerPage
@@ -90,41 +93,147 @@ func (lib *LocalLibrary) BrowseAlbums(args BrowseArgs) ([]Album, int) {
 		order = "DESC"
 	}
 
-	rows, err := lib.db.Query(fmt.Sprintf(`
+	rows, err := lib.db.Query(albumsQuery("1", fmt.Sprintf(`
+        ORDER BY
+            %s %s
+        LIMIT
+            ?, ?
+    `, orderBy, order)), page*perPage, perPage)
+
+	if err != nil {
+		log.Printf("Query for browsing albums not successful: %s\n", err)
+		return output, albumsCount
+	}
+
+	defer rows.Close()
+	return scanAlbums(rows), albumsCount
+}
+
+// GetArtist satisfies the Library interface
+func (lib *LocalLibrary) GetArtist(artistID int64) (*Artist, error) {
+	artist := &Artist{ID: artistID}
+
+	err := lib.db.QueryRow(`
+        SELECT
+            name
+        FROM
+            artists
+        WHERE
+            id = ?
+    `, artistID).Scan(&artist.Name)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrArtistNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return artist, nil
+}
+
+// GetArtistAlbums satisfies the Library interface. Albums are ordered by their year
+// and name.
+func (lib *LocalLibrary) GetArtistAlbums(artistID int64) []Album {
+	rows, err := lib.db.Query(albumsQuery(`
+        tr.album_id IN (
+            SELECT album_id FROM tracks WHERE artist_id = ?
+        )
+    `, `
+        ORDER BY
+            MAX(tr.year), al.name
+    `), artistID)
+
+	if err != nil {
+		log.Printf("Query for artist albums not successful: %s\n", err)
+		return nil
+	}
+
+	defer rows.Close()
+	return scanAlbums(rows)
+}
+
+// GetAlbum satisfies the Library interface
+func (lib *LocalLibrary) GetAlbum(albumID int64) (*Album, error) {
+	rows, err := lib.db.Query(albumsQuery("tr.album_id = ?", ""), albumID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	found := scanAlbums(rows)
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, ErrAlbumNotFound
+	}
+
+	return &found[0], nil
+}
+
+// albumsQuery returns an SQL query for albums with the columns expected by
+// scanAlbums. Only the tracks (aliased as "tr") for which condition is true are
+// considered. tail is added after the GROUP BY clause and is the place for ordering
+// and limits.
+func albumsQuery(condition, tail string) string {
+	return fmt.Sprintf(`
         SELECT
             al.id,
             al.name as album_name,
-            CASE WHEN COUNT(DISTINCT tr.artist_id) = 1
-            THEN ar.name
-            ELSE "Various Artists"
-            END AS arist_name
+            CASE
+                WHEN al.album_artist != '' THEN al.album_artist
+                WHEN al.compilation = 1 THEN "Various Artists"
+                WHEN COUNT(DISTINCT tr.artist_id) = 1 THEN ar.name
+                ELSE (
+                    SELECT
+                        mar.name
+                    FROM
+                        tracks mtr
+                        LEFT JOIN
+                            artists mar ON mar.id = mtr.artist_id
+                    WHERE
+                        mtr.album_id = al.id
+                    GROUP BY
+                        mtr.artist_id
+                    ORDER BY
+                        COUNT(*) DESC, mar.name
+                    LIMIT 1
+                )
+            END AS arist_name,
+            SUM(tr.duration) as duration,
+            COUNT(*) as song_count,
+            MAX(tr.year) as year
         FROM
             tracks tr
             LEFT JOIN
                 albums al ON al.id = tr.album_id
             LEFT JOIN
                 artists ar ON ar.id = tr.artist_id
+        WHERE
+            %s
         GROUP BY
             tr.album_id
-        ORDER BY
-            %s %s
-        LIMIT
-            ?, ?
-    `, orderBy, order), page*perPage, perPage)
+        %s
+    `, condition, tail)
+}
 
-	if err != nil {
-		log.Printf("Query for browsing albums not successful: %s\n", err)
-		return output, albumsCount
-	}
+// scanAlbums reads all albums from rows returned by a query made with albumsQuery.
+func scanAlbums(rows *sql.Rows) []Album {
+	var output []Album
 
-	defer rows.Close()
 	for rows.Next() {
 		var res Album
-		rows.Scan(&res.ID, &res.Name, &res.Artist)
+		rows.Scan(&res.ID, &res.Name, &res.Artist, &res.Duration, &res.SongCount,
+			&res.Year)
 		output = append(output, res)
 	}
 
-	return output, albumsCount
+	return output
 }
 
 func (lib *LocalLibrary) getTableSize(table string) int {
@@ -43,7 +43,7 @@ func TestBrowsingArtists(t *testing.T) {
 				track:  3,
 				length: 218 * time.Second,
 			},
-			"/media/return-of-the-bugs/track-2.mp3",
+			"/media/return-of-the-bugs/track-3.mp3",
 		},
 		{
 			MockMedia{
@@ -53,7 +53,7 @@ func TestBrowsingArtists(t *testing.T) {
 				track:  4,
 				length: 602 * time.Second,
 			},
-			"/media/return-of-the-bugs/track-2.mp3",
+			"/media/return-of-the-bugs/track-4.mp3",
 		},
 		{
 			MockMedia{
@@ -63,7 +63,7 @@ func TestBrowsingArtists(t *testing.T) {
 				track:  1,
 				length: 244 * time.Second,
 			},
-			"/media/two-by-two/track-3.mp3",
+			"/media/two-by-two/track-1.mp3",
 		},
 	}
 
@@ -146,3 +146,247 @@ func TestBrowsingArtists(t *testing.T) {
 	}
 
 }
+
+func TestBrowsingAlbumsDuration(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	tracks := []struct {
+		track MockMedia
+		path  string
+	}{
+		{
+			MockMedia{
+				artist: "Buggy Bugoff",
+				album:  "The Return Of The Bugs",
+				title:  "Payback",
+				track:  1,
+				length: 340 * time.Second,
+			},
+			"/media/return-of-the-bugs/track-1.mp3",
+		},
+		{
+			MockMedia{
+				artist: "Buggy Bugoff",
+				album:  "The Return Of The Bugs",
+				title:  "Realization",
+				track:  2,
+				length: 345*time.Second + 500*time.Millisecond,
+			},
+			"/media/return-of-the-bugs/track-2.mp3",
+		},
+	}
+
+	for _, trackData := range tracks {
+		err := lib.insertMediaIntoDatabase(&trackData.track, trackData.path)
+
+		if err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", trackData.track.Title(), err)
+		}
+	}
+
+	lib.stop()
+
+	albums, _ := lib.BrowseAlbums(BrowseArgs{Page: 0, PerPage: 10})
+
+	if len(albums) != 1 {
+		t.Fatalf("Expected one album but found %d", len(albums))
+	}
+
+	if albums[0].Duration != 685500 {
+		t.Errorf("Expected album duration 685500ms but it was %dms", albums[0].Duration)
+	}
+
+	albumFiles := lib.GetAlbumFiles(albums[0].ID)
+
+	if len(albumFiles) != 2 {
+		t.Fatalf("Expected two album files but found %d", len(albumFiles))
+	}
+
+	if albumFiles[1].Duration != 345500 {
+		t.Errorf("Expected track duration 345500ms but it was %dms",
+			albumFiles[1].Duration)
+	}
+}
+
+func TestBrowsingAlbumsArtist(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	tracks := []struct {
+		track MockMedia
+		path  string
+	}{
+		// An album with a guest feature and no album artist
+		{
+			MockMedia{artist: "Main Act", album: "A Guest Appearance", title: "One"},
+			"/media/guest/track-1.mp3",
+		},
+		{
+			MockMedia{artist: "Main Act feat. Guest", album: "A Guest Appearance",
+				title: "Two"},
+			"/media/guest/track-2.mp3",
+		},
+		{
+			MockMedia{artist: "Main Act", album: "A Guest Appearance", title: "Three"},
+			"/media/guest/track-3.mp3",
+		},
+
+		// A compilation
+		{
+			MockMedia{artist: "First Artist", album: "B Compilation", title: "Four",
+				compilation: true},
+			"/media/compilation/track-1.mp3",
+		},
+		{
+			MockMedia{artist: "Second Artist", album: "B Compilation", title: "Five",
+				compilation: true},
+			"/media/compilation/track-2.mp3",
+		},
+
+		// An album with an album artist
+		{
+			MockMedia{artist: "Some Singer", album: "C Tribute", title: "Six",
+				albumArtist: "Tribute Band"},
+			"/media/tribute/track-1.mp3",
+		},
+		{
+			MockMedia{artist: "Other Singer", album: "C Tribute", title: "Seven",
+				albumArtist: "Tribute Band"},
+			"/media/tribute/track-2.mp3",
+		},
+	}
+
+	for _, trackData := range tracks {
+		err := lib.insertMediaIntoDatabase(&trackData.track, trackData.path)
+
+		if err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", trackData.track.Title(), err)
+		}
+	}
+
+	lib.stop()
+
+	albums, count := lib.BrowseAlbums(BrowseArgs{
+		Page:    0,
+		PerPage: 10,
+		Order:   OrderAsc,
+		OrderBy: OrderByName,
+	})
+
+	if count != 3 || len(albums) != 3 {
+		t.Fatalf("Expected 3 albums but found %d (count %d)", len(albums), count)
+	}
+
+	expectedArtists := []string{"Main Act", "Various Artists", "Tribute Band"}
+
+	for ind, expected := range expectedArtists {
+		if albums[ind].Artist != expected {
+			t.Errorf("Expected artist of %s to be `%s` but it was `%s`",
+				albums[ind].Name, expected, albums[ind].Artist)
+		}
+	}
+}
+
+func TestGettingArtistsAndAlbums(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	tracks := []struct {
+		track MockMedia
+		path  string
+	}{
+		{
+			MockMedia{artist: "Buggy Bugoff", album: "Return Of The Bugs",
+				title: "Payback", track: 1, year: 2013, length: 340 * time.Second},
+			"/media/return-of-the-bugs/track-1.mp3",
+		},
+		{
+			MockMedia{artist: "Buggy Bugoff", album: "Return Of The Bugs",
+				title: "Realization", track: 2, year: 2013, length: 345 * time.Second},
+			"/media/return-of-the-bugs/track-2.mp3",
+		},
+		{
+			MockMedia{artist: "Buggy Bugoff", album: "First Bugs", title: "Larva",
+				track: 1, year: 2009, length: 100 * time.Second},
+			"/media/first-bugs/track-1.mp3",
+		},
+		{
+			MockMedia{artist: "Off By One", album: "Various Bugs", title: "Index",
+				track: 1, year: 2015, compilation: true},
+			"/media/various-bugs/track-1.mp3",
+		},
+		{
+			MockMedia{artist: "Buggy Bugoff", album: "Various Bugs", title: "Guest",
+				track: 2, year: 2015, compilation: true},
+			"/media/various-bugs/track-2.mp3",
+		},
+	}
+
+	for _, trackData := range tracks {
+		err := lib.insertMediaIntoDatabase(&trackData.track, trackData.path)
+
+		if err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", trackData.track.Title(), err)
+		}
+	}
+
+	lib.stop()
+
+	found, _ := lib.Search(SearchArgs{Query: "title:payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected to find Payback once but found it %d times", len(found))
+	}
+
+	artist, err := lib.GetArtist(found[0].ArtistID)
+
+	if err != nil {
+		t.Fatalf("Getting artist failed: %s", err)
+	}
+
+	if artist.Name != "Buggy Bugoff" {
+		t.Errorf("Expected artist Buggy Bugoff but got %s", artist.Name)
+	}
+
+	if _, err := lib.GetArtist(666); err != ErrArtistNotFound {
+		t.Errorf("Expected ErrArtistNotFound for missing artist but got %v", err)
+	}
+
+	albums := lib.GetArtistAlbums(artist.ID)
+	expectedAlbums := []string{"First Bugs", "Return Of The Bugs", "Various Bugs"}
+
+	if len(albums) != len(expectedAlbums) {
+		t.Fatalf("Expected albums %v but got %v", expectedAlbums, albums)
+	}
+
+	for ind, name := range expectedAlbums {
+		if albums[ind].Name != name {
+			t.Errorf("Expected album %d to be %s but it was %s", ind, name,
+				albums[ind].Name)
+		}
+	}
+
+	album, err := lib.GetAlbum(found[0].AlbumID)
+
+	if err != nil {
+		t.Fatalf("Getting album failed: %s", err)
+	}
+
+	expected := Album{
+		ID:        found[0].AlbumID,
+		Name:      "Return Of The Bugs",
+		Artist:    "Buggy Bugoff",
+		Duration:  685000,
+		SongCount: 2,
+		Year:      2013,
+	}
+
+	if *album != expected {
+		t.Errorf("Expected album %+v but got %+v", expected, *album)
+	}
+
+	if _, err := lib.GetAlbum(666); err != ErrAlbumNotFound {
+		t.Errorf("Expected ErrAlbumNotFound for missing album but got %v", err)
+	}
+}
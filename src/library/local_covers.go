@@ -0,0 +1,217 @@
+package library
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// coverFileNames are the names, without extensions, of image files in album
+// directories which are used as album covers. Earlier names are preferred.
+var coverFileNames = []string{"cover", "folder", "front", "album", "albumart"}
+
+// coverFileExtensions are the extensions of image files which could be album covers.
+// Earlier extensions are preferred.
+var coverFileExtensions = []string{".jpg", ".jpeg", ".png", ".gif"}
+
+// GetAlbumCover satisfies the Library interface. The cover is read from the file
+// system every time so changes in the image files are seen immediately. Returns
+// ErrCoverNotFound when the album has no cover or its file is missing.
+func (lib *LocalLibrary) GetAlbumCover(albumID int64) (*Cover, error) {
+	var (
+		coverPath string
+		embedded  bool
+	)
+
+	err := lib.db.QueryRow(`
+		SELECT
+			cover_path,
+			cover_embedded
+		FROM
+			albums
+		WHERE
+			id = ?
+	`, albumID).Scan(&coverPath, &embedded)
+
+	if err == sql.ErrNoRows || (err == nil && coverPath == "") {
+		return nil, ErrCoverNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := os.Stat(coverPath)
+
+	if os.IsNotExist(err) {
+		return nil, ErrCoverNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if embedded {
+		return readEmbeddedCover(coverPath, st.ModTime())
+	}
+
+	data, err := ioutil.ReadFile(coverPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cover{
+		Data:     data,
+		MIMEType: mime.TypeByExtension(strings.ToLower(filepath.Ext(coverPath))),
+		ModTime:  st.ModTime(),
+	}, nil
+}
+
+// readEmbeddedCover returns the picture embedded in the tags of a media file. Returns
+// ErrCoverNotFound when the file has no picture.
+func readEmbeddedCover(filePath string, modTime time.Time) (*Cover, error) {
+	fh, err := os.Open(filePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	metadata, err := tag.ReadFrom(fh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	picture := metadata.Picture()
+
+	if picture == nil || len(picture.Data) == 0 {
+		return nil, ErrCoverNotFound
+	}
+
+	mimeType := picture.MIMEType
+
+	if mimeType == "" || !strings.Contains(mimeType, "/") {
+		mimeType = mime.TypeByExtension("." + strings.ToLower(picture.Ext))
+	}
+
+	return &Cover{
+		Data:     picture.Data,
+		MIMEType: mimeType,
+		ModTime:  modTime,
+	}, nil
+}
+
+// setAlbumCover finds a cover for the album while adding one of its tracks to the
+// library. Image files in the track's directory and the album's directory are
+// preferred over pictures embedded in the track. Albums which already have a cover
+// keep it, unless it was embedded in this very track or its file has disappeared.
+func (lib *LocalLibrary) setAlbumCover(albumID int64, trackPath, albumDir string) error {
+	var coverPath string
+
+	err := lib.db.QueryRow(`
+		SELECT
+			cover_path
+		FROM
+			albums
+		WHERE
+			id = ?
+	`, albumID).Scan(&coverPath)
+
+	if err != nil {
+		return err
+	}
+
+	if coverPath != "" && coverPath != trackPath {
+		if _, err := os.Stat(coverPath); err == nil {
+			return nil
+		}
+	}
+
+	dirs := []string{filepath.Dir(trackPath)}
+	if albumDir != dirs[0] {
+		dirs = append(dirs, albumDir)
+	}
+
+	newCoverPath := findCoverFile(dirs...)
+	embedded := false
+
+	if newCoverPath == "" && hasEmbeddedCover(trackPath) {
+		newCoverPath = trackPath
+		embedded = true
+	}
+
+	if newCoverPath == "" && coverPath != "" && coverPath != trackPath {
+		// The old cover is gone but it is possible that another track of this album
+		// has one. There is no point in forgetting the old one until then.
+		return nil
+	}
+
+	if newCoverPath == coverPath {
+		return nil
+	}
+
+	_, err = lib.db.Exec(`
+		UPDATE
+			albums
+		SET
+			cover_path = ?,
+			cover_embedded = ?
+		WHERE
+			id = ?
+	`, newCoverPath, embedded, albumID)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Album id: %d, cover: %s\n", albumID, newCoverPath)
+
+	return nil
+}
+
+// findCoverFile returns the path to the first cover image found in one of the
+// directories. File names are matched case insensitively. Returns an empty string
+// when no cover image was found.
+func findCoverFile(dirs ...string) string {
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+
+		if err != nil {
+			continue
+		}
+
+		images := make(map[string]string)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			images[strings.ToLower(entry.Name())] = entry.Name()
+		}
+
+		for _, name := range coverFileNames {
+			for _, ext := range coverFileExtensions {
+				if found, ok := images[name+ext]; ok {
+					return filepath.Join(dir, found)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// hasEmbeddedCover returns true when there is a picture in the tags of the file.
+func hasEmbeddedCover(filePath string) bool {
+	cover, err := readEmbeddedCover(filePath, time.Time{})
+	return err == nil && cover != nil
+}
@@ -0,0 +1,166 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// id3v2WithPicture returns an ID3v2.3 tag which contains only an APIC frame with
+// this picture.
+func id3v2WithPicture(mimeType string, picture []byte) []byte {
+	var frame bytes.Buffer
+	frame.WriteByte(0) // ISO-8859-1 text encoding
+	frame.WriteString(mimeType)
+	frame.WriteByte(0)
+	frame.WriteByte(3) // front cover
+	frame.WriteByte(0) // empty description
+	frame.Write(picture)
+
+	var tag bytes.Buffer
+	tag.WriteString("APIC")
+	binary.Write(&tag, binary.BigEndian, uint32(frame.Len()))
+	tag.Write([]byte{0, 0})
+	tag.Write(frame.Bytes())
+
+	// The tag size is a "synchsafe" integer with 7 bits in every byte.
+	size := tag.Len()
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f),
+		byte(size >> 7 & 0x7f), byte(size & 0x7f),
+	}
+
+	return append(header, tag.Bytes()...)
+}
+
+func writeTestFile(t *testing.T, path string, contents []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAlbumCovers(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	libraryDir, err := ioutil.TempDir("", "httpms_covers_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(libraryDir)
+
+	folderCover := []byte("folder cover")
+	embeddedCover := []byte("embedded cover")
+
+	// Album with a cover image in its directory, above the disc directories. Its
+	// tracks have embedded pictures as well but the image file is preferred.
+	writeTestFile(t, filepath.Join(libraryDir, "box-set", "Folder.JPG"), folderCover)
+
+	// Album with an embedded picture only.
+	embeddedTrack := filepath.Join(libraryDir, "embedded", "track-1.mp3")
+
+	tracks := []struct {
+		track    MockMedia
+		path     string
+		contents []byte
+	}{
+		{
+			MockMedia{artist: "The Band", album: "The Box Set", title: "One", track: 1},
+			filepath.Join(libraryDir, "box-set", "CD1", "track-1.mp3"),
+			id3v2WithPicture("image/png", embeddedCover),
+		},
+		{
+			MockMedia{artist: "The Band", album: "The Box Set", title: "Two", track: 1},
+			filepath.Join(libraryDir, "box-set", "CD2", "track-1.mp3"),
+			nil,
+		},
+		{
+			MockMedia{artist: "The Band", album: "Embedded", title: "Three", track: 1},
+			embeddedTrack,
+			id3v2WithPicture("image/png", embeddedCover),
+		},
+		{
+			MockMedia{artist: "The Band", album: "No Cover", title: "Four", track: 1},
+			filepath.Join(libraryDir, "no-cover", "track-1.mp3"),
+			nil,
+		},
+	}
+
+	for _, trackData := range tracks {
+		writeTestFile(t, trackData.path, trackData.contents)
+
+		err := lib.insertMediaIntoDatabase(&trackData.track, trackData.path)
+
+		if err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", trackData.track.Title(), err)
+		}
+	}
+
+	lib.stop()
+
+	getAlbumID := func(title string) int64 {
+		found, _ := lib.Search(SearchArgs{Query: "title:" + title})
+		if len(found) != 1 {
+			t.Fatalf("Expected to find one track %s but found %d", title, len(found))
+		}
+		return found[0].AlbumID
+	}
+
+	cover, err := lib.GetAlbumCover(getAlbumID("two"))
+
+	if err != nil {
+		t.Fatalf("Getting the box set cover: %s", err)
+	}
+
+	if !bytes.Equal(cover.Data, folderCover) || cover.MIMEType != "image/jpeg" {
+		t.Errorf("Wrong box set cover. Data: `%s`, type: %s", cover.Data,
+			cover.MIMEType)
+	}
+
+	if cover.ModTime.IsZero() {
+		t.Errorf("Box set cover had no modification time")
+	}
+
+	cover, err = lib.GetAlbumCover(getAlbumID("three"))
+
+	if err != nil {
+		t.Fatalf("Getting the embedded cover: %s", err)
+	}
+
+	if !bytes.Equal(cover.Data, embeddedCover) || cover.MIMEType != "image/png" {
+		t.Errorf("Wrong embedded cover. Data: `%s`, type: %s", cover.Data,
+			cover.MIMEType)
+	}
+
+	if _, err := lib.GetAlbumCover(getAlbumID("four")); err != ErrCoverNotFound {
+		t.Errorf("Expected ErrCoverNotFound for album without a cover but got %v", err)
+	}
+
+	if _, err := lib.GetAlbumCover(666); err != ErrCoverNotFound {
+		t.Errorf("Expected ErrCoverNotFound for missing album but got %v", err)
+	}
+
+	// The picture was removed from the track.
+	albumID := getAlbumID("three")
+	writeTestFile(t, embeddedTrack, nil)
+	track := tracks[2].track
+
+	if err := lib.insertMediaIntoDatabase(&track, embeddedTrack); err != nil {
+		t.Fatalf("Updating a media file failed: %s", err)
+	}
+
+	if _, err := lib.GetAlbumCover(albumID); err != ErrCoverNotFound {
+		t.Errorf("Expected removed embedded cover to be gone but got %v", err)
+	}
+}
@@ -13,9 +13,9 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/howeyc/fsnotify"
-	taglib "github.com/wtolson/go-taglib"
 
 	// Blind import is the way a SQL driver is imported. This is the proposed way
 	// from the golang documentation.
@@ -63,6 +63,9 @@ type LocalLibrary struct {
 	// The configuration for how to scan the libraries.
 	ScanConfig config.ScanSection
 
+	// IndexTranscodedFormats adds files in the transcodedFormats to the library.
+	IndexTranscodedFormats bool
+
 	database string         // The location of the library's database
 	paths    []string       // FS locations which contain the library's media files
 	db       *sql.DB        // Database handler
@@ -87,6 +90,11 @@ type LocalLibrary struct {
 	isRunningLock sync.Mutex
 	waitScanLock  sync.RWMutex
 
+	// Playlist files found while scanning. They are imported after all paths
+	// are scanned so that their tracks are already in the library.
+	playlistFiles     []string
+	playlistFilesLock sync.Mutex
+
 	watcherWG sync.WaitGroup
 }
 
@@ -133,48 +141,6 @@ func (lib *LocalLibrary) AddLibraryPath(path string) {
 	lib.paths = append(lib.paths, path)
 }
 
-// Search searches in the library. Will match against the track's name, artist and album.
-func (lib *LocalLibrary) Search(searchTerm string) []SearchResult {
-	var output []SearchResult
-
-	searchTerm = fmt.Sprintf("%%%s%%", searchTerm)
-
-	rows, err := lib.db.Query(`
-		SELECT
-			t.id as track_id,
-			t.name as track,
-			al.name as album,
-			at.name as artist,
-			t.number as track_number,
-			t.album_id as album_id
-		FROM
-			tracks as t
-				LEFT JOIN albums as al ON al.id = t.album_id
-				LEFT JOIN artists as at ON at.id = t.artist_id
-		WHERE
-			t.name LIKE ? OR
-			al.name LIKE ? OR
-			at.name LIKE ?
-		ORDER BY
-			al.name, t.number
-	`, searchTerm, searchTerm, searchTerm)
-
-	if err != nil {
-		log.Printf("Query not successful: %s\n", err.Error())
-		return output
-	}
-
-	defer rows.Close()
-	for rows.Next() {
-		var res SearchResult
-		rows.Scan(&res.ID, &res.Title, &res.Album, &res.Artist,
-			&res.TrackNumber, &res.AlbumID)
-		output = append(output, res)
-	}
-
-	return output
-}
-
 // GetFilePath returns the filsystem path for a file specified by its ID.
 func (lib *LocalLibrary) GetFilePath(ID int64) string {
 
@@ -207,16 +173,9 @@ func (lib *LocalLibrary) GetFilePath(ID int64) string {
 
 // GetAlbumFiles satisfies the Library interface
 func (lib *LocalLibrary) GetAlbumFiles(albumID int64) []SearchResult {
-	var output []SearchResult
-
-	rows, err := lib.db.Query(`
+	rows, err := lib.db.Query(fmt.Sprintf(`
 		SELECT
-			t.id as track_id,
-			t.name as track,
-			al.name as album,
-			at.name as artist,
-			t.number as track_number,
-			t.album_id as album_id
+			%s
 		FROM
 			tracks as t
 				LEFT JOIN albums as al ON al.id = t.album_id
@@ -224,23 +183,70 @@ func (lib *LocalLibrary) GetAlbumFiles(albumID int64) []SearchResult {
 		WHERE
 			t.album_id = ?
 		ORDER BY
-			al.name, t.number
-	`, albumID)
+			t.disc, t.number
+	`, searchResultColumns), albumID)
 
 	if err != nil {
 		log.Printf("Query not successful: %s\n", err.Error())
-		return output
+		return nil
 	}
 
 	defer rows.Close()
-	for rows.Next() {
-		var res SearchResult
-		rows.Scan(&res.ID, &res.Title, &res.Album, &res.Artist,
-			&res.TrackNumber, &res.AlbumID)
-		output = append(output, res)
+	return scanSearchResults(rows)
+}
+
+// GetTrack satisfies the Library interface
+func (lib *LocalLibrary) GetTrack(trackID int64) (*SearchResult, error) {
+	rows, err := lib.db.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			tracks as t
+				LEFT JOIN albums as al ON al.id = t.album_id
+				LEFT JOIN artists as at ON at.id = t.artist_id
+		WHERE
+			t.id = ?
+	`, searchResultColumns), trackID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	found := scanSearchResults(rows)
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, ErrTrackNotFound
+	}
+
+	return &found[0], nil
+}
+
+// RecordPlay satisfies the Library interface
+func (lib *LocalLibrary) RecordPlay(trackID int64) error {
+	res, err := lib.db.Exec(`
+		UPDATE
+			tracks
+		SET
+			play_count = play_count + 1,
+			last_played = ?
+		WHERE
+			id = ?
+	`, time.Now().Unix(), trackID)
+
+	if err != nil {
+		return err
 	}
 
-	return output
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrTrackNotFound
+	}
+
+	return err
 }
 
 // Removes the file from the library. That means finding it in the database and
@@ -261,6 +267,11 @@ func (lib *LocalLibrary) removeFile(filePath string) {
 
 	if err != nil {
 		log.Printf("Error removing %s: %s\n", fullPath, err.Error())
+		return
+	}
+
+	if _, _, err := lib.removeEmptyAlbumsAndArtists(); err != nil {
+		log.Printf("Error cleaning up after removing %s: %s\n", fullPath, err)
 	}
 }
 
@@ -277,9 +288,46 @@ func (lib *LocalLibrary) removeDirectory(dirPath string) {
 
 	if err != nil {
 		log.Printf("Error removing %s: %s\n", dirPath, err.Error())
+		return
+	}
+
+	if _, _, err := lib.removeEmptyAlbumsAndArtists(); err != nil {
+		log.Printf("Error cleaning up after removing %s: %s\n", dirPath, err)
 	}
 }
 
+// removeEmptyAlbumsAndArtists deletes all albums and artists which do not have any
+// tracks in the library. Returns the number of deleted albums and artists.
+func (lib *LocalLibrary) removeEmptyAlbumsAndArtists() (int64, int64, error) {
+	res, err := lib.db.Exec(`
+		DELETE FROM albums
+		WHERE id NOT IN (
+			SELECT DISTINCT album_id FROM tracks WHERE album_id IS NOT NULL
+		)
+	`)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	albums, _ := res.RowsAffected()
+
+	res, err = lib.db.Exec(`
+		DELETE FROM artists
+		WHERE id NOT IN (
+			SELECT DISTINCT artist_id FROM tracks WHERE artist_id IS NOT NULL
+		)
+	`)
+
+	if err != nil {
+		return albums, 0, err
+	}
+
+	artists, _ := res.RowsAffected()
+
+	return albums, artists, nil
+}
+
 // Reads from the media channel and saves into the database every file
 // received.
 func (lib *LocalLibrary) databaseWriter() {
@@ -314,7 +362,8 @@ func (lib *LocalLibrary) writeInDb(media string) {
 }
 
 // Determines if the file will be saved to the database. Only media files which
-// jplayer can use are saved.
+// jplayer can use are saved. Files which could be played only after transcoding are
+// saved too when IndexTranscodedFormats is set.
 func (lib *LocalLibrary) isSupportedFormat(path string) bool {
 	supportedFormats := []string{
 		".mp3",
@@ -326,6 +375,10 @@ func (lib *LocalLibrary) isSupportedFormat(path string) bool {
 		".m4a",
 	}
 
+	if lib.IndexTranscodedFormats {
+		supportedFormats = append(supportedFormats, transcodedFormats...)
+	}
+
 	for _, format := range supportedFormats {
 		if !strings.HasSuffix(path, format) {
 			continue
@@ -335,27 +388,41 @@ func (lib *LocalLibrary) isSupportedFormat(path string) bool {
 	return false
 }
 
+// transcodedFormats are the extensions of media files which are read by TagLib but
+// most browsers cannot play. They are in the library only with
+// IndexTranscodedFormats.
+var transcodedFormats = []string{
+	".opus",
+	".wma",
+	".ape",
+	".wv",
+	".mpc",
+	".aac",
+	".aif",
+	".aiff",
+}
+
 // AddMedia adds a file specified by its filesystem name to the library. Will create the
 // needed Artist, Album if neccessery.
+// Files which are already in the library are read again only when their modification
+// time or size have changed.
 func (lib *LocalLibrary) AddMedia(filename string) error {
-	if lib.MediaExistsInLibrary(filename) {
-		return nil
-	}
-
-	_, err := os.Stat(filename)
+	st, err := os.Stat(filename)
 
 	if err != nil {
 		return err
 	}
 
-	file, err := taglib.Read(filename)
+	if stored, err := lib.getTrackFileInfo(filename); err == nil && stored.matches(st) {
+		return nil
+	}
+
+	file, err := readTaglibMedia(filename)
 
 	if err != nil {
 		return fmt.Errorf("Taglib error for %s: %s", filename, err.Error())
 	}
 
-	defer file.Close()
-
 	// log.Printf("New Song:\nArtist: %s\nAlbum: %s\nTitle: %s\nTrack: %d\n",
 	// 	file.Artist(), file.Album(), file.Title(), int(file.Track()))
 
@@ -363,7 +430,8 @@ func (lib *LocalLibrary) AddMedia(filename string) error {
 }
 
 // insertMediaIntoDatabase accepts an already parsed media info object, its path.
-// The method inserts this media into the library database.
+// The method inserts this media into the library database. If there is a track
+// for this path already it is updated and keeps its ID.
 func (lib *LocalLibrary) insertMediaIntoDatabase(file MediaFile, filePath string) error {
 	artistID, err := lib.setArtistID(file.Artist())
 
@@ -371,25 +439,122 @@ func (lib *LocalLibrary) insertMediaIntoDatabase(file MediaFile, filePath string
 		return err
 	}
 
-	fileDir := filepath.Dir(filePath)
+	fileDir := helpers.AlbumDirectory(filepath.Dir(filePath))
 
-	albumID, err := lib.setAlbumID(file.Album(), fileDir)
+	albumID, err := lib.setAlbumID(file.Album(), file.AlbumArtist(),
+		file.Compilation(), fileDir)
 
 	if err != nil {
 		return err
 	}
 
+	if err := lib.setAlbumCover(albumID, filePath, fileDir); err != nil {
+		log.Printf("Error finding a cover for album %d: %s\n", albumID, err)
+	}
+
 	trackNumber := int64(file.Track())
 
 	if trackNumber == 0 {
 		trackNumber = helpers.GuessTrackNumber(filePath)
 	}
 
-	_, err = lib.setTrackID(file.Title(), filePath, trackNumber, artistID, albumID)
+	var fileInfo trackFileInfo
+	if st, err := os.Stat(filePath); err == nil {
+		fileInfo.mtime = st.ModTime().UnixNano()
+		fileInfo.size = st.Size()
+	}
+
+	stored, err := lib.getTrackFileInfo(filePath)
+
+	if err == nil {
+		fileInfo.id = stored.id
+		return lib.updateTrack(file, filePath, trackNumber, artistID, albumID, fileInfo)
+	}
+
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = lib.setTrackID(file, filePath, trackNumber, artistID, albumID, fileInfo)
 
 	return err
 }
 
+// trackFileInfo is the file system information stored for a track. It is used for
+// finding out whether the track's file has changed since it was last read.
+type trackFileInfo struct {
+	id    int64
+	mtime int64 // modification time in nanoseconds since the Unix epoch
+	size  int64
+}
+
+// matches returns true when the stored information is the same as the one in st.
+func (ti trackFileInfo) matches(st os.FileInfo) bool {
+	return ti.mtime == st.ModTime().UnixNano() && ti.size == st.Size()
+}
+
+// getTrackFileInfo returns the stored file system information for the track with
+// this file path. Returns sql.ErrNoRows when there is no such track.
+func (lib *LocalLibrary) getTrackFileInfo(filename string) (trackFileInfo, error) {
+	var ti trackFileInfo
+
+	err := lib.db.QueryRow(`
+		SELECT
+			id,
+			fs_mtime,
+			fs_size
+		FROM
+			tracks
+		WHERE
+			fs_path = ?
+	`, filename).Scan(&ti.id, &ti.mtime, &ti.size)
+
+	return ti, err
+}
+
+// getDirectoryFileInfos returns the stored file system information for all tracks
+// in a directory and its subdirectories. The returned map is keyed by file path.
+func (lib *LocalLibrary) getDirectoryFileInfos(dirPath string) (
+	map[string]trackFileInfo, error) {
+
+	dirMatch := fmt.Sprintf("%s%c%%", strings.TrimRight(dirPath, string(filepath.Separator)),
+		filepath.Separator)
+
+	rows, err := lib.db.Query(`
+		SELECT
+			id,
+			fs_path,
+			fs_mtime,
+			fs_size
+		FROM
+			tracks
+		WHERE
+			fs_path LIKE ?
+	`, dirMatch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	infos := make(map[string]trackFileInfo)
+	for rows.Next() {
+		var (
+			ti     trackFileInfo
+			fsPath string
+		)
+
+		if err := rows.Scan(&ti.id, &fsPath, &ti.mtime, &ti.size); err != nil {
+			return nil, err
+		}
+
+		infos[fsPath] = ti
+	}
+
+	return infos, rows.Err()
+}
+
 // MediaExistsInLibrary checks if the media file with file system path "filename" has
 // been added to the library already.
 func (lib *LocalLibrary) MediaExistsInLibrary(filename string) bool {
@@ -515,25 +680,67 @@ func (lib *LocalLibrary) GetAlbumID(album string, fsPath string) (int64, error)
 	return id, nil
 }
 
+// getAlbumIDByArtist returns the id for the album with this name by this album
+// artist. When missing or on error returns that error.
+func (lib *LocalLibrary) getAlbumIDByArtist(album, albumArtist string) (int64, error) {
+	var id int64
+
+	err := lib.db.QueryRow(`
+		SELECT
+			id
+		FROM
+			albums
+		WHERE
+			name = ? AND
+			album_artist = ?
+	`, album, albumArtist).Scan(&id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
 // Sets a new ID for this album if it is new to the library. If not, returns
-// its current id. Albums with the same name but by different locations need to have
+// its current id.
+//
+// When the album artist is known the album is identified by its name and album
+// artist. This way releases split in many directories are still a single album.
+// Otherwise albums with the same name but by different locations need to have
 // separate IDs hence the fsPath parameter.
-func (lib *LocalLibrary) setAlbumID(album string, fsPath string) (int64, error) {
+//
+// An album is marked as a compilation as soon as one of its tracks is.
+func (lib *LocalLibrary) setAlbumID(album, albumArtist string, compilation bool,
+	fsPath string) (int64, error) {
+
 	if len(album) < 1 {
 		album = UnknownLabel
 	}
 
-	id, err := lib.GetAlbumID(album, fsPath)
+	var (
+		id  int64
+		err error
+	)
+
+	if albumArtist != "" {
+		id, err = lib.getAlbumIDByArtist(album, albumArtist)
+	} else {
+		id, err = lib.GetAlbumID(album, fsPath)
+	}
 
 	if err == nil {
-		return id, nil
+		if compilation {
+			err = lib.markAlbumAsCompilation(id)
+		}
+		return id, err
 	}
 
 	stmt, err := lib.db.Prepare(`
 			INSERT INTO
-				albums (name, fs_path)
+				albums (name, fs_path, album_artist, compilation)
 			VALUES
-				(?, ?)
+				(?, ?, ?, ?)
 	`)
 
 	if err != nil {
@@ -542,7 +749,7 @@ func (lib *LocalLibrary) setAlbumID(album string, fsPath string) (int64, error)
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(album, fsPath)
+	_, err = stmt.Exec(album, fsPath, albumArtist, compilation)
 
 	if err != nil {
 		return 0, err
@@ -550,11 +757,27 @@ func (lib *LocalLibrary) setAlbumID(album string, fsPath string) (int64, error)
 
 	newID, err := lib.lastInsertID()
 
-	log.Printf("Inserted album id: %d, name: %s, path: %s\n", newID, album, fsPath)
+	log.Printf("Inserted album id: %d, name: %s, album artist: %s, path: %s\n",
+		newID, album, albumArtist, fsPath)
 
 	return newID, err
 }
 
+// markAlbumAsCompilation sets the compilation flag for the album with this ID.
+func (lib *LocalLibrary) markAlbumAsCompilation(albumID int64) error {
+	_, err := lib.db.Exec(`
+		UPDATE
+			albums
+		SET
+			compilation = 1
+		WHERE
+			id = ? AND
+			compilation = 0
+	`, albumID)
+
+	return err
+}
+
 // GetAlbumFSPathByName returns all the file paths which contain versions of an album.
 func (lib *LocalLibrary) GetAlbumFSPathByName(albumName string) ([]string, error) {
 	var paths []string
@@ -624,13 +847,13 @@ func (lib *LocalLibrary) GetTrackID(title string,
 // its current id. Tracks with the same name but by different artists and/or album
 // need to have separate IDs hence the artistID and albumID parameters.
 // Additionally trackNumber and filesystem path (fsPath) are required. They are
-// used when retreiving this particular song for playing.
-func (lib *LocalLibrary) setTrackID(title, fsPath string,
-	trackNumber, artistID, albumID int64) (int64, error) {
+// used when retreiving this particular song for playing. The file's modification
+// time and size from fileInfo are stored for detecting changes on later scans.
+// The title and the rest of the track's meta data are taken from file.
+func (lib *LocalLibrary) setTrackID(file MediaFile, fsPath string,
+	trackNumber, artistID, albumID int64, fileInfo trackFileInfo) (int64, error) {
 
-	if len(title) < 1 {
-		title = filepath.Base(fsPath)
-	}
+	title := trackTitle(file, fsPath)
 
 	id, err := lib.GetTrackID(title, artistID, albumID)
 
@@ -640,9 +863,10 @@ func (lib *LocalLibrary) setTrackID(title, fsPath string,
 
 	stmt, err := lib.db.Prepare(`
 		INSERT INTO
-			tracks (name, album_id, artist_id, fs_path, number)
+			tracks (name, album_id, artist_id, fs_path, number, fs_mtime, fs_size,
+				duration, year, genre, disc, album_artist, composer, added_at)
 		VALUES
-			(?, ?, ?, ?, ?)
+			(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 
 	if err != nil {
@@ -651,18 +875,88 @@ func (lib *LocalLibrary) setTrackID(title, fsPath string,
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(title, albumID, artistID, fsPath, trackNumber)
+	res, err := stmt.Exec(title, albumID, artistID, fsPath, trackNumber,
+		fileInfo.mtime, fileInfo.size, durationMilliseconds(file), file.Year(),
+		file.Genre(), file.Disc(), file.AlbumArtist(), file.Composer(),
+		time.Now().Unix())
 
 	if err != nil {
 		return 0, err
 	}
 
-	newID, err := lib.lastInsertID()
+	newID, err := res.LastInsertId()
+
+	if err != nil {
+		return 0, err
+	}
 
 	log.Printf("Inserted id: %d, name: %s, album ID: %d, artist ID: %d, number: %d, fs_path: %s\n",
 		newID, title, albumID, artistID, trackNumber, fsPath)
 
-	return newID, err
+	return newID, indexTrackForSearch(lib.db, newID)
+}
+
+// updateTrack sets new meta data for the track with ID fileInfo.id. This is used when
+// the track's file has been changed since the last time it was read. Albums and
+// artists which are left without tracks after the update are not removed here. This
+// is done once at the end of the scan or after the watch event instead. Tracks which
+// do not know when they were added, such as ones from migrated databases, get the
+// file's modification time as their added time.
+func (lib *LocalLibrary) updateTrack(file MediaFile, fsPath string,
+	trackNumber, artistID, albumID int64, fileInfo trackFileInfo) error {
+
+	title := trackTitle(file, fsPath)
+
+	_, err := lib.db.Exec(`
+		UPDATE
+			tracks
+		SET
+			name = ?,
+			album_id = ?,
+			artist_id = ?,
+			number = ?,
+			fs_mtime = ?,
+			fs_size = ?,
+			duration = ?,
+			year = ?,
+			genre = ?,
+			disc = ?,
+			album_artist = ?,
+			composer = ?,
+			added_at = CASE added_at WHEN 0 THEN ? ELSE added_at END
+		WHERE
+			id = ?
+	`, title, albumID, artistID, trackNumber, fileInfo.mtime, fileInfo.size,
+		durationMilliseconds(file), file.Year(), file.Genre(), file.Disc(),
+		file.AlbumArtist(), file.Composer(), fileInfo.mtime/int64(time.Second),
+		fileInfo.id)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Updated id: %d, name: %s, album ID: %d, artist ID: %d, number: %d, fs_path: %s\n",
+		fileInfo.id, title, albumID, artistID, trackNumber, fsPath)
+
+	return indexTrackForSearch(lib.db, fileInfo.id)
+}
+
+// trackTitle returns the title of the media file. Files without a title are named
+// after their file name.
+func trackTitle(file MediaFile, fsPath string) string {
+	title := file.Title()
+
+	if len(title) < 1 {
+		title = filepath.Base(fsPath)
+	}
+
+	return title
+}
+
+// durationMilliseconds returns the length of the media file in milliseconds. This is
+// how durations are stored in the database.
+func durationMilliseconds(file MediaFile) int64 {
+	return int64(file.Length() / time.Millisecond)
 }
 
 // Returns the last ID insert in the database.
@@ -683,24 +977,33 @@ func (lib *LocalLibrary) lastInsertID() (int64, error) {
 }
 
 // Initialize should be run once every time a library is created. It checks for the
-// sqlite database file and creates one if it is absent. If a file is found
-// it does nothing.
+// sqlite database file and creates one if it is absent. Then it applies all schema
+// migrations which are missing from the database.
 func (lib *LocalLibrary) Initialize() error {
+	if lib.db == nil {
+		return errors.New("library is not opened, call its Open method first")
+	}
 
 	if st, err := os.Stat(lib.database); err == nil && st.Size() > 0 {
-		return nil
+		return lib.applyMigrations()
+	}
+
+	if err := lib.createBaseSchema(); err != nil {
+		return err
 	}
 
+	return lib.applyMigrations()
+}
+
+// createBaseSchema creates all tables from sqls/library_schema.sql in an empty
+// database.
+func (lib *LocalLibrary) createBaseSchema() error {
 	sqlSchema, err := lib.readSchema()
 
 	if err != nil {
 		return err
 	}
 
-	if lib.db == nil {
-		return errors.New("library is not opened, call its Open method first")
-	}
-
 	queries := strings.Split(sqlSchema, ";")
 
 	for _, query := range queries {
@@ -4,12 +4,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Scan scans all of the folders in paths for media files. New files will be added to the
-// database.
-//!TODO: make scan also remove files which have been deleted since the previous scan
+// database. Files which have been deleted since the previous scan are removed from it
+// together with the albums and artists left without tracks. Playlist files found in
+// the folders are imported as playlists at the end.
 func (lib *LocalLibrary) Scan() {
 	// Make sure there are no other scans working at the moment
 	lib.waitScanLock.RLock()
@@ -35,13 +37,122 @@ func (lib *LocalLibrary) Scan() {
 	lib.waitScanLock.RLock()
 	lib.walkWG.Wait()
 	lib.waitScanLock.RUnlock()
+
+	stats, err := lib.pruneLibrary()
+	if err != nil {
+		log.Printf("Error pruning deleted files from the library: %s", err)
+	} else {
+		log.Printf("Pruned %d deleted tracks, %d empty albums and %d empty artists",
+			stats.tracks, stats.albums, stats.artists)
+	}
+
+	lib.importPlaylistFiles()
+
 	log.Printf("Scaning took %s", time.Since(start))
 }
 
+// pruneStats holds the number of database entries removed while pruning the library.
+type pruneStats struct {
+	tracks  int64
+	albums  int64
+	artists int64
+}
+
+// pruneLibrary removes all tracks whose files are no longer on the file system and
+// then all albums and artists which were left without any tracks.
+//
+// Tracks which are in a library directory which is currently missing are left
+// alone. This way an unmounted drive would not wipe out its part of the library.
+func (lib *LocalLibrary) pruneLibrary() (pruneStats, error) {
+	var stats pruneStats
+
+	var missingRoots []string
+	for _, path := range lib.paths {
+		if _, err := os.Stat(path); err != nil {
+			missingRoots = append(missingRoots, path)
+		}
+	}
+
+	rows, err := lib.db.Query(`
+		SELECT
+			id,
+			fs_path
+		FROM
+			tracks
+	`)
+
+	if err != nil {
+		return stats, err
+	}
+
+	var deleted []int64
+	for rows.Next() {
+		var (
+			id     int64
+			fsPath string
+		)
+
+		if err := rows.Scan(&id, &fsPath); err != nil {
+			rows.Close()
+			return stats, err
+		}
+
+		if _, err := os.Stat(fsPath); !os.IsNotExist(err) {
+			continue
+		}
+
+		if isInAnyDirectory(fsPath, missingRoots) {
+			continue
+		}
+
+		deleted = append(deleted, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	for _, id := range deleted {
+		res, err := lib.db.Exec(`
+			DELETE FROM tracks
+			WHERE id = ?
+		`, id)
+
+		if err != nil {
+			return stats, err
+		}
+
+		affected, _ := res.RowsAffected()
+		stats.tracks += affected
+	}
+
+	stats.albums, stats.artists, err = lib.removeEmptyAlbumsAndArtists()
+
+	return stats, err
+}
+
+// isInAnyDirectory returns true if path is inside one of the directories.
+func isInAnyDirectory(path string, directories []string) bool {
+	for _, dir := range directories {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // This is the goroutine which actually scans a library path.
 // For now it ignores everything but the list of supported files. It is so
 // because jplayer cannot play anything else. Sends every suitable
-// file into the media channel
+// file into the media channel. Files which are already in the library and have
+// not been modified since are skipped. Playlist files are collected for importing
+// them once all paths are scanned.
 func (lib *LocalLibrary) scanPath(scannedPath string) {
 	start := time.Now()
 
@@ -50,6 +161,11 @@ func (lib *LocalLibrary) scanPath(scannedPath string) {
 		lib.walkWG.Done()
 	}()
 
+	knownFiles, err := lib.getDirectoryFileInfos(scannedPath)
+	if err != nil {
+		log.Printf("error getting known files in %s: %s", scannedPath, err)
+	}
+
 	filesPerOperation := lib.ScanConfig.FilesPerOperation
 	sleepPerOperation := lib.ScanConfig.SleepPerOperation
 
@@ -63,7 +179,15 @@ func (lib *LocalLibrary) scanPath(scannedPath string) {
 		}
 
 		if lib.isSupportedFormat(path) {
-			lib.writeInDb(path)
+			if stored, ok := knownFiles[path]; !ok || !stored.matches(info) {
+				lib.writeInDb(path)
+			}
+		}
+
+		if !info.IsDir() && isPlaylistFile(path) {
+			lib.playlistFilesLock.Lock()
+			lib.playlistFiles = append(lib.playlistFiles, path)
+			lib.playlistFilesLock.Unlock()
 		}
 
 		lib.watchLock.RLock()
@@ -87,7 +211,7 @@ func (lib *LocalLibrary) scanPath(scannedPath string) {
 		return nil
 	}
 
-	err := filepath.Walk(scannedPath, walkFunc)
+	err = filepath.Walk(scannedPath, walkFunc)
 
 	if err != nil {
 		log.Printf("error while walking %s: %s", scannedPath, err)
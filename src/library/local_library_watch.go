@@ -115,8 +115,14 @@ func (lib *LocalLibrary) handleWatchEvent(event *fsnotify.FileEvent) {
 
 	if event.IsModify() && !st.IsDir() {
 		if lib.isSupportedFormat(event.Name) {
-			lib.removeFile(event.Name)
+			// Adding a file which is already in the library updates its track
+			// while keeping the track ID.
 			lib.writeInDb(event.Name)
+
+			if _, _, err := lib.removeEmptyAlbumsAndArtists(); err != nil {
+				log.Printf("Error cleaning up after updating %s: %s\n", event.Name,
+					err)
+			}
 		}
 		return
 	}
@@ -0,0 +1,185 @@
+package library
+
+import (
+	"database/sql"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ironsmile/httpms/src/playlists"
+)
+
+// isPlaylistFile returns true for files in one of the playlist formats which could
+// be imported.
+func isPlaylistFile(path string) bool {
+	_, ok := playlists.FormatByExtension(filepath.Ext(path))
+	return ok
+}
+
+// importPlaylistFiles imports all playlist files found by the last scan.
+func (lib *LocalLibrary) importPlaylistFiles() {
+	lib.playlistFilesLock.Lock()
+	files := lib.playlistFiles
+	lib.playlistFiles = nil
+	lib.playlistFilesLock.Unlock()
+
+	for _, path := range files {
+		if err := lib.importPlaylistFile(path); err != nil {
+			log.Printf("Error importing playlist `%s`: %s\n", path, err)
+		}
+	}
+}
+
+// importPlaylistFile stores the playlist file at path as a library playlist. Its
+// entries are resolved to the tracks with these files. Entries which are not in the
+// library are skipped. The playlist is named after the title in the file or after
+// the file name when there is no title.
+//
+// A playlist is imported again only when its file has been modified since the last
+// import. Then its entries are replaced but its name is not changed.
+func (lib *LocalLibrary) importPlaylistFile(path string) error {
+	st, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	var (
+		id      int64
+		fsMtime int64
+	)
+
+	err = lib.db.QueryRow(`
+		SELECT
+			id,
+			fs_mtime
+		FROM
+			playlists
+		WHERE
+			fs_path = ?
+	`, path).Scan(&id, &fsMtime)
+
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == nil && fsMtime == st.ModTime().Unix() {
+		return nil
+	}
+
+	format, _ := playlists.FormatByExtension(filepath.Ext(path))
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	playlist, err := playlists.Read(fh, format)
+
+	if err != nil {
+		return err
+	}
+
+	var trackIDs []int64
+	for _, entry := range playlist.Entries {
+		entryPath, ok := resolvePlaylistEntry(path, entry.Location)
+
+		if !ok {
+			continue
+		}
+
+		var trackID int64
+		err := lib.db.QueryRow(`
+			SELECT
+				id
+			FROM
+				tracks
+			WHERE
+				fs_path = ?
+		`, entryPath).Scan(&trackID)
+
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		trackIDs = append(trackIDs, trackID)
+	}
+
+	if id == 0 {
+		name := playlist.Title
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+
+		res, err := lib.db.Exec(`
+			INSERT INTO
+				playlists (name, fs_path)
+			VALUES
+				(?, ?)
+		`, name, path)
+
+		if err != nil {
+			return err
+		}
+
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	err = lib.editPlaylist(id, func(_ *sql.Tx, _ []int64) ([]int64, error) {
+		return trackIDs, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported playlist `%s` with %d of its %d entries\n", path,
+		len(trackIDs), len(playlist.Entries))
+
+	_, err = lib.db.Exec(`
+		UPDATE
+			playlists
+		SET
+			fs_mtime = ?
+		WHERE
+			id = ?
+	`, st.ModTime().Unix(), id)
+
+	return err
+}
+
+// resolvePlaylistEntry returns the file system path of a playlist entry. Relative
+// paths are relative to the directory of the playlist file. Entries could be file://
+// URLs as well. Playlists made on Windows have backslashes as path separators.
+// Returns false for entries which cannot be local files such as HTTP URLs.
+func resolvePlaylistEntry(playlistPath, location string) (string, bool) {
+	if strings.HasPrefix(strings.ToLower(location), "file://") {
+		parsed, err := url.Parse(location)
+
+		if err != nil {
+			return "", false
+		}
+
+		location = parsed.Path
+	} else if strings.Contains(location, "://") {
+		return "", false
+	}
+
+	location = filepath.FromSlash(strings.Replace(location, `\`, "/", -1))
+
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(filepath.Dir(playlistPath), location)
+	}
+
+	return filepath.Clean(location), true
+}
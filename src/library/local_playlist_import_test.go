@@ -0,0 +1,169 @@
+package library
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/helpers"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestImportingPlaylistFiles(t *testing.T) {
+	projRoot, err := helpers.ProjectRoot()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	libraryDir, err := ioutil.TempDir("", "httpms_playlists_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(libraryDir)
+
+	one := filepath.Join(libraryDir, "music", "one.mp3")
+	two := filepath.Join(libraryDir, "music", "sub", "two.mp3")
+
+	for src, dst := range map[string]string{"test_file_one.mp3": one, "test_file_two.mp3": two} {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		err := helpers.Copy(filepath.Join(projRoot, "test_files", "library", src), dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mixPath := filepath.Join(libraryDir, "lists", "mix.m3u")
+	oneURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(one)}).String()
+
+	writeTestFile(t, mixPath, []byte("#EXTM3U\n../music/sub/two.mp3\n"+one+"\n"+
+		"missing.mp3\nhttp://example.com/stream.mp3\n"))
+	writeTestFile(t, filepath.Join(libraryDir, "music", "Evening.pls"), []byte(
+		"[playlist]\nFile1=sub\\two.mp3\nFile2=one.mp3\nNumberOfEntries=2\n"))
+	writeTestFile(t, filepath.Join(libraryDir, "music", "list.xspf"), []byte(
+		`<playlist version="1" xmlns="http://xspf.org/ns/0/"><title>Morning</title>`+
+			`<trackList><track><location>`+oneURL+`</location></track></trackList>`+
+			`</playlist>`))
+
+	lib, err := NewLocalLibrary(context.TODO(), SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lib.Truncate()
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatalf("Initializing library: %s", err)
+	}
+
+	lib.AddLibraryPath(libraryDir)
+
+	scan := func() {
+		ch := testErrorAfter(10, "Scanning library took too long")
+		lib.Scan()
+		ch <- 42
+	}
+
+	checkPlaylists := func(expected map[string][]string) {
+		found := lib.GetPlaylists()
+
+		if len(found) != len(expected) {
+			t.Fatalf("Expected %d playlists but found %+v", len(expected), found)
+		}
+
+		for _, playlist := range found {
+			files, ok := expected[playlist.Name]
+
+			if !ok {
+				t.Errorf("Unexpected playlist %s", playlist.Name)
+				continue
+			}
+
+			tracks, err := lib.GetPlaylistTracks(playlist.ID)
+
+			if err != nil {
+				t.Fatalf("Getting playlist tracks: %s", err)
+			}
+
+			if len(tracks) != len(files) {
+				t.Errorf("Expected %d tracks in %s but found %d", len(files),
+					playlist.Name, len(tracks))
+				continue
+			}
+
+			for i, track := range tracks {
+				if path := lib.GetFilePath(track.ID); path != files[i] {
+					t.Errorf("Expected %s at %d in %s but found %s", files[i], i,
+						playlist.Name, path)
+				}
+			}
+		}
+	}
+
+	scan()
+	checkPlaylists(map[string][]string{
+		"mix":     {two, one},
+		"Evening": {two, one},
+		"Morning": {one},
+	})
+
+	var mixID int64
+	for _, playlist := range lib.GetPlaylists() {
+		if playlist.Name == "mix" {
+			mixID = playlist.ID
+		}
+	}
+
+	if err := lib.RenamePlaylist(mixID, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged files are not imported again while changed ones replace the entries
+	// of their playlists.
+	writeTestFile(t, mixPath, []byte(one+"\n"))
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(mixPath, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	scan()
+	checkPlaylists(map[string][]string{
+		"Renamed": {one},
+		"Evening": {two, one},
+		"Morning": {one},
+	})
+}
+
+func TestResolvingPlaylistEntries(t *testing.T) {
+	playlist := filepath.FromSlash("/music/lists/mix.m3u")
+
+	tests := map[string]string{
+		"song.mp3":                    "/music/lists/song.mp3",
+		"../album/song.mp3":           "/music/album/song.mp3",
+		`..\album\song.mp3`:           "/music/album/song.mp3",
+		"/other/song.mp3":             "/other/song.mp3",
+		"file:///other/my%20song.mp3": "/other/my song.mp3",
+	}
+
+	for location, expected := range tests {
+		found, ok := resolvePlaylistEntry(playlist, location)
+
+		if !ok || found != filepath.FromSlash(expected) {
+			t.Errorf("Expected %s for %s but got %s", expected, location, found)
+		}
+	}
+
+	if found, ok := resolvePlaylistEntry(playlist, "http://example.com/a.mp3"); ok {
+		t.Errorf("Expected HTTP URLs to be skipped but got %s", found)
+	}
+}
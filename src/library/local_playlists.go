@@ -0,0 +1,522 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// playlistColumns are the columns for scanPlaylist. They are selected from the
+// playlists (p) table joined with its entries and their tracks and must be grouped by
+// the playlist ID.
+const playlistColumns = `
+			p.id,
+			p.name,
+			p.rules,
+			p.owner_id,
+			COUNT(pe.id),
+			IFNULL(SUM(t.duration), 0)
+`
+
+// playlistJoins are the tables needed by playlistColumns.
+const playlistJoins = `
+			playlists as p
+				LEFT JOIN playlist_entries as pe ON pe.playlist_id = p.id
+				LEFT JOIN tracks as t ON t.id = pe.track_id
+`
+
+// GetPlaylists satisfies the Library interface
+func (lib *LocalLibrary) GetPlaylists() []Playlist {
+	var playlists []Playlist
+
+	rows, err := lib.db.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s
+		GROUP BY
+			p.id
+		ORDER BY
+			p.name COLLATE NOCASE, p.id
+	`, playlistColumns, playlistJoins))
+
+	if err != nil {
+		log.Printf("Query not successful: %s\n", err.Error())
+		return playlists
+	}
+
+	for rows.Next() {
+		var playlist Playlist
+		if err := scanPlaylist(rows, &playlist); err != nil {
+			log.Printf("Error scanning playlist row: %s\n", err)
+			continue
+		}
+		playlists = append(playlists, playlist)
+	}
+	rows.Close()
+
+	for i := range playlists {
+		if err := lib.countSmartPlaylist(&playlists[i]); err != nil {
+			log.Printf("Error counting smart playlist tracks: %s\n", err)
+		}
+	}
+
+	return playlists
+}
+
+// GetPlaylist satisfies the Library interface
+func (lib *LocalLibrary) GetPlaylist(id int64) (*Playlist, error) {
+	var playlist Playlist
+
+	row := lib.db.QueryRow(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s
+		WHERE
+			p.id = ?
+		GROUP BY
+			p.id
+	`, playlistColumns, playlistJoins), id)
+
+	err := scanPlaylist(row, &playlist)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrPlaylistNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lib.countSmartPlaylist(&playlist); err != nil {
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// scanPlaylist reads a row with the playlistColumns into playlist.
+func scanPlaylist(row interface{ Scan(...interface{}) error }, playlist *Playlist) error {
+	var rules sql.NullString
+
+	err := row.Scan(
+		&playlist.ID,
+		&playlist.Name,
+		&rules,
+		&playlist.OwnerID,
+		&playlist.TrackCount,
+		&playlist.Duration,
+	)
+
+	if err != nil || !rules.Valid {
+		return err
+	}
+
+	playlist.Rules = &SmartRules{}
+	return json.Unmarshal([]byte(rules.String), playlist.Rules)
+}
+
+// countSmartPlaylist sets the track count and duration of smart playlists. Their
+// tracks are not in the playlist entries so they are counted with their rules.
+func (lib *LocalLibrary) countSmartPlaylist(playlist *Playlist) error {
+	if playlist.Rules == nil {
+		return nil
+	}
+
+	conditions, args, err := playlist.Rules.sql(time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	return lib.db.QueryRow(fmt.Sprintf(`
+		SELECT
+			COUNT(*),
+			IFNULL(SUM(duration), 0)
+		FROM (
+			SELECT
+				t.duration as duration
+			FROM
+				tracks as t
+					LEFT JOIN albums as al ON al.id = t.album_id
+					LEFT JOIN artists as at ON at.id = t.artist_id
+			%s
+		)
+	`, conditions), args...).Scan(&playlist.TrackCount, &playlist.Duration)
+}
+
+// CreatePlaylist satisfies the Library interface
+func (lib *LocalLibrary) CreatePlaylist(name string, ownerID int64) (*Playlist, error) {
+	res, err := lib.db.Exec(`
+		INSERT INTO
+			playlists (name, owner_id)
+		VALUES
+			(?, ?)
+	`, name, ownerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Playlist{ID: id, Name: name, OwnerID: ownerID}, nil
+}
+
+// CreateSmartPlaylist satisfies the Library interface
+func (lib *LocalLibrary) CreateSmartPlaylist(name string, rules SmartRules,
+	ownerID int64) (*Playlist, error) {
+
+	encoded, err := encodeSmartRules(rules)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := lib.db.Exec(`
+		INSERT INTO
+			playlists (name, rules, owner_id)
+		VALUES
+			(?, ?, ?)
+	`, name, encoded, ownerID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.GetPlaylist(id)
+}
+
+// SetSmartPlaylistRules satisfies the Library interface
+func (lib *LocalLibrary) SetSmartPlaylistRules(id int64, rules SmartRules) error {
+	encoded, err := encodeSmartRules(rules)
+
+	if err != nil {
+		return err
+	}
+
+	res, err := lib.db.Exec(`
+		UPDATE
+			playlists
+		SET
+			rules = ?
+		WHERE
+			id = ? AND
+			rules IS NOT NULL
+	`, encoded, id)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrPlaylistNotFound
+	}
+
+	return err
+}
+
+// encodeSmartRules returns the rules as they are stored in the database. Returns an
+// error when they are not valid.
+func encodeSmartRules(rules SmartRules) (string, error) {
+	if err := rules.Validate(); err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(rules)
+	return string(encoded), err
+}
+
+// RenamePlaylist satisfies the Library interface
+func (lib *LocalLibrary) RenamePlaylist(id int64, name string) error {
+	res, err := lib.db.Exec(`
+		UPDATE
+			playlists
+		SET
+			name = ?
+		WHERE
+			id = ?
+	`, name, id)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrPlaylistNotFound
+	}
+
+	return err
+}
+
+// DeletePlaylist satisfies the Library interface
+func (lib *LocalLibrary) DeletePlaylist(id int64) error {
+	tx, err := lib.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM playlists WHERE id = ?`, id)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		_ = tx.Rollback()
+		if err != nil {
+			return err
+		}
+		return ErrPlaylistNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM playlist_entries WHERE playlist_id = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPlaylistTracks satisfies the Library interface
+func (lib *LocalLibrary) GetPlaylistTracks(id int64) ([]SearchResult, error) {
+	playlist, err := lib.GetPlaylist(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if playlist.Rules != nil {
+		return lib.smartPlaylistTracks(*playlist.Rules)
+	}
+
+	rows, err := lib.db.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			playlist_entries as pe
+				JOIN tracks as t ON t.id = pe.track_id
+				LEFT JOIN albums as al ON al.id = t.album_id
+				LEFT JOIN artists as at ON at.id = t.artist_id
+		WHERE
+			pe.playlist_id = ?
+		ORDER BY
+			pe.position, pe.id
+	`, searchResultColumns), id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	tracks := scanSearchResults(rows)
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+// smartPlaylistTracks returns the tracks matched by these rules.
+func (lib *LocalLibrary) smartPlaylistTracks(rules SmartRules) ([]SearchResult, error) {
+	conditions, args, err := rules.sql(time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := lib.db.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			tracks as t
+				LEFT JOIN albums as al ON al.id = t.album_id
+				LEFT JOIN artists as at ON at.id = t.artist_id
+		%s
+	`, searchResultColumns, conditions), args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	tracks := scanSearchResults(rows)
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tracks, nil
+}
+
+// AddPlaylistTracks satisfies the Library interface
+func (lib *LocalLibrary) AddPlaylistTracks(
+	id int64,
+	trackIDs []int64,
+	position int,
+) error {
+	return lib.editPlaylist(id, func(tx *sql.Tx, entries []int64) ([]int64, error) {
+		for _, trackID := range trackIDs {
+			var found int64
+			err := tx.QueryRow(`SELECT id FROM tracks WHERE id = ?`, trackID).Scan(&found)
+
+			if err == sql.ErrNoRows {
+				return nil, ErrTrackNotFound
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if position < 0 || position > len(entries) {
+			position = len(entries)
+		}
+
+		edited := make([]int64, 0, len(entries)+len(trackIDs))
+		edited = append(edited, entries[:position]...)
+		edited = append(edited, trackIDs...)
+		return append(edited, entries[position:]...), nil
+	})
+}
+
+// RemovePlaylistTrack satisfies the Library interface
+func (lib *LocalLibrary) RemovePlaylistTrack(id int64, position int) error {
+	return lib.editPlaylist(id, func(_ *sql.Tx, entries []int64) ([]int64, error) {
+		if position < 0 || position >= len(entries) {
+			return nil, ErrPlaylistEntryNotFound
+		}
+
+		return append(entries[:position], entries[position+1:]...), nil
+	})
+}
+
+// MovePlaylistTrack satisfies the Library interface
+func (lib *LocalLibrary) MovePlaylistTrack(id int64, from, to int) error {
+	return lib.editPlaylist(id, func(_ *sql.Tx, entries []int64) ([]int64, error) {
+		if from < 0 || from >= len(entries) || to < 0 || to >= len(entries) {
+			return nil, ErrPlaylistEntryNotFound
+		}
+
+		trackID := entries[from]
+		entries = append(entries[:from], entries[from+1:]...)
+		entries = append(entries[:to], append([]int64{trackID}, entries[to:]...)...)
+		return entries, nil
+	})
+}
+
+// editPlaylist calls edit with the track IDs of the playlist entries in their order
+// and stores the entries it returns in their place. Everything happens in a single
+// transaction. Positions are renumbered so that they always start from zero and
+// have no gaps, even after entries are removed together with their tracks.
+func (lib *LocalLibrary) editPlaylist(
+	id int64,
+	edit func(tx *sql.Tx, entries []int64) ([]int64, error),
+) error {
+	tx, err := lib.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	entries, err := playlistEntries(tx, id)
+
+	if err == nil {
+		entries, err = edit(tx, entries)
+	}
+
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM playlist_entries WHERE playlist_id = ?`, id)
+	}
+
+	for position, trackID := range entries {
+		if err != nil {
+			break
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO
+				playlist_entries (playlist_id, track_id, position)
+			VALUES
+				(?, ?, ?)
+		`, id, trackID, position)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// playlistEntries returns the track IDs of the entries of a playlist in their order.
+// Returns ErrPlaylistNotFound when there is no such playlist and ErrPlaylistReadOnly
+// for smart playlists.
+func playlistEntries(tx *sql.Tx, id int64) ([]int64, error) {
+	var smart bool
+	err := tx.QueryRow(`
+		SELECT
+			rules IS NOT NULL
+		FROM
+			playlists
+		WHERE
+			id = ?
+	`, id).Scan(&smart)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrPlaylistNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if smart {
+		return nil, ErrPlaylistReadOnly
+	}
+
+	rows, err := tx.Query(`
+		SELECT
+			track_id
+		FROM
+			playlist_entries
+		WHERE
+			playlist_id = ?
+		ORDER BY
+			position, id
+	`, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var entries []int64
+	for rows.Next() {
+		var trackID int64
+		if err := rows.Scan(&trackID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, trackID)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,220 @@
+package library
+
+import (
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// playlistTitles returns the titles of the tracks in a playlist in their order.
+func playlistTitles(t *testing.T, lib *LocalLibrary, id int64) []string {
+	tracks, err := lib.GetPlaylistTracks(id)
+
+	if err != nil {
+		t.Fatalf("Getting playlist tracks: %s", err)
+	}
+
+	titles := []string{}
+	for _, track := range tracks {
+		titles = append(titles, track.Title)
+	}
+
+	return titles
+}
+
+func checkTitles(t *testing.T, lib *LocalLibrary, id int64, expected ...string) {
+	found := playlistTitles(t, lib, id)
+
+	if len(found) != len(expected) {
+		t.Fatalf("Expected playlist tracks %q but they were %q", expected, found)
+	}
+
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Fatalf("Expected playlist tracks %q but they were %q", expected, found)
+		}
+	}
+}
+
+// playlistDuration returns the sum of the durations of the tracks in the playlist
+// with this ID. The test files are not long so it must be positive.
+func playlistDuration(t *testing.T, lib *LocalLibrary, id int64) int64 {
+	tracks, err := lib.GetPlaylistTracks(id)
+
+	if err != nil {
+		t.Fatalf("Getting playlist tracks: %s", err)
+	}
+
+	var duration int64
+	for _, track := range tracks {
+		duration += track.Duration
+	}
+
+	if duration <= 0 {
+		t.Fatalf("Expected the tracks to have duration but they were %+v", tracks)
+	}
+
+	return duration
+}
+
+func TestPlaylists(t *testing.T) {
+	lib := getScannedLibrary(t)
+	defer lib.Truncate()
+
+	if playlists := lib.GetPlaylists(); len(playlists) != 0 {
+		t.Fatalf("Expected no playlists in a new library but found %+v", playlists)
+	}
+
+	second, err := lib.CreatePlaylist("Second", 0)
+
+	if err != nil {
+		t.Fatalf("Creating playlist: %s", err)
+	}
+
+	first, err := lib.CreatePlaylist("first", 0)
+
+	if err != nil {
+		t.Fatalf("Creating playlist: %s", err)
+	}
+
+	playlists := lib.GetPlaylists()
+
+	if len(playlists) != 2 || playlists[0].ID != first.ID || playlists[1].ID != second.ID {
+		t.Fatalf("Expected playlists ordered by name but they were %+v", playlists)
+	}
+
+	if err := lib.RenamePlaylist(second.ID, "Renamed"); err != nil {
+		t.Fatalf("Renaming playlist: %s", err)
+	}
+
+	found, err := lib.GetPlaylist(second.ID)
+
+	if err != nil {
+		t.Fatalf("Getting playlist: %s", err)
+	}
+
+	if found.Name != "Renamed" {
+		t.Errorf("Expected the playlist to be renamed but its name was %s", found.Name)
+	}
+
+	if err := lib.DeletePlaylist(second.ID); err != nil {
+		t.Fatalf("Deleting playlist: %s", err)
+	}
+
+	if _, err := lib.GetPlaylist(second.ID); err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound for deleted playlist but got %v", err)
+	}
+
+	if err := lib.RenamePlaylist(second.ID, "Again"); err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound when renaming but got %v", err)
+	}
+
+	if err := lib.DeletePlaylist(second.ID); err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound when deleting but got %v", err)
+	}
+
+	if _, err := lib.GetPlaylistTracks(second.ID); err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound for tracks but got %v", err)
+	}
+
+	err = lib.AddPlaylistTracks(second.ID, []int64{1}, -1)
+	if err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound when adding tracks but got %v", err)
+	}
+}
+
+func TestPlaylistEntries(t *testing.T) {
+	lib := getScannedLibrary(t)
+	defer lib.Truncate()
+
+	ids := make(map[string]int64)
+	tracks, _ := lib.Search(SearchArgs{})
+
+	for _, track := range tracks {
+		ids[track.Title] = track.ID
+	}
+
+	playlist, err := lib.CreatePlaylist("Mix", 0)
+
+	if err != nil {
+		t.Fatalf("Creating playlist: %s", err)
+	}
+
+	id := playlist.ID
+	add := func(position int, titles ...string) {
+		var trackIDs []int64
+		for _, title := range titles {
+			trackIDs = append(trackIDs, ids[title])
+		}
+
+		if err := lib.AddPlaylistTracks(id, trackIDs, position); err != nil {
+			t.Fatalf("Adding tracks %q: %s", titles, err)
+		}
+	}
+
+	add(-1, "Payback", "Tittled Track")
+	add(1, "Another One")
+	add(10, "Payback")
+	checkTitles(t, lib, id, "Payback", "Another One", "Tittled Track", "Payback")
+
+	if err := lib.AddPlaylistTracks(id, []int64{ids["Payback"], 9999}, 0); err != ErrTrackNotFound {
+		t.Errorf("Expected ErrTrackNotFound for missing track but got %v", err)
+	}
+	checkTitles(t, lib, id, "Payback", "Another One", "Tittled Track", "Payback")
+
+	if err := lib.MovePlaylistTrack(id, 0, 2); err != nil {
+		t.Fatalf("Moving playlist entry: %s", err)
+	}
+	checkTitles(t, lib, id, "Another One", "Tittled Track", "Payback", "Payback")
+
+	if err := lib.MovePlaylistTrack(id, 3, 0); err != nil {
+		t.Fatalf("Moving playlist entry: %s", err)
+	}
+	checkTitles(t, lib, id, "Payback", "Another One", "Tittled Track", "Payback")
+
+	if err := lib.RemovePlaylistTrack(id, 3); err != nil {
+		t.Fatalf("Removing playlist entry: %s", err)
+	}
+	checkTitles(t, lib, id, "Payback", "Another One", "Tittled Track")
+
+	for _, position := range []int{-1, 3} {
+		if err := lib.RemovePlaylistTrack(id, position); err != ErrPlaylistEntryNotFound {
+			t.Errorf("Expected ErrPlaylistEntryNotFound for %d but got %v", position, err)
+		}
+
+		if err := lib.MovePlaylistTrack(id, 0, position); err != ErrPlaylistEntryNotFound {
+			t.Errorf("Expected ErrPlaylistEntryNotFound for %d but got %v", position, err)
+		}
+	}
+
+	found, err := lib.GetPlaylist(id)
+
+	if err != nil {
+		t.Fatalf("Getting playlist: %s", err)
+	}
+
+	if expected := playlistDuration(t, lib, id); found.TrackCount != 3 ||
+		found.Duration != expected {
+		t.Errorf("Expected 3 tracks with duration %d but got %+v", expected, found)
+	}
+
+	// Rescanning changed files keeps their IDs so the entries must be unchanged.
+	if _, err := lib.db.Exec("UPDATE tracks SET fs_mtime = 0"); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := testErrorAfter(10, "Scanning library took too long")
+	lib.Scan()
+	ch <- 42
+
+	checkTitles(t, lib, id, "Payback", "Another One", "Tittled Track")
+
+	// Entries are removed together with their tracks.
+	lib.removeFile(lib.GetFilePath(ids["Another One"]))
+	checkTitles(t, lib, id, "Payback", "Tittled Track")
+
+	if err := lib.MovePlaylistTrack(id, 1, 0); err != nil {
+		t.Fatalf("Moving playlist entry: %s", err)
+	}
+	checkTitles(t, lib, id, "Tittled Track", "Payback")
+}
@@ -0,0 +1,462 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx. It makes it possible to use
+// the same functions in normal operation and in migrations.
+type sqlExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// searchResultColumns are the columns from the tracks (t), albums (al) and artists (at)
+// tables needed for a SearchResult. They are in the order expected by
+// scanSearchResults.
+const searchResultColumns = `
+			t.id as track_id,
+			t.name as track,
+			al.name as album,
+			at.name as artist,
+			t.number as track_number,
+			t.album_id as album_id,
+			t.duration as duration,
+			t.year as year,
+			t.genre as genre,
+			t.disc as disc,
+			t.album_artist as album_artist,
+			t.composer as composer,
+			t.artist_id as artist_id`
+
+// searchRanking is the relevance of a search match. The weights are for the title,
+// album, artist and genre columns of the search index. Smaller values are better
+// matches.
+const searchRanking = "bm25(tracks_search, 2.0, 1.0, 1.0, 0.5)"
+
+// Search searches in the library. The search query is parsed with ParseSearchQuery.
+// Words without a field qualifier are matched against the track's name, artist and
+// album and every one of them must be found in at least one of these. Results are
+// ordered by relevance. Matching is case and diacritic insensitive. An empty query
+// matches all tracks. Malformed queries match nothing. Returns the results for the
+// requested offset and limit and the number of all results for this query.
+func (lib *LocalLibrary) Search(args SearchArgs) ([]SearchResult, int) {
+	stmt, ok := parseSearchArgs(args, fieldAny)
+
+	if !ok {
+		return nil, 0
+	}
+
+	queryArgs := append([]interface{}{}, stmt.args...)
+	queryArgs = append(queryArgs, sqlLimit(args.Limit), args.Offset)
+
+	rows, err := lib.db.Query(fmt.Sprintf(`
+		SELECT
+			%s
+		FROM
+			%s
+				LEFT JOIN albums as al ON al.id = t.album_id
+				LEFT JOIN artists as at ON at.id = t.artist_id
+		%s
+		ORDER BY
+			%s
+		LIMIT ? OFFSET ?
+	`, searchResultColumns, stmt.from, stmt.where, stmt.orderBy), queryArgs...)
+
+	if err != nil {
+		log.Printf("Query not successful: %s\n", err.Error())
+		return nil, 0
+	}
+
+	results := scanSearchResults(rows)
+	rows.Close()
+
+	if args.Limit == 0 && args.Offset == 0 {
+		return results, len(results)
+	}
+
+	var count int
+	err = lib.db.QueryRow(fmt.Sprintf(`
+		SELECT
+			COUNT(*)
+		FROM
+			%s
+		%s
+	`, stmt.from, stmt.where), stmt.args...).Scan(&count)
+
+	if err != nil {
+		log.Printf("Query for search results count not successful: %s\n", err)
+		return results, len(results)
+	}
+
+	return results, count
+}
+
+// SearchArtists satisfies the Library interface. Artists are ordered by their name.
+func (lib *LocalLibrary) SearchArtists(args SearchArgs) ([]Artist, int) {
+	stmt, ok := parseSearchArgs(args, fieldArtist)
+
+	if !ok {
+		return nil, 0
+	}
+
+	condition := fmt.Sprintf("ar.id IN (SELECT t.artist_id FROM %s %s)", stmt.from,
+		stmt.where)
+
+	queryArgs := append([]interface{}{}, stmt.args...)
+	queryArgs = append(queryArgs, sqlLimit(args.Limit), args.Offset)
+
+	rows, err := lib.db.Query(fmt.Sprintf(`
+		SELECT
+			ar.id,
+			ar.name
+		FROM
+			artists ar
+		WHERE
+			%s
+		ORDER BY
+			ar.name
+		LIMIT ? OFFSET ?
+	`, condition), queryArgs...)
+
+	if err != nil {
+		log.Printf("Query for searching artists not successful: %s\n", err)
+		return nil, 0
+	}
+
+	var output []Artist
+	for rows.Next() {
+		var res Artist
+		rows.Scan(&res.ID, &res.Name)
+		output = append(output, res)
+	}
+	rows.Close()
+
+	return output, lib.countSearchResults(stmt, "t.artist_id", len(output), args)
+}
+
+// SearchAlbums satisfies the Library interface. Albums are ordered by their name.
+func (lib *LocalLibrary) SearchAlbums(args SearchArgs) ([]Album, int) {
+	stmt, ok := parseSearchArgs(args, fieldAlbum)
+
+	if !ok {
+		return nil, 0
+	}
+
+	condition := fmt.Sprintf("tr.album_id IN (SELECT t.album_id FROM %s %s)",
+		stmt.from, stmt.where)
+
+	queryArgs := append([]interface{}{}, stmt.args...)
+	queryArgs = append(queryArgs, sqlLimit(args.Limit), args.Offset)
+
+	rows, err := lib.db.Query(albumsQuery(condition, `
+		ORDER BY
+			al.name
+		LIMIT ? OFFSET ?
+	`), queryArgs...)
+
+	if err != nil {
+		log.Printf("Query for searching albums not successful: %s\n", err)
+		return nil, 0
+	}
+
+	output := scanAlbums(rows)
+	rows.Close()
+
+	return output, lib.countSearchResults(stmt, "t.album_id", len(output), args)
+}
+
+// countSearchResults returns the number of distinct values of column in the tracks
+// matched by the search statement. found is the number of results returned for the
+// search arguments. When there was no limit and offset it is the count of all of
+// them and the database is not queried at all.
+func (lib *LocalLibrary) countSearchResults(
+	stmt searchStatement,
+	column string,
+	found int,
+	args SearchArgs,
+) int {
+	if args.Limit == 0 && args.Offset == 0 {
+		return found
+	}
+
+	var count int
+	err := lib.db.QueryRow(fmt.Sprintf(`
+		SELECT
+			COUNT(DISTINCT %s)
+		FROM
+			%s
+		%s
+	`, column, stmt.from, stmt.where), stmt.args...).Scan(&count)
+
+	if err != nil {
+		log.Printf("Query for search results count not successful: %s\n", err)
+		return found
+	}
+
+	return count
+}
+
+// parseSearchArgs parses the query from the search arguments and translates it into
+// parts of an SQL query. Words without a field qualifier are matched against
+// defaultField. ok is false when the query is malformed or there is nothing in it
+// which could be matched. An empty query matches all tracks.
+func parseSearchArgs(args SearchArgs, defaultField string) (
+	stmt searchStatement,
+	ok bool,
+) {
+	query, err := ParseSearchQuery(args.Query)
+
+	if err != nil {
+		log.Printf("Malformed search query `%s`: %s\n", args.Query, err)
+		return stmt, false
+	}
+
+	if query.Empty() && strings.TrimSpace(args.Query) != "" {
+		// There is nothing which could be matched in the search query.
+		return stmt, false
+	}
+
+	for ind := range query.terms {
+		if query.terms[ind].field == fieldAny {
+			query.terms[ind].field = defaultField
+		}
+	}
+
+	return searchSQL(query), true
+}
+
+// sqlLimit returns the value for an SQL LIMIT clause. Zero means "no limit" for the
+// search arguments while SQLite uses negative values for this.
+func sqlLimit(limit uint) int64 {
+	if limit == 0 {
+		return -1
+	}
+	return int64(limit)
+}
+
+// searchStatement holds the parts of an SQL query which finds the tracks matching a
+// search query. The tracks table is aliased as "t".
+type searchStatement struct {
+	from    string
+	where   string
+	orderBy string
+	args    []interface{}
+}
+
+// searchSQL translates the search query into parts of an SQL query and its arguments.
+// The text in the query is matched using the full-text search index while years are
+// compared directly in the tracks table.
+func searchSQL(query *SearchQuery) searchStatement {
+	var (
+		matches, exclusions []string
+		yearConditions      []string
+		yearArgs            []interface{}
+	)
+
+	for _, term := range query.terms {
+		if term.field == fieldYear {
+			condition := "t.year BETWEEN ? AND ?"
+			if term.negated {
+				condition = "NOT (" + condition + ")"
+			}
+			yearConditions = append(yearConditions, condition)
+			yearArgs = append(yearArgs, term.yearFrom, term.yearTo)
+			continue
+		}
+
+		if term.negated {
+			exclusions = append(exclusions, ftsExpression(term))
+		} else {
+			matches = append(matches, ftsExpression(term))
+		}
+	}
+
+	var conditions []string
+
+	stmt := searchStatement{
+		from:    "tracks as t",
+		orderBy: "al.name, t.disc, t.number",
+	}
+
+	if len(matches) > 0 {
+		stmt.from = "tracks_search JOIN tracks as t ON t.id = tracks_search.rowid"
+		stmt.orderBy = searchRanking + ", " + stmt.orderBy
+		conditions = append(conditions, "tracks_search MATCH ?")
+		stmt.args = append(stmt.args, strings.Join(matches, " AND "))
+	}
+
+	if len(exclusions) > 0 {
+		conditions = append(conditions, `t.id NOT IN (
+			SELECT rowid FROM tracks_search WHERE tracks_search MATCH ?
+		)`)
+		stmt.args = append(stmt.args, strings.Join(exclusions, " OR "))
+	}
+
+	conditions = append(conditions, yearConditions...)
+	stmt.args = append(stmt.args, yearArgs...)
+
+	if len(conditions) > 0 {
+		stmt.where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return stmt
+}
+
+// ftsExpression converts a search term into an expression for the full-text search
+// index. Its text becomes a quoted string so that nothing in it is treated as query
+// syntax. Terms without a field are matched against the title, album and artist.
+func ftsExpression(term searchTerm) string {
+	columns := "{title album artist}"
+	if term.field != fieldAny {
+		columns = term.field
+	}
+
+	phrase := `"` + strings.Replace(term.text, `"`, `""`, -1) + `"`
+	if term.prefix {
+		phrase += "*"
+	}
+
+	return fmt.Sprintf("(%s : %s)", columns, phrase)
+}
+
+// scanSearchResults reads all rows which were selected with searchResultColumns.
+func scanSearchResults(rows *sql.Rows) []SearchResult {
+	var output []SearchResult
+
+	for rows.Next() {
+		var res SearchResult
+		rows.Scan(&res.ID, &res.Title, &res.Album, &res.Artist,
+			&res.TrackNumber, &res.AlbumID, &res.Duration, &res.Year, &res.Genre,
+			&res.DiscNumber, &res.AlbumArtist, &res.Composer, &res.ArtistID)
+		output = append(output, res)
+	}
+
+	return output
+}
+
+// isWordCharacter returns true for characters which are indexed by the full-text
+// search. Everything else is a separator.
+func isWordCharacter(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// normalizeForSearch returns text suitable for case and diacritic insensitive
+// matching. Diacritics are removed and the result is case folded so that "Björk"
+// and "BJORK" are both "bjork". It is used for both the indexed text and the
+// search terms.
+func normalizeForSearch(text string) string {
+	// Transformers keep state and cannot be shared between goroutines.
+	normalizer := transform.Chain(
+		norm.NFD,
+		runes.Remove(runes.In(unicode.Mn)),
+		cases.Fold(),
+		norm.NFC,
+	)
+
+	normalized, _, err := transform.String(normalizer, text)
+
+	if err != nil {
+		return strings.ToLower(text)
+	}
+
+	return normalized
+}
+
+// indexTrackForSearch adds the track with this ID to the full-text search index. If
+// it is there already, its entry is replaced. Removing tracks from the index is done
+// by a trigger on deleting them. The indexed names are normalized with
+// normalizeForSearch.
+func indexTrackForSearch(db sqlExecutor, trackID int64) error {
+	var title, album, artist, genre sql.NullString
+
+	err := db.QueryRow(`
+		SELECT
+			t.name,
+			al.name,
+			at.name,
+			t.genre
+		FROM
+			tracks as t
+				LEFT JOIN albums as al ON al.id = t.album_id
+				LEFT JOIN artists as at ON at.id = t.artist_id
+		WHERE
+			t.id = ?
+	`, trackID).Scan(&title, &album, &artist, &genre)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		DELETE FROM tracks_search
+		WHERE rowid = ?
+	`, trackID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO
+			tracks_search (rowid, title, album, artist, genre)
+		VALUES
+			(?, ?, ?, ?, ?)
+	`, trackID, normalizeForSearch(title.String), normalizeForSearch(album.String),
+		normalizeForSearch(artist.String), normalizeForSearch(genre.String))
+
+	return err
+}
+
+// rebuildSearchIndex creates the full-text search index anew with its current columns
+// and adds all tracks to it.
+func rebuildSearchIndex(tx *sql.Tx) error {
+	err := execQueries(tx,
+		"drop table if exists tracks_search",
+		"create virtual table tracks_search using fts5(title, album, artist, genre)",
+	)
+
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT `id` FROM `tracks`")
+
+	if err != nil {
+		return err
+	}
+
+	var trackIDs []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		trackIDs = append(trackIDs, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range trackIDs {
+		if err := indexTrackForSearch(tx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,504 @@
+package library
+
+import (
+	"fmt"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Adds tracks to the library in the order they are given. Their paths are the
+// map keys.
+func addSearchTracks(t *testing.T, lib *LocalLibrary, paths []string,
+	tracks map[string]MockMedia) {
+
+	for _, path := range paths {
+		track := tracks[path]
+		if err := lib.insertMediaIntoDatabase(&track, path); err != nil {
+			t.Fatalf("Adding a media file %s failed: %s", track.Title(), err)
+		}
+	}
+}
+
+func TestSearchingMultipleWords(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	paths := []string{
+		"/media/bugs/track-1.mp3",
+		"/media/bugs/track-2.mp3",
+		"/media/other/track-1.mp3",
+	}
+
+	addSearchTracks(t, lib, paths, map[string]MockMedia{
+		paths[0]: {
+			artist: "Buggy Bugoff",
+			album:  "The Return Of The Bugs",
+			title:  "Payback",
+			track:  1,
+		},
+		paths[1]: {
+			artist: "Buggy Bugoff",
+			album:  "The Return Of The Bugs",
+			title:  "Realization",
+			track:  2,
+		},
+		paths[2]: {
+			artist: "Someone Else",
+			album:  "Payback Time",
+			title:  "Opening",
+			track:  1,
+		},
+	})
+
+	lib.stop()
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"bugoff payback", []string{"Payback"}},
+		{"payback BUGOFF", []string{"Payback"}},
+		{"return realiz", []string{"Realization"}},
+		{"payback", []string{"Payback", "Opening"}},
+		{"payback nonexistent", nil},
+		{`"payback`, nil},
+		{"- ! ?", nil},
+	}
+
+	for _, test := range tests {
+		found, _ := lib.Search(SearchArgs{Query: test.query})
+
+		if len(found) != len(test.expected) {
+			t.Errorf("Expected %d results for `%s` but got %d: %v", len(test.expected),
+				test.query, len(found), found)
+			continue
+		}
+
+		for ind, title := range test.expected {
+			if found[ind].Title != title {
+				t.Errorf("Expected result %d for `%s` to be %s but it was %s", ind,
+					test.query, title, found[ind].Title)
+			}
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	paths := []string{
+		"/media/album-match/track-1.mp3",
+		"/media/artist-match/track-1.mp3",
+		"/media/title-match/track-1.mp3",
+	}
+
+	addSearchTracks(t, lib, paths, map[string]MockMedia{
+		paths[0]: {
+			artist: "Someone",
+			album:  "Songs About Rabbits",
+			title:  "First Song",
+			track:  1,
+		},
+		paths[1]: {
+			artist: "Rabbit Rabbit",
+			album:  "Anything",
+			title:  "Second Song",
+			track:  1,
+		},
+		paths[2]: {
+			artist: "Somebody",
+			album:  "Another",
+			title:  "White Rabbit",
+			track:  1,
+		},
+	})
+
+	lib.stop()
+
+	found, _ := lib.Search(SearchArgs{Query: "rabbit"})
+
+	if len(found) != 3 {
+		t.Fatalf("Expected three results but got %d: %v", len(found), found)
+	}
+
+	// The title is more important than the album and the artist.
+	if found[0].Title != "White Rabbit" {
+		t.Errorf("Expected the title match to be first but it was %s", found[0].Title)
+	}
+}
+
+func TestSearchIndexIsKeptInSync(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	path := "/media/changing/track-1.mp3"
+	track := MockMedia{
+		artist: "Changing Artist",
+		album:  "Changing Album",
+		title:  "Old Title",
+		track:  1,
+	}
+
+	if err := lib.insertMediaIntoDatabase(&track, path); err != nil {
+		t.Fatalf("Adding a media file failed: %s", err)
+	}
+
+	track.title = "New Title"
+
+	if err := lib.insertMediaIntoDatabase(&track, path); err != nil {
+		t.Fatalf("Updating a media file failed: %s", err)
+	}
+
+	lib.stop()
+
+	if found, _ := lib.Search(SearchArgs{Query: "old title"}); len(found) != 0 {
+		t.Errorf("Expected the old title not to be found but got %v", found)
+	}
+
+	if found, _ := lib.Search(SearchArgs{Query: "new title"}); len(found) != 1 {
+		t.Errorf("Expected the new title to be found once but got %v", found)
+	}
+
+	lib.removeFile(path)
+
+	if found, _ := lib.Search(SearchArgs{Query: "changing"}); len(found) != 0 {
+		t.Errorf("Expected removed track not to be found but got %v", found)
+	}
+
+	var indexed int
+	err := lib.db.QueryRow("SELECT COUNT(*) FROM tracks_search").Scan(&indexed)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexed != 0 {
+		t.Errorf("Expected the search index to be empty but it had %d rows", indexed)
+	}
+}
+
+func TestSearchIsCaseAndDiacriticInsensitive(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	paths := []string{
+		"/media/homogenic/track-1.mp3",
+		"/media/mechta/track-1.mp3",
+		"/media/greek/track-1.mp3",
+	}
+
+	addSearchTracks(t, lib, paths, map[string]MockMedia{
+		paths[0]: {
+			artist: "Björk",
+			album:  "Homogenic",
+			title:  "Jóga",
+			track:  1,
+		},
+		paths[1]: {
+			artist: "Кино",
+			album:  "Звезда по имени Солнце",
+			title:  "Мечта",
+			track:  1,
+		},
+		paths[2]: {
+			artist: "Μίκης Θεοδωράκης",
+			album:  "Ζορμπάς",
+			title:  "Χορός Του Ζορμπά",
+			track:  1,
+		},
+	})
+
+	lib.stop()
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"bjork", "Jóga"},
+		{"BJÖRK joga", "Jóga"},
+		{"мечта", "Мечта"},
+		{"КИНО", "Мечта"},
+		{"звезда СОЛН", "Мечта"},
+		{"ΧΟΡΟΣ", "Χορός Του Ζορμπά"},
+		{"μικης θεοδωρακης", "Χορός Του Ζορμπά"},
+		{"ζορμπας", "Χορός Του Ζορμπά"},
+	}
+
+	for _, test := range tests {
+		found, _ := lib.Search(SearchArgs{Query: test.query})
+
+		if len(found) != 1 {
+			t.Errorf("Expected one result for `%s` but got %d: %v", test.query,
+				len(found), found)
+			continue
+		}
+
+		if found[0].Title != test.expected {
+			t.Errorf("Expected `%s` to find %s but it found %s", test.query,
+				test.expected, found[0].Title)
+		}
+	}
+}
+
+func TestNormalizingForSearch(t *testing.T) {
+	tests := map[string]string{
+		"Björk":          "bjork",
+		"Ελλάδα":         "ελλαδα",
+		"ΟΔΥΣΣΕΥΣ":       "οδυσσευσ",
+		"Мечта":          "мечта",
+		"Ёлка":           "елка",
+		"Straße":         "strasse",
+		"Crème Brûlée":   "creme brulee",
+		"Bjo\u0308rk":    "bjork",
+		"plain ascii 42": "plain ascii 42",
+	}
+
+	for text, expected := range tests {
+		if found := normalizeForSearch(text); found != expected {
+			t.Errorf("Expected `%s` to be normalized to `%s` but it was `%s`", text,
+				expected, found)
+		}
+	}
+}
+
+func TestSearchingWithFieldQualifiers(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	paths := []string{
+		"/media/surrealistic-pillow/track-1.mp3",
+		"/media/surrealistic-pillow/track-2.mp3",
+		"/media/bless-its-pointed-little-head/track-1.mp3",
+		"/media/loves-you/track-1.mp3",
+	}
+
+	addSearchTracks(t, lib, paths, map[string]MockMedia{
+		paths[0]: {
+			artist: "Jefferson Airplane",
+			album:  "Surrealistic Pillow",
+			title:  "White Rabbit",
+			track:  1,
+			year:   1967,
+			genre:  "Psychedelic Rock",
+		},
+		paths[1]: {
+			artist: "Jefferson Airplane",
+			album:  "Surrealistic Pillow",
+			title:  "Somebody to Love",
+			track:  2,
+			year:   1967,
+			genre:  "Psychedelic Rock",
+		},
+		paths[2]: {
+			artist: "Jefferson Airplane",
+			album:  "Bless Its Pointed Little Head (Live)",
+			title:  "Somebody to Love",
+			track:  1,
+			year:   1969,
+			genre:  "Rock",
+		},
+		paths[3]: {
+			artist: "Love Band",
+			album:  "Loves You",
+			title:  "Airplane Song",
+			track:  1,
+			year:   1992,
+			genre:  "Pop",
+		},
+	})
+
+	lib.stop()
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{`airplane`, 4},
+		{`artist:airplane`, 3},
+		{`ARTIST:Airplane title:love`, 2},
+		{`title:airplane`, 1},
+		{`artist:"jefferson airplane"`, 3},
+		{`artist:"airplane jefferson"`, 0},
+		{`"somebody to love"`, 2},
+		{`"to somebody"`, 0},
+		{`love -live`, 2},
+		{`airplane -album:live -genre:pop`, 2},
+		{`genre:rock`, 3},
+		{`genre:psychedelic`, 2},
+		{`year:1967`, 2},
+		{`year:1960..1969`, 3},
+		{`year:1968..`, 2},
+		{`year:..1968 love`, 1},
+		{`-year:1960..1969`, 1},
+		{`-airplane`, 0},
+		{`-artist:jefferson`, 1},
+		{`somebody year:1969`, 1},
+		{`title:somebody album:pillow year:1967 artist:jeff`, 1},
+		{`notes:nothing`, 0},
+	}
+
+	for _, test := range tests {
+		found, _ := lib.Search(SearchArgs{Query: test.query})
+
+		if len(found) != test.expected {
+			t.Errorf("Expected %d results for `%s` but got %d: %v", test.expected,
+				test.query, len(found), found)
+		}
+	}
+}
+
+func TestSearchWithOffsetAndLimit(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	var paths []string
+	tracks := make(map[string]MockMedia)
+
+	for i := 1; i <= 5; i++ {
+		path := fmt.Sprintf("/media/paginated/track-%d.mp3", i)
+		paths = append(paths, path)
+		tracks[path] = MockMedia{
+			artist: "Paginated Artist",
+			album:  "Paginated Album",
+			title:  fmt.Sprintf("Track Number %d", i),
+			track:  i,
+		}
+	}
+
+	addSearchTracks(t, lib, paths, tracks)
+	lib.stop()
+
+	tests := []struct {
+		args     SearchArgs
+		count    int
+		expected []int
+	}{
+		{SearchArgs{Query: "paginated"}, 5, []int{1, 2, 3, 4, 5}},
+		{SearchArgs{Query: "paginated", Limit: 2}, 5, []int{1, 2}},
+		{SearchArgs{Query: "paginated", Limit: 2, Offset: 2}, 5, []int{3, 4}},
+		{SearchArgs{Query: "paginated", Limit: 2, Offset: 4}, 5, []int{5}},
+		{SearchArgs{Query: "paginated", Offset: 3}, 5, []int{4, 5}},
+		{SearchArgs{Query: "paginated", Limit: 2, Offset: 10}, 5, nil},
+		{SearchArgs{Query: "", Limit: 3, Offset: 1}, 5, []int{2, 3, 4}},
+		{
+			SearchArgs{Query: `paginated -title:"number 4"`, Limit: 3, Offset: 1},
+			4,
+			[]int{2, 3, 5},
+		},
+	}
+
+	for _, test := range tests {
+		found, count := lib.Search(test.args)
+
+		if count != test.count {
+			t.Errorf("Expected count %d for %+v but it was %d", test.count,
+				test.args, count)
+		}
+
+		if len(found) != len(test.expected) {
+			t.Errorf("Expected %d results for %+v but got %d", len(test.expected),
+				test.args, len(found))
+			continue
+		}
+
+		for ind, trackNumber := range test.expected {
+			if found[ind].TrackNumber != int64(trackNumber) {
+				t.Errorf("Expected result %d for %+v to be track %d but it was %d",
+					ind, test.args, trackNumber, found[ind].TrackNumber)
+			}
+		}
+	}
+}
+
+func TestSearchingArtistsAndAlbums(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	paths := []string{
+		"/media/surrealistic-pillow/track-1.mp3",
+		"/media/surrealistic-pillow/track-2.mp3",
+		"/media/airplane-songs/track-1.mp3",
+		"/media/airplane-songs/track-2.mp3",
+	}
+
+	addSearchTracks(t, lib, paths, map[string]MockMedia{
+		paths[0]: {
+			artist: "Jefferson Airplane",
+			album:  "Surrealistic Pillow",
+			title:  "White Rabbit",
+			track:  1,
+		},
+		paths[1]: {
+			artist: "Jefferson Airplane",
+			album:  "Surrealistic Pillow",
+			title:  "Somebody to Love",
+			track:  2,
+		},
+		paths[2]: {
+			artist: "Love Band",
+			album:  "Airplane Songs",
+			title:  "Jefferson",
+			track:  1,
+		},
+		paths[3]: {
+			artist: "Another Band",
+			album:  "Airplane Songs",
+			title:  "Second",
+			track:  2,
+		},
+	})
+
+	lib.stop()
+
+	artistTests := []struct {
+		args     SearchArgs
+		expected []string
+		count    int
+	}{
+		{SearchArgs{Query: "jefferson"}, []string{"Jefferson Airplane"}, 1},
+		{SearchArgs{Query: "band"}, []string{"Another Band", "Love Band"}, 2},
+		{SearchArgs{Query: "band", Limit: 1, Offset: 1}, []string{"Love Band"}, 2},
+		{SearchArgs{Query: "album:airplane"}, []string{"Another Band", "Love Band"}, 2},
+		{SearchArgs{Query: "rabbit"}, nil, 0},
+		{SearchArgs{Query: `"unclosed`}, nil, 0},
+	}
+
+	for _, test := range artistTests {
+		found, count := lib.SearchArtists(test.args)
+
+		if count != test.count {
+			t.Errorf("Expected %d artists for %+v but the count was %d", test.count,
+				test.args, count)
+		}
+
+		if len(found) != len(test.expected) {
+			t.Errorf("Expected artists %v for %+v but got %v", test.expected,
+				test.args, found)
+			continue
+		}
+
+		for ind, name := range test.expected {
+			if found[ind].Name != name {
+				t.Errorf("Expected artist %d for %+v to be %s but it was %s", ind,
+					test.args, name, found[ind].Name)
+			}
+		}
+	}
+
+	albums, count := lib.SearchAlbums(SearchArgs{Query: "airplane"})
+
+	if count != 1 || len(albums) != 1 {
+		t.Fatalf("Expected one album for airplane but got %d: %v", count, albums)
+	}
+
+	if albums[0].Name != "Airplane Songs" || albums[0].SongCount != 2 {
+		t.Errorf("Wrong album found for airplane: %+v", albums[0])
+	}
+
+	if albums, count := lib.SearchAlbums(SearchArgs{Query: ""}); count != 2 ||
+		len(albums) != 2 {
+		t.Errorf("Expected all albums for empty query but got %d: %v", count, albums)
+	}
+}
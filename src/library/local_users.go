@@ -0,0 +1,135 @@
+package library
+
+import (
+	"database/sql"
+	"log"
+)
+
+// GetUsers satisfies the Library interface
+func (lib *LocalLibrary) GetUsers() []User {
+	var users []User
+
+	rows, err := lib.db.Query(`
+		SELECT
+			id, name, role, password
+		FROM
+			users
+		ORDER BY
+			name
+	`)
+
+	if err != nil {
+		log.Printf("Query not successful: %s\n", err.Error())
+		return users
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Name, &user.Role, &user.PasswordHash)
+		if err != nil {
+			log.Printf("Error scanning user row: %s\n", err)
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return users
+}
+
+// GetUser satisfies the Library interface
+func (lib *LocalLibrary) GetUser(name string) (*User, error) {
+	var user User
+
+	err := lib.db.QueryRow(`
+		SELECT
+			id, name, role, password
+		FROM
+			users
+		WHERE
+			name = ?
+	`, name).Scan(&user.ID, &user.Name, &user.Role, &user.PasswordHash)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateUser satisfies the Library interface
+func (lib *LocalLibrary) CreateUser(name, passwordHash, role string) (*User, error) {
+	if role != RoleAdmin && role != RoleListener {
+		return nil, ErrUnknownRole
+	}
+
+	res, err := lib.db.Exec(`
+		INSERT INTO
+			users (name, password, role)
+		SELECT
+			?, ?, ?
+		WHERE
+			NOT EXISTS (SELECT 1 FROM users WHERE name = ?)
+	`, name, passwordHash, role, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrUserExists
+	}
+
+	id, err := res.LastInsertId()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{ID: id, Name: name, Role: role, PasswordHash: passwordHash}, nil
+}
+
+// DeleteUser satisfies the Library interface
+func (lib *LocalLibrary) DeleteUser(name string) error {
+	res, err := lib.db.Exec(`DELETE FROM users WHERE name = ?`, name)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return err
+}
+
+// SetUserPassword satisfies the Library interface
+func (lib *LocalLibrary) SetUserPassword(name, passwordHash string) error {
+	res, err := lib.db.Exec(`
+		UPDATE
+			users
+		SET
+			password = ?
+		WHERE
+			name = ?
+	`, passwordHash, name)
+
+	if err != nil {
+		return err
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return err
+}
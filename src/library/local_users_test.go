@@ -0,0 +1,96 @@
+package library
+
+import (
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestUsers(t *testing.T) {
+	lib := getPathedLibrary(t)
+	defer lib.Truncate()
+
+	if users := lib.GetUsers(); len(users) != 0 {
+		t.Fatalf("Expected no users in a new library but found %+v", users)
+	}
+
+	listener, err := lib.CreateUser("listener", "hash1", RoleListener)
+
+	if err != nil {
+		t.Fatalf("Creating user: %s", err)
+	}
+
+	if listener.ID == 0 || listener.IsAdmin() {
+		t.Errorf("Unexpected created user: %+v", listener)
+	}
+
+	if _, err := lib.CreateUser("admin", "hash2", RoleAdmin); err != nil {
+		t.Fatalf("Creating user: %s", err)
+	}
+
+	if _, err := lib.CreateUser("listener", "hash3", RoleAdmin); err != ErrUserExists {
+		t.Errorf("Expected ErrUserExists for taken name but got %v", err)
+	}
+
+	if _, err := lib.CreateUser("other", "hash3", "superuser"); err != ErrUnknownRole {
+		t.Errorf("Expected ErrUnknownRole but got %v", err)
+	}
+
+	users := lib.GetUsers()
+
+	if len(users) != 2 || users[0].Name != "admin" || !users[0].IsAdmin() ||
+		users[1].Name != "listener" {
+		t.Errorf("Expected the admin and the listener but got %+v", users)
+	}
+
+	if err := lib.SetUserPassword("listener", "changed"); err != nil {
+		t.Fatalf("Changing password: %s", err)
+	}
+
+	found, err := lib.GetUser("listener")
+
+	if err != nil {
+		t.Fatalf("Getting user: %s", err)
+	}
+
+	if found.ID != listener.ID || found.PasswordHash != "changed" ||
+		found.Role != RoleListener {
+		t.Errorf("Unexpected user: %+v", found)
+	}
+
+	playlist, err := lib.CreatePlaylist("Mine", listener.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if playlist.OwnerID != listener.ID {
+		t.Errorf("Expected playlist owned by %d but got %+v", listener.ID, playlist)
+	}
+
+	if err := lib.DeleteUser("listener"); err != nil {
+		t.Fatalf("Deleting user: %s", err)
+	}
+
+	if _, err := lib.GetUser("listener"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound for deleted user but got %v", err)
+	}
+
+	if err := lib.DeleteUser("listener"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound when deleting twice but got %v", err)
+	}
+
+	if err := lib.SetUserPassword("listener", "x"); err != ErrUserNotFound {
+		t.Errorf("Expected ErrUserNotFound when changing password but got %v", err)
+	}
+
+	playlist, err = lib.GetPlaylist(playlist.ID)
+
+	if err != nil {
+		t.Fatalf("Expected the playlist of the deleted user to be kept: %s", err)
+	}
+
+	if playlist.OwnerID != 0 {
+		t.Errorf("Expected playlist without owner but got %+v", playlist)
+	}
+}
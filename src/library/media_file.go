@@ -20,4 +20,24 @@ type MediaFile interface {
 
 	// Length returns the duration of this pirce of media
 	Length() time.Duration
+
+	// Year returns the year in which this media was released
+	Year() int
+
+	// Genre returns the genre of this media file
+	Genre() string
+
+	// Disc returns the number of the disc from its album this media file is on
+	Disc() int
+
+	// AlbumArtist returns the artist responsible for the whole album. For
+	// compilations it is different from Artist.
+	AlbumArtist() string
+
+	// Composer returns the composer of this piece of media
+	Composer() string
+
+	// Compilation returns true when this media file is part of a compilation
+	// album by various artists
+	Compilation() bool
 }
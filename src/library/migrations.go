@@ -0,0 +1,569 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// baseSchemaVersion is the schema version of a database created from
+// sqls/library_schema.sql. Databases created before the schema_version table
+// existed are considered to be on this version as well.
+const baseSchemaVersion = 1
+
+// migration is a single step in the evolution of the library database schema.
+// Migrations are applied in order of their version, each one in its own
+// transaction. The apply function must be idempotent. It may be run against a
+// database which already has some of its changes, for example one which was
+// created by an older version of HTTPMS whose schema file contained them.
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations contains all schema changes made after the base schema. New steps
+// must be appended at the end with a version bigger than the last one. Never
+// change or remove a step once it has been released.
+var migrations = []migration{
+	{
+		version:     2,
+		description: "indexes for artist and album lookups by name",
+		apply: func(tx *sql.Tx) error {
+			return execQueries(tx,
+				"create index if not exists artists_names on `artists` (`name`)",
+				"create index if not exists albums_names on `albums` (`name`, `fs_path`)",
+			)
+		},
+	},
+	{
+		version:     3,
+		description: "file modification time and size for tracks",
+		apply: func(tx *sql.Tx) error {
+			err := addColumn(tx, "tracks", "fs_mtime", "integer not null default 0")
+			if err != nil {
+				return err
+			}
+			return addColumn(tx, "tracks", "fs_size", "integer not null default 0")
+		},
+	},
+	{
+		version:     4,
+		description: "track duration",
+		apply: func(tx *sql.Tx) error {
+			err := addColumn(tx, "tracks", "duration", "integer not null default 0")
+			if err != nil {
+				return err
+			}
+
+			// Makes sure all tracks will be read again on the next scan so that
+			// their durations are populated.
+			_, err = tx.Exec("UPDATE `tracks` SET `fs_mtime` = 0")
+			return err
+		},
+	},
+	{
+		version:     5,
+		description: "year, genre, disc number, album artist and composer for tracks",
+		apply: func(tx *sql.Tx) error {
+			columns := [][2]string{
+				{"year", "integer not null default 0"},
+				{"genre", "text not null default ''"},
+				{"disc", "integer not null default 0"},
+				{"album_artist", "text not null default ''"},
+				{"composer", "text not null default ''"},
+			}
+
+			for _, column := range columns {
+				if err := addColumn(tx, "tracks", column[0], column[1]); err != nil {
+					return err
+				}
+			}
+
+			// The new tags will be read on the next scan.
+			_, err := tx.Exec("UPDATE `tracks` SET `fs_mtime` = 0")
+			return err
+		},
+	},
+	{
+		version:     6,
+		description: "album artist and compilation flag for albums",
+		apply: func(tx *sql.Tx) error {
+			err := addColumn(tx, "albums", "album_artist", "text not null default ''")
+			if err != nil {
+				return err
+			}
+
+			err = addColumn(tx, "albums", "compilation", "integer not null default 0")
+			if err != nil {
+				return err
+			}
+
+			err = execQueries(tx,
+				"create index if not exists albums_artists on `albums` "+
+					"(`name`, `album_artist`)",
+
+				// Tracks will be grouped again in albums on the next scan.
+				"UPDATE `tracks` SET `fs_mtime` = 0",
+			)
+			return err
+		},
+	},
+	{
+		version:     7,
+		description: "full-text search index for tracks",
+		apply: func(tx *sql.Tx) error {
+			return execQueries(tx,
+				"create virtual table if not exists tracks_search using fts5"+
+					"(title, album, artist)",
+				`create trigger if not exists tracks_search_delete
+					after delete on tracks
+				begin
+					delete from tracks_search where rowid = old.id;
+				end`,
+				"delete from tracks_search",
+				`insert into tracks_search (rowid, title, album, artist)
+					select
+						t.id, t.name, al.name, at.name
+					from
+						tracks as t
+							left join albums as al on al.id = t.album_id
+							left join artists as at on at.id = t.artist_id`,
+			)
+		},
+	},
+	{
+		version:     8,
+		description: "case and diacritic insensitive search index",
+		apply: func(tx *sql.Tx) error {
+			rows, err := tx.Query("SELECT `id` FROM `tracks`")
+
+			if err != nil {
+				return err
+			}
+
+			var trackIDs []int64
+
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return err
+				}
+				trackIDs = append(trackIDs, id)
+			}
+
+			rows.Close()
+
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			// The index has only the title, album and artist columns at this
+			// version so indexTrackForSearch, which follows the latest schema,
+			// cannot be used here.
+			for _, id := range trackIDs {
+				var title, album, artist sql.NullString
+
+				err := tx.QueryRow(`
+					SELECT
+						t.name,
+						al.name,
+						at.name
+					FROM
+						tracks as t
+							LEFT JOIN albums as al ON al.id = t.album_id
+							LEFT JOIN artists as at ON at.id = t.artist_id
+					WHERE
+						t.id = ?
+				`, id).Scan(&title, &album, &artist)
+
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec("DELETE FROM tracks_search WHERE rowid = ?", id)
+
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec(`
+					INSERT INTO
+						tracks_search (rowid, title, album, artist)
+					VALUES
+						(?, ?, ?, ?)
+				`, id, normalizeForSearch(title.String),
+					normalizeForSearch(album.String),
+					normalizeForSearch(artist.String))
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		version:     9,
+		description: "genre in the search index",
+		apply:       rebuildSearchIndex,
+	},
+	{
+		version:     10,
+		description: "album covers",
+		apply: func(tx *sql.Tx) error {
+			err := addColumn(tx, "albums", "cover_path", "text not null default ''")
+			if err != nil {
+				return err
+			}
+
+			err = addColumn(tx, "albums", "cover_embedded", "integer not null default 0")
+			if err != nil {
+				return err
+			}
+
+			// Covers will be found on the next scan.
+			_, err = tx.Exec("UPDATE `tracks` SET `fs_mtime` = 0")
+			return err
+		},
+	},
+	{
+		version:     11,
+		description: "playlists",
+		apply: func(tx *sql.Tx) error {
+			return execQueries(tx,
+				"create table if not exists `playlists` ("+
+					"`id` integer not null primary key, "+
+					"`name` text not null)",
+				"create table if not exists `playlist_entries` ("+
+					"`id` integer not null primary key, "+
+					"`playlist_id` integer not null, "+
+					"`track_id` integer not null, "+
+					"`position` integer not null)",
+				"create index if not exists playlist_entries_positions on "+
+					"`playlist_entries` (`playlist_id`, `position`)",
+				"create index if not exists playlist_entries_tracks on "+
+					"`playlist_entries` (`track_id`)",
+
+				// Track IDs do not change on rescans. Entries are removed only
+				// when their tracks are removed from the library.
+				`create trigger if not exists playlist_entries_delete
+					after delete on tracks
+				begin
+					delete from playlist_entries where track_id = old.id;
+				end`,
+			)
+		},
+	},
+	{
+		version:     12,
+		description: "imported playlist files",
+		apply: func(tx *sql.Tx) error {
+			err := addColumn(tx, "playlists", "fs_path", "text")
+			if err != nil {
+				return err
+			}
+
+			err = addColumn(tx, "playlists", "fs_mtime", "integer not null default 0")
+			if err != nil {
+				return err
+			}
+
+			return execQueries(tx,
+				"create unique index if not exists playlists_fs_path on "+
+					"`playlists` (`fs_path`)",
+			)
+		},
+	},
+	{
+		version:     13,
+		description: "smart playlists and track play statistics",
+		apply: func(tx *sql.Tx) error {
+			columns := []struct {
+				table, column, definition string
+			}{
+				{"playlists", "rules", "text"},
+				{"tracks", "added_at", "integer not null default 0"},
+				{"tracks", "play_count", "integer not null default 0"},
+				{"tracks", "last_played", "integer not null default 0"},
+			}
+
+			for _, c := range columns {
+				if err := addColumn(tx, c.table, c.column, c.definition); err != nil {
+					return err
+				}
+			}
+
+			// The file modification time is the best guess for when the existing
+			// tracks were added. fs_mtime is in nanoseconds while added_at is in
+			// seconds. Earlier migrations reset fs_mtime to 0 in order to force a
+			// rescan so for such tracks the file itself is checked. Tracks whose
+			// files are missing get added_at on the next scan.
+			rows, err := tx.Query(`
+				SELECT id, fs_path, fs_mtime FROM tracks WHERE added_at = 0
+			`)
+
+			if err != nil {
+				return err
+			}
+
+			addedAt := make(map[int64]int64)
+
+			for rows.Next() {
+				var (
+					id     int64
+					fsPath string
+					mtime  int64
+				)
+
+				if err := rows.Scan(&id, &fsPath, &mtime); err != nil {
+					rows.Close()
+					return err
+				}
+
+				if mtime == 0 {
+					if st, err := os.Stat(fsPath); err == nil {
+						mtime = st.ModTime().UnixNano()
+					}
+				}
+
+				if mtime != 0 {
+					addedAt[id] = mtime / int64(time.Second)
+				}
+			}
+
+			rows.Close()
+
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for id, added := range addedAt {
+				_, err := tx.Exec("UPDATE `tracks` SET `added_at` = ? WHERE `id` = ?",
+					added, id)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		version:     14,
+		description: "users and playlist owners",
+		apply: func(tx *sql.Tx) error {
+			err := addColumn(tx, "playlists", "owner_id", "integer not null default 0")
+			if err != nil {
+				return err
+			}
+
+			return execQueries(tx,
+				"create table if not exists `users` ("+
+					"`id` integer not null primary key, "+
+					"`name` text not null, "+
+					"`password` text not null, "+
+					"`role` text not null)",
+				"create unique index if not exists users_names on `users` (`name`)",
+
+				// Playlists of deleted users are kept but only admins could
+				// change them.
+				`create trigger if not exists users_delete
+					after delete on users
+				begin
+					update playlists set owner_id = 0 where owner_id = old.id;
+				end`,
+			)
+		},
+	},
+	{
+		version:     15,
+		description: "API tokens",
+		apply: func(tx *sql.Tx) error {
+			return execQueries(tx,
+				"create table if not exists `api_tokens` ("+
+					"`id` integer not null primary key, "+
+					"`user_id` integer not null, "+
+					"`label` text not null, "+
+					"`token_hash` text not null, "+
+					"`read_only` integer not null default 0, "+
+					"`created_at` integer not null default 0, "+
+					"`last_used` integer not null default 0)",
+				"create unique index if not exists api_tokens_hashes on "+
+					"`api_tokens` (`token_hash`)",
+				"create index if not exists api_tokens_users on `api_tokens` (`user_id`)",
+				`create trigger if not exists users_delete_api_tokens
+					after delete on users
+				begin
+					delete from api_tokens where user_id = old.id;
+				end`,
+			)
+		},
+	},
+}
+
+// applyMigrations brings the database schema to the latest version by applying
+// every migration which has not been applied yet.
+func (lib *LocalLibrary) applyMigrations() error {
+	current, err := lib.schemaVersion()
+
+	if err != nil {
+		return fmt.Errorf("getting schema version: %s", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := lib.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %s", m.version,
+				m.description, err)
+		}
+
+		log.Printf("Applied library migration %d: %s\n", m.version, m.description)
+		current = m.version
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration and records its version in the
+// schema_version table. Both happen in the same transaction so a failed
+// migration leaves no trace in the database.
+func (lib *LocalLibrary) applyMigration(m migration) error {
+	tx, err := lib.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	if err := m.apply(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO
+			schema_version (version, description)
+		VALUES
+			(?, ?)
+	`, m.version, m.description)
+
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// schemaVersion returns the version of the last migration applied to the database.
+// It creates the schema_version table when missing. Databases without any recorded
+// version are considered to be on baseSchemaVersion.
+func (lib *LocalLibrary) schemaVersion() (int, error) {
+	_, err := lib.db.Exec(`
+		create table if not exists schema_version (
+			version integer not null primary key,
+			description text,
+			applied_at integer not null default (strftime('%s', 'now'))
+		)
+	`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = lib.db.QueryRow(`
+		SELECT
+			MAX(version)
+		FROM
+			schema_version
+	`).Scan(&version)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !version.Valid {
+		return baseSchemaVersion, nil
+	}
+
+	return int(version.Int64), nil
+}
+
+// execQueries executes all queries in order and stops on the first error.
+func execQueries(tx *sql.Tx, queries ...string) error {
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column to a table unless the table has it already. SQLite does
+// not support "ADD COLUMN IF NOT EXISTS" so the check is done with table_info.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s",
+		table, column, definition))
+
+	return err
+}
+
+// columnExists returns true when the table has a column with this name.
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(`%s`)", table))
+
+	if err != nil {
+		return false, err
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+
+	if err != nil {
+		return false, err
+	}
+
+	values := make([]interface{}, len(columns))
+	var name string
+
+	for ind, col := range columns {
+		if strings.ToLower(col) == "name" {
+			values[ind] = &name
+		} else {
+			values[ind] = new(interface{})
+		}
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			return false, err
+		}
+
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
@@ -0,0 +1,321 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	lastVersion := baseSchemaVersion
+
+	for _, m := range migrations {
+		if m.version <= lastVersion {
+			t.Errorf("Migration %d (%s) is not after version %d", m.version,
+				m.description, lastVersion)
+		}
+
+		if m.apply == nil {
+			t.Errorf("Migration %d has no apply function", m.version)
+		}
+
+		lastVersion = m.version
+	}
+}
+
+func TestInitializeAppliesMigrations(t *testing.T) {
+	lib := getLibrary(t)
+	defer lib.Truncate()
+
+	version, err := lib.schemaVersion()
+
+	if err != nil {
+		t.Fatalf("Getting schema version: %s", err)
+	}
+
+	expected := migrations[len(migrations)-1].version
+
+	if version != expected {
+		t.Errorf("Expected schema version %d but it was %d", expected, version)
+	}
+}
+
+// createOldDatabase creates a database such as the ones created by an older HTTPMS
+// which knew nothing about migrations. It has a single track with ID 1. Returns the
+// path to the database file.
+func createOldDatabase(t *testing.T) string {
+	libDB, err := ioutil.TempFile("", "httpms_library_test_")
+
+	if err != nil {
+		t.Fatalf("Error creating temporary library: %s", err)
+	}
+
+	libDB.Close()
+
+	db, err := sql.Open("sqlite3", libDB.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib := &LocalLibrary{db: db}
+	schema, err := lib.readSchema()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range strings.Split(schema, ";") {
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+		if _, err := db.Exec(query); err != nil {
+			t.Fatalf("Creating old schema: %s", err)
+		}
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO
+			tracks (name, album_id, artist_id, fs_path, number)
+		VALUES
+			("Old Track", 1, 1, "/old/track.mp3", 3)
+	`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Close()
+
+	return libDB.Name()
+}
+
+// Simulates a database created by an older HTTPMS which knew nothing about
+// migrations. Its data must survive the initialization.
+func TestMigratingExistingDatabase(t *testing.T) {
+	libDB := createOldDatabase(t)
+	defer os.Remove(libDB)
+
+	for i := 0; i < 2; i++ {
+		lib, err := NewLocalLibrary(context.Background(), libDB)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lib.Initialize(); err != nil {
+			lib.Close()
+			t.Fatalf("Initialization number %d failed: %s", i+1, err)
+		}
+
+		version, err := lib.schemaVersion()
+
+		if err != nil {
+			t.Errorf("Getting schema version: %s", err)
+		}
+
+		if expected := migrations[len(migrations)-1].version; version != expected {
+			t.Errorf("Expected schema version %d but it was %d", expected, version)
+		}
+
+		if path := lib.GetFilePath(1); path != "/old/track.mp3" {
+			t.Errorf("Old track was not found after migration. Path: `%s`", path)
+		}
+
+		lib.Close()
+	}
+}
+
+// Databases which were migrated in steps must end up with the same schema as the
+// ones which were migrated at once. This one stops at version 8 as if it was
+// created by a release which did not have the later migrations.
+func TestMigratingInSteps(t *testing.T) {
+	libDB := createOldDatabase(t)
+	defer os.Remove(libDB)
+
+	db, err := sql.Open("sqlite3", libDB)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := &LocalLibrary{db: db}
+
+	if _, err := old.schemaVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range migrations {
+		if m.version > 8 {
+			break
+		}
+
+		if err := old.applyMigration(m); err != nil {
+			t.Fatalf("Migration %d failed: %s", m.version, err)
+		}
+	}
+
+	var indexed int
+	err = db.QueryRow("SELECT count(*) FROM tracks_search WHERE title = 'old track'").
+		Scan(&indexed)
+
+	if err != nil || indexed != 1 {
+		t.Fatalf("Expected the old track in the version 8 search index but got %d, %v",
+			indexed, err)
+	}
+
+	db.Close()
+
+	lib, err := NewLocalLibrary(context.Background(), libDB)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer lib.Close()
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatalf("Initializing library: %s", err)
+	}
+
+	fresh := getLibrary(t)
+	defer fresh.Truncate()
+
+	schema := func(lib *LocalLibrary) map[string]string {
+		rows, err := lib.db.Query(`
+			SELECT name, IFNULL(sql, '') FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'
+		`)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer rows.Close()
+
+		found := make(map[string]string)
+
+		for rows.Next() {
+			var name, query string
+			if err := rows.Scan(&name, &query); err != nil {
+				t.Fatal(err)
+			}
+			found[name] = strings.Join(strings.Fields(query), " ")
+		}
+
+		return found
+	}
+
+	migrated, created := schema(lib), schema(fresh)
+
+	for name, query := range created {
+		if migrated[name] != query {
+			t.Errorf("Schema of %s differs.\nMigrated: %s\nFresh: %s", name,
+				migrated[name], query)
+		}
+	}
+
+	if found, _ := lib.Search(SearchArgs{Query: "old"}); len(found) != 1 {
+		t.Errorf("Expected to find the old track after the migration but got %+v", found)
+	}
+}
+
+// Migration 13 guesses when the existing tracks were added. The added time must be
+// in seconds, as the rest of the times in the database, even though fs_mtime is in
+// nanoseconds.
+func TestMigratingAddedAt(t *testing.T) {
+	libDB := createOldDatabase(t)
+	defer os.Remove(libDB)
+
+	trackFile, err := ioutil.TempFile("", "httpms_added_at_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trackFile.Close()
+	defer os.Remove(trackFile.Name())
+
+	fileMtime := time.Date(2018, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	if err := os.Chtimes(trackFile.Name(), fileMtime, fileMtime); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite3", libDB)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	old := &LocalLibrary{db: db}
+
+	if _, err := old.schemaVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	var addedAtMigration migration
+
+	for _, m := range migrations {
+		if m.version == 13 {
+			addedAtMigration = m
+			break
+		}
+
+		if err := old.applyMigration(m); err != nil {
+			t.Fatalf("Migration %d failed: %s", m.version, err)
+		}
+	}
+
+	storedMtime := time.Date(2019, time.May, 20, 8, 30, 0, 0, time.UTC)
+
+	_, err = db.Exec(`
+		UPDATE tracks SET fs_mtime = ? WHERE id = 1
+	`, storedMtime.UnixNano())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO
+			tracks (id, name, album_id, artist_id, fs_path, number, fs_mtime)
+		VALUES
+			(2, "Rescanned Track", 1, 1, ?, 1, 0),
+			(3, "Missing Track", 1, 1, "/missing/track.mp3", 2, 0)
+	`, trackFile.Name())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := old.applyMigration(addedAtMigration); err != nil {
+		t.Fatalf("Migration 13 failed: %s", err)
+	}
+
+	for id, expected := range map[int64]int64{
+		1: storedMtime.Unix(),
+		2: fileMtime.Unix(),
+		3: 0,
+	} {
+		var addedAt int64
+		err := db.QueryRow("SELECT added_at FROM tracks WHERE id = ?", id).
+			Scan(&addedAt)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if addedAt != expected {
+			t.Errorf("Expected track %d to be added at %d but it was %d", id,
+				expected, addedAt)
+		}
+	}
+}
@@ -4,11 +4,17 @@ import "time"
 
 // MockMedia is a type used for testing the media insertion methods
 type MockMedia struct {
-	artist string
-	album  string
-	title  string
-	track  int
-	length time.Duration
+	artist      string
+	album       string
+	title       string
+	track       int
+	length      time.Duration
+	year        int
+	genre       string
+	disc        int
+	albumArtist string
+	composer    string
+	compilation bool
 }
 
 // Artist satisfiees the MediaFile interface and just returns the objec attribute
@@ -35,3 +41,33 @@ func (m *MockMedia) Track() int {
 func (m *MockMedia) Length() time.Duration {
 	return m.length
 }
+
+// Year satisfiees the MediaFile interface and just returns the objec attribute
+func (m *MockMedia) Year() int {
+	return m.year
+}
+
+// Genre satisfiees the MediaFile interface and just returns the objec attribute
+func (m *MockMedia) Genre() string {
+	return m.genre
+}
+
+// Disc satisfiees the MediaFile interface and just returns the objec attribute
+func (m *MockMedia) Disc() int {
+	return m.disc
+}
+
+// AlbumArtist satisfiees the MediaFile interface and just returns the objec attribute
+func (m *MockMedia) AlbumArtist() string {
+	return m.albumArtist
+}
+
+// Composer satisfiees the MediaFile interface and just returns the objec attribute
+func (m *MockMedia) Composer() string {
+	return m.composer
+}
+
+// Compilation satisfiees the MediaFile interface and just returns the objec attribute
+func (m *MockMedia) Compilation() bool {
+	return m.compilation
+}
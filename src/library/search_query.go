@@ -0,0 +1,238 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The fields which could be used as qualifiers in search queries. For example
+// "artist:Bugoff" matches only tracks by artists with "Bugoff" in their name.
+// With the exception of fieldYear, they are the names of the columns in the
+// full-text search index.
+const (
+	fieldAny    = ""
+	fieldTitle  = "title"
+	fieldAlbum  = "album"
+	fieldArtist = "artist"
+	fieldGenre  = "genre"
+	fieldYear   = "year"
+)
+
+// searchFields are all fields known to the search query parser.
+var searchFields = map[string]bool{
+	fieldTitle:  true,
+	fieldAlbum:  true,
+	fieldArtist: true,
+	fieldGenre:  true,
+	fieldYear:   true,
+}
+
+// searchTerm is a single condition in a search query.
+type searchTerm struct {
+
+	// field is one of the field* constants. fieldAny matches the title, album and
+	// artist.
+	field string
+
+	// text is the normalized text which must be found in the field. Unused for
+	// fieldYear.
+	text string
+
+	// quoted is true for phrases which were in quotes in the query.
+	quoted bool
+
+	// prefix is true when the last word in text may be just the beginning of a word.
+	prefix bool
+
+	// negated terms exclude the tracks they match.
+	negated bool
+
+	// yearFrom and yearTo are the inclusive range of years for fieldYear.
+	yearFrom int64
+	yearTo   int64
+}
+
+// SearchQuery is a parsed search query. Create one with ParseSearchQuery.
+type SearchQuery struct {
+	terms []searchTerm
+}
+
+// Empty returns true when the query has no terms. This is the case for blank
+// queries and ones which consist only of punctuation.
+func (q *SearchQuery) Empty() bool {
+	return len(q.terms) == 0
+}
+
+// ParseSearchQuery parses a search query. Its syntax is:
+//
+//   - Words are searched for in the title, album and artist. All of them must match.
+//   - "quoted phrases" match the words in this exact order.
+//   - artist:, album:, title: and genre: match only in this field. Their value may
+//     be a word or a quoted phrase, e.g. artist:"Jefferson Airplane".
+//   - year:1994 matches a single year and year:1990..1999 a range of years. One of
+//     the ends of the range may be omitted.
+//   - A minus in front of any of the above excludes the tracks it matches, e.g. -live.
+//
+// The last word of the query may be just the beginning of a word, unless it is
+// quoted or negated. The returned error describes what is wrong with the query
+// and is suitable for showing to users.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	parsed := &SearchQuery{}
+	rest := query
+
+	for {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+
+		if rest == "" {
+			break
+		}
+
+		var (
+			term  searchTerm
+			found bool
+			err   error
+		)
+
+		term, found, rest, err = parseSearchTerm(rest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			parsed.terms = append(parsed.terms, term)
+		}
+	}
+
+	if len(parsed.terms) > 0 {
+		last := &parsed.terms[len(parsed.terms)-1]
+		last.prefix = last.field != fieldYear && !last.quoted && !last.negated
+	}
+
+	return parsed, nil
+}
+
+// parseSearchTerm parses the term in the beginning of query and returns the rest of
+// the query after it. found is false when the term could be safely ignored, such as
+// free standing punctuation.
+func parseSearchTerm(query string) (
+	term searchTerm,
+	found bool,
+	rest string,
+	err error,
+) {
+	value := query
+
+	if strings.HasPrefix(value, "-") && len(value) > 1 {
+		term.negated = true
+		value = value[1:]
+	}
+
+	if ind := strings.IndexAny(value, ": \t\n\""); ind > 0 && value[ind] == ':' {
+		if field := strings.ToLower(value[:ind]); searchFields[field] {
+			term.field = field
+			value = value[ind+1:]
+		}
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		end := strings.Index(value[1:], `"`)
+
+		if end < 0 {
+			return term, false, "", fmt.Errorf("%s: missing closing quote", query)
+		}
+
+		term.quoted = true
+		rest = value[end+2:]
+		value = value[1 : end+1]
+	} else {
+		end := strings.IndexFunc(value, unicode.IsSpace)
+
+		if end < 0 {
+			end = len(value)
+		}
+
+		rest = value[end:]
+		value = value[:end]
+	}
+
+	termText := strings.TrimSpace(query[:len(query)-len(rest)])
+
+	if term.field == fieldYear {
+		term.yearFrom, term.yearTo, err = parseYearRange(value)
+
+		if err != nil {
+			return term, false, "", fmt.Errorf("%s: %s", termText, err)
+		}
+
+		return term, true, rest, nil
+	}
+
+	term.text = normalizeForSearch(value)
+
+	if strings.IndexFunc(term.text, isWordCharacter) >= 0 {
+		return term, true, rest, nil
+	}
+
+	if term.field != fieldAny {
+		return term, false, "", fmt.Errorf("%s: must be followed by a word or a "+
+			"quoted phrase", term.field)
+	}
+
+	if term.quoted {
+		return term, false, "", fmt.Errorf("%s: there are no words in the quotes",
+			termText)
+	}
+
+	return term, false, rest, nil
+}
+
+// parseYearRange parses the value of a year: qualifier. It is either a single year
+// or a range such as 1990..1999 in which one of the ends could be omitted. Tracks
+// without a year have 0 for year and are never matched.
+func parseYearRange(value string) (from, to int64, err error) {
+	fromText, toText := value, value
+
+	if ind := strings.Index(value, ".."); ind >= 0 {
+		fromText, toText = value[:ind], value[ind+2:]
+	}
+
+	if fromText == "" && toText == "" {
+		return 0, 0, fmt.Errorf("expected a year or a range of years such as " +
+			"1990..1999")
+	}
+
+	from, to = 1, math.MaxInt32
+
+	if fromText != "" {
+		if from, err = parseYear(fromText); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if toText != "" {
+		if to, err = parseYear(toText); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if from > to {
+		return 0, 0, fmt.Errorf("the range starts after it ends")
+	}
+
+	return from, to, nil
+}
+
+// parseYear parses a single year for parseYearRange.
+func parseYear(text string) (int64, error) {
+	year, err := strconv.ParseInt(text, 10, 32)
+
+	if err != nil || year < 1 {
+		return 0, fmt.Errorf("%q is not a valid year", text)
+	}
+
+	return year, nil
+}
@@ -0,0 +1,108 @@
+package library
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParsingSearchQueries(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []searchTerm
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"- ! ?", nil},
+		{
+			"Björk joga",
+			[]searchTerm{
+				{text: "bjork"},
+				{text: "joga", prefix: true},
+			},
+		},
+		{
+			`artist:"Jefferson Airplane" -live`,
+			[]searchTerm{
+				{field: fieldArtist, text: "jefferson airplane", quoted: true},
+				{text: "live", negated: true},
+			},
+		},
+		{
+			`Title:rabbit ALBUM:pill`,
+			[]searchTerm{
+				{field: fieldTitle, text: "rabbit"},
+				{field: fieldAlbum, text: "pill", prefix: true},
+			},
+		},
+		{
+			`genre:rock "white rabbit"`,
+			[]searchTerm{
+				{field: fieldGenre, text: "rock"},
+				{text: "white rabbit", quoted: true},
+			},
+		},
+		{
+			"year:1990..1999 -year:1994 year:2000.. year:..1970",
+			[]searchTerm{
+				{field: fieldYear, yearFrom: 1990, yearTo: 1999},
+				{field: fieldYear, yearFrom: 1994, yearTo: 1994, negated: true},
+				{field: fieldYear, yearFrom: 2000, yearTo: math.MaxInt32},
+				{field: fieldYear, yearFrom: 1, yearTo: 1970},
+			},
+		},
+		{
+			"Star Wars: Episode",
+			[]searchTerm{
+				{text: "star"},
+				{text: "wars:"},
+				{text: "episode", prefix: true},
+			},
+		},
+		{
+			`not-such-thing" OR t.name="kleopatra`,
+			[]searchTerm{
+				{text: `not-such-thing"`},
+				{text: "or"},
+				{text: `t.name="kleopatra`, prefix: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		parsed, err := ParseSearchQuery(test.query)
+
+		if err != nil {
+			t.Errorf("Error parsing `%s`: %s", test.query, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(parsed.terms, test.expected) {
+			t.Errorf("Expected `%s` to be parsed as %+v but it was %+v", test.query,
+				test.expected, parsed.terms)
+		}
+	}
+}
+
+func TestParsingMalformedSearchQueries(t *testing.T) {
+	queries := []string{
+		`artist:"Jefferson Airplane`,
+		`"white rabbit`,
+		`artist:`,
+		`title:!!!`,
+		`""`,
+		`-album:`,
+		`year:`,
+		`year:..`,
+		`year:nineties`,
+		`year:1999..1990`,
+		`year:0`,
+		`year:1990...1999`,
+	}
+
+	for _, query := range queries {
+		if _, err := ParseSearchQuery(query); err == nil {
+			t.Errorf("Expected an error for `%s` but there was none", query)
+		}
+	}
+}
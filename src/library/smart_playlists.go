@@ -0,0 +1,253 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// SmartRules defines the tracks of a smart playlist. Its rules are combined as
+// described by Match. The found tracks are ordered by the Sort field, which could
+// be "random" as well, and at most Limit of them are in the playlist. Zero Limit
+// means there is no limit.
+//
+// As JSON the rules for "jazz tracks from before 1970" look like this:
+//
+//	{
+//	    "match": "all",
+//	    "rules": [
+//	        {"field": "genre", "operator": "is", "value": "Jazz"},
+//	        {"field": "year", "operator": "lt", "value": 1970}
+//	    ],
+//	    "sort": "year",
+//	    "order": "desc",
+//	    "limit": 100
+//	}
+type SmartRules struct {
+	Match string      `json:"match,omitempty"` // "all" (the default) or "any"
+	Rules []SmartRule `json:"rules"`
+	Sort  string      `json:"sort,omitempty"`
+	Order string      `json:"order,omitempty"` // "asc" (the default) or "desc"
+	Limit int64       `json:"limit,omitempty"`
+}
+
+// SmartRule is a single condition in SmartRules. It compares a track field with
+// Value using Operator. Alternatively it could be a group of rules which are
+// combined as described by Match. Then Field, Operator and Value must be empty.
+type SmartRule struct {
+	Field    string      `json:"field,omitempty"`
+	Operator string      `json:"operator,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+
+	Match string      `json:"match,omitempty"`
+	Rules []SmartRule `json:"rules,omitempty"`
+}
+
+// The kinds of track fields. They determine which operators could be used with
+// the fields.
+const (
+	smartText = iota
+	smartNumber
+	smartTime
+)
+
+// smartField is a track field which could be used in smart playlist rules.
+type smartField struct {
+	column string // The SQL expression for the field
+	kind   int
+}
+
+// smartFields are all fields which could be used in rules and for sorting. Times
+// are stored as Unix timestamps and durations are in milliseconds.
+var smartFields = map[string]smartField{
+	"title":        {"t.name", smartText},
+	"artist":       {"at.name", smartText},
+	"album":        {"al.name", smartText},
+	"album_artist": {"t.album_artist", smartText},
+	"genre":        {"t.genre", smartText},
+	"composer":     {"t.composer", smartText},
+	"year":         {"t.year", smartNumber},
+	"track_number": {"t.number", smartNumber},
+	"disc":         {"t.disc", smartNumber},
+	"duration":     {"t.duration", smartNumber},
+	"play_count":   {"t.play_count", smartNumber},
+	"added":        {"t.added_at", smartTime},
+	"last_played":  {"t.last_played", smartTime},
+}
+
+// smartOperators are the operators which could be used with every kind of fields.
+var smartOperators = map[int][]string{
+	smartText: {"is", "is_not", "contains", "not_contains", "starts_with",
+		"ends_with"},
+	smartNumber: {"is", "is_not", "lt", "gt"},
+	smartTime:   {"in_the_last", "not_in_the_last"},
+}
+
+// Validate returns an error which describes what is wrong with the rules. It is
+// suitable for showing to users.
+func (sr SmartRules) Validate() error {
+	_, _, err := sr.sql(time.Now())
+	return err
+}
+
+// sql returns the WHERE condition with its arguments and the ORDER BY and LIMIT
+// clauses for the tracks matched by the rules. They expect the tracks (t), albums
+// (al) and artists (at) tables. Relative times such as "in the last 30 days" are
+// relative to now.
+func (sr SmartRules) sql(now time.Time) (string, []interface{}, error) {
+	where, args, err := smartGroupSQL(sr.Match, sr.Rules, now)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sr.Order != "" && sr.Order != "asc" && sr.Order != "desc" {
+		return "", nil, fmt.Errorf(`"order" must be "asc" or "desc"`)
+	}
+
+	if sr.Limit < 0 {
+		return "", nil, fmt.Errorf(`"limit" must not be negative`)
+	}
+
+	orderBy := "t.id"
+
+	switch field, ok := smartFields[sr.Sort]; {
+	case sr.Sort == "random":
+		orderBy = "RANDOM()"
+	case ok:
+		orderBy = fmt.Sprintf("%s %s, t.id", field.column, strings.ToUpper(sr.Order))
+	case sr.Sort != "":
+		return "", nil, fmt.Errorf("unknown sort field %q", sr.Sort)
+	}
+
+	limit := sr.Limit
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	tail := fmt.Sprintf("ORDER BY %s LIMIT %d", orderBy, limit)
+
+	return fmt.Sprintf("WHERE %s %s", where, tail), args, nil
+}
+
+// smartGroupSQL returns the condition for a group of rules combined by match.
+// Groups without rules match all tracks.
+func smartGroupSQL(match string, rules []SmartRule, now time.Time) (
+	string, []interface{}, error) {
+
+	separator := " AND "
+
+	switch match {
+	case "", "all":
+	case "any":
+		separator = " OR "
+	default:
+		return "", nil, fmt.Errorf(`"match" must be "all" or "any", not %q`, match)
+	}
+
+	if len(rules) == 0 {
+		return "1", nil, nil
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	for _, rule := range rules {
+		condition, ruleArgs, err := rule.sql(now)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		conditions = append(conditions, condition)
+		args = append(args, ruleArgs...)
+	}
+
+	return "(" + strings.Join(conditions, separator) + ")", args, nil
+}
+
+// sql returns the condition for a single rule together with its arguments.
+func (rule SmartRule) sql(now time.Time) (string, []interface{}, error) {
+	if rule.Field == "" && rule.Operator == "" && rule.Value == nil {
+		return smartGroupSQL(rule.Match, rule.Rules, now)
+	}
+
+	field, ok := smartFields[rule.Field]
+
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field %q", rule.Field)
+	}
+
+	if !rule.operatorAllowed(field) {
+		return "", nil, fmt.Errorf("operator %q cannot be used with %q",
+			rule.Operator, rule.Field)
+	}
+
+	if field.kind == smartText {
+		value, ok := rule.Value.(string)
+
+		if !ok {
+			return "", nil, fmt.Errorf("the value for %q must be a string", rule.Field)
+		}
+
+		condition, args := smartTextSQL(field.column, rule.Operator, value)
+		return condition, args, nil
+	}
+
+	number, ok := rule.Value.(float64)
+
+	if !ok || number != math.Trunc(number) {
+		return "", nil, fmt.Errorf("the value for %q must be an integer", rule.Field)
+	}
+
+	if field.kind == smartTime {
+		cutoff := now.Add(-time.Duration(number) * 24 * time.Hour).Unix()
+
+		if rule.Operator == "in_the_last" {
+			return field.column + " >= ?", []interface{}{cutoff}, nil
+		}
+
+		return field.column + " < ?", []interface{}{cutoff}, nil
+	}
+
+	sqlOperators := map[string]string{"is": "=", "is_not": "!=", "lt": "<", "gt": ">"}
+
+	return fmt.Sprintf("%s %s ?", field.column, sqlOperators[rule.Operator]),
+		[]interface{}{int64(number)}, nil
+}
+
+func (rule SmartRule) operatorAllowed(field smartField) bool {
+	for _, operator := range smartOperators[field.kind] {
+		if operator == rule.Operator {
+			return true
+		}
+	}
+	return false
+}
+
+// smartTextSQL returns the case insensitive condition for comparing a text column
+// with value together with its argument.
+func smartTextSQL(column, operator, value string) (string, []interface{}) {
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+
+	switch operator {
+	case "contains", "not_contains":
+		pattern = "%" + pattern + "%"
+	case "starts_with":
+		pattern = pattern + "%"
+	case "ends_with":
+		pattern = "%" + pattern
+	}
+
+	// Patterns without wildcards make LIKE a case insensitive comparison.
+	not := ""
+	if operator == "is_not" || operator == "not_contains" {
+		not = "NOT "
+	}
+
+	return fmt.Sprintf(`IFNULL(%s, '') %sLIKE ? ESCAPE '\'`, column, not),
+		[]interface{}{pattern}
+}
@@ -0,0 +1,193 @@
+package library
+
+import (
+	"encoding/json"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSmartRulesValidation(t *testing.T) {
+	valid := []string{
+		`{}`,
+		`{"rules": [{"field": "genre", "operator": "is", "value": "Jazz"},
+			{"field": "year", "operator": "lt", "value": 1970}]}`,
+		`{"match": "any", "rules": [{"field": "added", "operator": "in_the_last",
+			"value": 30}, {"match": "all", "rules": [{"field": "play_count",
+			"operator": "is", "value": 0}]}], "sort": "random", "limit": 10}`,
+		`{"rules": [], "sort": "last_played", "order": "desc"}`,
+	}
+
+	for _, rulesJSON := range valid {
+		var rules SmartRules
+
+		if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+			t.Fatalf("Error decoding %s: %s", rulesJSON, err)
+		}
+
+		if err := rules.Validate(); err != nil {
+			t.Errorf("Expected %s to be valid but got: %s", rulesJSON, err)
+		}
+	}
+
+	invalid := []string{
+		`{"match": "some"}`,
+		`{"rules": [{"field": "colour", "operator": "is", "value": "red"}]}`,
+		`{"rules": [{"field": "title", "operator": "lt", "value": "a"}]}`,
+		`{"rules": [{"field": "title", "operator": "is", "value": 5}]}`,
+		`{"rules": [{"field": "year", "operator": "is", "value": "1970"}]}`,
+		`{"rules": [{"field": "year", "operator": "is", "value": 19.5}]}`,
+		`{"rules": [{"field": "added", "operator": "is", "value": 5}]}`,
+		`{"rules": [{"match": "any", "rules": [{"field": "year"}]}]}`,
+		`{"sort": "colour"}`,
+		`{"order": "up"}`,
+		`{"limit": -1}`,
+	}
+
+	for _, rulesJSON := range invalid {
+		var rules SmartRules
+
+		if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+			t.Fatalf("Error decoding %s: %s", rulesJSON, err)
+		}
+
+		if err := rules.Validate(); err == nil {
+			t.Errorf("Expected an error for %s", rulesJSON)
+		}
+	}
+}
+
+func TestSmartPlaylists(t *testing.T) {
+	lib := getScannedLibrary(t)
+	defer lib.Truncate()
+
+	ids := make(map[string]int64)
+	tracks, _ := lib.Search(SearchArgs{})
+
+	for _, track := range tracks {
+		ids[track.Title] = track.ID
+	}
+
+	smartTitles := func(rulesJSON string) []string {
+		var rules SmartRules
+
+		if err := json.Unmarshal([]byte(rulesJSON), &rules); err != nil {
+			t.Fatalf("Error decoding %s: %s", rulesJSON, err)
+		}
+
+		playlist, err := lib.CreateSmartPlaylist("Smart", rules, 0)
+
+		if err != nil {
+			t.Fatalf("Creating smart playlist with %s: %s", rulesJSON, err)
+		}
+
+		defer lib.DeletePlaylist(playlist.ID)
+
+		titles := playlistTitles(t, lib, playlist.ID)
+
+		if playlist.TrackCount != int64(len(titles)) {
+			t.Errorf("Expected track count %d for %s but it was %d", len(titles),
+				rulesJSON, playlist.TrackCount)
+		}
+
+		return titles
+	}
+
+	checkSmart := func(rulesJSON string, expected ...string) {
+		found := smartTitles(rulesJSON)
+
+		if len(found) != len(expected) {
+			t.Errorf("Expected %q for %s but got %q", expected, rulesJSON, found)
+			return
+		}
+
+		for i := range expected {
+			if found[i] != expected[i] {
+				t.Errorf("Expected %q for %s but got %q", expected, rulesJSON, found)
+				return
+			}
+		}
+	}
+
+	checkSmart(`{"rules": [{"field": "artist", "operator": "is", "value": "artist testoff"}],
+		"sort": "title"}`, "Another One", "Tittled Track")
+	checkSmart(`{"rules": [{"field": "title", "operator": "not_contains", "value": "O"}],
+		"sort": "title"}`, "Payback", "Tittled Track")
+	checkSmart(`{"rules": [{"field": "title", "operator": "starts_with", "value": "Pay"}]}`,
+		"Payback")
+	checkSmart(`{"rules": [{"field": "title", "operator": "contains", "value": "%"}]}`)
+	checkSmart(`{"match": "any", "rules": [
+			{"field": "title", "operator": "ends_with", "value": "back"},
+			{"match": "all", "rules": [
+				{"field": "album", "operator": "is", "value": "Album Of Tests"},
+				{"field": "title", "operator": "is_not", "value": "Another One"}
+			]}
+		], "sort": "title", "order": "desc"}`, "Tittled Track", "Payback")
+	checkSmart(`{"rules": [{"field": "added", "operator": "in_the_last", "value": 30}],
+		"sort": "title", "limit": 2}`, "Another One", "Payback")
+	checkSmart(`{"rules": [{"field": "added", "operator": "not_in_the_last", "value": 30}]}`)
+
+	if err := lib.RecordPlay(ids["Payback"]); err != nil {
+		t.Fatalf("Recording play: %s", err)
+	}
+
+	if err := lib.RecordPlay(9999); err != ErrTrackNotFound {
+		t.Errorf("Expected ErrTrackNotFound when recording missing track but got %v", err)
+	}
+
+	checkSmart(`{"rules": [{"field": "play_count", "operator": "is", "value": 0}],
+		"sort": "title"}`, "Another One", "Tittled Track")
+	checkSmart(`{"rules": [{"field": "last_played", "operator": "in_the_last", "value": 1}]}`,
+		"Payback")
+
+	smart, err := lib.CreateSmartPlaylist("Never Played", SmartRules{
+		Rules: []SmartRule{{Field: "play_count", Operator: "is", Value: 0.0}},
+	}, 0)
+
+	if err != nil {
+		t.Fatalf("Creating smart playlist: %s", err)
+	}
+
+	if smart.Rules == nil || smart.TrackCount != 2 ||
+		smart.Duration != playlistDuration(t, lib, smart.ID) {
+		t.Errorf("Unexpected smart playlist: %+v", smart)
+	}
+
+	if err := lib.AddPlaylistTracks(smart.ID, []int64{ids["Payback"]}, -1); err != ErrPlaylistReadOnly {
+		t.Errorf("Expected ErrPlaylistReadOnly when adding tracks but got %v", err)
+	}
+
+	if err := lib.RemovePlaylistTrack(smart.ID, 0); err != ErrPlaylistReadOnly {
+		t.Errorf("Expected ErrPlaylistReadOnly when removing tracks but got %v", err)
+	}
+
+	err = lib.SetSmartPlaylistRules(smart.ID, SmartRules{
+		Rules: []SmartRule{{Field: "play_count", Operator: "gt", Value: 0.0}},
+	})
+
+	if err != nil {
+		t.Fatalf("Changing smart playlist rules: %s", err)
+	}
+
+	checkTitles(t, lib, smart.ID, "Payback")
+
+	static, err := lib.CreatePlaylist("Static", 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.SetSmartPlaylistRules(static.ID, SmartRules{}); err != ErrPlaylistNotFound {
+		t.Errorf("Expected ErrPlaylistNotFound for static playlist rules but got %v", err)
+	}
+
+	if err := lib.SetSmartPlaylistRules(smart.ID, SmartRules{Match: "x"}); err == nil {
+		t.Errorf("Expected an error for invalid rules")
+	}
+
+	for _, playlist := range lib.GetPlaylists() {
+		if playlist.ID == smart.ID && playlist.TrackCount != 1 {
+			t.Errorf("Expected one track in listed smart playlist but got %+v", playlist)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package library
+
+// #cgo pkg-config: taglib_c
+// #include <stdlib.h>
+// #include <tag_c.h>
+import "C"
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Keys in the TagLib property map for tags which are not part of the basic TagLib
+// interface.
+const (
+	propertyDiscNumber  = "DISCNUMBER"
+	propertyAlbumArtist = "ALBUMARTIST"
+	propertyComposer    = "COMPOSER"
+	propertyCompilation = "COMPILATION"
+)
+
+// errTaglibInvalid is returned for files which TagLib cannot read.
+var errTaglibInvalid = errors.New("invalid file")
+
+// taglibLock guards the calls to TagLib. Its C bindings keep global state such as
+// the strings encoding.
+var taglibLock sync.Mutex
+
+func init() {
+	C.taglib_set_strings_unicode(1)
+	C.taglib_set_string_management_enabled(0)
+}
+
+// taglibMedia is a MediaFile read with TagLib. The basic tags, the length and the
+// tags from the file's property map are all read at once so that every file is
+// opened and parsed only one time.
+type taglibMedia struct {
+	title  string
+	artist string
+	album  string
+	genre  string
+	track  int
+	year   int
+	length time.Duration
+
+	properties map[string]string
+}
+
+// Artist satisfies the MediaFile interface.
+func (m *taglibMedia) Artist() string {
+	return m.artist
+}
+
+// Album satisfies the MediaFile interface.
+func (m *taglibMedia) Album() string {
+	return m.album
+}
+
+// Title satisfies the MediaFile interface.
+func (m *taglibMedia) Title() string {
+	return m.title
+}
+
+// Track satisfies the MediaFile interface.
+func (m *taglibMedia) Track() int {
+	return m.track
+}
+
+// Length satisfies the MediaFile interface. TagLib's C bindings give it in whole
+// seconds.
+func (m *taglibMedia) Length() time.Duration {
+	return m.length
+}
+
+// Year satisfies the MediaFile interface.
+func (m *taglibMedia) Year() int {
+	return m.year
+}
+
+// Genre satisfies the MediaFile interface.
+func (m *taglibMedia) Genre() string {
+	return m.genre
+}
+
+// Disc satisfies the MediaFile interface. Values such as "1/2" are supported.
+func (m *taglibMedia) Disc() int {
+	return parseNumberTag(m.properties[propertyDiscNumber])
+}
+
+// AlbumArtist satisfies the MediaFile interface.
+func (m *taglibMedia) AlbumArtist() string {
+	return m.properties[propertyAlbumArtist]
+}
+
+// Composer satisfies the MediaFile interface.
+func (m *taglibMedia) Composer() string {
+	return m.properties[propertyComposer]
+}
+
+// Compilation satisfies the MediaFile interface. It uses the compilation flag which
+// is the TCMP frame for ID3v2 tags, "cpil" for MP4 and COMPILATION for Vorbis
+// comments.
+func (m *taglibMedia) Compilation() bool {
+	value := strings.TrimSpace(m.properties[propertyCompilation])
+	return value != "" && value != "0"
+}
+
+// readTaglibMedia reads the meta data of a media file with a single TagLib handle.
+// The property map requires TagLib 1.13 or newer.
+func readTaglibMedia(filename string) (*taglibMedia, error) {
+	cFilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	taglibLock.Lock()
+	defer taglibLock.Unlock()
+
+	file := C.taglib_file_new(cFilename)
+
+	if file == nil {
+		return nil, errTaglibInvalid
+	}
+
+	defer C.taglib_file_free(file)
+
+	if C.taglib_file_is_valid(file) == 0 {
+		return nil, errTaglibInvalid
+	}
+
+	media := &taglibMedia{}
+
+	if tag := C.taglib_file_tag(file); tag != nil {
+		media.title = taglibString(C.taglib_tag_title(tag))
+		media.artist = taglibString(C.taglib_tag_artist(tag))
+		media.album = taglibString(C.taglib_tag_album(tag))
+		media.genre = taglibString(C.taglib_tag_genre(tag))
+		media.track = int(C.taglib_tag_track(tag))
+		media.year = int(C.taglib_tag_year(tag))
+	}
+
+	if props := C.taglib_file_audioproperties(file); props != nil {
+		seconds := C.taglib_audioproperties_length(props)
+		media.length = time.Duration(seconds) * time.Second
+	}
+
+	media.properties = make(map[string]string)
+
+	for _, key := range []string{
+		propertyDiscNumber,
+		propertyAlbumArtist,
+		propertyComposer,
+		propertyCompilation,
+	} {
+		cKey := C.CString(key)
+		values := C.taglib_property_get(file, cKey)
+		C.free(unsafe.Pointer(cKey))
+
+		if values == nil {
+			continue
+		}
+
+		if *values != nil {
+			media.properties[key] = C.GoString(*values)
+		}
+
+		C.taglib_property_free(values)
+	}
+
+	return media, nil
+}
+
+// taglibString converts a string returned by TagLib and frees it. TagLib's string
+// management is disabled so every returned string must be freed.
+func taglibString(cString *C.char) string {
+	defer C.taglib_free(unsafe.Pointer(cString))
+	return C.GoString(cString)
+}
+
+// parseNumberTag returns the number in tags such as the disc or track number. They
+// may be in the form "number/total". Returns 0 when there is no valid number.
+func parseNumberTag(value string) int {
+	value = strings.TrimSpace(value)
+
+	if ind := strings.Index(value, "/"); ind >= 0 {
+		value = strings.TrimSpace(value[:ind])
+	}
+
+	num, err := strconv.Atoi(value)
+
+	if err != nil || num < 0 {
+		return 0
+	}
+
+	return num
+}
@@ -0,0 +1,28 @@
+package library
+
+import "testing"
+
+func TestParsingNumberTags(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+	}{
+		{"1", 1},
+		{"02", 2},
+		{"1/2", 1},
+		{" 3 / 4 ", 3},
+		{"", 0},
+		{"/2", 0},
+		{"one", 0},
+		{"-1", 0},
+	}
+
+	for _, test := range tests {
+		found := parseNumberTag(test.value)
+
+		if found != test.expected {
+			t.Errorf("Parsing `%s`: expected %d but got %d", test.value,
+				test.expected, found)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 	"github.com/ironsmile/httpms/src/daemon"
 	"github.com/ironsmile/httpms/src/helpers"
 	"github.com/ironsmile/httpms/src/library"
+	"github.com/ironsmile/httpms/src/mpd"
 	"github.com/ironsmile/httpms/src/webserver"
 )
 
@@ -51,6 +53,14 @@ func Main() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "user" {
+		if err := RunUserCommand(flag.Args()[1:]); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	projRoot, err := helpers.ProjectRoot()
 	if err != nil {
 		log.Println(err)
@@ -96,6 +106,7 @@ func getLibrary(ctx context.Context, userPath string,
 	}
 
 	lib.ScanConfig = cfg.LibraryScan
+	lib.IndexTranscodedFormats = cfg.IndexTranscodedFormats
 
 	err = lib.Initialize()
 
@@ -110,6 +121,32 @@ func getLibrary(ctx context.Context, userPath string,
 	return lib, nil
 }
 
+// startMPDServer starts the server for MPD clients in the background. It stops
+// when ctx is done. authState is shared with the webserver.
+func startMPDServer(ctx context.Context, cfg config.Config, lib library.Library,
+	authState webserver.Auth) {
+	opts := mpd.Options{HTTPS: cfg.SSL}
+
+	if cfg.Auth {
+		opts.Password = cfg.Authenticate.Password
+		opts.Authenticator = authState.Authenticator
+		opts.Throttle = authState.Throttle
+		opts.URLSigner = authState.URLSigner
+	}
+
+	if _, port, err := net.SplitHostPort(cfg.Listen); err == nil {
+		opts.HTTPPort, _ = net.LookupPort("tcp", port)
+	}
+
+	mpdSrv := mpd.NewServer(lib, opts)
+
+	go func() {
+		if err := mpdSrv.ListenAndServe(ctx, cfg.MPD.Listen); err != nil {
+			log.Printf("MPD server stopped: %s\n", err)
+		}
+	}()
+}
+
 // ParseConfigAndStartWebserver parses the config, sets the logfile, setups the
 // pidfile, and makes an signal handler goroutine
 func ParseConfigAndStartWebserver(projRoot string) error {
@@ -144,8 +181,17 @@ func ParseConfigAndStartWebserver(projRoot string) error {
 	go lib.Scan()
 
 	cfg.HTTPRoot = helpers.AbsolutePath(cfg.HTTPRoot, projRoot)
+	cfg.UserPath = userPath
+
+	// The servers share the authentication state so that a client could not get
+	// more password guesses by switching between protocols.
+	authState := webserver.NewAuth(cfg, lib)
+
+	if cfg.MPD.Enabled {
+		startMPDServer(ctx, cfg, lib, authState)
+	}
 
-	srv := webserver.NewServer(ctx, cfg, lib)
+	srv := webserver.NewServerWithAuth(ctx, cfg, lib, authState)
 	srv.Serve()
 	srv.Wait()
 	return nil
@@ -0,0 +1,515 @@
+package mpd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// command is a MPD command which is supported by the server.
+type command struct {
+	handler func(s *session, out *bytes.Buffer, args []string) error
+
+	// minArgs and maxArgs are the allowed numbers of arguments. Negative maxArgs
+	// means any number of them.
+	minArgs int
+	maxArgs int
+
+	// public commands are allowed before the client has sent its password.
+	public bool
+}
+
+// commands are all of the commands which are supported by the server.
+var commands map[string]command
+
+func init() {
+	// The commands are set here since some of them list all commands.
+	commands = map[string]command{
+		"ping":        {cmdNothing, 0, 0, true},
+		"password":    {cmdPassword, 1, 1, true},
+		"commands":    {cmdCommands, 0, 0, true},
+		"notcommands": {cmdNotCommands, 0, 0, true},
+		"tagtypes":    {cmdTagTypes, 0, -1, false},
+		"urlhandlers": {cmdURLHandlers, 0, 0, false},
+		"decoders":    {cmdNothing, 0, 0, false},
+		"clearerror":  {cmdNothing, 0, 0, false},
+
+		// There is no player and no play queue so these always say that nothing is
+		// playing.
+		"status":             {cmdStatus, 0, 0, false},
+		"currentsong":        {cmdNothing, 0, 0, false},
+		"playlistinfo":       {cmdNothing, 0, 1, false},
+		"playlistid":         {cmdNothing, 0, 1, false},
+		"plchanges":          {cmdNothing, 1, 2, false},
+		"plchangesposid":     {cmdNothing, 1, 2, false},
+		"listplaylists":      {cmdNothing, 0, 0, false},
+		"outputs":            {cmdNothing, 0, 0, false},
+		"replay_gain_status": {cmdReplayGainStatus, 0, 0, false},
+
+		"stats":       {cmdStats, 0, 0, false},
+		"lsinfo":      {cmdLsInfo, 0, 1, false},
+		"listall":     {cmdListAll, 0, 1, false},
+		"listallinfo": {cmdListAllInfo, 0, 1, false},
+		"list":        {cmdList, 1, -1, false},
+		"find":        {cmdFind, 1, -1, false},
+		"search":      {cmdSearch, 1, -1, false},
+		"count":       {cmdCount, 1, -1, false},
+	}
+}
+
+func cmdNothing(s *session, out *bytes.Buffer, args []string) error {
+	return nil
+}
+
+func cmdPassword(s *session, out *bytes.Buffer, args []string) error {
+	return s.checkPassword(args[0])
+}
+
+func cmdCommands(s *session, out *bytes.Buffer, args []string) error {
+	for _, name := range commandNames() {
+		if s.authenticated || commands[name].public {
+			fmt.Fprintf(out, "command: %s\n", name)
+		}
+	}
+	return nil
+}
+
+func cmdNotCommands(s *session, out *bytes.Buffer, args []string) error {
+	for _, name := range commandNames() {
+		if !s.authenticated && !commands[name].public {
+			fmt.Fprintf(out, "command: %s\n", name)
+		}
+	}
+	return nil
+}
+
+// commandNames returns the names of all supported commands in alphabetical order.
+func commandNames() []string {
+	names := []string{"close", "command_list_begin", "command_list_end",
+		"command_list_ok_begin", "idle", "noidle"}
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// cmdTagTypes lists the supported tags. Its sub commands for choosing which tags
+// are sent are accepted but all of the tags are always sent.
+func cmdTagTypes(s *session, out *bytes.Buffer, args []string) error {
+	if len(args) > 0 {
+		return nil
+	}
+
+	for _, tag := range songTags {
+		fmt.Fprintf(out, "tagtype: %s\n", tag.name)
+	}
+	return nil
+}
+
+func cmdURLHandlers(s *session, out *bytes.Buffer, args []string) error {
+	out.WriteString("handler: http://\nhandler: https://\n")
+	return nil
+}
+
+func cmdStatus(s *session, out *bytes.Buffer, args []string) error {
+	out.WriteString("volume: -1\n" +
+		"repeat: 0\n" +
+		"random: 0\n" +
+		"single: 0\n" +
+		"consume: 0\n" +
+		"playlist: 0\n" +
+		"playlistlength: 0\n" +
+		"mixrampdb: 0.000000\n" +
+		"state: stop\n")
+	return nil
+}
+
+func cmdReplayGainStatus(s *session, out *bytes.Buffer, args []string) error {
+	out.WriteString("replay_gain_mode: off\n")
+	return nil
+}
+
+func cmdStats(s *session, out *bytes.Buffer, args []string) error {
+	songs := s.allSongs()
+
+	var playtime int64
+	for _, sng := range songs {
+		playtime += sng.Duration
+	}
+
+	_, artists := s.srv.library.BrowseArtists(library.BrowseArgs{PerPage: 1})
+	_, albums := s.srv.library.BrowseAlbums(library.BrowseArgs{PerPage: 1})
+
+	fmt.Fprintf(out, "artists: %d\n", artists)
+	fmt.Fprintf(out, "albums: %d\n", albums)
+	fmt.Fprintf(out, "songs: %d\n", len(songs))
+	fmt.Fprintf(out, "uptime: %d\n", int64(time.Since(startTime)/time.Second))
+	fmt.Fprintf(out, "db_playtime: %d\n", playtime/1000)
+	fmt.Fprintf(out, "db_update: %d\n", startTime.Unix())
+	fmt.Fprintf(out, "playtime: 0\n")
+	return nil
+}
+
+// startTime is used for the uptime in the statistics.
+var startTime = time.Now()
+
+// cmdLsInfo lists the contents of a directory. The root directory has a directory for
+// every artist. In them there is a directory for every album of the artist with
+// the artist's tracks from it. With the URL of a track it returns its information.
+func cmdLsInfo(s *session, out *bytes.Buffer, args []string) error {
+	var uri string
+	if len(args) > 0 {
+		uri = args[0]
+	}
+
+	if strings.HasPrefix(uri, s.baseURL+"/file/") {
+		idStr := strings.TrimPrefix(uri, s.baseURL+"/file/")
+		idStr = strings.SplitN(idStr, "?", 2)[0]
+
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return newACK(ackErrNoExist, "No such song")
+		}
+
+		track, err := s.srv.library.GetTrack(id)
+		if err == library.ErrTrackNotFound {
+			return newACK(ackErrNoExist, "No such song")
+		} else if err != nil {
+			return err
+		}
+
+		s.writeSong(out, s.song(*track))
+		return nil
+	}
+
+	return s.walk(uri, false, func(dir string, songs []song) {
+		if songs == nil {
+			writeLine(out, "directory", dir)
+			return
+		}
+		for _, sng := range songs {
+			s.writeSong(out, sng)
+		}
+	})
+}
+
+// cmdListAll lists the directories and the song URLs in a directory and all of its
+// sub directories.
+func cmdListAll(s *session, out *bytes.Buffer, args []string) error {
+	var uri string
+	if len(args) > 0 {
+		uri = args[0]
+	}
+
+	return s.walk(uri, true, func(dir string, songs []song) {
+		if songs == nil {
+			writeLine(out, "directory", dir)
+			return
+		}
+		for _, sng := range songs {
+			fmt.Fprintf(out, "file: %s\n", sng.file)
+		}
+	})
+}
+
+// cmdListAllInfo is the same as cmdListAll but lists all information for the songs.
+func cmdListAllInfo(s *session, out *bytes.Buffer, args []string) error {
+	var uri string
+	if len(args) > 0 {
+		uri = args[0]
+	}
+
+	return s.walk(uri, true, func(dir string, songs []song) {
+		if songs == nil {
+			writeLine(out, "directory", dir)
+			return
+		}
+		for _, sng := range songs {
+			s.writeSong(out, sng)
+		}
+	})
+}
+
+// cmdList lists the unique values of a tag for the songs which match the filter. For
+// every "group" argument the values are grouped by another tag. The old form
+// "list album {artist}" lists the albums of this artist.
+func cmdList(s *session, out *bytes.Buffer, args []string) error {
+	tag, ok := findTag(args[0])
+
+	if !ok {
+		return newACK(ackErrArg, "Unknown tag type: %s", args[0])
+	}
+
+	args = args[1:]
+	if tag.name == "Album" && len(args) == 1 && !strings.HasPrefix(args[0], "(") {
+		args = []string{"artist", args[0]}
+	}
+
+	f, args, err := parseFilter(args, false, false)
+
+	if err != nil {
+		return err
+	}
+
+	var groups []songTag
+
+	for len(args) > 0 {
+		if len(args) < 2 || !strings.EqualFold(args[0], "group") {
+			return newACK(ackErrArg, "Unexpected argument: %s", args[0])
+		}
+
+		group, ok := findTag(args[1])
+
+		if !ok {
+			return newACK(ackErrArg, "Unknown tag type: %s", args[1])
+		}
+
+		groups = append(groups, group)
+		args = args[2:]
+	}
+
+	seen := make(map[string]bool)
+	var rows [][]string
+
+	for _, sng := range s.allSongs() {
+		if !f.matches(sng) || tag.value(sng.SearchResult) == "" {
+			continue
+		}
+
+		var row []string
+		for _, group := range groups {
+			row = append(row, group.value(sng.SearchResult))
+		}
+		row = append(row, tag.value(sng.SearchResult))
+
+		key := strings.Join(row, "\x00")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		for k := range rows[i] {
+			if rows[i][k] != rows[j][k] {
+				return rows[i][k] < rows[j][k]
+			}
+		}
+		return false
+	})
+
+	var previous []string
+
+	for _, row := range rows {
+		for k, group := range groups {
+			if previous == nil || previous[k] != row[k] {
+				writeLine(out, group.name, row[k])
+				previous = nil
+			}
+		}
+		writeLine(out, tag.name, row[len(row)-1])
+		previous = row
+	}
+
+	return nil
+}
+
+// cmdFind lists the songs which match the filter exactly.
+func cmdFind(s *session, out *bytes.Buffer, args []string) error {
+	return s.findSongs(out, args, false)
+}
+
+// cmdSearch lists the songs which contain the filter values in their tags
+// regardless of case.
+func cmdSearch(s *session, out *bytes.Buffer, args []string) error {
+	return s.findSongs(out, args, true)
+}
+
+// cmdCount returns the number and the total duration of the songs which match the
+// filter exactly.
+func cmdCount(s *session, out *bytes.Buffer, args []string) error {
+	f, args, err := parseFilter(args, false, false)
+
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 0 {
+		return newACK(ackErrArg, "Unexpected argument: %s", args[0])
+	}
+
+	var count, playtime int64
+
+	for _, sng := range s.allSongs() {
+		if f.matches(sng) {
+			count++
+			playtime += sng.Duration
+		}
+	}
+
+	fmt.Fprintf(out, "songs: %d\n", count)
+	fmt.Fprintf(out, "playtime: %d\n", playtime/1000)
+	return nil
+}
+
+// findSongs writes the songs which match the filter at the start of args. The rest of
+// args could be "sort {tag}" and "window {start}:{end}". With search the filter
+// values have to be contained in the tags regardless of case.
+func (s *session) findSongs(out *bytes.Buffer, args []string, search bool) error {
+	f, args, err := parseFilter(args, search, search)
+
+	if err != nil {
+		return err
+	}
+
+	var sortTag *songTag
+	start, end := 0, math.MaxInt32
+
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return newACK(ackErrArg, "Unexpected argument: %s", args[0])
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "sort":
+			tag, ok := findTag(strings.TrimPrefix(args[1], "-"))
+			if !ok {
+				return newACK(ackErrArg, "Unknown sort tag: %s", args[1])
+			}
+			sortTag = &tag
+		case "window":
+			start, end, err = parseRange(args[1])
+			if err != nil {
+				return err
+			}
+		default:
+			return newACK(ackErrArg, "Unexpected argument: %s", args[0])
+		}
+
+		args = args[2:]
+	}
+
+	var found []song
+
+	for _, sng := range s.allSongs() {
+		if f.matches(sng) {
+			found = append(found, sng)
+		}
+	}
+
+	if sortTag != nil {
+		sort.SliceStable(found, func(i, j int) bool {
+			return sortTag.value(found[i].SearchResult) <
+				sortTag.value(found[j].SearchResult)
+		})
+	}
+
+	for index, sng := range found {
+		if index >= start && index < end {
+			s.writeSong(out, sng)
+		}
+	}
+
+	return nil
+}
+
+// parseRange parses a range such as "10:20" which includes its start but not its
+// end. The end could be omitted.
+func parseRange(text string) (start, end int, err error) {
+	parts := strings.SplitN(text, ":", 2)
+	end = math.MaxInt32
+
+	start, err = strconv.Atoi(parts[0])
+
+	if err == nil && len(parts) == 2 && parts[1] != "" {
+		end, err = strconv.Atoi(parts[1])
+	}
+
+	if err != nil || start < 0 || end < start {
+		return 0, 0, newACK(ackErrArg, "Bad range: %s", text)
+	}
+
+	return start, end, nil
+}
+
+// allSongs returns all tracks from the library ordered by artist, album, disc and
+// track number.
+func (s *session) allSongs() []song {
+	tracks, _ := s.srv.library.Search(library.SearchArgs{})
+
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.Artist != b.Artist {
+			return a.Artist < b.Artist
+		}
+		if a.Album != b.Album {
+			return a.Album < b.Album
+		}
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		return a.TrackNumber < b.TrackNumber
+	})
+
+	songs := make([]song, 0, len(tracks))
+	for _, track := range tracks {
+		songs = append(songs, s.song(track))
+	}
+
+	return songs
+}
+
+// song returns the song of a library track.
+func (s *session) song(track library.SearchResult) song {
+	return song{
+		SearchResult: track,
+		file:         s.baseURL + s.filePath(track.ID),
+	}
+}
+
+// filePath returns the path from which a track is played. It is signed when the
+// server has a signer.
+func (s *session) filePath(trackID int64) string {
+	path := fmt.Sprintf("/file/%d", trackID)
+
+	if s.srv.opts.URLSigner != nil {
+		path = s.srv.opts.URLSigner.Sign(path,
+			time.Now().Add(auth.DefaultSignedURLLifetime))
+	}
+
+	return path
+}
+
+// writeSong writes all information for a song.
+func (s *session) writeSong(out *bytes.Buffer, sng song) {
+	fmt.Fprintf(out, "file: %s\n", sng.file)
+
+	if sng.Duration > 0 {
+		fmt.Fprintf(out, "Time: %d\n", (sng.Duration+500)/1000)
+		fmt.Fprintf(out, "duration: %.3f\n", float64(sng.Duration)/1000)
+	}
+
+	for _, tag := range songTags {
+		if value := tag.value(sng.SearchResult); value != "" {
+			writeLine(out, tag.name, value)
+		}
+	}
+}
+
+// writeLine writes a single line of a response. Line breaks in tags would break the
+// protocol so they are replaced with spaces.
+func writeLine(out *bytes.Buffer, key, value string) {
+	value = strings.Replace(value, "\n", " ", -1)
+	fmt.Fprintf(out, "%s: %s\n", key, value)
+}
@@ -0,0 +1,109 @@
+package mpd
+
+import (
+	"math"
+	"strings"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// walk calls visit for the contents of the directory at uri. Sub directories are
+// visited with their path and nil songs. Albums are visited once more with their
+// path and their songs. With recursive the contents of the sub directories are
+// visited as well. Otherwise only the directory itself is listed.
+//
+// The root directory has a directory for every artist. In every one of them there
+// is a directory for each album of the artist. Album directories have the songs of
+// the artist from the album.
+func (s *session) walk(
+	uri string,
+	recursive bool,
+	visit func(dir string, songs []song),
+) error {
+	uri = strings.Trim(uri, "/")
+	lib := s.srv.library
+
+	artists, _ := lib.BrowseArtists(library.BrowseArgs{
+		PerPage: math.MaxInt32,
+		Order:   library.OrderAsc,
+		OrderBy: library.OrderByName,
+	})
+
+	if uri == "" {
+		for _, artist := range artists {
+			visit(artist.Name, nil)
+			if recursive {
+				s.walkArtist(artist, "", true, visit)
+			}
+		}
+		return nil
+	}
+
+	// Artist names could contain slashes so the longest matching one is used.
+	var artist *library.Artist
+	for i, candidate := range artists {
+		if uri != candidate.Name && !strings.HasPrefix(uri, candidate.Name+"/") {
+			continue
+		}
+		if artist == nil || len(candidate.Name) > len(artist.Name) {
+			artist = &artists[i]
+		}
+	}
+
+	if artist == nil {
+		return newACK(ackErrNoExist, "No such directory")
+	}
+
+	albumName := strings.TrimPrefix(strings.TrimPrefix(uri, artist.Name), "/")
+
+	if albumName == "" {
+		s.walkArtist(*artist, "", recursive, visit)
+		return nil
+	}
+
+	if !s.walkArtist(*artist, albumName, true, visit) {
+		return newACK(ackErrNoExist, "No such directory")
+	}
+
+	return nil
+}
+
+// walkArtist visits the albums of an artist. When albumName is not empty only the
+// songs of the album with this name are visited. Returns false when the artist has
+// no such album.
+func (s *session) walkArtist(
+	artist library.Artist,
+	albumName string,
+	withSongs bool,
+	visit func(dir string, songs []song),
+) bool {
+	found := false
+
+	for _, album := range s.srv.library.GetArtistAlbums(artist.ID) {
+		dir := artist.Name + "/" + album.Name
+
+		if albumName != "" {
+			if album.Name != albumName {
+				continue
+			}
+		} else {
+			visit(dir, nil)
+		}
+
+		found = true
+
+		if !withSongs {
+			continue
+		}
+
+		songs := []song{}
+		for _, track := range s.srv.library.GetAlbumFiles(album.ID) {
+			if track.ArtistID == artist.ID {
+				songs = append(songs, s.song(track))
+			}
+		}
+		visit(dir, songs)
+	}
+
+	return found
+}
@@ -0,0 +1,375 @@
+package mpd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// songTag is a tag of the songs which is known to MPD clients.
+type songTag struct {
+	name  string
+	value func(library.SearchResult) string
+}
+
+// songTags are the supported tags in the order in which they are sent to clients.
+var songTags = []songTag{
+	{"Artist", func(tr library.SearchResult) string { return tr.Artist }},
+	{"AlbumArtist", func(tr library.SearchResult) string { return tr.AlbumArtist }},
+	{"Title", func(tr library.SearchResult) string { return tr.Title }},
+	{"Album", func(tr library.SearchResult) string { return tr.Album }},
+	{"Track", func(tr library.SearchResult) string { return formatNumber(tr.TrackNumber) }},
+	{"Date", func(tr library.SearchResult) string { return formatNumber(tr.Year) }},
+	{"Genre", func(tr library.SearchResult) string { return tr.Genre }},
+	{"Composer", func(tr library.SearchResult) string { return tr.Composer }},
+	{"Disc", func(tr library.SearchResult) string { return formatNumber(tr.DiscNumber) }},
+}
+
+// findTag returns the tag with this name. Tag names are case insensitive.
+func findTag(name string) (songTag, bool) {
+	for _, tag := range songTags {
+		if strings.EqualFold(tag.name, name) {
+			return tag, true
+		}
+	}
+
+	return songTag{}, false
+}
+
+// formatNumber returns a tag value for numeric properties. Zero means there is no
+// value.
+func formatNumber(number int64) string {
+	if number <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(number, 10)
+}
+
+// song is a library track as seen by MPD clients. Its "file" is the URL from which it
+// is played.
+type song struct {
+	library.SearchResult
+	file string
+}
+
+// filter selects songs.
+type filter interface {
+	matches(song) bool
+}
+
+// allFilter is the filter of commands without any conditions.
+type allFilter struct{}
+
+func (allFilter) matches(song) bool {
+	return true
+}
+
+// tagFilter compares one tag of the songs with a value. The tag "any" matches when
+// any of the tags matches and "file" compares the song URL.
+type tagFilter struct {
+	tag      string
+	operator string // "==", "!=" or "contains"
+	value    string
+	foldCase bool
+}
+
+func (tf tagFilter) matches(s song) bool {
+	var values []string
+
+	switch {
+	case strings.EqualFold(tf.tag, "file"):
+		values = []string{s.file}
+	case strings.EqualFold(tf.tag, "any"):
+		for _, tag := range songTags {
+			values = append(values, tag.value(s.SearchResult))
+		}
+	default:
+		tag, _ := findTag(tf.tag)
+		values = []string{tag.value(s.SearchResult)}
+	}
+
+	for _, value := range values {
+		if tf.compare(value) {
+			return tf.operator != "!="
+		}
+	}
+
+	return tf.operator == "!="
+}
+
+// compare returns true when value is equal to the filter's value. For "contains"
+// filters it returns true when value contains it.
+func (tf tagFilter) compare(value string) bool {
+	expected := tf.value
+
+	if tf.foldCase {
+		value = strings.ToLower(value)
+		expected = strings.ToLower(expected)
+	}
+
+	if tf.operator == "contains" {
+		return strings.Contains(value, expected)
+	}
+
+	return value == expected
+}
+
+// andFilter matches songs which are matched by all of its filters.
+type andFilter []filter
+
+func (af andFilter) matches(s song) bool {
+	for _, f := range af {
+		if !f.matches(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// notFilter matches songs which are not matched by its filter.
+type notFilter struct {
+	filter
+}
+
+func (nf notFilter) matches(s song) bool {
+	return !nf.filter.matches(s)
+}
+
+// filterOptions are the arguments which could follow the filter of a command.
+var filterOptions = map[string]bool{
+	"sort":   true,
+	"window": true,
+	"group":  true,
+}
+
+// parseFilter parses the filter at the start of the arguments of "find", "search"
+// and similar commands. It is either a filter expression such as
+// (artist == "Bugoff") or pairs of tags and values. Values of pairs must be equal to
+// the tags or with contains they have to be found in them. With foldCase all
+// comparisons are case insensitive. Returns the filter and the rest of the
+// arguments.
+func parseFilter(
+	args []string,
+	contains bool,
+	foldCase bool,
+) (filter, []string, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "(") {
+		p := &expressionParser{text: args[0], foldCase: foldCase}
+		f, err := p.parse()
+		return f, args[1:], err
+	}
+
+	operator := "=="
+	if contains {
+		operator = "contains"
+	}
+
+	var filters andFilter
+
+	for len(args) > 0 && !filterOptions[strings.ToLower(args[0])] {
+		if len(args) < 2 {
+			return nil, nil, newACK(ackErrArg, "not enough arguments")
+		}
+
+		tag := args[0]
+		if _, ok := findTag(tag); !ok && !isSpecialTag(tag) {
+			return nil, nil, newACK(ackErrArg, "Unknown filter type: %s", tag)
+		}
+
+		filters = append(filters, tagFilter{
+			tag:      tag,
+			operator: operator,
+			value:    args[1],
+			foldCase: foldCase,
+		})
+		args = args[2:]
+	}
+
+	if len(filters) == 0 {
+		return allFilter{}, args, nil
+	}
+
+	return filters, args, nil
+}
+
+// isSpecialTag returns true for the filter types which are not song tags.
+func isSpecialTag(tag string) bool {
+	return strings.EqualFold(tag, "any") || strings.EqualFold(tag, "file")
+}
+
+// expressionParser parses filter expressions. They are either comparisons such as
+// (artist == "Bugoff"), (album != 'Tests') and (title contains "pay"), negations
+// such as (!(genre == "Jazz")) or conjunctions of other expressions such as
+// ((artist == "Bugoff") AND (date == "2017")).
+type expressionParser struct {
+	text     string
+	pos      int
+	foldCase bool
+}
+
+func (p *expressionParser) parse() (filter, error) {
+	f, err := p.expression()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+
+	if p.pos != len(p.text) {
+		return nil, p.errorf("unexpected text after the filter")
+	}
+
+	return f, nil
+}
+
+func (p *expressionParser) expression() (filter, error) {
+	p.skipSpaces()
+
+	if !p.consume("(") {
+		return nil, p.errorf("'(' expected")
+	}
+
+	p.skipSpaces()
+
+	if p.consume("!") {
+		negated, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{negated}, p.closing()
+	}
+
+	if p.peek() == '(' {
+		var filters andFilter
+
+		for {
+			f, err := p.expression()
+
+			if err != nil {
+				return nil, err
+			}
+
+			filters = append(filters, f)
+			p.skipSpaces()
+
+			if p.consume(")") {
+				return filters, nil
+			}
+
+			if !p.consume("AND") {
+				return nil, p.errorf("'AND' or ')' expected")
+			}
+		}
+	}
+
+	tag := p.word()
+	if _, ok := findTag(tag); !ok && !isSpecialTag(tag) {
+		return nil, p.errorf("unknown tag %q", tag)
+	}
+
+	p.skipSpaces()
+	operator := p.word()
+
+	if operator != "==" && operator != "!=" && operator != "contains" {
+		return nil, p.errorf("unsupported operator %q", operator)
+	}
+
+	p.skipSpaces()
+	value, err := p.quoted()
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := tagFilter{
+		tag:      tag,
+		operator: operator,
+		value:    value,
+		foldCase: p.foldCase,
+	}
+
+	return f, p.closing()
+}
+
+// closing consumes the closing parenthesis of an expression.
+func (p *expressionParser) closing() error {
+	p.skipSpaces()
+
+	if !p.consume(")") {
+		return p.errorf("')' expected")
+	}
+
+	return nil
+}
+
+// quoted returns the value of a string in single or double quotes. A backslash
+// escapes the next character.
+func (p *expressionParser) quoted() (string, error) {
+	quote := p.peek()
+
+	if quote != '"' && quote != '\'' {
+		return "", p.errorf("quoted string expected")
+	}
+
+	var value []byte
+
+	for p.pos++; p.pos < len(p.text); p.pos++ {
+		c := p.text[p.pos]
+
+		if c == '\\' && p.pos+1 < len(p.text) {
+			p.pos++
+			value = append(value, p.text[p.pos])
+			continue
+		}
+
+		if c == quote {
+			p.pos++
+			return string(value), nil
+		}
+
+		value = append(value, c)
+	}
+
+	return "", p.errorf("closing quote expected")
+}
+
+// word returns the text up to the next space or parenthesis.
+func (p *expressionParser) word() string {
+	start := p.pos
+
+	for p.pos < len(p.text) && !strings.ContainsRune(" \t()", rune(p.text[p.pos])) {
+		p.pos++
+	}
+
+	return p.text[start:p.pos]
+}
+
+func (p *expressionParser) consume(token string) bool {
+	if !strings.HasPrefix(p.text[p.pos:], token) {
+		return false
+	}
+
+	p.pos += len(token)
+	return true
+}
+
+func (p *expressionParser) peek() byte {
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *expressionParser) errorf(format string, args ...interface{}) error {
+	return newACK(ackErrArg, "malformed filter at position %d: %s", p.pos,
+		fmt.Sprintf(format, args...))
+}
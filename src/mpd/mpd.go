@@ -0,0 +1,395 @@
+// Package mpd contains a server for the Music Player Daemon protocol. MPD clients
+// such as ncmpcpp and mpc connect to it and use the library as a read-only music
+// database. They browse it, search in it and get the tracks as URLs to the /file/
+// handler of the webserver. The server has no play queue and does not play anything
+// on its own.
+package mpd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// protocolVersion is the version of the MPD protocol which is announced to clients.
+const protocolVersion = "0.21.0"
+
+// maxLineLength is the maximum length of a single command line.
+const maxLineLength = 64 * 1024
+
+// Options configure a Server.
+type Options struct {
+
+	// Password must be sent with the "password" command before any other command
+	// is accepted. When empty and there is no Authenticator no password is required.
+	Password string
+
+	// Authenticator makes it possible to use the credentials of the users in the
+	// library and API tokens with the "password" command. MPD has no user names so
+	// users send "name:password" as their password.
+	Authenticator *auth.Authenticator
+
+	// HTTPPort is the port of the webserver. Tracks are given to clients as URLs
+	// to it.
+	HTTPPort int
+
+	// HTTPS is true when the webserver uses TLS.
+	HTTPS bool
+
+	// URLSigner signs the URLs of the tracks so that clients could play them without
+	// authentication. They are not signed when it is nil.
+	URLSigner *auth.URLSigner
+
+	// Throttle blocks clients which have sent too many wrong passwords. Clients are
+	// never blocked when it is nil.
+	Throttle *auth.Throttle
+}
+
+// Server accepts connections from MPD clients and answers their commands.
+type Server struct {
+	library library.Library
+	opts    Options
+
+	// wg waits for all client connections to be closed.
+	wg sync.WaitGroup
+}
+
+// NewServer returns a MPD server for the library.
+func NewServer(lib library.Library, opts Options) *Server {
+	srv := new(Server)
+	srv.library = lib
+	srv.opts = opts
+	return srv
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients until ctx is
+// done.
+func (srv *Server) ListenAndServe(ctx context.Context, addr string) error {
+	lsn, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	return srv.Serve(ctx, lsn)
+}
+
+// Serve accepts connections from lsn and serves them until ctx is done. Then it
+// closes lsn and all client connections and waits for them to finish. It always
+// returns a non-nil error unless ctx is done.
+func (srv *Server) Serve(ctx context.Context, lsn net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		lsn.Close()
+	}()
+
+	for {
+		conn, err := lsn.Accept()
+
+		if ctx.Err() != nil {
+			srv.wg.Wait()
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		srv.wg.Add(1)
+		go srv.serveConn(ctx, conn)
+	}
+}
+
+// serveConn reads the commands of a single client and answers them until the client
+// closes the connection or ctx is done.
+func (srv *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer srv.wg.Done()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	s := &session{
+		srv:           srv,
+		lines:         bufio.NewScanner(conn),
+		out:           bufio.NewWriter(conn),
+		authenticated: srv.opts.Password == "" && srv.opts.Authenticator == nil,
+		baseURL:       srv.baseURL(conn),
+		ip:            clientIP(conn),
+	}
+	s.lines.Buffer(make([]byte, 4096), maxLineLength)
+
+	fmt.Fprintf(s.out, "OK MPD %s\n", protocolVersion)
+
+	for {
+		if err := s.out.Flush(); err != nil {
+			return
+		}
+
+		line, ok := s.readLine()
+
+		if !ok || !s.handleLine(line) {
+			s.out.Flush()
+			return
+		}
+	}
+}
+
+// baseURL returns the URL of the webserver as seen by the client on conn. The host is
+// the local address to which the client is connected.
+func (srv *Server) baseURL(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+
+	if err != nil {
+		host = "127.0.0.1"
+	}
+
+	scheme := "http"
+	if srv.opts.HTTPS {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme,
+		net.JoinHostPort(host, strconv.Itoa(srv.opts.HTTPPort)))
+}
+
+// clientIP returns the IP address of the client on conn.
+func clientIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return host
+}
+
+// session is the state of a single client connection.
+type session struct {
+	srv   *Server
+	lines *bufio.Scanner
+	out   *bufio.Writer
+
+	// authenticated is true when the client has sent the right password.
+	authenticated bool
+
+	// baseURL is the URL of the webserver for this client.
+	baseURL string
+
+	// ip is the address of the client.
+	ip string
+
+	// commandList collects the commands between "command_list_begin" and
+	// "command_list_end". It is nil when not in a command list.
+	commandList []string
+
+	// listOK is true for command lists started with "command_list_ok_begin".
+	listOK bool
+}
+
+// readLine returns the next command line of the client without the line ending.
+func (s *session) readLine() (string, bool) {
+	if !s.lines.Scan() {
+		return "", false
+	}
+
+	return strings.TrimSuffix(s.lines.Text(), "\r"), true
+}
+
+// handleLine handles a single line from the client. It returns false when the
+// connection has to be closed.
+func (s *session) handleLine(line string) bool {
+	name := commandName(line)
+
+	switch {
+	case s.commandList != nil && name == "command_list_end":
+		s.runCommandList()
+		return true
+	case s.commandList != nil:
+		s.commandList = append(s.commandList, line)
+		return true
+	case name == "command_list_begin" || name == "command_list_ok_begin":
+		s.commandList = []string{}
+		s.listOK = name == "command_list_ok_begin"
+		return true
+	case name == "close":
+		return false
+	case name == "idle":
+		return s.idle()
+	}
+
+	var response bytes.Buffer
+
+	if err := s.run(line, &response); err != nil {
+		s.writeError(err, 0, name)
+		return true
+	}
+
+	s.out.Write(response.Bytes())
+	s.out.WriteString("OK\n")
+	return true
+}
+
+// runCommandList runs the commands from the current command list. It stops at the
+// first failed command.
+func (s *session) runCommandList() {
+	lines := s.commandList
+	s.commandList = nil
+
+	var response bytes.Buffer
+
+	for index, line := range lines {
+		if err := s.run(line, &response); err != nil {
+			s.out.Write(response.Bytes())
+			s.writeError(err, index, commandName(line))
+			return
+		}
+
+		if s.listOK {
+			response.WriteString("list_OK\n")
+		}
+	}
+
+	s.out.Write(response.Bytes())
+	s.out.WriteString("OK\n")
+}
+
+// idle waits for changes in the database. Since HTTPMS does not notify about
+// changes it only waits for the client to cancel the waiting with "noidle". It
+// returns false when the client sends anything else.
+func (s *session) idle() bool {
+	if err := s.out.Flush(); err != nil {
+		return false
+	}
+
+	line, ok := s.readLine()
+
+	if !ok || commandName(line) != "noidle" {
+		return false
+	}
+
+	s.out.WriteString("OK\n")
+	return true
+}
+
+// run runs a single command line and writes its response without the final "OK".
+func (s *session) run(line string, response *bytes.Buffer) error {
+	args, err := splitArgs(line)
+
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return newACK(ackErrUnknown, "No command given")
+	}
+
+	name := args[0]
+	cmd, ok := commands[name]
+
+	if !ok {
+		return newACK(ackErrUnknown, "unknown command %q", name)
+	}
+
+	if !s.authenticated && !cmd.public {
+		return newACK(ackErrPermission, "you don't have permission for %q", name)
+	}
+
+	if len(args)-1 < cmd.minArgs || (cmd.maxArgs >= 0 && len(args)-1 > cmd.maxArgs) {
+		return newACK(ackErrArg, "wrong number of arguments for %q", name)
+	}
+
+	return cmd.handler(s, response, args[1:])
+}
+
+// checkPassword authenticates the session when password is the right one. It is
+// either the password from the options, an API token or "name:password" of a user.
+// Failures are counted for the user name, if there is one, as well as for the IP.
+func (s *session) checkPassword(password string) error {
+	throttle := s.srv.opts.Throttle
+
+	var user string
+	if ind := strings.Index(password, ":"); ind > 0 {
+		user = password[:ind]
+	}
+
+	if _, blocked := throttle.Blocked(s.ip, user); blocked {
+		return newACK(ackErrPassword, "too many incorrect passwords, try again later")
+	}
+
+	if !s.srv.correctPassword(password) {
+		throttle.Failed(s.ip, user)
+		return newACK(ackErrPassword, "incorrect password")
+	}
+
+	throttle.Succeeded(s.ip, user)
+	s.authenticated = true
+	return nil
+}
+
+// correctPassword returns true when password is accepted by checkPassword.
+func (srv *Server) correctPassword(password string) bool {
+	expected := srv.opts.Password
+
+	if expected != "" && subtle.ConstantTimeCompare([]byte(password),
+		[]byte(expected)) == 1 {
+		return true
+	}
+
+	authenticator := srv.opts.Authenticator
+
+	if authenticator == nil {
+		return false
+	}
+
+	if ind := strings.Index(password, ":"); ind > 0 {
+		_, ok := authenticator.Authenticate(password[:ind], password[ind+1:])
+		return ok
+	}
+
+	_, _, ok := authenticator.AuthenticateToken(password)
+	return ok
+}
+
+// writeError writes the ACK line for an error from the command with this name at
+// this position in the command list.
+func (s *session) writeError(err error, listIndex int, name string) {
+	ack, ok := err.(*ackError)
+
+	if !ok {
+		log.Printf("Error in MPD command %s: %s\n", name, err)
+		ack = newACK(ackErrSystem, "%s", err)
+	}
+
+	fmt.Fprintf(s.out, "ACK [%d@%d] {%s} %s\n", ack.code, listIndex, name,
+		ack.message)
+}
+
+// commandName returns the name of the command on a line without parsing all of its
+// arguments.
+func commandName(line string) string {
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
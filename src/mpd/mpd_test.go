@@ -0,0 +1,578 @@
+package mpd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/helpers"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// testHTTPPort is the webserver port which is used in the song URLs.
+const testHTTPPort = 9996
+
+// testClient is a MPD client connected to a test server.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// getServer starts a MPD server for the test library. It is stopped with the
+// returned function.
+func getServer(t *testing.T, opts Options) (string, library.Library, func()) {
+	return getServerWithOptions(t, func(library.Library) Options {
+		return opts
+	})
+}
+
+// getServerWithOptions is like getServer but the options are returned by
+// getOptions. It is called with the test library before the server is started.
+func getServerWithOptions(
+	t *testing.T,
+	getOptions func(lib library.Library) Options,
+) (string, library.Library, func()) {
+	projRoot, err := helpers.ProjectRoot()
+
+	if err != nil {
+		t.Fatalf("Was not able to find test_files directory: %s", err)
+	}
+
+	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatalf("Initializing library: %s", err)
+	}
+
+	lib.AddLibraryPath(filepath.Join(projRoot, "test_files", "library"))
+	lib.Scan()
+
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := NewServer(lib, getOptions(lib))
+	serveErr := make(chan error)
+
+	go func() {
+		serveErr <- srv.Serve(ctx, lsn)
+	}()
+
+	stop := func() {
+		cancel()
+
+		select {
+		case err := <-serveErr:
+			if err != nil {
+				t.Errorf("Serving returned an error after stopping: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("MPD server did not stop on time")
+		}
+
+		lib.Truncate()
+	}
+
+	return lsn.Addr().String(), lib, stop
+}
+
+// connect connects to the server at addr and reads its greeting.
+func connect(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	client := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	greeting := client.readLine()
+
+	if !strings.HasPrefix(greeting, "OK MPD ") {
+		t.Fatalf("Unexpected greeting: %s", greeting)
+	}
+
+	return client
+}
+
+func (c *testClient) readLine() string {
+	line, err := c.reader.ReadString('\n')
+
+	if err != nil {
+		c.t.Fatalf("Reading from MPD server: %s", err)
+	}
+
+	return strings.TrimSuffix(line, "\n")
+}
+
+// command sends a command line and returns the lines of its response. The final
+// "OK" is not among them. For failed commands ack is the ACK line.
+func (c *testClient) command(line string) (lines []string, ack string) {
+	if _, err := fmt.Fprintf(c.conn, "%s\n", line); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		response := c.readLine()
+
+		if response == "OK" {
+			return lines, ""
+		}
+
+		if strings.HasPrefix(response, "ACK ") {
+			return lines, response
+		}
+
+		lines = append(lines, response)
+	}
+}
+
+// mustCommand sends a command line and fails the test when it is not successful.
+func (c *testClient) mustCommand(line string) []string {
+	lines, ack := c.command(line)
+
+	if ack != "" {
+		c.t.Fatalf("Command %s failed: %s", line, ack)
+	}
+
+	return lines
+}
+
+// values returns the values of all lines with this key.
+func values(lines []string, key string) []string {
+	var found []string
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, key+": ") {
+			found = append(found, strings.TrimPrefix(line, key+": "))
+		}
+	}
+
+	return found
+}
+
+func TestPingAndUnknownCommands(t *testing.T) {
+	addr, _, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	client.mustCommand("ping")
+
+	_, ack := client.command("play")
+	if !strings.HasPrefix(ack, "ACK [5@0] {play}") {
+		t.Errorf("Unexpected response for unknown command: %s", ack)
+	}
+
+	_, ack = client.command(`lsinfo "not closed`)
+	if !strings.HasPrefix(ack, "ACK [2@0] {lsinfo}") {
+		t.Errorf("Unexpected response for malformed arguments: %s", ack)
+	}
+
+	lines := client.mustCommand("status")
+	if state := values(lines, "state"); len(state) != 1 || state[0] != "stop" {
+		t.Errorf("Expected stopped state but status was %v", lines)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	addr, _, stop := getServer(t, Options{
+		HTTPPort: testHTTPPort,
+		Password: "secret",
+	})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	client.mustCommand("ping")
+
+	_, ack := client.command("lsinfo")
+	if !strings.HasPrefix(ack, "ACK [4@0] {lsinfo}") {
+		t.Errorf("Expected permission error before the password but got %q", ack)
+	}
+
+	_, ack = client.command("password wrong")
+	if !strings.HasPrefix(ack, "ACK [3@0] {password}") {
+		t.Errorf("Expected password error for a wrong password but got %q", ack)
+	}
+
+	client.mustCommand(`password "secret"`)
+	client.mustCommand("lsinfo")
+}
+
+func TestPasswordThrottling(t *testing.T) {
+	addr, _, stop := getServer(t, Options{
+		HTTPPort: testHTTPPort,
+		Password: "secret",
+		Throttle: auth.NewThrottle(config.Throttling{
+			Enabled:     true,
+			MaxFailures: 3,
+			BaseDelay:   time.Minute,
+			Lockout:     time.Hour,
+			ResetAfter:  2 * time.Hour,
+		}),
+	})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	_, ack := client.command("password wrong")
+	if !strings.HasSuffix(ack, "incorrect password") {
+		t.Errorf("Expected password error for a wrong password but got %q", ack)
+	}
+
+	// The client is blocked even on other connections and with the right password.
+	other := connect(t, addr)
+	defer other.conn.Close()
+
+	_, ack = other.command(`password "secret"`)
+	if !strings.HasPrefix(ack, "ACK [3@0] {password} too many") {
+		t.Errorf("Expected the client to be blocked but got %q", ack)
+	}
+}
+
+// Users in the library send "name:password" with the password command. API tokens
+// are accepted as passwords as well.
+func TestUserPasswordsAndTokens(t *testing.T) {
+	var token string
+
+	addr, _, stop := getServerWithOptions(t, func(lib library.Library) Options {
+		hash, _ := auth.HashPassword("alicepass")
+		alice, err := lib.CreateUser("alice", hash, library.RoleListener)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tokenHash string
+		token, tokenHash, _ = auth.NewAPIToken()
+
+		if _, err := lib.CreateAPIToken(alice.ID, "mpd", tokenHash, true); err != nil {
+			t.Fatal(err)
+		}
+
+		authenticator := auth.NewAuthenticator(config.Auth{
+			User:     "admin",
+			Password: "secret",
+		}, lib)
+
+		return Options{
+			HTTPPort:      testHTTPPort,
+			Password:      "secret",
+			Authenticator: authenticator,
+		}
+	})
+	defer stop()
+
+	for _, password := range []string{
+		"secret",
+		"admin:secret",
+		"alice:alicepass",
+		token,
+	} {
+		client := connect(t, addr)
+		client.mustCommand(fmt.Sprintf("password %q", password))
+		client.mustCommand("lsinfo")
+		client.conn.Close()
+	}
+
+	for _, password := range []string{
+		"alicepass",
+		"alice:secret",
+		"nobody:alicepass",
+		token + "x",
+	} {
+		client := connect(t, addr)
+
+		_, ack := client.command(fmt.Sprintf("password %q", password))
+		if !strings.HasSuffix(ack, "incorrect password") {
+			t.Errorf("Expected %q to be an incorrect password but got %q", password,
+				ack)
+		}
+
+		client.conn.Close()
+	}
+}
+
+func TestLsInfo(t *testing.T) {
+	addr, lib, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	root := values(client.mustCommand("lsinfo"), "directory")
+
+	if !contains(root, "Buggy Bugoff") || !contains(root, "Artist Testoff") {
+		t.Errorf("Artists were not found in the root directory: %v", root)
+	}
+
+	albums := values(client.mustCommand(`lsinfo "Buggy Bugoff"`), "directory")
+	expectedAlbums := []string{"Buggy Bugoff/Return Of The Bugs"}
+
+	if !reflect.DeepEqual(albums, expectedAlbums) {
+		t.Errorf("Expected albums %v but got %v", expectedAlbums, albums)
+	}
+
+	lines := client.mustCommand(`lsinfo "Buggy Bugoff/Return Of The Bugs"`)
+	found, _ := lib.Search(library.SearchArgs{Query: "Payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one track for Payback but got %d", len(found))
+	}
+
+	expectedFile := fmt.Sprintf("http://127.0.0.1:%d/file/%d", testHTTPPort,
+		found[0].ID)
+
+	if files := values(lines, "file"); len(files) != 1 || files[0] != expectedFile {
+		t.Errorf("Expected file %s but got %v", expectedFile, files)
+	}
+
+	if titles := values(lines, "Title"); len(titles) != 1 || titles[0] != "Payback" {
+		t.Errorf("Expected title Payback but got %v", titles)
+	}
+
+	song := client.mustCommand(fmt.Sprintf("lsinfo %s", expectedFile))
+	if titles := values(song, "Title"); len(titles) != 1 || titles[0] != "Payback" {
+		t.Errorf("Expected the song for its URL but got %v", song)
+	}
+
+	_, ack := client.command(`lsinfo "No Such Artist"`)
+	if !strings.HasPrefix(ack, "ACK [50@0] {lsinfo}") {
+		t.Errorf("Expected error for missing directory but got %q", ack)
+	}
+}
+
+func TestSignedSongURLs(t *testing.T) {
+	signer := auth.NewURLSigner([]byte("secret"))
+	addr, _, stop := getServer(t, Options{HTTPPort: testHTTPPort, URLSigner: signer})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	lines := client.mustCommand(`lsinfo "Buggy Bugoff/Return Of The Bugs"`)
+	files := values(lines, "file")
+
+	if len(files) != 1 {
+		t.Fatalf("Expected one file but got %v", files)
+	}
+
+	u, err := url.Parse(files[0])
+
+	if err != nil {
+		t.Fatalf("Parsing song URL %s: %s", files[0], err)
+	}
+
+	if !signer.Valid(u) {
+		t.Errorf("Expected song URL with valid signature but got %s", files[0])
+	}
+
+	song := client.mustCommand(fmt.Sprintf("lsinfo %s", files[0]))
+	if titles := values(song, "Title"); len(titles) != 1 || titles[0] != "Payback" {
+		t.Errorf("Expected the song for its signed URL but got %v", song)
+	}
+}
+
+func TestListAllInfo(t *testing.T) {
+	addr, lib, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	_, total := lib.Search(library.SearchArgs{})
+
+	files := values(client.mustCommand("listallinfo"), "file")
+	if len(files) != total {
+		t.Errorf("Expected %d songs in listallinfo but got %d", total, len(files))
+	}
+
+	files = values(client.mustCommand("listall"), "file")
+	if len(files) != total {
+		t.Errorf("Expected %d songs in listall but got %d", total, len(files))
+	}
+
+	lines := client.mustCommand(`listallinfo "Artist Testoff"`)
+	titles := values(lines, "Title")
+
+	if !contains(titles, "Tittled Track") || !contains(titles, "Another One") {
+		t.Errorf("Songs of Artist Testoff were not listed: %v", lines)
+	}
+}
+
+func TestList(t *testing.T) {
+	addr, _, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	artists := values(client.mustCommand("list artist"), "Artist")
+
+	if !contains(artists, "Buggy Bugoff") || !contains(artists, "Artist Testoff") {
+		t.Errorf("Artists were not listed: %v", artists)
+	}
+
+	albums := values(client.mustCommand(`list album "Artist Testoff"`), "Album")
+	if !reflect.DeepEqual(albums, []string{"Album Of Tests"}) {
+		t.Errorf("Unexpected albums of Artist Testoff: %v", albums)
+	}
+
+	lines := client.mustCommand(`list album "(artist == 'Buggy Bugoff')" group artist`)
+	expected := []string{"Artist: Buggy Bugoff", "Album: Return Of The Bugs"}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected grouped albums %v but got %v", expected, lines)
+	}
+
+	_, ack := client.command("list colour")
+	if !strings.HasPrefix(ack, "ACK [2@0] {list}") {
+		t.Errorf("Expected error for unknown tag but got %q", ack)
+	}
+}
+
+func TestFindAndSearch(t *testing.T) {
+	addr, _, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	tests := []struct {
+		command string
+		titles  []string
+	}{
+		{`find artist "Buggy Bugoff"`, []string{"Payback"}},
+		{`find artist "buggy bugoff"`, nil},
+		{`find album "Album Of Tests" title "Another One"`, []string{"Another One"}},
+		{`search artist "bugoff"`, []string{"Payback"}},
+		{`search any "TITTLED"`, []string{"Tittled Track"}},
+		{`find "(album == 'Album Of Tests')" sort title`,
+			[]string{"Another One", "Tittled Track"}},
+		{`find "(album == 'Album Of Tests')" sort title window 1:2`,
+			[]string{"Tittled Track"}},
+		{`find "((artist == \"Artist Testoff\") AND (!(title == \"Another One\")))"`,
+			[]string{"Tittled Track"}},
+		{`search "(title contains 'payb')"`, []string{"Payback"}},
+	}
+
+	for _, test := range tests {
+		lines := client.mustCommand(test.command)
+		titles := values(lines, "Title")
+
+		if !reflect.DeepEqual(titles, test.titles) {
+			t.Errorf("Expected %v for %s but got %v", test.titles, test.command,
+				titles)
+		}
+	}
+
+	lines := client.mustCommand(`count artist "Artist Testoff"`)
+	if songs := values(lines, "songs"); len(songs) != 1 || songs[0] != "2" {
+		t.Errorf("Expected 2 songs by Artist Testoff but got %v", lines)
+	}
+
+	_, ack := client.command(`find "(artist =~ 'Bug')"`)
+	if !strings.HasPrefix(ack, "ACK [2@0] {find}") {
+		t.Errorf("Expected error for unsupported operator but got %q", ack)
+	}
+}
+
+func TestCommandLists(t *testing.T) {
+	addr, _, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	fmt.Fprintf(client.conn, "command_list_ok_begin\nping\nreplay_gain_status\n")
+	lines := client.mustCommand("command_list_end")
+	expected := []string{"list_OK", "replay_gain_mode: off", "list_OK"}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v from command list but got %v", expected, lines)
+	}
+
+	fmt.Fprintf(client.conn, "command_list_begin\nping\nstop\nping\n")
+	_, ack := client.command("command_list_end")
+
+	if !strings.HasPrefix(ack, "ACK [5@1] {stop}") {
+		t.Errorf("Expected error for the second command but got %q", ack)
+	}
+
+	client.mustCommand("ping")
+}
+
+func TestIdle(t *testing.T) {
+	addr, _, stop := getServer(t, Options{HTTPPort: testHTTPPort})
+	defer stop()
+
+	client := connect(t, addr)
+	defer client.conn.Close()
+
+	fmt.Fprintf(client.conn, "idle database\n")
+	client.mustCommand("noidle")
+	client.mustCommand("ping")
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"ping", []string{"ping"}},
+		{"  find  artist   Bugoff ", []string{"find", "artist", "Bugoff"}},
+		{`find artist "Buggy Bugoff"`, []string{"find", "artist", "Buggy Bugoff"}},
+		{`find "(artist == \"A \\\\ B\")"`, []string{"find", `(artist == "A \\ B")`}},
+		{`lsinfo ""`, []string{"lsinfo", ""}},
+	}
+
+	for _, test := range tests {
+		args, err := splitArgs(test.line)
+
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %s", test.line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("Expected %q for %s but got %q", test.expected, test.line, args)
+		}
+	}
+
+	for _, line := range []string{`find "artist`, `find "artist"album`} {
+		if _, err := splitArgs(line); err == nil {
+			t.Errorf("Expected an error for %s", line)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package mpd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Error codes of the ACK responses from the MPD protocol.
+const (
+	ackErrArg        = 2
+	ackErrPassword   = 3
+	ackErrPermission = 4
+	ackErrUnknown    = 5
+	ackErrNoExist    = 50
+	ackErrSystem     = 52
+)
+
+// ackError is an error returned by the commands. It is sent to the client as an ACK
+// response.
+type ackError struct {
+	code    int
+	message string
+}
+
+// Error satisfies the error interface.
+func (ae *ackError) Error() string {
+	return ae.message
+}
+
+// newACK returns an error with this ACK code and message.
+func newACK(code int, format string, args ...interface{}) *ackError {
+	return &ackError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// splitArgs splits a command line into the command name and its arguments. They
+// are separated by whitespace. Arguments with whitespace in them are put in double
+// quotes. In quoted arguments a backslash escapes the next character.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+
+	for {
+		line = strings.TrimLeft(line, " \t")
+
+		if line == "" {
+			return args, nil
+		}
+
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		var arg bytes.Buffer
+		closed := false
+		i := 1
+
+		for ; i < len(line); i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+				arg.WriteByte(line[i])
+				continue
+			}
+
+			if line[i] == '"' {
+				closed = true
+				break
+			}
+
+			arg.WriteByte(line[i])
+		}
+
+		if !closed {
+			return nil, newACK(ackErrArg, "Missing closing '\"'")
+		}
+
+		args = append(args, arg.String())
+		line = line[i+1:]
+
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return nil, newACK(ackErrArg, "Space expected after closing '\"'")
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Package playlists reads and writes playlist files in the M3U8, PLS and XSPF
+// formats.
+package playlists
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a playlist file format. Its value is the file extension used for it,
+// without the dot.
+type Format string
+
+// The supported playlist file formats.
+const (
+	M3U8 Format = "m3u8"
+	PLS  Format = "pls"
+	XSPF Format = "xspf"
+)
+
+// Formats are all supported formats.
+var Formats = []Format{M3U8, PLS, XSPF}
+
+// FormatByExtension returns the format of files with this extension. The extension
+// could be given with or without the leading dot and is case insensitive. Files
+// with the ".m3u" extension are read as M3U8 files.
+func FormatByExtension(extension string) (Format, bool) {
+	extension = strings.ToLower(strings.TrimPrefix(extension, "."))
+
+	if extension == "m3u" {
+		return M3U8, true
+	}
+
+	for _, format := range Formats {
+		if string(format) == extension {
+			return format, true
+		}
+	}
+
+	return "", false
+}
+
+// ContentType returns the MIME type of the files in this format.
+func (f Format) ContentType() string {
+	switch f {
+	case M3U8:
+		return "audio/x-mpegurl; charset=utf-8"
+	case PLS:
+		return "audio/x-scpls; charset=utf-8"
+	case XSPF:
+		return "application/xspf+xml; charset=utf-8"
+	}
+
+	return "application/octet-stream"
+}
+
+// Entry is a single track in a playlist. Location is either a file system path
+// or an URL. All other properties are optional and could be empty.
+type Entry struct {
+	Location string
+	Title    string
+	Artist   string
+	Album    string
+	Duration time.Duration
+}
+
+// displayTitle returns the title of an entry in the "Artist - Title" form used by
+// the M3U8 and PLS formats.
+func (e Entry) displayTitle() string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// Playlist is the contents of a playlist file.
+type Playlist struct {
+	Title   string
+	Entries []Entry
+}
+
+// Read parses a playlist file in this format.
+func Read(r io.Reader, format Format) (*Playlist, error) {
+	switch format {
+	case M3U8:
+		return readM3U8(r)
+	case PLS:
+		return readPLS(r)
+	case XSPF:
+		return readXSPF(r)
+	}
+
+	return nil, fmt.Errorf("unsupported playlist format %q", format)
+}
+
+// Write writes the playlist in this format.
+func Write(w io.Writer, format Format, playlist *Playlist) error {
+	switch format {
+	case M3U8:
+		return writeM3U8(w, playlist)
+	case PLS:
+		return writePLS(w, playlist)
+	case XSPF:
+		return writeXSPF(w, playlist)
+	}
+
+	return fmt.Errorf("unsupported playlist format %q", format)
+}
+
+// utf8BOM is the byte order mark with which some programs start their UTF-8 files.
+const utf8BOM = "\ufeff"
+
+// readLines calls handle for every line in r without its line ending. Blank lines
+// are skipped.
+func readLines(r io.Reader, handle func(line string)) error {
+	scanner := bufio.NewScanner(r)
+	first := true
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if first {
+			line = strings.TrimPrefix(line, utf8BOM)
+			first = false
+		}
+
+		if line != "" {
+			handle(line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readM3U8 parses both simple and extended M3U files. The #EXTINF directive gives
+// the duration and "Artist - Title" of the following entry and #PLAYLIST gives the
+// title of the playlist. All other comments are ignored.
+func readM3U8(r io.Reader) (*Playlist, error) {
+	playlist := &Playlist{}
+	var next Entry
+
+	err := readLines(r, func(line string) {
+		if strings.HasPrefix(line, "#PLAYLIST:") {
+			playlist.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+			return
+		}
+
+		if strings.HasPrefix(line, "#EXTINF:") {
+			info := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)
+			next = Entry{}
+
+			// Attributes such as tvg-id="..." could follow the duration.
+			fields := strings.Fields(info[0])
+			if len(fields) > 0 {
+				if seconds, err := strconv.Atoi(fields[0]); err == nil && seconds > 0 {
+					next.Duration = time.Duration(seconds) * time.Second
+				}
+			}
+
+			if len(info) > 1 {
+				next.Artist, next.Title = splitDisplayTitle(info[1])
+			}
+			return
+		}
+
+		if strings.HasPrefix(line, "#") {
+			return
+		}
+
+		next.Location = line
+		playlist.Entries = append(playlist.Entries, next)
+		next = Entry{}
+	})
+
+	return playlist, err
+}
+
+// splitDisplayTitle splits "Artist - Title" into its parts. Titles without an
+// artist are returned as they are.
+func splitDisplayTitle(title string) (string, string) {
+	title = strings.TrimSpace(title)
+	parts := strings.SplitN(title, " - ", 2)
+
+	if len(parts) != 2 {
+		return "", title
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+func writeM3U8(w io.Writer, playlist *Playlist) error {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+
+	if playlist.Title != "" {
+		fmt.Fprintf(&buf, "#PLAYLIST:%s\n", oneLine(playlist.Title))
+	}
+
+	for _, entry := range playlist.Entries {
+		seconds := -1
+		if entry.Duration > 0 {
+			seconds = int(entry.Duration.Round(time.Second) / time.Second)
+		}
+
+		fmt.Fprintf(&buf, "#EXTINF:%d,%s\n", seconds, oneLine(entry.displayTitle()))
+		fmt.Fprintf(&buf, "%s\n", entry.Location)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readPLS parses PLS files. They are INI files with a [playlist] section in which
+// FileN, TitleN and LengthN are the properties of the N-th entry. Entries are
+// ordered by N.
+func readPLS(r io.Reader) (*Playlist, error) {
+	entries := make(map[int]*Entry)
+
+	err := readLines(r, func(line string) {
+		keyValue := strings.SplitN(line, "=", 2)
+
+		if len(keyValue) != 2 {
+			return
+		}
+
+		key := strings.ToLower(strings.TrimSpace(keyValue[0]))
+		value := strings.TrimSpace(keyValue[1])
+
+		for _, property := range []string{"file", "title", "length"} {
+			if !strings.HasPrefix(key, property) {
+				continue
+			}
+
+			number, err := strconv.Atoi(key[len(property):])
+
+			if err != nil {
+				return
+			}
+
+			entry, ok := entries[number]
+			if !ok {
+				entry = &Entry{}
+				entries[number] = entry
+			}
+
+			switch property {
+			case "file":
+				entry.Location = value
+			case "title":
+				entry.Artist, entry.Title = splitDisplayTitle(value)
+			case "length":
+				if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+					entry.Duration = time.Duration(seconds) * time.Second
+				}
+			}
+			return
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []int
+	for number, entry := range entries {
+		if entry.Location != "" {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+
+	playlist := &Playlist{}
+	for _, number := range numbers {
+		playlist.Entries = append(playlist.Entries, *entries[number])
+	}
+
+	return playlist, nil
+}
+
+func writePLS(w io.Writer, playlist *Playlist) error {
+	var buf bytes.Buffer
+	buf.WriteString("[playlist]\n")
+
+	for i, entry := range playlist.Entries {
+		seconds := -1
+		if entry.Duration > 0 {
+			seconds = int(entry.Duration.Round(time.Second) / time.Second)
+		}
+
+		fmt.Fprintf(&buf, "File%d=%s\n", i+1, entry.Location)
+		fmt.Fprintf(&buf, "Title%d=%s\n", i+1, oneLine(entry.displayTitle()))
+		fmt.Fprintf(&buf, "Length%d=%d\n", i+1, seconds)
+	}
+
+	fmt.Fprintf(&buf, "NumberOfEntries=%d\n", len(playlist.Entries))
+	buf.WriteString("Version=2\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// oneLine replaces line breaks with spaces. The line based formats have no way of
+// escaping them.
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// xspfNamespace is the XML namespace of XSPF version 1.
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+// xspfPlaylist is the root element of XSPF files. Its XMLName has no tag so that
+// files without the namespace could be read as well.
+type xspfPlaylist struct {
+	XMLName xml.Name
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int64  `xml:"duration,omitempty"` // In milliseconds
+}
+
+func readXSPF(r io.Reader) (*Playlist, error) {
+	var parsed xspfPlaylist
+
+	if err := xml.NewDecoder(r).Decode(&parsed); err != nil {
+		return nil, err
+	}
+
+	playlist := &Playlist{Title: strings.TrimSpace(parsed.Title)}
+
+	for _, track := range parsed.Tracks {
+		if strings.TrimSpace(track.Location) == "" {
+			continue
+		}
+
+		playlist.Entries = append(playlist.Entries, Entry{
+			Location: strings.TrimSpace(track.Location),
+			Title:    track.Title,
+			Artist:   track.Creator,
+			Album:    track.Album,
+			Duration: time.Duration(track.Duration) * time.Millisecond,
+		})
+	}
+
+	return playlist, nil
+}
+
+func writeXSPF(w io.Writer, playlist *Playlist) error {
+	out := xspfPlaylist{
+		XMLName: xml.Name{Space: xspfNamespace, Local: "playlist"},
+		Version: "1",
+		Title:   playlist.Title,
+		Tracks:  []xspfTrack{},
+	}
+
+	for _, entry := range playlist.Entries {
+		out.Tracks = append(out.Tracks, xspfTrack{
+			Location: entry.Location,
+			Title:    entry.Title,
+			Creator:  entry.Artist,
+			Album:    entry.Album,
+			Duration: int64(entry.Duration / time.Millisecond),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
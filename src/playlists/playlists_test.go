@@ -0,0 +1,163 @@
+package playlists
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatByExtension(t *testing.T) {
+	tests := map[string]Format{
+		".m3u":  M3U8,
+		"M3U8":  M3U8,
+		".PLS":  PLS,
+		".xspf": XSPF,
+	}
+
+	for extension, expected := range tests {
+		if found, ok := FormatByExtension(extension); !ok || found != expected {
+			t.Errorf("Expected %s for %s but got %q", expected, extension, found)
+		}
+	}
+
+	for _, extension := range []string{"", ".mp3", ".m3u9"} {
+		if found, ok := FormatByExtension(extension); ok {
+			t.Errorf("Expected no format for %q but got %s", extension, found)
+		}
+	}
+}
+
+func TestReadingFiles(t *testing.T) {
+	expected := []Entry{
+		{Location: "Artist/Album/01 - First.mp3", Title: "First", Artist: "Artist",
+			Duration: 215 * time.Second},
+		{Location: `C:\Music\second.flac`, Title: "Second"},
+		{Location: "http://example.com/third.ogg"},
+	}
+
+	tests := []struct {
+		format   Format
+		contents string
+		title    string
+	}{
+		{
+			format: M3U8,
+			title:  "Favourites",
+			contents: "\ufeff#EXTM3U\n#PLAYLIST:Favourites\n" +
+				"#EXTINF:215,Artist - First\r\nArtist/Album/01 - First.mp3\r\n\n" +
+				"#EXTINF:-1 tvg-id=\"x\",Second\n#EXTALB:Unknown\n" +
+				`C:\Music\second.flac` + "\n" +
+				"# a comment\nhttp://example.com/third.ogg\n",
+		},
+		{
+			format: PLS,
+			contents: "[playlist]\nNumberOfEntries=3\n" +
+				"File3=http://example.com/third.ogg\n" +
+				"File1=Artist/Album/01 - First.mp3\nTitle1=Artist - First\n" +
+				"Length1=215\n" +
+				`file2=C:\Music\second.flac` + "\ntitle2=Second\nlength2=-1\n" +
+				"Title4=Without File\nVersion=2\n",
+		},
+		{
+			format: XSPF,
+			title:  "Favourites",
+			contents: `<?xml version="1.0" encoding="UTF-8"?>
+				<playlist version="1" xmlns="http://xspf.org/ns/0/">
+				  <title>Favourites</title>
+				  <trackList>
+				    <track>
+				      <location>Artist/Album/01 - First.mp3</location>
+				      <title>First</title>
+				      <creator>Artist</creator>
+				      <duration>215000</duration>
+				    </track>
+				    <track>
+				      <location>C:\Music\second.flac</location>
+				      <title>Second</title>
+				    </track>
+				    <track><location>http://example.com/third.ogg</location></track>
+				    <track><title>Without Location</title></track>
+				  </trackList>
+				</playlist>`,
+		},
+	}
+
+	for _, test := range tests {
+		playlist, err := Read(strings.NewReader(test.contents), test.format)
+
+		if err != nil {
+			t.Errorf("Error reading %s: %s", test.format, err)
+			continue
+		}
+
+		if playlist.Title != test.title {
+			t.Errorf("Expected %s title %q but got %q", test.format, test.title,
+				playlist.Title)
+		}
+
+		if !reflect.DeepEqual(playlist.Entries, expected) {
+			t.Errorf("Wrong %s entries. Expected\n%+v\nbut got\n%+v", test.format,
+				expected, playlist.Entries)
+		}
+	}
+
+	if _, err := Read(strings.NewReader("<playlist>"), XSPF); err == nil {
+		t.Errorf("Expected an error for malformed XSPF")
+	}
+}
+
+func TestWritingAndReadingBack(t *testing.T) {
+	playlist := &Playlist{
+		Title: "Mix\nTape",
+		Entries: []Entry{
+			{
+				Location: "http://example.com/file/1",
+				Title:    "Payback",
+				Artist:   "Buggy Bugoff",
+				Album:    "Return Of The Bugs",
+				Duration: 3 * time.Second,
+			},
+			{Location: "http://example.com/file/2", Title: "Untitled & Unknown"},
+		},
+	}
+
+	for _, format := range Formats {
+		var buf bytes.Buffer
+
+		if err := Write(&buf, format, playlist); err != nil {
+			t.Fatalf("Error writing %s: %s", format, err)
+		}
+
+		read, err := Read(&buf, format)
+
+		if err != nil {
+			t.Fatalf("Error reading back %s: %s", format, err)
+		}
+
+		if len(read.Entries) != 2 {
+			t.Fatalf("Expected 2 %s entries but got %+v", format, read.Entries)
+		}
+
+		for i, entry := range read.Entries {
+			original := playlist.Entries[i]
+
+			// Only XSPF has albums.
+			if format != XSPF {
+				original.Album = ""
+			}
+
+			if entry != original {
+				t.Errorf("Expected %s entry %+v but got %+v", format, original, entry)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	Write(&buf, M3U8, playlist)
+
+	if !strings.Contains(buf.String(), "#PLAYLIST:Mix Tape\n") {
+		t.Errorf("Expected a title without line breaks in:\n%s", buf.String())
+	}
+}
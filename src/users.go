@@ -0,0 +1,168 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// usersUsage describes the commands for managing users.
+const usersUsage = `Usage: httpms user <command> [arguments]
+
+Commands:
+    add [-admin] <name>    creates a listener, or an admin with -admin
+    del <name>             deletes a user
+    passwd <name>          changes the password of a user
+    list                   lists all users
+
+Passwords are read from the standard input. They are not echoed when it is a
+terminal.
+`
+
+// errUsersUsage is returned for wrong user commands.
+var errUsersUsage = errors.New("wrong user command")
+
+// RunUserCommand parses the config, opens the library and runs the command for
+// managing users from args.
+func RunUserCommand(args []string) error {
+	var cfg config.Config
+
+	if err := cfg.FindAndParse(); err != nil {
+		return err
+	}
+
+	userPath := filepath.Dir(cfg.UserConfigPath())
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	lib, err := getLibrary(ctx, userPath, cfg)
+	if err != nil {
+		return err
+	}
+	defer lib.Close()
+
+	err = manageUsers(lib, args, os.Stdin, os.Stdout)
+
+	if err == errUsersUsage {
+		fmt.Fprint(os.Stderr, usersUsage)
+	}
+
+	return err
+}
+
+// manageUsers runs the command from args with the users in lib. Passwords are read
+// from input and messages are written to output.
+func manageUsers(lib library.Library, args []string, input io.Reader,
+	output io.Writer) error {
+
+	if len(args) < 1 {
+		return errUsersUsage
+	}
+
+	if args[0] == "list" {
+		for _, user := range lib.GetUsers() {
+			fmt.Fprintf(output, "%s\t%s\n", user.Name, user.Role)
+		}
+		return nil
+	}
+
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	flags.SetOutput(output)
+	admin := flags.Bool("admin", false, "The new user is an admin.")
+
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+		return errUsersUsage
+	}
+
+	name := flags.Arg(0)
+
+	switch args[0] {
+	case "add":
+		role := library.RoleListener
+		if *admin {
+			role = library.RoleAdmin
+		}
+
+		hash, err := readPassword(input, output)
+		if err != nil {
+			return err
+		}
+
+		if _, err := lib.CreateUser(name, hash, role); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(output, "Created %s %s.\n", role, name)
+	case "del":
+		if err := lib.DeleteUser(name); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(output, "Deleted %s.\n", name)
+	case "passwd":
+		if _, err := lib.GetUser(name); err != nil {
+			return err
+		}
+
+		hash, err := readPassword(input, output)
+		if err != nil {
+			return err
+		}
+
+		if err := lib.SetUserPassword(name, hash); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(output, "Changed the password of %s.\n", name)
+	default:
+		return errUsersUsage
+	}
+
+	return nil
+}
+
+// readPassword reads a password line from input and returns its hash. The password
+// is not echoed when input is a terminal.
+func readPassword(input io.Reader, output io.Writer) (string, error) {
+	fmt.Fprint(output, "Password: ")
+
+	var line string
+
+	if file, ok := input.(*os.File); ok && term.IsTerminal(int(file.Fd())) {
+		password, err := term.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(output)
+
+		if err != nil {
+			return "", err
+		}
+
+		line = string(password)
+	} else {
+		read, err := bufio.NewReader(input).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		line = read
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("the password must not be empty")
+	}
+
+	return auth.HashPassword(password)
+}
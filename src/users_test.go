@@ -0,0 +1,82 @@
+package src
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestManageUsers(t *testing.T) {
+	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatalf("Initializing library: %s", err)
+	}
+
+	defer lib.Truncate()
+
+	// The commands are run in order against the same library.
+	tests := []struct {
+		args   []string
+		input  string
+		output string
+
+		// expectErr is true when the command must fail. With nil err any error
+		// is fine.
+		expectErr bool
+		err       error
+	}{
+		{[]string{}, "", "", true, errUsersUsage},
+		{[]string{"add"}, "", "", true, errUsersUsage},
+		{[]string{"rename", "alice"}, "", "", true, errUsersUsage},
+		{[]string{"add", "alice"}, "alicepass\n", "Created listener alice.", false, nil},
+		{[]string{"add", "-admin", "bob"}, "bobpass", "Created admin bob.", false, nil},
+		{[]string{"add", "alice"}, "other\n", "", true, library.ErrUserExists},
+		{[]string{"add", "carol"}, "\n", "", true, nil},
+		{[]string{"list"}, "", "alice\tlistener\nbob\tadmin\n", false, nil},
+		{[]string{"passwd", "carol"}, "secret\n", "", true, library.ErrUserNotFound},
+		{[]string{"passwd", "alice"}, "changed\n", "Changed the password of alice.",
+			false, nil},
+		{[]string{"del", "carol"}, "", "", true, library.ErrUserNotFound},
+		{[]string{"del", "bob"}, "", "Deleted bob.", false, nil},
+		{[]string{"list"}, "", "alice\tlistener\n", false, nil},
+	}
+
+	for _, test := range tests {
+		var output bytes.Buffer
+		err := manageUsers(lib, test.args, strings.NewReader(test.input), &output)
+
+		if test.expectErr {
+			if err == nil || (test.err != nil && err != test.err) {
+				t.Errorf("Expected error %v for %q but got %v", test.err, test.args,
+					err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", test.args, err)
+			continue
+		}
+
+		if !strings.Contains(output.String(), test.output) {
+			t.Errorf("Expected output %q for %q but it was %q", test.output,
+				test.args, output.String())
+		}
+	}
+
+	authenticator := auth.NewAuthenticator(config.Auth{}, lib)
+
+	if _, ok := authenticator.Authenticate("alice", "changed"); !ok {
+		t.Errorf("Expected the changed password of alice to work")
+	}
+}
@@ -0,0 +1,219 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Registers the GIF format for image.Decode
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// thumbnailJPEGQuality is the quality of the JPEG thumbnails.
+const thumbnailJPEGQuality = 85
+
+// thumbnailCache creates downscaled versions of album covers and stores them on
+// disk so that they are created only once. A thumbnail's file name contains a hash
+// of the original image. That way a changed cover never results in a stale
+// thumbnail.
+type thumbnailCache struct {
+
+	// dir is the directory in which thumbnails are stored. When empty they are
+	// created anew for every request.
+	dir string
+}
+
+// thumbnail returns the cover downscaled so that it fits in a square with this size,
+// in pixels, for side. The aspect ratio is kept. PNG covers result in PNG thumbnails
+// while all others are converted to JPEG. Covers which are small enough already are
+// returned as they are.
+func (tc thumbnailCache) thumbnail(
+	albumID int64,
+	cover *library.Cover,
+	size int,
+) (*library.Cover, error) {
+	sourceHash := fmt.Sprintf("%x", sha1.Sum(cover.Data))
+
+	if cached := tc.cached(albumID, size, sourceHash); cached != nil {
+		cached.ModTime = cover.ModTime
+		return cached, nil
+	}
+
+	original, format, err := image.Decode(bytes.NewReader(cover.Data))
+
+	if err != nil {
+		return nil, fmt.Errorf("decoding cover of album %d: %s", albumID, err)
+	}
+
+	bounds := original.Bounds()
+
+	if bounds.Dx() <= size && bounds.Dy() <= size {
+		return cover, nil
+	}
+
+	resized := resizeImage(original, size)
+
+	var (
+		buf      bytes.Buffer
+		mimeType = "image/jpeg"
+	)
+
+	if format == "png" {
+		mimeType = "image/png"
+		err = png.Encode(&buf, resized)
+	} else {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := &library.Cover{
+		Data:     buf.Bytes(),
+		MIMEType: mimeType,
+		ModTime:  cover.ModTime,
+	}
+
+	if err := tc.store(albumID, size, sourceHash, thumb); err != nil {
+		log.Printf("Error storing thumbnail for album %d: %s\n", albumID, err)
+	}
+
+	return thumb, nil
+}
+
+// cached returns the stored thumbnail for this album, size and original image.
+// Returns nil when there is no such thumbnail.
+func (tc thumbnailCache) cached(albumID int64, size int, sourceHash string) *library.Cover {
+	if tc.dir == "" {
+		return nil
+	}
+
+	for mimeType, ext := range map[string]string{"image/jpeg": ".jpg", "image/png": ".png"} {
+		data, err := ioutil.ReadFile(tc.path(albumID, size, sourceHash, ext))
+
+		if err == nil {
+			return &library.Cover{Data: data, MIMEType: mimeType}
+		}
+	}
+
+	return nil
+}
+
+// store saves the thumbnail to the cache directory and removes all thumbnails of
+// older versions of the album cover in this size. The file is written under a
+// temporary name first so that concurrent requests never see it half-written.
+func (tc thumbnailCache) store(
+	albumID int64,
+	size int,
+	sourceHash string,
+	thumb *library.Cover,
+) error {
+	if tc.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(tc.dir, 0750); err != nil {
+		return err
+	}
+
+	stale, _ := filepath.Glob(tc.path(albumID, size, "*", ".*"))
+	for _, stalePath := range stale {
+		os.Remove(stalePath)
+	}
+
+	ext := ".jpg"
+	if thumb.MIMEType == "image/png" {
+		ext = ".png"
+	}
+
+	tmpFile, err := ioutil.TempFile(tc.dir, "thumbnail_")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(thumb.Data)
+
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), tc.path(albumID, size, sourceHash, ext))
+}
+
+// path returns the file path of a thumbnail in the cache directory.
+func (tc thumbnailCache) path(albumID int64, size int, sourceHash, ext string) string {
+	return filepath.Join(tc.dir, fmt.Sprintf("%d-%d-%s%s", albumID, size, sourceHash,
+		ext))
+}
+
+// resizeImage downscales the image so that it fits in a square with sides of size
+// pixels while keeping its aspect ratio. Every pixel of the result is the average of
+// the pixels of the original which it covers.
+func resizeImage(original image.Image, size int) *image.RGBA {
+	bounds := original.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := size, size
+	if srcWidth > srcHeight {
+		dstHeight = maxInt(1, srcHeight*size/srcWidth)
+	} else {
+		dstWidth = maxInt(1, srcWidth*size/srcHeight)
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, srcWidth, srcHeight))
+	draw.Draw(src, src.Bounds(), original, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for dy := 0; dy < dstHeight; dy++ {
+		y0 := dy * srcHeight / dstHeight
+		y1 := maxInt(y0+1, (dy+1)*srcHeight/dstHeight)
+
+		for dx := 0; dx < dstWidth; dx++ {
+			x0 := dx * srcWidth / dstWidth
+			x1 := maxInt(x0+1, (dx+1)*srcWidth/dstWidth)
+
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := src.Pix[y*src.Stride+x0*4 : y*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			count := (x1 - x0) * (y1 - y0)
+			offset := dst.PixOffset(dx, dy)
+			for i := range sum {
+				dst.Pix[offset+i] = uint8(sum[i] / count)
+			}
+		}
+	}
+
+	return dst
+}
+
+// maxInt returns the bigger of two integers.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+package webserver
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestResizingImages(t *testing.T) {
+	// The left half is red and the right half is blue.
+	original := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x < 200 {
+				original.Set(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				original.Set(x, y, color.NRGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	resized := resizeImage(original, 100)
+	bounds := resized.Bounds()
+
+	if bounds.Dx() != 100 || bounds.Dy() != 50 {
+		t.Fatalf("Expected 100x50 image but it was %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	expected := map[image.Point]color.RGBA{
+		{0, 0}:   {255, 0, 0, 255},
+		{49, 49}: {255, 0, 0, 255},
+		{50, 0}:  {0, 0, 255, 255},
+		{99, 49}: {0, 0, 255, 255},
+	}
+
+	for point, expectedColor := range expected {
+		if found := resized.RGBAAt(point.X, point.Y); found != expectedColor {
+			t.Errorf("Expected color %v at %v but it was %v", expectedColor, point,
+				found)
+		}
+	}
+
+	// Every pixel of the result covers one red and one blue pixel of the original.
+	stripes := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			stripes.Set(x, y, color.NRGBA{uint8(255 * (x % 2)), 0, 0, 255})
+		}
+	}
+
+	if found := resizeImage(stripes, 2).RGBAAt(1, 1); found.R != 127 {
+		t.Errorf("Expected averaged red of 127 but it was %d", found.R)
+	}
+
+	tall := resizeImage(image.NewGray(image.Rect(0, 0, 10, 3000)), 100)
+
+	if tall.Bounds().Dx() != 1 || tall.Bounds().Dy() != 100 {
+		t.Errorf("Expected 1x100 image but it was %v", tall.Bounds())
+	}
+}
+
+func TestThumbnailCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "httpms_thumbnails_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(cacheDir)
+
+	cache := thumbnailCache{dir: filepath.Join(cacheDir, "thumbnails")}
+
+	encode := func(img image.Image, format string) *library.Cover {
+		var buf bytes.Buffer
+		cover := &library.Cover{ModTime: time.Now()}
+
+		if format == "png" {
+			png.Encode(&buf, img)
+			cover.MIMEType = "image/png"
+		} else {
+			jpeg.Encode(&buf, img, nil)
+			cover.MIMEType = "image/jpeg"
+		}
+
+		cover.Data = buf.Bytes()
+		return cover
+	}
+
+	decode := func(thumb *library.Cover) image.Image {
+		img, _, err := image.Decode(bytes.NewReader(thumb.Data))
+
+		if err != nil {
+			t.Fatalf("Decoding thumbnail: %s", err)
+		}
+
+		return img
+	}
+
+	jpegCover := encode(image.NewRGBA(image.Rect(0, 0, 300, 300)), "jpeg")
+	thumb, err := cache.thumbnail(1, jpegCover, 100)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if thumb.MIMEType != "image/jpeg" || decode(thumb).Bounds().Dx() != 100 {
+		t.Errorf("Wrong JPEG thumbnail. Type: %s", thumb.MIMEType)
+	}
+
+	if !thumb.ModTime.Equal(jpegCover.ModTime) {
+		t.Errorf("Thumbnail modification time was not the one of the cover")
+	}
+
+	stored, _ := filepath.Glob(filepath.Join(cache.dir, "1-100-*.jpg"))
+
+	if len(stored) != 1 {
+		t.Fatalf("Expected one stored thumbnail but there were %d", len(stored))
+	}
+
+	cached, err := cache.thumbnail(1, jpegCover, 100)
+
+	if err != nil || !bytes.Equal(cached.Data, thumb.Data) {
+		t.Errorf("Cached thumbnail was different. Error: %v", err)
+	}
+
+	// The cover has been changed to a PNG image.
+	pngCover := encode(image.NewRGBA(image.Rect(0, 0, 200, 400)), "png")
+	thumb, err = cache.thumbnail(1, pngCover, 100)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if thumb.MIMEType != "image/png" || decode(thumb).Bounds().Dy() != 100 {
+		t.Errorf("Wrong PNG thumbnail. Type: %s", thumb.MIMEType)
+	}
+
+	if _, err := os.Stat(stored[0]); !os.IsNotExist(err) {
+		t.Errorf("Thumbnail of the old cover was not removed")
+	}
+
+	stored, _ = filepath.Glob(filepath.Join(cache.dir, "1-100-*.png"))
+
+	if len(stored) != 1 {
+		t.Errorf("Expected one stored PNG thumbnail but there were %d", len(stored))
+	}
+
+	small := encode(image.NewRGBA(image.Rect(0, 0, 50, 50)), "png")
+
+	if thumb, _ := cache.thumbnail(2, small, 100); thumb != small {
+		t.Errorf("Expected small cover to be returned as it is")
+	}
+
+	if _, err := cache.thumbnail(3, &library.Cover{Data: []byte("junk")}, 100); err == nil {
+		t.Errorf("Expected an error for a cover which is not an image")
+	}
+}
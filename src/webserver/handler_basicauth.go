@@ -1,30 +1,81 @@
 package webserver
 
 import (
-	"encoding/base64"
+	"context"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
 )
 
 // BasicAuthHandler is a handler wrapper used for basic authenticate. Its only job is
-// to do the authentication and then pass the work to the Handler it wraps around
+// to do the authentication and then pass the work to the Handler it wraps around.
+// The authenticated user could be found in the wrapped handler with requestUser.
+// Users who have logged in with the LoginHandler are authenticated by their session
+// cookie instead. Headless clients could send an API token in an
+// "Authorization: Bearer" header. Read-only tokens are allowed only for GET and
+// HEAD requests. Clients with too many wrong credentials are blocked by the throttle
+// for a while.
 type BasicAuthHandler struct {
-	wrapped  http.Handler // The actual handler that does the APP Logic job
-	username string       // Username to be used for basic authenticate
-	password string       // Password to be used for basic authenticate
+	wrapped       http.Handler // The actual handler that does the APP Logic job
+	authenticator *auth.Authenticator
+	sessions      *auth.Sessions
+	throttle      *auth.Throttle
 }
 
 // ServeHTTP implements the http.Handler interface and does the actual basic authenticate
 // check for every request
 func (hl BasicAuthHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
-	auth, err := req.Header["Authorization"]
+	if user, ok := hl.sessionUser(req); ok {
+		hl.wrapped.ServeHTTP(writer, withUser(req, user))
+		return
+	}
+
+	if req.Header.Get("Authorization") == "" {
+		if wantsPage(req) {
+			loginURL := "/login?return=" + url.QueryEscape(req.RequestURI)
+			http.Redirect(writer, req, loginURL, http.StatusSeeOther)
+			return
+		}
+
+		InternalErrorOnErrorHandler(writer, req, hl.challengeAuthentication)
+		return
+	}
+
+	ip := hl.throttle.ClientIP(req)
+	username, _, _ := req.BasicAuth()
 
-	if !err || len(auth) < 1 || !hl.authenticate(auth[0]) {
+	if retryAfter, blocked := hl.throttle.Blocked(ip, username); blocked {
+		tooManyFailures(writer, retryAfter)
+		return
+	}
+
+	user, token, ok := hl.authenticate(req)
+
+	if !ok {
+		hl.throttle.Failed(ip, username)
 		InternalErrorOnErrorHandler(writer, req, hl.challengeAuthentication)
 		return
 	}
 
-	hl.wrapped.ServeHTTP(writer, req)
+	hl.throttle.Succeeded(ip, username)
+
+	if token != nil && token.ReadOnly &&
+		req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(writer, "This API token is read-only", http.StatusForbidden)
+		return
+	}
+
+	if token != nil {
+		req = withAPIToken(req, token)
+	}
+
+	hl.wrapped.ServeHTTP(writer, withUser(req, user))
 }
 
 // Sends 401 and authentication challenge in the writer
@@ -37,6 +88,7 @@ func (hl BasicAuthHandler) challengeAuthentication(writer http.ResponseWriter,
 	}
 
 	writer.Header().Set("WWW-Authenticate", `Basic realm="HTTPMS"`)
+	writer.Header().Add("WWW-Authenticate", `Bearer realm="HTTPMS"`)
 	writer.WriteHeader(http.StatusUnauthorized)
 
 	err = tmpl.Execute(writer, nil)
@@ -44,27 +96,130 @@ func (hl BasicAuthHandler) challengeAuthentication(writer http.ResponseWriter,
 	return err
 }
 
-// Compares the authentication header with the stored user and passwords
-// and returns true if they pass.
-func (hl BasicAuthHandler) authenticate(auth string) bool {
+// sessionUser returns the user of the session in the session cookie. Returns false
+// when there is no cookie or the session has ended.
+func (hl BasicAuthHandler) sessionUser(req *http.Request) (*library.User, bool) {
+	cookie, err := req.Cookie(sessionCookie)
+
+	if err != nil || hl.sessions == nil {
+		return nil, false
+	}
 
-	s := strings.SplitN(auth, " ", 2)
+	user, ok := hl.sessions.Get(cookie.Value)
 
-	if len(s) != 2 || s[0] != "Basic" {
-		return false
+	if !ok {
+		return nil, false
 	}
 
-	b, err := base64.StdEncoding.DecodeString(s[1])
+	return hl.authenticator.Revalidate(user)
+}
 
-	if err != nil {
-		return false
+// Checks the credentials from the authentication header and returns the user with
+// them. The API token is returned too when one was used. Returns false when they
+// are missing or wrong.
+func (hl BasicAuthHandler) authenticate(req *http.Request) (
+	*library.User,
+	*library.APIToken,
+	bool,
+) {
+	if token, ok := bearerToken(req); ok {
+		return hl.authenticator.AuthenticateToken(token)
 	}
 
-	pair := strings.SplitN(string(b), ":", 2)
+	username, password, ok := req.BasicAuth()
 
-	if len(pair) != 2 {
-		return false
+	if !ok {
+		return nil, nil, false
 	}
 
-	return pair[0] == hl.username && pair[1] == hl.password
+	user, ok := hl.authenticator.Authenticate(username, password)
+	return user, nil, ok
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(req *http.Request) (string, bool) {
+	const prefix = "bearer "
+	header := req.Header.Get("Authorization")
+
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// wantsPage returns true for requests from browsers which navigate to a page.
+// Instead of an authentication challenge they are redirected to the login form. API
+// clients and requests from scripts do not accept HTML so they are challenged.
+func wantsPage(req *http.Request) bool {
+	return req.Method == http.MethodGet &&
+		req.Header.Get("Authorization") == "" &&
+		strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// tooManyFailures responds to clients which are blocked by the throttle. They could
+// try again after retryAfter.
+func tooManyFailures(writer http.ResponseWriter, retryAfter time.Duration) {
+	writer.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+	http.Error(writer, "Too many failed authentication attempts. Try again later.",
+		http.StatusTooManyRequests)
+}
+
+// retryAfterSeconds returns the value of the Retry-After header for this duration.
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10)
+}
+
+// AdminHandler is a handler wrapper which allows only admins to use the wrapped
+// handler. It is meant to be wrapped in a BasicAuthHandler. Everyone is allowed when
+// there is no authenticated user since then authentication is disabled.
+type AdminHandler struct {
+	wrapped http.Handler
+}
+
+// ServeHTTP implements the http.Handler interface
+func (ah AdminHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if user := requestUser(req); user != nil && !user.IsAdmin() {
+		http.Error(writer, "Only admins are allowed to do this", http.StatusForbidden)
+		return
+	}
+
+	ah.wrapped.ServeHTTP(writer, req)
+}
+
+// contextKey is the type of the keys for values which handler wrappers put in the
+// request context.
+type contextKey int
+
+// Keys for the values in the request context.
+const (
+	// userContextKey is the key for the authenticated user.
+	userContextKey contextKey = iota
+
+	// apiTokenContextKey is the key for the API token used for authentication.
+	apiTokenContextKey
+)
+
+// withUser returns the request with user in its context.
+func withUser(req *http.Request, user *library.User) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userContextKey, user))
+}
+
+// withAPIToken returns the request with the API token in its context.
+func withAPIToken(req *http.Request, token *library.APIToken) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), apiTokenContextKey, token))
+}
+
+// requestAPIToken returns the API token with which the request was authenticated. It
+// is nil for requests authenticated in other ways.
+func requestAPIToken(req *http.Request) *library.APIToken {
+	token, _ := req.Context().Value(apiTokenContextKey).(*library.APIToken)
+	return token
+}
+
+// requestUser returns the authenticated user who made the request. It is nil when
+// authentication is disabled.
+func requestUser(req *http.Request) *library.User {
+	user, _ := req.Context().Value(userContextKey).(*library.User)
+	return user
 }
@@ -12,6 +12,10 @@ import (
 	"github.com/ironsmile/httpms/src/library"
 )
 
+// maxPerPage is the biggest number of items which could be returned in one page by
+// the paginated API calls. Bigger "per-page" values are lowered to it.
+const maxPerPage = 500
+
 // BrowseHandler is a http.Handler which will allow you to browse through artists or
 // albums with the help of pagination.
 type BrowseHandler struct {
@@ -79,6 +83,10 @@ func (bh BrowseHandler) browse(writer http.ResponseWriter, req *http.Request) er
 		return nil
 	}
 
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
 	if browseBy == "artist" {
 		return bh.browseArtists(writer, page, perPage, orderBy, order)
 	}
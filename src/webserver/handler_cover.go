@@ -0,0 +1,111 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// coverMaxAge is the number of seconds for which clients may cache album covers
+// without asking the server again.
+const coverMaxAge = 7 * 24 * 60 * 60
+
+// maxThumbnailSize is the biggest size in pixels which could be requested for a
+// cover thumbnail.
+const maxThumbnailSize = 1024
+
+// CoverHandler is a http.Handler which serves the cover image of an album by the
+// album ID.
+type CoverHandler struct {
+	library    library.Library
+	thumbnails thumbnailCache
+
+	// private is true when the covers are served only to authenticated users. Then
+	// shared caches such as proxies must not store them.
+	private bool
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (ch CoverHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, ch.find)
+}
+
+// Finds the album's cover in the library and serves it. Returns 404 when there is no
+// such album or it does not have a cover. Conditional requests are supported with
+// both the Last-Modified and ETag headers. With the "size" parameter a thumbnail of
+// the cover is served which fits in a square with sides of this many pixels.
+func (ch CoverHandler) find(writer http.ResponseWriter, req *http.Request) error {
+
+	id, err := strconv.ParseInt(req.URL.Path, 10, 64)
+
+	if err != nil {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	var size int
+
+	if sizeStr := req.URL.Query().Get("size"); sizeStr != "" {
+		size, err = strconv.Atoi(sizeStr)
+
+		if err != nil || size < 1 || size > maxThumbnailSize {
+			http.Error(writer, fmt.Sprintf(
+				`"size" must be an integer between 1 and %d`, maxThumbnailSize,
+			), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	cover, err := ch.library.GetAlbumCover(id)
+
+	if err == library.ErrCoverNotFound {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if size > 0 {
+		cover, err = ch.thumbnails.thumbnail(id, cover, size)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if cover.MIMEType != "" {
+		writer.Header().Set("Content-Type", cover.MIMEType)
+	}
+
+	cacheScope := "public"
+	if ch.private {
+		cacheScope = "private"
+	}
+
+	writer.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheScope,
+		coverMaxAge))
+	writer.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha1.Sum(cover.Data)))
+
+	http.ServeContent(writer, req, "", cover.ModTime, bytes.NewReader(cover.Data))
+
+	return nil
+}
+
+// NewCoverHandler returns a new Cover handler. It needs a library in which to find
+// the albums' covers. Thumbnails are stored in thumbnailsDir. When it is empty they
+// are not stored at all. When private is true the covers are allowed to be cached
+// only by the clients since they require authentication.
+func NewCoverHandler(lib library.Library, thumbnailsDir string,
+	private bool) *CoverHandler {
+	ch := new(CoverHandler)
+	ch.library = lib
+	ch.thumbnails = thumbnailCache{dir: thumbnailsDir}
+	ch.private = private
+	return ch
+}
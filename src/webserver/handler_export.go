@@ -0,0 +1,179 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+	"github.com/ironsmile/httpms/src/playlists"
+)
+
+// ExportHandler is a http.Handler which returns albums, search results and playlists
+// as playlist files. Its paths are relative to its mount point:
+//
+//	/album/{albumID}.{format}
+//	/playlist/{playlistID}.{format}
+//	/search.{format}?q={query}
+//
+// The format is one of "m3u8", "pls" or "xspf". Playlist files contain absolute URLs
+// to the /file/ endpoint so that external players could stream the tracks. When
+// there is a signer the URLs are signed so that they work without authentication.
+type ExportHandler struct {
+	library library.Library
+	signer  *auth.URLSigner
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (eh ExportHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, eh.export)
+}
+
+// export finds the requested tracks and writes them in the requested format.
+func (eh ExportHandler) export(writer http.ResponseWriter, req *http.Request) error {
+	filePath := strings.Trim(req.URL.Path, "/")
+	extension := path.Ext(filePath)
+	format, ok := playlists.FormatByExtension(extension)
+
+	if !ok || extension == ".m3u" {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	var (
+		title  string
+		tracks []library.SearchResult
+		err    error
+	)
+
+	kind, idStr := path.Split(strings.TrimSuffix(filePath, extension))
+
+	switch kind {
+	case "":
+		if idStr != "search" {
+			http.NotFoundHandler().ServeHTTP(writer, req)
+			return nil
+		}
+
+		title = req.URL.Query().Get("q")
+
+		if _, err := library.ParseSearchQuery(title); err != nil {
+			http.Error(writer, fmt.Sprintf("Malformed search query: %s", err),
+				http.StatusBadRequest)
+			return nil
+		}
+
+		tracks, _ = eh.library.Search(library.SearchArgs{Query: title})
+	case "album/", "playlist/":
+		id, parseErr := strconv.ParseInt(idStr, 10, 64)
+
+		if parseErr != nil {
+			http.NotFoundHandler().ServeHTTP(writer, req)
+			return nil
+		}
+
+		title, tracks, err = eh.findTracks(kind, id)
+	default:
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	if err == library.ErrAlbumNotFound || err == library.ErrPlaylistNotFound {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	playlist := &playlists.Playlist{Title: title}
+
+	for _, track := range tracks {
+		playlist.Entries = append(playlist.Entries, playlists.Entry{
+			Location: eh.fileURL(req, track.ID),
+			Title:    track.Title,
+			Artist:   track.Artist,
+			Album:    track.Album,
+			Duration: time.Duration(track.Duration) * time.Millisecond,
+		})
+	}
+
+	fileName := title
+	if fileName == "" {
+		fileName = "playlist"
+	}
+
+	writer.Header().Set("Content-Type", format.ContentType())
+	writer.Header().Set("Content-Disposition",
+		fmt.Sprintf(`filename="%s%s"`, strings.Replace(fileName, `"`, "'", -1), extension))
+
+	return playlists.Write(writer, format, playlist)
+}
+
+// findTracks returns the name and the tracks of an album or a playlist, depending on
+// kind.
+func (eh ExportHandler) findTracks(kind string, id int64) (
+	string, []library.SearchResult, error) {
+
+	if kind == "album/" {
+		tracks := eh.library.GetAlbumFiles(id)
+
+		if len(tracks) < 1 {
+			return "", nil, library.ErrAlbumNotFound
+		}
+
+		return tracks[0].Album, tracks, nil
+	}
+
+	playlist, err := eh.library.GetPlaylist(id)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	tracks, err := eh.library.GetPlaylistTracks(id)
+
+	return playlist.Name, tracks, err
+}
+
+// fileURL returns the absolute URL from which a track is played.
+func (eh ExportHandler) fileURL(req *http.Request, trackID int64) string {
+	path := filePath(trackID)
+
+	if eh.signer != nil {
+		path = eh.signer.Sign(path, time.Now().Add(auth.DefaultSignedURLLifetime))
+	}
+
+	return absoluteURL(req, path)
+}
+
+// filePath returns the path from which a track is played.
+func filePath(trackID int64) string {
+	return fmt.Sprintf("/file/%d", trackID)
+}
+
+// absoluteURL returns the URL with this path. Its host is the one used by the client
+// for reaching the server.
+func absoluteURL(req *http.Request, path string) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, req.Host, path)
+}
+
+// NewExportHandler returns a new Export handler. It needs a library from which the
+// exported tracks are taken. The URLs in the playlist files are signed with signer
+// unless it is nil.
+func NewExportHandler(lib library.Library, signer *auth.URLSigner) *ExportHandler {
+	eh := new(ExportHandler)
+	eh.library = lib
+	eh.signer = signer
+	return eh
+}
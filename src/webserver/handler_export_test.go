@@ -0,0 +1,111 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/library"
+	"github.com/ironsmile/httpms/src/playlists"
+)
+
+func TestExportHandler(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Album Of Tests"})
+
+	if len(found) != 2 {
+		t.Fatalf("Expected 2 tracks of Album Of Tests but found %d", len(found))
+	}
+
+	playlist, err := lib.CreatePlaylist("Road Trip", 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.AddPlaylistTracks(playlist.ID, []int64{found[1].ID}, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(uri string, expectedStatus int) *playlists.Playlist {
+		url := fmt.Sprintf("http://127.0.0.1:%d/export/%s", TestPort, uri)
+		resp, err := http.Get(url)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected status %d for %s but got %d", expectedStatus, uri,
+				resp.StatusCode)
+		}
+
+		if expectedStatus != http.StatusOK {
+			return nil
+		}
+
+		format, _ := playlists.FormatByExtension(path.Ext(strings.Split(uri, "?")[0]))
+
+		if contentType := resp.Header.Get("Content-Type"); contentType != format.ContentType() {
+			t.Errorf("Expected Content-Type %s for %s but got %s", format.ContentType(),
+				uri, contentType)
+		}
+
+		exported, err := playlists.Read(resp.Body, format)
+
+		if err != nil {
+			t.Fatalf("Error reading exported %s: %s", uri, err)
+		}
+
+		return exported
+	}
+
+	for _, format := range playlists.Formats {
+		exported := export(fmt.Sprintf("album/%d.%s", found[0].AlbumID, format),
+			http.StatusOK)
+
+		if len(exported.Entries) != 2 {
+			t.Fatalf("Expected 2 album entries in %s but got %+v", format,
+				exported.Entries)
+		}
+
+		for _, entry := range exported.Entries {
+			if !strings.HasPrefix(entry.Location, fmt.Sprintf("http://127.0.0.1:%d/file/",
+				TestPort)) {
+				t.Errorf("Expected absolute file URL in %s but got %s", format,
+					entry.Location)
+			}
+		}
+
+		exported = export(fmt.Sprintf("playlist/%d.%s", playlist.ID, format),
+			http.StatusOK)
+
+		expectedURL := fmt.Sprintf("http://127.0.0.1:%d/file/%d", TestPort, found[1].ID)
+		if len(exported.Entries) != 1 || exported.Entries[0].Location != expectedURL ||
+			exported.Entries[0].Title != found[1].Title {
+			t.Errorf("Expected only %s in exported playlist but got %+v", expectedURL,
+				exported.Entries)
+		}
+
+		exported = export(fmt.Sprintf("search.%s?q=Payback", format), http.StatusOK)
+
+		if len(exported.Entries) != 1 || exported.Entries[0].Title != "Payback" {
+			t.Errorf("Expected only Payback in %s search but got %+v", format,
+				exported.Entries)
+		}
+	}
+
+	export("album/666.m3u8", http.StatusNotFound)
+	export("playlist/666.pls", http.StatusNotFound)
+	export(fmt.Sprintf("album/%d.mp3", found[0].AlbumID), http.StatusNotFound)
+	export(fmt.Sprintf("album/%d.m3u", found[0].AlbumID), http.StatusNotFound)
+	export("artist/1.xspf", http.StatusNotFound)
+	export("search.xspf?q=year:199x", http.StatusBadRequest)
+}
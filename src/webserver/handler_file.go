@@ -1,18 +1,41 @@
 package webserver
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 
+	"github.com/ironsmile/httpms/src/config"
 	"github.com/ironsmile/httpms/src/library"
 )
 
+// maxTranscodingBitrate is the biggest bitrate in kbps which could be requested for
+// transcoding.
+const maxTranscodingBitrate = 1024
+
+// transcodingFirstChunk is the size of the buffer for the first output of the
+// encoder. It is read before sending the response status.
+const transcodingFirstChunk = 32 * 1024
+
 // FileHandler will find and serve a media file by its ID
 type FileHandler struct {
-	library library.Library
+	library     library.Library
+	transcoding config.Transcoding
+}
+
+// transcodingArgs are the arguments for converting a file with a transcoding profile.
+type transcodingArgs struct {
+	format  string
+	profile config.TranscodingProfile
+	bitrate int // in kbps
 }
 
 // ServeHTTP is required by the http.Handler's interface
@@ -22,7 +45,9 @@ func (fh FileHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
 
 // Actually searches through the library for this file and serves it
 // if it is found. Returns 404 if not (duh)
-// Uses http.FileServer for serving the found files
+// Uses http.FileServer for serving the found files. When a format or bitrate is
+// requested and there is a matching transcoding profile the file is converted on
+// the fly instead.
 func (fh FileHandler) find(writer http.ResponseWriter, req *http.Request) error {
 
 	id, err := strconv.Atoi(req.URL.Path)
@@ -45,6 +70,23 @@ func (fh FileHandler) find(writer http.ResponseWriter, req *http.Request) error
 		return nil
 	}
 
+	if isPlayStart(req) {
+		if err := fh.library.RecordPlay(int64(id)); err != nil {
+			log.Printf("Error recording play of track %d: %s\n", id, err)
+		}
+	}
+
+	args, transcode, err := fh.getTranscodingArgs(req.URL.Query(), filePath)
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	if transcode && fh.transcode(writer, req, filePath, args) {
+		return nil
+	}
+
 	baseName := filepath.Base(filePath)
 
 	writer.Header().Add("Content-Disposition",
@@ -56,10 +98,156 @@ func (fh FileHandler) find(writer http.ResponseWriter, req *http.Request) error
 	return nil
 }
 
+// isPlayStart returns true for requests which start playing a file from its
+// beginning. Players request the rest of the file with other range requests which
+// must not be counted as plays.
+func isPlayStart(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+
+	rangeHeader := strings.TrimSpace(req.Header.Get("Range"))
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// getTranscodingArgs finds out how the file should be transcoded from the "format"
+// and "bitrate" query parameters. transcode is false when the file should be served
+// as it is. This is the case when there is no transcoding profile for the requested
+// format or when the file is already in this format and no bitrate was requested.
+// Without a format the file is converted to its own format with the requested
+// bitrate.
+func (fh FileHandler) getTranscodingArgs(query url.Values, filePath string) (
+	args transcodingArgs,
+	transcode bool,
+	err error,
+) {
+	fileFormat := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+	args.format = strings.ToLower(query.Get("format"))
+
+	args.bitrate, err = parseBitrate(query)
+
+	if err != nil {
+		return args, false, err
+	}
+
+	if args.format == "" && args.bitrate == 0 {
+		return args, false, nil
+	}
+
+	if args.format == "" {
+		args.format = fileFormat
+	}
+
+	profile, ok := fh.transcoding[args.format]
+
+	if !ok || (args.format == fileFormat && args.bitrate == 0) {
+		return args, false, nil
+	}
+
+	args.profile = profile
+
+	if args.bitrate == 0 {
+		args.bitrate = profile.DefaultBitrate
+	}
+
+	return args, true, nil
+}
+
+// parseBitrate returns the bitrate in kbps from the "bitrate" query parameter. It is
+// zero when there is no such parameter.
+func parseBitrate(query url.Values) (int, error) {
+	bitrateStr := query.Get("bitrate")
+
+	if bitrateStr == "" {
+		return 0, nil
+	}
+
+	bitrate, err := strconv.Atoi(bitrateStr)
+
+	if err != nil || bitrate < 1 || bitrate > maxTranscodingBitrate {
+		return 0, fmt.Errorf(`"bitrate" must be an integer between 1 and %d`,
+			maxTranscodingBitrate)
+	}
+
+	return bitrate, nil
+}
+
+// transcode streams the file converted by the encoder from the transcoding profile.
+// The size of the result is not known in advance so it is sent with chunked
+// encoding. The response status is sent only after the encoder has produced its
+// first output so that encoders which fail right away result in an error response.
+// Returns false when the encoder could not be found. In this case nothing has been
+// written and the original file should be served instead.
+func (fh FileHandler) transcode(
+	writer http.ResponseWriter,
+	req *http.Request,
+	filePath string,
+	args transcodingArgs,
+) bool {
+	tr, err := startTranscoder(req.Context(), args.profile, transcodingJob{
+		filePath: filePath,
+		bitrate:  args.bitrate,
+	})
+
+	if err != nil {
+		if execErr, ok := err.(*exec.Error); ok && execErr.Err == exec.ErrNotFound {
+			log.Printf("Transcoding to %s is not possible: %s\n", args.format, err)
+			return false
+		}
+
+		log.Printf("Error starting transcoder for %s: %s\n", filePath, err)
+		http.Error(writer, "Transcoding failed", http.StatusInternalServerError)
+		return true
+	}
+
+	first := make([]byte, transcodingFirstChunk)
+	n, err := io.ReadAtLeast(tr, first, 1)
+
+	if err != nil {
+		if waitErr := tr.Wait(); waitErr != nil {
+			err = waitErr
+		}
+
+		if req.Context().Err() == nil {
+			log.Printf("Transcoding %s to %s failed: %s\n", filePath, args.format, err)
+			http.Error(writer, "Transcoding failed", http.StatusInternalServerError)
+		}
+
+		return true
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+
+	writer.Header().Set("Content-Type", args.profile.ContentType)
+	writer.Header().Set("Content-Disposition",
+		fmt.Sprintf("filename=\"%s.%s\"", baseName, args.format))
+	writer.Header().Set("Accept-Ranges", "none")
+	writer.WriteHeader(http.StatusOK)
+
+	output := io.MultiReader(bytes.NewReader(first[:n]), tr)
+
+	// The request context is not canceled when the client goes away so the encoder
+	// has to be stopped here.
+	if _, err := io.Copy(writer, output); err != nil {
+		log.Printf("Error sending transcoded %s: %s\n", filePath, err)
+		tr.Kill()
+		_ = tr.Wait()
+		return true
+	}
+
+	if err := tr.Wait(); err != nil && req.Context().Err() == nil {
+		log.Printf("Transcoding %s to %s failed: %s\n", filePath, args.format, err)
+	}
+
+	return true
+}
+
 // NewFileHandler returns a new File handler will will be resposible for serving a file
-// from the library identified from its ID.
-func NewFileHandler(lib library.Library) *FileHandler {
+// from the library identified from its ID. Files could be transcoded with the
+// profiles in transcoding.
+func NewFileHandler(lib library.Library, transcoding config.Transcoding) *FileHandler {
 	fh := new(FileHandler)
 	fh.library = lib
+	fh.transcoding = transcoding
 	return fh
 }
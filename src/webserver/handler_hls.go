@@ -0,0 +1,262 @@
+package webserver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// hlsPlaylistName is the name of the playlist file of every track.
+const hlsPlaylistName = "index.m3u8"
+
+// HLSHandler is a http.Handler which streams library tracks with HTTP Live
+// Streaming. For every track there is a playlist at {trackID}/index.m3u8 which
+// lists its segments at {trackID}/segment-{index}.ts. The segments are cut by the
+// encoder from the configured transcoding profile.
+type HLSHandler struct {
+	library         library.Library
+	profile         config.TranscodingProfile
+	enabled         bool
+	segmentDuration time.Duration
+	segments        hlsSegmentCache
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (hh HLSHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, hh.find)
+}
+
+// Finds the track and serves its playlist or one of its segments. Returns 404 when
+// HLS is disabled, there is no such track or its duration is not known. The
+// optional "bitrate" parameter of the playlist is passed to all of its segments.
+func (hh HLSHandler) find(writer http.ResponseWriter, req *http.Request) error {
+	if !hh.enabled {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	parts := strings.Split(req.URL.Path, "/")
+
+	if len(parts) != 2 {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	bitrate, err := parseBitrate(req.URL.Query())
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	if bitrate == 0 {
+		bitrate = hh.profile.DefaultBitrate
+	}
+
+	track, err := hh.library.GetTrack(id)
+
+	if err == library.ErrTrackNotFound || (err == nil && track.Duration <= 0) {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	segments := hh.segmentDurations(time.Duration(track.Duration) * time.Millisecond)
+
+	if parts[1] == hlsPlaylistName {
+		hh.servePlaylist(writer, req, segments)
+		return nil
+	}
+
+	index, ok := parseSegmentName(parts[1])
+
+	if !ok || index >= len(segments) {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	return hh.serveSegment(writer, req, id, bitrate, index, segments[index])
+}
+
+// segmentDurations returns the durations of the segments of a track with this
+// length. All of them are as long as the configured segment duration except for
+// the last one which could be shorter.
+func (hh HLSHandler) segmentDurations(trackDuration time.Duration) []time.Duration {
+	var durations []time.Duration
+
+	for start := time.Duration(0); start < trackDuration; start += hh.segmentDuration {
+		duration := hh.segmentDuration
+		if start+duration > trackDuration {
+			duration = trackDuration - start
+		}
+		durations = append(durations, duration)
+	}
+
+	return durations
+}
+
+// servePlaylist writes the playlist of a track with segments with these durations.
+func (hh HLSHandler) servePlaylist(
+	writer http.ResponseWriter,
+	req *http.Request,
+	segments []time.Duration,
+) {
+	var query string
+	if bitrate := req.URL.Query().Get("bitrate"); bitrate != "" {
+		query = "?bitrate=" + bitrate
+	}
+
+	targetDuration := (hh.segmentDuration + time.Second - 1) / time.Second
+
+	var playlist bytes.Buffer
+	fmt.Fprintf(&playlist, "#EXTM3U\n")
+	fmt.Fprintf(&playlist, "#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&playlist, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(&playlist, "#EXT-X-MEDIA-SEQUENCE:0\n")
+
+	for index, duration := range segments {
+		fmt.Fprintf(&playlist, "#EXTINF:%s,\n", formatSeconds(duration))
+		fmt.Fprintf(&playlist, "segment-%d.ts%s\n", index, query)
+	}
+
+	fmt.Fprintf(&playlist, "#EXT-X-ENDLIST\n")
+
+	writer.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	writer.Header().Set("Cache-Control", "no-cache")
+
+	if _, err := writer.Write(playlist.Bytes()); err != nil {
+		log.Printf("Error writing HLS playlist: %s\n", err)
+	}
+}
+
+// serveSegment writes a single segment of the track. It is taken from the segment
+// cache when possible. Otherwise it is cut by the encoder and stored in the cache.
+func (hh HLSHandler) serveSegment(
+	writer http.ResponseWriter,
+	req *http.Request,
+	trackID int64,
+	bitrate int,
+	index int,
+	duration time.Duration,
+) error {
+	filePath := hh.library.GetFilePath(trackID)
+	st, err := os.Stat(filePath)
+
+	if err != nil {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	key := segmentKey{
+		trackID:         trackID,
+		modTime:         st.ModTime(),
+		bitrate:         bitrate,
+		segmentDuration: hh.segmentDuration,
+		index:           index,
+	}
+
+	data := hh.segments.get(key)
+
+	if data == nil {
+		data, err = hh.cutSegment(req, transcodingJob{
+			filePath: filePath,
+			bitrate:  bitrate,
+			start:    time.Duration(index) * hh.segmentDuration,
+			duration: duration,
+		})
+
+		if err != nil {
+			return fmt.Errorf("cutting segment %d of track %d: %s", index, trackID,
+				err)
+		}
+
+		if err := hh.segments.store(key, data); err != nil {
+			log.Printf("Error storing HLS segment: %s\n", err)
+		}
+	}
+
+	writer.Header().Set("Content-Type", hh.profile.ContentType)
+	http.ServeContent(writer, req, "", st.ModTime(), bytes.NewReader(data))
+
+	return nil
+}
+
+// cutSegment runs the encoder for a single segment and returns its output.
+func (hh HLSHandler) cutSegment(req *http.Request, job transcodingJob) ([]byte, error) {
+	tr, err := startTranscoder(req.Context(), hh.profile, job)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, readErr := ioutil.ReadAll(tr)
+
+	if err := tr.Wait(); err != nil {
+		return nil, err
+	}
+
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	return data, nil
+}
+
+// parseSegmentName returns the index of the segment from its file name such as
+// "segment-12.ts".
+func parseSegmentName(name string) (int, bool) {
+	if !strings.HasPrefix(name, "segment-") || !strings.HasSuffix(name, ".ts") {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(name[len("segment-") : len(name)-len(".ts")])
+
+	if err != nil || index < 0 {
+		return 0, false
+	}
+
+	return index, true
+}
+
+// NewHLSHandler returns a new HLS handler. The segments are cut with the profile from
+// transcoding named in hlsConfig. They are stored in segmentsDir. When it is empty
+// they are not stored at all. HLS is disabled when there is no such profile.
+func NewHLSHandler(
+	lib library.Library,
+	hlsConfig config.HLS,
+	transcoding config.Transcoding,
+	segmentsDir string,
+) *HLSHandler {
+	hh := new(HLSHandler)
+	hh.library = lib
+	hh.profile, hh.enabled = transcoding[hlsConfig.Profile]
+	hh.segmentDuration = hlsConfig.SegmentDuration
+	hh.segments = hlsSegmentCache{dir: segmentsDir, maxAge: hlsConfig.CacheMaxAge}
+
+	if hh.enabled && hh.segmentDuration <= 0 {
+		log.Printf("HLS is disabled because its segment duration is not set\n")
+		hh.enabled = false
+	}
+
+	return hh
+}
@@ -0,0 +1,168 @@
+package webserver
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+)
+
+// sessionCookie is the name of the cookie with the session token.
+const sessionCookie = "httpms_session"
+
+// LoginHandler is a http.Handler which shows the login form on GET requests and
+// starts a session for the user on POST requests with correct credentials. The
+// session token is set in a cookie which the BasicAuthHandler accepts instead of
+// the Authorization header. After logging in the user is redirected to the path in
+// the "return" parameter.
+type LoginHandler struct {
+	authenticator *auth.Authenticator
+	sessions      *auth.Sessions
+	throttle      *auth.Throttle
+	secureCookie  bool // Whether the session cookie is sent only over HTTPS
+}
+
+// loginPage is the data for the login template.
+type loginPage struct {
+	Error  string
+	Return string
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (lh LoginHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, lh.login)
+}
+
+// login shows the form or checks the submitted credentials.
+func (lh LoginHandler) login(writer http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	case http.MethodGet:
+		page := loginPage{Return: returnPath(req.URL.Query().Get("return"))}
+		return lh.showForm(writer, http.StatusOK, page)
+	case http.MethodPost:
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	page := loginPage{Return: returnPath(req.PostFormValue("return"))}
+	ip := lh.throttle.ClientIP(req)
+	username := req.PostFormValue("username")
+
+	if retryAfter, blocked := lh.throttle.Blocked(ip, username); blocked {
+		writer.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		page.Error = "Too many failed attempts. Try again later."
+		return lh.showForm(writer, http.StatusTooManyRequests, page)
+	}
+
+	user, ok := lh.authenticator.Authenticate(username, req.PostFormValue("password"))
+
+	if !ok {
+		lh.throttle.Failed(ip, username)
+		page.Error = "Wrong user or password"
+		return lh.showForm(writer, http.StatusUnauthorized, page)
+	}
+
+	lh.throttle.Succeeded(ip, username)
+
+	token, expires, err := lh.sessions.Create(*user)
+
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   lh.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(writer, req, page.Return, http.StatusSeeOther)
+
+	return nil
+}
+
+func (lh LoginHandler) showForm(
+	writer http.ResponseWriter,
+	status int,
+	page loginPage,
+) error {
+	tmpl, err := getTemplate("login.html")
+
+	if err != nil {
+		return err
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(status)
+
+	return tmpl.Execute(writer, page)
+}
+
+// LogoutHandler is a http.Handler which ends the session of the user and redirects
+// them to the login form.
+type LogoutHandler struct {
+	sessions     *auth.Sessions
+	secureCookie bool
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (lh LogoutHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if cookie, err := req.Cookie(sessionCookie); err == nil {
+		lh.sessions.Delete(cookie.Value)
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   lh.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(writer, req, "/login", http.StatusSeeOther)
+}
+
+// returnPath returns the path to which users are redirected after logging in. Only
+// local paths are allowed so that the login form cannot be used for redirecting to
+// other sites.
+func returnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") ||
+		strings.Contains(path, `\`) {
+		return "/"
+	}
+	return path
+}
+
+// NewLoginHandler returns a new Login handler. It needs the authenticator for
+// checking the credentials, the sessions in which the logged in users are kept and
+// the throttle which blocks clients with too many wrong credentials. The session
+// cookie is sent only over HTTPS when secureCookie is true.
+func NewLoginHandler(
+	authenticator *auth.Authenticator,
+	sessions *auth.Sessions,
+	throttle *auth.Throttle,
+	secureCookie bool,
+) *LoginHandler {
+	lh := new(LoginHandler)
+	lh.authenticator = authenticator
+	lh.sessions = sessions
+	lh.throttle = throttle
+	lh.secureCookie = secureCookie
+	return lh
+}
+
+// NewLogoutHandler returns a new Logout handler. It needs the sessions from which
+// the logged out users are removed.
+func NewLogoutHandler(sessions *auth.Sessions, secureCookie bool) *LogoutHandler {
+	lh := new(LogoutHandler)
+	lh.sessions = sessions
+	lh.secureCookie = secureCookie
+	return lh
+}
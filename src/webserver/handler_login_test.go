@@ -0,0 +1,147 @@
+package webserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestLoginSessions(t *testing.T) {
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{
+			User:     "testuser",
+			Password: "testpass",
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	hash, _ := auth.HashPassword("alicepass")
+
+	if _, err := lib.CreateUser("alice", hash, library.RoleListener); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	do := func(req *http.Request, expectedStatus int) *http.Response {
+		resp, err := client.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected status %d for %s %s but got %d", expectedStatus,
+				req.Method, req.URL, resp.StatusCode)
+		}
+
+		return resp
+	}
+
+	testURL := func(path string) string {
+		return fmt.Sprintf("http://127.0.0.1:%d%s", TestPort, path)
+	}
+
+	login := func(user, password, returnTo string, expectedStatus int) *http.Response {
+		form := url.Values{}
+		form.Set("username", user)
+		form.Set("password", password)
+		form.Set("return", returnTo)
+
+		req, _ := http.NewRequest("POST", testURL("/login"),
+			strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return do(req, expectedStatus)
+	}
+
+	withCookie := func(method, path string, cookie *http.Cookie) *http.Request {
+		req, _ := http.NewRequest(method, testURL(path), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	sessionCookie := func(resp *http.Response) *http.Cookie {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "httpms_session" {
+				return cookie
+			}
+		}
+		t.Fatalf("No session cookie in response")
+		return nil
+	}
+
+	req, _ := http.NewRequest("GET", testURL("/search/?q=Payback"), nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp := do(req, http.StatusSeeOther)
+
+	if location := resp.Header.Get("Location"); location != "/login?return=%2Fsearch%2F%3Fq%3DPayback" {
+		t.Errorf("Expected redirect to the login form but it was to %s", location)
+	}
+
+	do(withCookie("GET", "/search/", nil), http.StatusUnauthorized)
+	do(withCookie("GET", "/login", nil), http.StatusOK)
+	login("testuser", "wrong", "/", http.StatusUnauthorized)
+
+	resp = login("testuser", "testpass", "/search/?q=Payback", http.StatusSeeOther)
+
+	if location := resp.Header.Get("Location"); location != "/search/?q=Payback" {
+		t.Errorf("Expected redirect to the return path but it was to %s", location)
+	}
+
+	cookie := sessionCookie(resp)
+
+	if !cookie.HttpOnly || cookie.Secure || !strings.Contains(
+		resp.Header.Get("Set-Cookie"), "SameSite=Lax") {
+		t.Errorf("Unexpected session cookie: %s", resp.Header.Get("Set-Cookie"))
+	}
+
+	do(withCookie("GET", "/search/", cookie), http.StatusOK)
+	do(withCookie("GET", "/search/", &http.Cookie{
+		Name:  "httpms_session",
+		Value: "wrong",
+	}), http.StatusUnauthorized)
+
+	resp = do(withCookie("POST", "/logout", cookie), http.StatusSeeOther)
+
+	if loggedOut := sessionCookie(resp); loggedOut.Value != "" || loggedOut.MaxAge >= 0 {
+		t.Errorf("Expected the session cookie to be removed but got %+v", loggedOut)
+	}
+
+	do(withCookie("GET", "/search/", cookie), http.StatusUnauthorized)
+
+	resp = login("alice", "alicepass", "//example.com/", http.StatusSeeOther)
+
+	if location := resp.Header.Get("Location"); location != "/" {
+		t.Errorf("Expected redirect to the root for foreign return path but got %s",
+			location)
+	}
+
+	cookie = sessionCookie(resp)
+	do(withCookie("GET", "/search/", cookie), http.StatusOK)
+
+	// Changing the password ends all sessions of the user.
+	hash, _ = auth.HashPassword("changed")
+
+	if err := lib.SetUserPassword("alice", hash); err != nil {
+		t.Fatal(err)
+	}
+
+	do(withCookie("GET", "/search/", cookie), http.StatusUnauthorized)
+}
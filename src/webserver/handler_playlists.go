@@ -0,0 +1,401 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// PlaylistsHandler is a http.Handler which manages the playlists stored in the
+// library. Its paths are relative to its mount point:
+//
+//	GET    /                     - lists all playlists
+//	POST   /                     - creates a playlist, body: {"name": "...", "rules": {...}}
+//	GET    /{id}                 - returns a playlist together with its tracks
+//	PATCH  /{id}                 - changes a playlist, body: {"name": "...", "rules": {...}}
+//	DELETE /{id}                 - deletes a playlist
+//	POST   /{id}/tracks          - adds tracks, body: {"tracks": [1, 2], "position": 0}
+//	PATCH  /{id}/tracks/{index}  - moves an entry, body: {"position": 3}
+//	DELETE /{id}/tracks/{index}  - removes an entry
+//
+// Entry indexes and positions start from zero. Adding tracks without position
+// appends them at the end of the playlist. Playlists created with rules are smart
+// playlists. Their tracks cannot be changed. Playlists are owned by the users who
+// created them. Everyone could see all playlists but only admins could change the
+// playlists of other users and the ones without owner.
+type PlaylistsHandler struct {
+	library library.Library
+}
+
+// playlistRequest is the body of the requests which change playlists.
+type playlistRequest struct {
+	Name     string              `json:"name"`
+	Rules    *library.SmartRules `json:"rules"`
+	Tracks   []int64             `json:"tracks"`
+	Position *int                `json:"position"`
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (ph PlaylistsHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, ph.serve)
+}
+
+// serve dispatches the request according to its path and method.
+func (ph PlaylistsHandler) serve(writer http.ResponseWriter, req *http.Request) error {
+	writer.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	path := strings.Trim(req.URL.Path, "/")
+
+	if path == "" {
+		switch req.Method {
+		case http.MethodGet:
+			return ph.list(writer)
+		case http.MethodPost:
+			return ph.create(writer, req)
+		}
+		return ph.methodNotAllowed(writer, "GET, POST")
+	}
+
+	parts := strings.Split(path, "/")
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil || len(parts) > 3 || (len(parts) > 1 && parts[1] != "tracks") {
+		return ph.notFound(writer, "Not found")
+	}
+
+	if req.Method != http.MethodGet {
+		if allowed, err := ph.allowed(writer, req, id); !allowed {
+			return err
+		}
+	}
+
+	switch len(parts) {
+	case 1:
+		switch req.Method {
+		case http.MethodGet:
+			return ph.get(writer, id)
+		case http.MethodPatch:
+			return ph.change(writer, req, id)
+		case http.MethodDelete:
+			return ph.respond(writer, http.StatusNoContent, ph.library.DeletePlaylist(id))
+		}
+		return ph.methodNotAllowed(writer, "GET, PATCH, DELETE")
+	case 2:
+		if req.Method == http.MethodPost {
+			return ph.addTracks(writer, req, id)
+		}
+		return ph.methodNotAllowed(writer, "POST")
+	}
+
+	index, err := strconv.Atoi(parts[2])
+
+	if err != nil {
+		return ph.notFound(writer, "Not found")
+	}
+
+	switch req.Method {
+	case http.MethodPatch:
+		return ph.moveTrack(writer, req, id, index)
+	case http.MethodDelete:
+		err := ph.library.RemovePlaylistTrack(id, index)
+		return ph.respond(writer, http.StatusNoContent, err)
+	}
+
+	return ph.methodNotAllowed(writer, "PATCH, DELETE")
+}
+
+func (ph PlaylistsHandler) list(writer http.ResponseWriter) error {
+	playlists := ph.library.GetPlaylists()
+
+	if playlists == nil {
+		playlists = []library.Playlist{}
+	}
+
+	return ph.writeJSON(writer, http.StatusOK, playlists)
+}
+
+func (ph PlaylistsHandler) create(writer http.ResponseWriter, req *http.Request) error {
+	body, ok := ph.readRequest(writer, req)
+
+	if !ok {
+		return nil
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		ph.badRequest(writer, `"name" must not be empty`)
+		return nil
+	}
+
+	var ownerID int64
+	if user := requestUser(req); user != nil {
+		ownerID = user.ID
+	}
+
+	if body.Rules == nil {
+		playlist, err := ph.library.CreatePlaylist(body.Name, ownerID)
+
+		if err != nil {
+			return err
+		}
+
+		return ph.writeJSON(writer, http.StatusCreated, playlist)
+	}
+
+	if err := body.Rules.Validate(); err != nil {
+		ph.badRequest(writer, fmt.Sprintf("Wrong rules: %s", err))
+		return nil
+	}
+
+	playlist, err := ph.library.CreateSmartPlaylist(body.Name, *body.Rules, ownerID)
+
+	if err != nil {
+		return err
+	}
+
+	return ph.writeJSON(writer, http.StatusCreated, playlist)
+}
+
+func (ph PlaylistsHandler) get(writer http.ResponseWriter, id int64) error {
+	playlist, err := ph.library.GetPlaylist(id)
+
+	if err != nil {
+		return ph.respond(writer, http.StatusOK, err)
+	}
+
+	tracks, err := ph.library.GetPlaylistTracks(id)
+
+	if err != nil {
+		return ph.respond(writer, http.StatusOK, err)
+	}
+
+	if tracks == nil {
+		tracks = []library.SearchResult{}
+	}
+
+	return ph.writeJSON(writer, http.StatusOK, struct {
+		*library.Playlist
+		Tracks []library.SearchResult `json:"tracks"`
+	}{
+		Playlist: playlist,
+		Tracks:   tracks,
+	})
+}
+
+// change renames a playlist or changes the rules of a smart playlist. Both could be
+// changed with a single request.
+func (ph PlaylistsHandler) change(
+	writer http.ResponseWriter,
+	req *http.Request,
+	id int64,
+) error {
+	body, ok := ph.readRequest(writer, req)
+
+	if !ok {
+		return nil
+	}
+
+	if body.Rules == nil && strings.TrimSpace(body.Name) == "" {
+		ph.badRequest(writer, `"name" must not be empty`)
+		return nil
+	}
+
+	if body.Rules != nil {
+		playlist, err := ph.library.GetPlaylist(id)
+
+		if err != nil {
+			return ph.respond(writer, http.StatusNoContent, err)
+		}
+
+		if playlist.Rules == nil {
+			ph.badRequest(writer, "Only smart playlists have rules")
+			return nil
+		}
+
+		if err := body.Rules.Validate(); err != nil {
+			ph.badRequest(writer, fmt.Sprintf("Wrong rules: %s", err))
+			return nil
+		}
+
+		if err := ph.library.SetSmartPlaylistRules(id, *body.Rules); err != nil {
+			return ph.respond(writer, http.StatusNoContent, err)
+		}
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		writer.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return ph.respond(writer, http.StatusNoContent, ph.library.RenamePlaylist(id, body.Name))
+}
+
+func (ph PlaylistsHandler) addTracks(
+	writer http.ResponseWriter,
+	req *http.Request,
+	id int64,
+) error {
+	body, ok := ph.readRequest(writer, req)
+
+	if !ok {
+		return nil
+	}
+
+	if len(body.Tracks) == 0 {
+		ph.badRequest(writer, `"tracks" must be a non-empty list of track IDs`)
+		return nil
+	}
+
+	position := -1
+	if body.Position != nil {
+		position = *body.Position
+	}
+
+	err := ph.library.AddPlaylistTracks(id, body.Tracks, position)
+
+	if err == library.ErrTrackNotFound {
+		ph.badRequest(writer, err.Error())
+		return nil
+	}
+
+	return ph.respond(writer, http.StatusNoContent, err)
+}
+
+func (ph PlaylistsHandler) moveTrack(
+	writer http.ResponseWriter,
+	req *http.Request,
+	id int64,
+	index int,
+) error {
+	body, ok := ph.readRequest(writer, req)
+
+	if !ok {
+		return nil
+	}
+
+	if body.Position == nil {
+		ph.badRequest(writer, `"position" is required`)
+		return nil
+	}
+
+	err := ph.library.MovePlaylistTrack(id, index, *body.Position)
+	return ph.respond(writer, http.StatusNoContent, err)
+}
+
+// allowed returns true when the user who made the request could change the playlist
+// with this ID. Otherwise an error response is written.
+func (ph PlaylistsHandler) allowed(
+	writer http.ResponseWriter,
+	req *http.Request,
+	id int64,
+) (bool, error) {
+	user := requestUser(req)
+
+	if user == nil || user.IsAdmin() {
+		return true, nil
+	}
+
+	playlist, err := ph.library.GetPlaylist(id)
+
+	if err != nil {
+		return false, ph.respond(writer, http.StatusOK, err)
+	}
+
+	if playlist.OwnerID != user.ID {
+		ph.writeError(writer, http.StatusForbidden,
+			"Only admins could change the playlists of other users")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// readRequest decodes the JSON body of a request. When it is malformed a bad request
+// response is written and false is returned.
+func (ph PlaylistsHandler) readRequest(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (playlistRequest, bool) {
+	var body playlistRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		ph.badRequest(writer, fmt.Sprintf("Malformed JSON body: %s", err))
+		return body, false
+	}
+
+	return body, true
+}
+
+// respond writes a response without body with this status when err is nil. Missing
+// playlists and entries result in not found responses and changing the tracks of
+// smart playlists in conflict responses. Other errors are returned.
+func (ph PlaylistsHandler) respond(writer http.ResponseWriter, status int, err error) error {
+	switch err {
+	case nil:
+		writer.WriteHeader(status)
+		return nil
+	case library.ErrPlaylistNotFound, library.ErrPlaylistEntryNotFound:
+		return ph.notFound(writer, err.Error())
+	case library.ErrPlaylistReadOnly:
+		ph.writeError(writer, http.StatusConflict, err.Error())
+		return nil
+	}
+
+	return err
+}
+
+func (ph PlaylistsHandler) writeJSON(
+	writer http.ResponseWriter,
+	status int,
+	data interface{},
+) error {
+	marshalled, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	writer.WriteHeader(status)
+	_, err = writer.Write(marshalled)
+	return err
+}
+
+func (ph PlaylistsHandler) badRequest(writer http.ResponseWriter, message string) {
+	ph.writeError(writer, http.StatusBadRequest, message)
+}
+
+func (ph PlaylistsHandler) notFound(writer http.ResponseWriter, message string) error {
+	ph.writeError(writer, http.StatusNotFound, message)
+	return nil
+}
+
+func (ph PlaylistsHandler) methodNotAllowed(writer http.ResponseWriter, allow string) error {
+	writer.Header().Set("Allow", allow)
+	ph.writeError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+	return nil
+}
+
+func (ph PlaylistsHandler) writeError(writer http.ResponseWriter, status int, message string) {
+	writer.WriteHeader(status)
+	msgJSON, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{
+		Error: message,
+	})
+	if _, err := writer.Write(msgJSON); err != nil {
+		log.Printf("error writing body in playlists handler: %s", err)
+	}
+}
+
+// NewPlaylistsHandler returns a new Playlists handler. It needs a library in which
+// the playlists are stored.
+func NewPlaylistsHandler(lib library.Library) *PlaylistsHandler {
+	ph := new(PlaylistsHandler)
+	ph.library = lib
+	return ph
+}
@@ -0,0 +1,298 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestPlaylistsHandler(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	tracks, _ := lib.Search(library.SearchArgs{})
+
+	if len(tracks) != 3 {
+		t.Fatalf("Expected 3 tracks in the library but found %d", len(tracks))
+	}
+
+	request := playlistsRequester(t, "", "")
+
+	var playlists []library.Playlist
+	request("GET", "", "", http.StatusOK, &playlists)
+
+	if len(playlists) != 0 {
+		t.Errorf("Expected no playlists but got %+v", playlists)
+	}
+
+	var created library.Playlist
+	request("POST", "", `{"name": "Road Trip"}`, http.StatusCreated, &created)
+
+	if created.ID == 0 || created.Name != "Road Trip" {
+		t.Fatalf("Unexpected created playlist: %+v", created)
+	}
+
+	uri := fmt.Sprintf("%d", created.ID)
+	tracksBody := fmt.Sprintf(`{"tracks": [%d, %d]}`, tracks[0].ID, tracks[1].ID)
+	request("POST", uri+"/tracks", tracksBody, http.StatusNoContent, nil)
+
+	tracksBody = fmt.Sprintf(`{"tracks": [%d], "position": 0}`, tracks[2].ID)
+	request("POST", uri+"/tracks", tracksBody, http.StatusNoContent, nil)
+	request("PATCH", uri+"/tracks/0", `{"position": 2}`, http.StatusNoContent, nil)
+	request("DELETE", uri+"/tracks/0", "", http.StatusNoContent, nil)
+	request("PATCH", uri, `{"name": "Long Road Trip"}`, http.StatusNoContent, nil)
+
+	var found struct {
+		library.Playlist
+		Tracks []library.SearchResult `json:"tracks"`
+	}
+	request("GET", uri, "", http.StatusOK, &found)
+
+	if found.Name != "Long Road Trip" || found.TrackCount != 2 {
+		t.Errorf("Unexpected playlist: %+v", found.Playlist)
+	}
+
+	if len(found.Tracks) != 2 || found.Tracks[0].ID != tracks[1].ID ||
+		found.Tracks[1].ID != tracks[2].ID {
+		t.Errorf("Unexpected playlist tracks: %+v", found.Tracks)
+	}
+
+	request("GET", "", "", http.StatusOK, &playlists)
+
+	if len(playlists) != 1 || playlists[0].ID != created.ID {
+		t.Errorf("Expected only the created playlist but got %+v", playlists)
+	}
+
+	request("POST", "", `{"name": ""}`, http.StatusBadRequest, nil)
+	request("POST", "", `not json`, http.StatusBadRequest, nil)
+	request("POST", uri+"/tracks", `{"tracks": [666]}`, http.StatusBadRequest, nil)
+	request("POST", uri+"/tracks", `{"tracks": []}`, http.StatusBadRequest, nil)
+	request("PATCH", uri+"/tracks/0", `{}`, http.StatusBadRequest, nil)
+	request("PATCH", uri+"/tracks/5", `{"position": 0}`, http.StatusNotFound, nil)
+	request("DELETE", uri+"/tracks/5", "", http.StatusNotFound, nil)
+	request("PUT", uri, "", http.StatusMethodNotAllowed, nil)
+	request("GET", uri+"/other", "", http.StatusNotFound, nil)
+
+	request("DELETE", uri, "", http.StatusNoContent, nil)
+	request("GET", uri, "", http.StatusNotFound, nil)
+	request("DELETE", uri, "", http.StatusNotFound, nil)
+	request("PATCH", uri, `{"name": "Gone"}`, http.StatusNotFound, nil)
+}
+
+func TestSmartPlaylistsHandler(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected to find Payback but found %d tracks", len(found))
+	}
+
+	request := playlistsRequester(t, "", "")
+
+	var created library.Playlist
+	request("POST", "", `{"name": "Played Once", "rules": {"rules": [
+		{"field": "play_count", "operator": "is", "value": 1}]}}`,
+		http.StatusCreated, &created)
+
+	if created.Rules == nil || created.TrackCount != 0 {
+		t.Fatalf("Unexpected created smart playlist: %+v", created)
+	}
+
+	uri := fmt.Sprintf("%d", created.ID)
+
+	play := func(rangeHeader string) {
+		url := fmt.Sprintf("http://127.0.0.1:%d/file/%d", TestPort, found[0].ID)
+		req, err := http.NewRequest("GET", url, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	// Only requests from the beginning of the file are plays.
+	play("")
+	play("bytes=100-")
+
+	var smart struct {
+		library.Playlist
+		Tracks []library.SearchResult `json:"tracks"`
+	}
+	request("GET", uri, "", http.StatusOK, &smart)
+
+	if len(smart.Tracks) != 1 || smart.Tracks[0].ID != found[0].ID ||
+		smart.TrackCount != 1 {
+		t.Errorf("Expected only the played track but got %+v", smart)
+	}
+
+	request("PATCH", uri, `{"rules": {"rules": [
+		{"field": "play_count", "operator": "is", "value": 0}]}}`,
+		http.StatusNoContent, nil)
+	request("GET", uri, "", http.StatusOK, &smart)
+
+	if len(smart.Tracks) != 2 {
+		t.Errorf("Expected the 2 tracks which were not played but got %+v", smart.Tracks)
+	}
+
+	tracksBody := fmt.Sprintf(`{"tracks": [%d]}`, found[0].ID)
+	request("POST", uri+"/tracks", tracksBody, http.StatusConflict, nil)
+	request("DELETE", uri+"/tracks/0", "", http.StatusConflict, nil)
+	request("PATCH", uri, `{"rules": {"match": "none"}}`, http.StatusBadRequest, nil)
+	request("POST", "", `{"name": "Bad", "rules": {"sort": "colour"}}`,
+		http.StatusBadRequest, nil)
+
+	var static library.Playlist
+	request("POST", "", `{"name": "Static"}`, http.StatusCreated, &static)
+	request("PATCH", fmt.Sprintf("%d", static.ID), `{"rules": {}}`,
+		http.StatusBadRequest, nil)
+}
+
+func TestPlaylistOwners(t *testing.T) {
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{
+			User:     "testuser",
+			Password: "testpass",
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	for _, name := range []string{"alice", "bob"} {
+		hash, err := auth.HashPassword(name + "pass")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := lib.CreateUser(name, hash, library.RoleListener); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := playlistsRequester(t, "testuser", "testpass")
+	alice := playlistsRequester(t, "alice", "alicepass")
+	bob := playlistsRequester(t, "bob", "bobpass")
+
+	playlistsRequester(t, "alice", "bobpass")("GET", "", "", http.StatusUnauthorized, nil)
+	playlistsRequester(t, "nobody", "")("GET", "", "", http.StatusUnauthorized, nil)
+
+	var mine, shared library.Playlist
+	alice("POST", "", `{"name": "Alice's"}`, http.StatusCreated, &mine)
+	admin("POST", "", `{"name": "Shared"}`, http.StatusCreated, &shared)
+
+	aliceUser, _ := lib.GetUser("alice")
+
+	if mine.OwnerID != aliceUser.ID || shared.OwnerID != 0 {
+		t.Errorf("Unexpected playlist owners: %+v and %+v", mine, shared)
+	}
+
+	mineURI := fmt.Sprintf("%d", mine.ID)
+	sharedURI := fmt.Sprintf("%d", shared.ID)
+
+	bob("GET", mineURI, "", http.StatusOK, nil)
+	bob("PATCH", mineURI, `{"name": "Bob's"}`, http.StatusForbidden, nil)
+	bob("POST", mineURI+"/tracks", `{"tracks": [1]}`, http.StatusForbidden, nil)
+	bob("DELETE", mineURI, "", http.StatusForbidden, nil)
+	bob("DELETE", "9999", "", http.StatusNotFound, nil)
+	alice("PATCH", sharedURI, `{"name": "Alice's now"}`, http.StatusForbidden, nil)
+
+	alice("PATCH", mineURI, `{"name": "Still Alice's"}`, http.StatusNoContent, nil)
+	admin("PATCH", mineURI, `{"name": "Admin's"}`, http.StatusNoContent, nil)
+	alice("DELETE", mineURI, "", http.StatusNoContent, nil)
+
+	rescan := func(method, user, password string, expectedStatus int) {
+		url := fmt.Sprintf("http://127.0.0.1:%d/rescan", TestPort)
+		req, err := http.NewRequest(method, url, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.SetBasicAuth(user, password)
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != expectedStatus {
+			t.Errorf("Expected status %d for %s rescan by %s but got %d",
+				expectedStatus, method, user, resp.StatusCode)
+		}
+	}
+
+	rescan("POST", "bob", "bobpass", http.StatusForbidden)
+	rescan("GET", "testuser", "testpass", http.StatusMethodNotAllowed)
+}
+
+// playlistsRequester returns a function which makes requests to the playlists API
+// with these basic authentication credentials unless user is empty. The JSON
+// response is decoded in response unless it is nil.
+func playlistsRequester(
+	t *testing.T,
+	user, password string,
+) func(method, uri, body string, expectedStatus int, response interface{}) {
+	return func(method, uri, body string, expectedStatus int, response interface{}) {
+		url := fmt.Sprintf("http://127.0.0.1:%d/playlists/%s", TestPort, uri)
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+		respBody, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected status %d for %s %s but got %d: %s", expectedStatus,
+				method, uri, resp.StatusCode, respBody)
+		}
+
+		if response == nil {
+			return
+		}
+
+		if err := json.Unmarshal(respBody, response); err != nil {
+			t.Fatalf("Error decoding response of %s %s: %s", method, uri, err)
+		}
+	}
+}
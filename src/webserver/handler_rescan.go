@@ -0,0 +1,34 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// RescanHandler is a http.Handler which starts a full library scan on POST
+// requests. The scan is done in the background so the response is returned
+// immediately.
+type RescanHandler struct {
+	library library.Library
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (rh RescanHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	go rh.library.Scan()
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// NewRescanHandler returns a new Rescan handler. It needs the library which will be
+// scanned.
+func NewRescanHandler(lib library.Library) *RescanHandler {
+	rh := new(RescanHandler)
+	rh.library = lib
+	return rh
+}
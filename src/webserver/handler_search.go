@@ -2,9 +2,12 @@ package webserver
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/ironsmile/httpms/src/library"
 )
@@ -25,10 +28,7 @@ func (sh SearchHandler) search(writer http.ResponseWriter, req *http.Request) er
 	writer.Header().Add("Content-Type", "application/json; charset=utf-8")
 
 	if err := req.ParseForm(); err != nil {
-		writer.WriteHeader(http.StatusBadRequest)
-		if _, err := writer.Write([]byte(err.Error())); err != nil {
-			log.Printf("error writing body in search handler: %s", err)
-		}
+		sh.badRequest(writer, err.Error())
 		return nil
 	}
 
@@ -44,8 +44,92 @@ func (sh SearchHandler) search(writer http.ResponseWriter, req *http.Request) er
 		}
 	}
 
-	results := sh.library.Search(query)
+	if _, err := library.ParseSearchQuery(query); err != nil {
+		sh.badRequest(writer, fmt.Sprintf("Malformed search query: %s", err))
+		return nil
+	}
+
+	pageStr := req.Form.Get("page")
+	perPageStr := req.Form.Get("per-page")
+
+	// Old clients expect a JSON array with all results. Pagination is used only when
+	// it has been explicitly requested.
+	if pageStr == "" && perPageStr == "" {
+		results, _ := sh.library.Search(library.SearchArgs{Query: query})
+		return sh.writeResults(writer, results)
+	}
+
+	var page, perPage int = 1, 10
+
+	if pageStr != "" {
+		var err error
+		page, err = strconv.Atoi(pageStr)
+
+		if err != nil {
+			sh.badRequest(writer, fmt.Sprintf(`Wrong "page" parameter: %s`, err))
+			return nil
+		}
+	}
+
+	if perPageStr != "" {
+		var err error
+		perPage, err = strconv.Atoi(perPageStr)
+
+		if err != nil {
+			sh.badRequest(writer, fmt.Sprintf(`Wrong "per-page" parameter: %s`, err))
+			return nil
+		}
+	}
+
+	if page < 1 || perPage < 1 {
+		sh.badRequest(writer, `"page" and "per-page" must be integers greater than zero`)
+		return nil
+	}
+
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	results, count := sh.library.Search(library.SearchArgs{
+		Query:  query,
+		Offset: uint((page - 1) * perPage),
+		Limit:  uint(perPage),
+	})
 
+	if results == nil {
+		results = []library.SearchResult{}
+	}
+
+	prevPage, nextPage := getSearchPrevNextPageURI(query, page, perPage, count)
+
+	retData := struct {
+		Data       []library.SearchResult `json:"data"`
+		Next       string                 `json:"next"`
+		Previous   string                 `json:"previous"`
+		PagesCount int                    `json:"pages_count"`
+	}{
+		Data:       results,
+		PagesCount: int(math.Ceil(float64(count) / float64(perPage))),
+		Next:       nextPage,
+		Previous:   prevPage,
+	}
+
+	marshalled, err := json.Marshal(retData)
+
+	if err != nil {
+		return err
+	}
+
+	writer.Write(marshalled)
+
+	return nil
+}
+
+// writeResults writes the search results as a JSON array.
+func (sh SearchHandler) writeResults(
+	writer http.ResponseWriter,
+	results []library.SearchResult,
+) error {
 	if len(results) == 0 {
 		writer.Write([]byte("[]"))
 		return nil
@@ -62,6 +146,44 @@ func (sh SearchHandler) search(writer http.ResponseWriter, req *http.Request) er
 	return nil
 }
 
+func (sh SearchHandler) badRequest(writer http.ResponseWriter, message string) {
+	writer.WriteHeader(http.StatusBadRequest)
+	msgJSON, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{
+		Error: message,
+	})
+	if _, err := writer.Write([]byte(msgJSON)); err != nil {
+		log.Printf("error writing body in search handler: %s", err)
+	}
+}
+
+func getSearchPrevNextPageURI(query string, page, perPage, count int) (string, string) {
+	prevPage := ""
+
+	if page-1 > 0 {
+		prevPage = fmt.Sprintf(
+			"/search/?q=%s&page=%d&per-page=%d",
+			url.QueryEscape(query),
+			page-1,
+			perPage,
+		)
+	}
+
+	nextPage := ""
+
+	if page*perPage < count {
+		nextPage = fmt.Sprintf(
+			"/search/?q=%s&page=%d&per-page=%d",
+			url.QueryEscape(query),
+			page+1,
+			perPage,
+		)
+	}
+
+	return prevPage, nextPage
+}
+
 // NewSearchHandler returns a new SearchHandler for processing search queries. They
 // will be run against the supplied library
 func NewSearchHandler(lib library.Library) *SearchHandler {
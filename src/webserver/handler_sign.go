@@ -0,0 +1,104 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// SignedURLHandler is a handler wrapper which lets requests with valid URL
+// signatures through without any other authentication. All other requests are
+// passed to the authenticated handler which should require credentials.
+type SignedURLHandler struct {
+	wrapped       http.Handler // Serves the requests with valid signatures
+	authenticated http.Handler // Serves all other requests
+	signer        *auth.URLSigner
+}
+
+// ServeHTTP implements the http.Handler interface
+func (sh SignedURLHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if sh.signer.Valid(req.URL) {
+		sh.wrapped.ServeHTTP(writer, req)
+		return
+	}
+
+	sh.authenticated.ServeHTTP(writer, req)
+}
+
+// maxSignedURLLifetime is the maximum lifetime of signed URLs in seconds which could
+// be requested. It is one year.
+const maxSignedURLLifetime = 365 * 24 * 60 * 60
+
+// SignHandler is a http.Handler which returns signed /file/ URLs for tracks. They
+// could be used without authentication until they expire. Its path is the track
+// ID. The lifetime of the URL in seconds could be set with the "expires_in"
+// parameter. The response looks like this:
+//
+//	{"url": "http://host/file/42?exp=...&sig=...", "expires": 1500000000}
+type SignHandler struct {
+	library library.Library
+	signer  *auth.URLSigner
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (sh SignHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, sh.sign)
+}
+
+// sign returns the signed URL for the track in the request path.
+func (sh SignHandler) sign(writer http.ResponseWriter, req *http.Request) error {
+	writer.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	id, err := strconv.ParseInt(strings.Trim(req.URL.Path, "/"), 10, 64)
+
+	if err != nil {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	}
+
+	if _, err := sh.library.GetTrack(id); err == library.ErrTrackNotFound {
+		http.NotFoundHandler().ServeHTTP(writer, req)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	lifetime := auth.DefaultSignedURLLifetime
+
+	if expiresIn := req.URL.Query().Get("expires_in"); expiresIn != "" {
+		seconds, err := strconv.ParseInt(expiresIn, 10, 64)
+
+		if err != nil || seconds <= 0 || seconds > maxSignedURLLifetime {
+			http.Error(writer, fmt.Sprintf("Wrong expires_in: %s", expiresIn),
+				http.StatusBadRequest)
+			return nil
+		}
+
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	expires := time.Now().Add(lifetime)
+
+	return json.NewEncoder(writer).Encode(struct {
+		URL     string `json:"url"`
+		Expires int64  `json:"expires"`
+	}{
+		URL:     absoluteURL(req, sh.signer.Sign(filePath(id), expires)),
+		Expires: expires.Unix(),
+	})
+}
+
+// NewSignHandler returns a new Sign handler. It needs the library with the tracks
+// and the signer for their URLs.
+func NewSignHandler(lib library.Library, signer *auth.URLSigner) *SignHandler {
+	sh := new(SignHandler)
+	sh.library = lib
+	sh.signer = signer
+	return sh
+}
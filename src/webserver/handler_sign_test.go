@@ -0,0 +1,123 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+	"github.com/ironsmile/httpms/src/playlists"
+)
+
+func TestSignedFileURLs(t *testing.T) {
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{
+			User:     "testuser",
+			Password: "testpass",
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Payback"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected to find Payback but found %d tracks", len(found))
+	}
+
+	get := func(url string, authenticated bool, expectedStatus int) *http.Response {
+		if !strings.HasPrefix(url, "http") {
+			url = fmt.Sprintf("http://127.0.0.1:%d/%s", TestPort, url)
+		}
+
+		req, err := http.NewRequest("GET", url, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if authenticated {
+			req.SetBasicAuth("testuser", "testpass")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != expectedStatus {
+			resp.Body.Close()
+			t.Fatalf("Expected status %d for %s but got %d", expectedStatus, url,
+				resp.StatusCode)
+		}
+
+		return resp
+	}
+
+	fileURI := fmt.Sprintf("file/%d", found[0].ID)
+	get(fileURI, false, http.StatusUnauthorized).Body.Close()
+	get(fileURI, true, http.StatusOK).Body.Close()
+	get("hls/1/index.m3u8", false, http.StatusUnauthorized).Body.Close()
+
+	signURI := fmt.Sprintf("sign/%d?expires_in=60", found[0].ID)
+	get(signURI, false, http.StatusUnauthorized).Body.Close()
+
+	resp := get(signURI, true, http.StatusOK)
+	defer resp.Body.Close()
+
+	var signed struct {
+		URL     string `json:"url"`
+		Expires int64  `json:"expires"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		t.Fatalf("Decoding signed URL: %s", err)
+	}
+
+	expectedPrefix := fmt.Sprintf("http://127.0.0.1:%d/%s?", TestPort, fileURI)
+
+	if !strings.HasPrefix(signed.URL, expectedPrefix) {
+		t.Errorf("Expected signed URL starting with %s but got %s", expectedPrefix,
+			signed.URL)
+	}
+
+	if remaining := time.Until(time.Unix(signed.Expires, 0)); remaining > time.Minute ||
+		remaining < 50*time.Second {
+		t.Errorf("Expected the URL to expire in a minute but it was %s", remaining)
+	}
+
+	get(signed.URL, false, http.StatusOK).Body.Close()
+
+	otherFile := strings.Replace(signed.URL, fileURI, fmt.Sprintf("file/%d",
+		found[0].ID+1), 1)
+	get(otherFile, false, http.StatusUnauthorized).Body.Close()
+	get(signed.URL[:len(signed.URL)-2], false, http.StatusUnauthorized).Body.Close()
+
+	get("sign/9999", true, http.StatusNotFound).Body.Close()
+	get(signURI+"0000000000", true, http.StatusBadRequest).Body.Close()
+	get(fmt.Sprintf("sign/%d?expires_in=-5", found[0].ID), true,
+		http.StatusBadRequest).Body.Close()
+
+	// Exported playlist files must be playable without authentication.
+	resp = get(fmt.Sprintf("export/album/%d.m3u8", found[0].AlbumID), true,
+		http.StatusOK)
+	defer resp.Body.Close()
+
+	exported, err := playlists.Read(resp.Body, playlists.M3U8)
+
+	if err != nil {
+		t.Fatalf("Reading exported playlist: %s", err)
+	}
+
+	if len(exported.Entries) != 1 {
+		t.Fatalf("Expected one exported track but got %+v", exported.Entries)
+	}
+
+	get(exported.Entries[0].Location, false, http.StatusOK).Body.Close()
+}
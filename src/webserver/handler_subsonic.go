@@ -0,0 +1,759 @@
+package webserver
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// subsonicIgnoredArticles are skipped in the beginning of artist names when they
+// are sorted and put in indexes.
+var subsonicIgnoredArticles = []string{"The", "El", "La", "Los", "Las", "Le", "Les"}
+
+// subsonicDefaultSearchCount is the number of results of every kind returned by
+// search3 by default.
+const subsonicDefaultSearchCount = 20
+
+// subsonicMethod is a method of the Subsonic API. Methods which return media files
+// or images write them on their own and return a nil response.
+type subsonicMethod func(
+	sh SubsonicHandler,
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error)
+
+// subsonicMethods are the supported methods of the Subsonic API by name.
+var subsonicMethods = map[string]subsonicMethod{
+	"ping":            SubsonicHandler.ping,
+	"getMusicFolders": SubsonicHandler.getMusicFolders,
+	"getArtists":      SubsonicHandler.getArtists,
+	"getArtist":       SubsonicHandler.getArtist,
+	"getAlbum":        SubsonicHandler.getAlbum,
+	"getSong":         SubsonicHandler.getSong,
+	"search3":         SubsonicHandler.search3,
+	"stream":          SubsonicHandler.stream,
+	"download":        SubsonicHandler.download,
+	"getCoverArt":     SubsonicHandler.getCoverArt,
+}
+
+// SubsonicHandler is a http.Handler which implements the parts of the Subsonic REST
+// API (http://www.subsonic.org/pages/api.jsp) needed for browsing and playing the
+// library with Subsonic clients. Methods are called by name, e.g. "ping" or
+// "ping.view". Responses are XML unless JSON is requested with "f=json".
+type SubsonicHandler struct {
+	library library.Library
+
+	// authenticator checks the credentials of the clients. Everyone is allowed
+	// when it is nil.
+	authenticator *auth.Authenticator
+
+	// throttle blocks clients with too many wrong credentials.
+	throttle *auth.Throttle
+
+	// files and covers serve the media files and album covers.
+	files  http.Handler
+	covers http.Handler
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (sh SubsonicHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		sh.writeError(writer, req, newSubsonicError(subsonicErrGeneric,
+			"Malformed request: %s", err))
+		return
+	}
+
+	if !sh.throttledAuthenticate(writer, req) {
+		return
+	}
+
+	method, ok := subsonicMethods[strings.TrimSuffix(req.URL.Path, ".view")]
+
+	if !ok {
+		sh.writeError(writer, req, newSubsonicError(subsonicErrNotFound,
+			"Unknown method %s", req.URL.Path))
+		return
+	}
+
+	resp, err := method(sh, writer, req)
+
+	if err != nil {
+		sh.writeError(writer, req, err)
+		return
+	}
+
+	if resp != nil {
+		sh.write(writer, req, resp)
+	}
+}
+
+// throttledAuthenticate checks the user's credentials unless the client is blocked
+// by the throttle. Failures are counted by the throttle. Returns false when the
+// request must not be served. An error response is written then.
+func (sh SubsonicHandler) throttledAuthenticate(
+	writer http.ResponseWriter,
+	req *http.Request,
+) bool {
+	if sh.authenticator == nil {
+		return true
+	}
+
+	ip := sh.throttle.ClientIP(req)
+	user := req.Form.Get("u")
+
+	if _, blocked := sh.throttle.Blocked(ip, user); blocked {
+		sh.writeError(writer, req, newSubsonicError(subsonicErrGeneric,
+			"Too many failed authentication attempts. Try again later."))
+		return false
+	}
+
+	err := sh.authenticate(req.Form)
+
+	if subErr, ok := err.(*subsonicError); ok && subErr.Code == subsonicErrWrongCredential {
+		sh.throttle.Failed(ip, user)
+	} else if err == nil {
+		sh.throttle.Succeeded(ip, user)
+	}
+
+	if err != nil {
+		sh.writeError(writer, req, err)
+		return false
+	}
+
+	return true
+}
+
+// authenticate checks the user's credentials. They are either the password, in plain
+// text or hex encoded with an "enc:" prefix, or a token which is the MD5 hash of
+// the password and a random salt. Passwords work for every user while tokens work
+// only for the user from the configuration. See Authenticator.AuthenticateSalted.
+func (sh SubsonicHandler) authenticate(form url.Values) error {
+	if sh.authenticator == nil {
+		return nil
+	}
+
+	user := form.Get("u")
+
+	if user == "" {
+		return newSubsonicError(subsonicErrMissingParam,
+			"Required parameter is missing: u")
+	}
+
+	wrongCredentials := newSubsonicError(subsonicErrWrongCredential,
+		"Wrong username or password")
+
+	var correct bool
+
+	if token := form.Get("t"); token != "" {
+		salt := form.Get("s")
+
+		if salt == "" {
+			return newSubsonicError(subsonicErrMissingParam,
+				"Required parameter is missing: s")
+		}
+
+		_, correct = sh.authenticator.AuthenticateSalted(user, token, salt)
+	} else {
+		password := form.Get("p")
+
+		if password == "" {
+			return newSubsonicError(subsonicErrMissingParam,
+				"Required parameter is missing: p")
+		}
+
+		if strings.HasPrefix(password, "enc:") {
+			decoded, err := hex.DecodeString(strings.TrimPrefix(password, "enc:"))
+
+			if err != nil {
+				return wrongCredentials
+			}
+
+			password = string(decoded)
+		}
+
+		_, correct = sh.authenticator.Authenticate(user, password)
+	}
+
+	if !correct {
+		return wrongCredentials
+	}
+
+	return nil
+}
+
+// write encodes the response in the format requested by the client.
+func (sh SubsonicHandler) write(
+	writer http.ResponseWriter,
+	req *http.Request,
+	resp *subsonicResponse,
+) {
+	var (
+		body []byte
+		err  error
+	)
+
+	switch format := req.Form.Get("f"); format {
+	case "json", "jsonp":
+		body, err = json.Marshal(map[string]*subsonicResponse{
+			"subsonic-response": resp,
+		})
+
+		if callback := req.Form.Get("callback"); format == "jsonp" && callback != "" {
+			writer.Header().Set("Content-Type", "application/javascript")
+			body = []byte(fmt.Sprintf("%s(%s);", callback, body))
+		} else {
+			writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+	default:
+		body, err = xml.Marshal(resp)
+		body = append([]byte(xml.Header), body...)
+		writer.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	}
+
+	if err != nil {
+		log.Printf("Error encoding Subsonic response: %s\n", err)
+		http.Error(writer, "Encoding the response failed",
+			http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		log.Printf("Error writing Subsonic response: %s\n", err)
+	}
+}
+
+// writeError writes a failed response for the error. Errors other than
+// *subsonicError have the generic error code.
+func (sh SubsonicHandler) writeError(
+	writer http.ResponseWriter,
+	req *http.Request,
+	err error,
+) {
+	subErr, ok := err.(*subsonicError)
+
+	if !ok {
+		log.Printf("Error in Subsonic method %s: %s\n", req.URL.Path, err)
+		subErr = newSubsonicError(subsonicErrGeneric, "%s", err)
+	}
+
+	resp := newSubsonicResponse()
+	resp.Status = "failed"
+	resp.Error = subErr
+
+	sh.write(writer, req, resp)
+}
+
+func (sh SubsonicHandler) ping(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	return newSubsonicResponse(), nil
+}
+
+// getMusicFolders returns a single folder for the whole library. The library does
+// not keep track of which of its paths a track is in.
+func (sh SubsonicHandler) getMusicFolders(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	resp := newSubsonicResponse()
+	resp.MusicFolders = &subsonicMusicFolders{
+		Folders: []subsonicMusicFolder{{ID: 1, Name: "Music"}},
+	}
+	return resp, nil
+}
+
+// getArtists returns all artists in the library grouped in indexes by the first
+// letter of their names.
+func (sh SubsonicHandler) getArtists(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	var artists []library.Artist
+
+	for page := uint(0); ; page++ {
+		found, count := sh.library.BrowseArtists(library.BrowseArgs{
+			Page:    page,
+			PerPage: 500,
+			OrderBy: library.OrderByName,
+			Order:   library.OrderAsc,
+		})
+		artists = append(artists, found...)
+
+		if len(found) == 0 || len(artists) >= count {
+			break
+		}
+	}
+
+	sort.SliceStable(artists, func(i, j int) bool {
+		return strings.ToLower(subsonicSortName(artists[i].Name)) <
+			strings.ToLower(subsonicSortName(artists[j].Name))
+	})
+
+	indexes := &subsonicArtists{
+		IgnoredArticles: strings.Join(subsonicIgnoredArticles, " "),
+		Indexes:         []subsonicIndex{},
+	}
+
+	for _, artist := range artists {
+		indexName := subsonicIndexName(artist.Name)
+		last := len(indexes.Indexes) - 1
+
+		if last < 0 || indexes.Indexes[last].Name != indexName {
+			indexes.Indexes = append(indexes.Indexes, subsonicIndex{Name: indexName})
+			last++
+		}
+
+		indexes.Indexes[last].Artists = append(indexes.Indexes[last].Artists,
+			subsonicArtist{
+				ID:         subsonicID(subsonicArtistPrefix, artist.ID),
+				Name:       artist.Name,
+				AlbumCount: len(sh.library.GetArtistAlbums(artist.ID)),
+			},
+		)
+	}
+
+	resp := newSubsonicResponse()
+	resp.Artists = indexes
+	return resp, nil
+}
+
+// getArtist returns an artist with all of the albums which have tracks by it.
+func (sh SubsonicHandler) getArtist(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	id, err := sh.requiredID(req, subsonicArtistPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	artist, err := sh.library.GetArtist(id)
+
+	if err == library.ErrArtistNotFound {
+		return nil, newSubsonicError(subsonicErrNotFound, "Artist not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	albums := sh.library.GetArtistAlbums(id)
+
+	result := &subsonicArtist{
+		ID:         subsonicID(subsonicArtistPrefix, artist.ID),
+		Name:       artist.Name,
+		AlbumCount: len(albums),
+		Albums:     []subsonicAlbum{},
+	}
+
+	for _, album := range albums {
+		subAlbum := toSubsonicAlbum(album)
+		subAlbum.ArtistID = result.ID
+		result.Albums = append(result.Albums, subAlbum)
+	}
+
+	resp := newSubsonicResponse()
+	resp.Artist = result
+	return resp, nil
+}
+
+// getAlbum returns an album with all of its songs.
+func (sh SubsonicHandler) getAlbum(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	id, err := sh.requiredID(req, subsonicAlbumPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	album, err := sh.library.GetAlbum(id)
+
+	if err == library.ErrAlbumNotFound {
+		return nil, newSubsonicError(subsonicErrNotFound, "Album not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := toSubsonicAlbum(*album)
+	result.Songs = []subsonicSong{}
+
+	for _, track := range sh.library.GetAlbumFiles(id) {
+		result.Songs = append(result.Songs, sh.toSubsonicSong(track))
+	}
+
+	resp := newSubsonicResponse()
+	resp.Album = &result
+	return resp, nil
+}
+
+func (sh SubsonicHandler) getSong(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	id, err := sh.requiredID(req, subsonicTrackPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := sh.library.GetTrack(id)
+
+	if err == library.ErrTrackNotFound {
+		return nil, newSubsonicError(subsonicErrNotFound, "Song not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	song := sh.toSubsonicSong(*track)
+
+	resp := newSubsonicResponse()
+	resp.Song = &song
+	return resp, nil
+}
+
+// search3 searches for artists, albums and songs. The query uses the same syntax as
+// the /search/ endpoint. An empty query, which some clients send as "", matches
+// everything.
+func (sh SubsonicHandler) search3(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	query := strings.TrimSpace(req.Form.Get("query"))
+	if query == `""` {
+		query = ""
+	}
+
+	if _, err := library.ParseSearchQuery(query); err != nil {
+		return nil, newSubsonicError(subsonicErrGeneric,
+			"Malformed search query: %s", err)
+	}
+
+	var searchArgs [3]library.SearchArgs
+
+	for ind, kind := range []string{"artist", "album", "song"} {
+		count, err := subsonicUintParam(req, kind+"Count", subsonicDefaultSearchCount)
+
+		if err != nil {
+			return nil, err
+		}
+
+		offset, err := subsonicUintParam(req, kind+"Offset", 0)
+
+		if err != nil {
+			return nil, err
+		}
+
+		searchArgs[ind] = library.SearchArgs{
+			Query:  query,
+			Offset: offset,
+			Limit:  count,
+		}
+	}
+
+	result := &subsonicSearchResult3{}
+
+	if searchArgs[0].Limit > 0 {
+		artists, _ := sh.library.SearchArtists(searchArgs[0])
+
+		for _, artist := range artists {
+			result.Artists = append(result.Artists, subsonicArtist{
+				ID:         subsonicID(subsonicArtistPrefix, artist.ID),
+				Name:       artist.Name,
+				AlbumCount: len(sh.library.GetArtistAlbums(artist.ID)),
+			})
+		}
+	}
+
+	if searchArgs[1].Limit > 0 {
+		albums, _ := sh.library.SearchAlbums(searchArgs[1])
+
+		for _, album := range albums {
+			result.Albums = append(result.Albums, toSubsonicAlbum(album))
+		}
+	}
+
+	if searchArgs[2].Limit > 0 {
+		tracks, _ := sh.library.Search(searchArgs[2])
+
+		for _, track := range tracks {
+			result.Songs = append(result.Songs, sh.toSubsonicSong(track))
+		}
+	}
+
+	resp := newSubsonicResponse()
+	resp.SearchResult3 = result
+	return resp, nil
+}
+
+// stream serves the media file of a song. With the "format" and "maxBitRate"
+// parameters it is transcoded the same way /file/ does it. The format "raw" means
+// the original file.
+func (sh SubsonicHandler) stream(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	id, err := sh.requiredID(req, subsonicTrackPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+
+	if format := req.Form.Get("format"); format != "" && format != "raw" {
+		query.Set("format", format)
+	}
+
+	maxBitRate, err := subsonicUintParam(req, "maxBitRate", 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Bit rates bigger than the maximum which could be transcoded are as good as
+	// no limit at all.
+	if maxBitRate > 0 && maxBitRate <= maxTranscodingBitrate {
+		query.Set("bitrate", strconv.FormatUint(uint64(maxBitRate), 10))
+	}
+
+	sh.files.ServeHTTP(writer, subsonicSubRequest(req, id, query))
+	return nil, nil
+}
+
+// download serves the original media file of a song.
+func (sh SubsonicHandler) download(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	id, err := sh.requiredID(req, subsonicTrackPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sh.files.ServeHTTP(writer, subsonicSubRequest(req, id, url.Values{}))
+	return nil, nil
+}
+
+// getCoverArt serves the cover of an album. Songs' IDs are accepted as well and
+// their album's cover is returned.
+func (sh SubsonicHandler) getCoverArt(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*subsonicResponse, error) {
+	subID := req.Form.Get("id")
+
+	if subID == "" {
+		return nil, newSubsonicError(subsonicErrMissingParam,
+			"Required parameter is missing: id")
+	}
+
+	albumID, ok := parseSubsonicID(subsonicAlbumPrefix, subID)
+
+	if strings.HasPrefix(subID, subsonicTrackPrefix) {
+		var trackID int64
+		trackID, ok = parseSubsonicID(subsonicTrackPrefix, subID)
+
+		if track, err := sh.library.GetTrack(trackID); ok && err == nil {
+			albumID = track.AlbumID
+		} else {
+			ok = false
+		}
+	}
+
+	if !ok {
+		return nil, newSubsonicError(subsonicErrNotFound, "Cover art not found")
+	}
+
+	query := url.Values{}
+
+	size, err := subsonicUintParam(req, "size", 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Bigger thumbnails than the maximum are served with the original cover.
+	if size > 0 && size <= maxThumbnailSize {
+		query.Set("size", strconv.FormatUint(uint64(size), 10))
+	}
+
+	sh.covers.ServeHTTP(writer, subsonicSubRequest(req, albumID, query))
+	return nil, nil
+}
+
+// requiredID returns the library ID from the "id" parameter of the request. prefix
+// is the prefix of the kind of IDs which are expected.
+func (sh SubsonicHandler) requiredID(req *http.Request, prefix string) (int64, error) {
+	subID := req.Form.Get("id")
+
+	if subID == "" {
+		return 0, newSubsonicError(subsonicErrMissingParam,
+			"Required parameter is missing: id")
+	}
+
+	id, ok := parseSubsonicID(prefix, subID)
+
+	if !ok {
+		return 0, newSubsonicError(subsonicErrNotFound, "Not found: %s", subID)
+	}
+
+	return id, nil
+}
+
+// toSubsonicSong converts a library track to a Subsonic song. The size and type of
+// the media file are read from the file system. The path is made up of the artist,
+// album and file name so that the real location of the file is not revealed.
+func (sh SubsonicHandler) toSubsonicSong(track library.SearchResult) subsonicSong {
+	albumID := subsonicID(subsonicAlbumPrefix, track.AlbumID)
+	filePath := sh.library.GetFilePath(track.ID)
+	suffix := strings.ToLower(strings.TrimPrefix(filepath.Ext(filePath), "."))
+
+	song := subsonicSong{
+		ID:          subsonicID(subsonicTrackPrefix, track.ID),
+		Parent:      albumID,
+		Title:       track.Title,
+		Album:       track.Album,
+		Artist:      track.Artist,
+		Track:       track.TrackNumber,
+		Year:        track.Year,
+		Genre:       track.Genre,
+		CoverArt:    albumID,
+		ContentType: mime.TypeByExtension("." + suffix),
+		Suffix:      suffix,
+		Duration:    track.Duration / 1000,
+		Path: fmt.Sprintf("%s/%s/%s", track.Artist, track.Album,
+			filepath.Base(filePath)),
+		DiscNumber: track.DiscNumber,
+		AlbumID:    albumID,
+		ArtistID:   subsonicID(subsonicArtistPrefix, track.ArtistID),
+		Type:       "music",
+	}
+
+	if song.ContentType == "" {
+		song.ContentType = "application/octet-stream"
+	}
+
+	if st, err := os.Stat(filePath); err == nil {
+		song.Size = st.Size()
+	}
+
+	return song
+}
+
+// toSubsonicAlbum converts a library album to a Subsonic album without songs.
+func toSubsonicAlbum(album library.Album) subsonicAlbum {
+	albumID := subsonicID(subsonicAlbumPrefix, album.ID)
+
+	return subsonicAlbum{
+		ID:        albumID,
+		Name:      album.Name,
+		Artist:    album.Artist,
+		CoverArt:  albumID,
+		SongCount: album.SongCount,
+		Duration:  album.Duration / 1000,
+		Year:      album.Year,
+	}
+}
+
+// subsonicSortName returns the artist name without the ignored articles in its
+// beginning.
+func subsonicSortName(name string) string {
+	for _, article := range subsonicIgnoredArticles {
+		prefix := article + " "
+
+		if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return name[len(prefix):]
+		}
+	}
+
+	return name
+}
+
+// subsonicIndexName returns the name of the index in which the artist belongs. It
+// is the first letter of its name. Names which do not start with a letter are in
+// the "#" index.
+func subsonicIndexName(name string) string {
+	for _, r := range subsonicSortName(name) {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+
+	return "#"
+}
+
+// subsonicUintParam returns the value of an optional non-negative integer parameter.
+func subsonicUintParam(req *http.Request, name string, defaultValue uint) (uint, error) {
+	value := req.Form.Get(name)
+
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, 32)
+
+	if err != nil {
+		return 0, newSubsonicError(subsonicErrGeneric,
+			"%s must be a non-negative integer", name)
+	}
+
+	return uint(parsed), nil
+}
+
+// subsonicSubRequest returns a copy of the request for another handler. Its path is
+// the library ID and its query is replaced.
+func subsonicSubRequest(req *http.Request, id int64, query url.Values) *http.Request {
+	subReq := req.WithContext(req.Context())
+
+	subURL := *req.URL
+	subURL.Path = strconv.FormatInt(id, 10)
+	subURL.RawQuery = query.Encode()
+
+	subReq.URL = &subURL
+	subReq.Form = nil
+
+	return subReq
+}
+
+// NewSubsonicHandler returns a new Subsonic API handler. Clients are authenticated
+// with authenticator unless it is nil. Clients with too many wrong credentials are
+// blocked by throttle. Media files are served by files and album covers by covers.
+func NewSubsonicHandler(
+	lib library.Library,
+	authenticator *auth.Authenticator,
+	throttle *auth.Throttle,
+	files http.Handler,
+	covers http.Handler,
+) *SubsonicHandler {
+	sh := new(SubsonicHandler)
+	sh.library = lib
+	sh.authenticator = authenticator
+	sh.throttle = throttle
+	sh.files = files
+	sh.covers = covers
+	return sh
+}
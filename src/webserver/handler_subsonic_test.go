@@ -0,0 +1,431 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// subsonicGet calls a Subsonic API method of the test server and returns the
+// response and its body.
+func subsonicGet(
+	t *testing.T,
+	method string,
+	params url.Values,
+) (*http.Response, []byte) {
+	reqURL := fmt.Sprintf("http://127.0.0.1:%d/rest/%s?%s", TestPort, method,
+		params.Encode())
+
+	resp, err := http.Get(reqURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status code %d for %s", resp.StatusCode, method)
+	}
+
+	return resp, body
+}
+
+// subsonicGetJSON calls a Subsonic API method and decodes its JSON response.
+func subsonicGetJSON(
+	t *testing.T,
+	method string,
+	params url.Values,
+) *subsonicResponse {
+	params.Set("f", "json")
+	_, body := subsonicGet(t, method, params)
+
+	var decoded struct {
+		Response *subsonicResponse `json:"subsonic-response"`
+	}
+
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("Decoding %s response failed: %s\n%s", method, err, body)
+	}
+
+	if decoded.Response == nil {
+		t.Fatalf("There was no subsonic-response in %s", body)
+	}
+
+	return decoded.Response
+}
+
+func subsonicCredentials() url.Values {
+	return url.Values{
+		"u": []string{"subsonic"},
+		"p": []string{"secret"},
+		"v": []string{"1.16.1"},
+		"c": []string{"test"},
+	}
+}
+
+func getSubsonicServer(t *testing.T) (*Server, library.Library) {
+	return getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{User: "subsonic", Password: "secret"}
+	})
+}
+
+func TestSubsonicAuthentication(t *testing.T) {
+	srv, lib := getSubsonicServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	hash, _ := auth.HashPassword("alicepass")
+
+	if _, err := lib.CreateUser("alice", hash, library.RoleListener); err != nil {
+		t.Fatal(err)
+	}
+
+	salt := "c19b2d"
+	tokenSum := md5.Sum([]byte("secret" + salt))
+	token := hex.EncodeToString(tokenSum[:])
+	encoded := "enc:" + hex.EncodeToString([]byte("secret"))
+	aliceSum := md5.Sum([]byte("alicepass" + salt))
+	aliceToken := hex.EncodeToString(aliceSum[:])
+	aliceEncoded := "enc:" + hex.EncodeToString([]byte("alicepass"))
+
+	tests := []struct {
+		desc      string
+		params    url.Values
+		errorCode int // zero for successful authentication
+	}{
+		{"plain password", url.Values{"u": {"subsonic"}, "p": {"secret"}}, 0},
+		{"encoded password", url.Values{"u": {"subsonic"}, "p": {encoded}}, 0},
+		{"token", url.Values{"u": {"subsonic"}, "t": {token}, "s": {salt}}, 0},
+		{"wrong password", url.Values{"u": {"subsonic"}, "p": {"wrong"}}, 40},
+		{"wrong user", url.Values{"u": {"someone"}, "p": {"secret"}}, 40},
+		{"wrong token", url.Values{"u": {"subsonic"}, "t": {token}, "s": {"salt"}}, 40},
+		{"malformed password", url.Values{"u": {"subsonic"}, "p": {"enc:zz"}}, 40},
+		{"user password", url.Values{"u": {"alice"}, "p": {"alicepass"}}, 0},
+		{"encoded user password", url.Values{"u": {"alice"}, "p": {aliceEncoded}}, 0},
+		{"wrong user password", url.Values{"u": {"alice"}, "p": {"secret"}}, 40},
+
+		// Only the configuration user could use tokens.
+		{"user token", url.Values{"u": {"alice"}, "t": {aliceToken}, "s": {salt}}, 40},
+		{"missing user", url.Values{"p": {"secret"}}, 10},
+		{"missing password", url.Values{"u": {"subsonic"}}, 10},
+		{"missing salt", url.Values{"u": {"subsonic"}, "t": {"abc"}}, 10},
+	}
+
+	for _, test := range tests {
+		resp := subsonicGetJSON(t, "ping.view", test.params)
+
+		if test.errorCode == 0 {
+			if resp.Status != "ok" || resp.Error != nil {
+				t.Errorf("Authentication with %s failed: %+v", test.desc, resp.Error)
+			}
+			continue
+		}
+
+		if resp.Status != "failed" || resp.Error == nil {
+			t.Errorf("Expected authentication with %s to fail", test.desc)
+			continue
+		}
+
+		if resp.Error.Code != test.errorCode {
+			t.Errorf("Expected error code %d for %s but it was %d", test.errorCode,
+				test.desc, resp.Error.Code)
+		}
+	}
+}
+
+func TestSubsonicXMLResponses(t *testing.T) {
+	srv, lib := getSubsonicServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	resp, body := subsonicGet(t, "ping", subsonicCredentials())
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "text/xml") {
+		t.Errorf("Wrong content type of XML response: %s", contentType)
+	}
+
+	var pong subsonicResponse
+	if err := xml.Unmarshal(body, &pong); err != nil {
+		t.Fatalf("Decoding ping response failed: %s\n%s", err, body)
+	}
+
+	if pong.Status != "ok" || pong.Version != subsonicAPIVersion {
+		t.Errorf("Unexpected ping response: %s", body)
+	}
+
+	if !bytes.Contains(body, []byte(`xmlns="http://subsonic.org/restapi"`)) {
+		t.Errorf("The Subsonic namespace was missing in %s", body)
+	}
+
+	_, body = subsonicGet(t, "noSuchMethod.view", subsonicCredentials())
+
+	var failed subsonicResponse
+	if err := xml.Unmarshal(body, &failed); err != nil {
+		t.Fatalf("Decoding error response failed: %s\n%s", err, body)
+	}
+
+	if failed.Status != "failed" || failed.Error == nil || failed.Error.Code != 70 {
+		t.Errorf("Unexpected response for an unknown method: %s", body)
+	}
+}
+
+func TestSubsonicBrowsing(t *testing.T) {
+	srv, lib := getSubsonicServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	folders := subsonicGetJSON(t, "getMusicFolders", subsonicCredentials())
+
+	if folders.MusicFolders == nil || len(folders.MusicFolders.Folders) != 1 {
+		t.Errorf("Expected a single music folder but got %+v", folders.MusicFolders)
+	}
+
+	artists := subsonicGetJSON(t, "getArtists", subsonicCredentials())
+
+	if artists.Artists == nil {
+		t.Fatalf("There were no artists in the response")
+	}
+
+	var bugoff *subsonicArtist
+	for _, index := range artists.Artists.Indexes {
+		for ind, artist := range index.Artists {
+			if subsonicIndexName(artist.Name) != index.Name {
+				t.Errorf("Artist %s was in index %s", artist.Name, index.Name)
+			}
+
+			if artist.Name == "Buggy Bugoff" {
+				bugoff = &index.Artists[ind]
+			}
+		}
+	}
+
+	if bugoff == nil {
+		t.Fatalf("Buggy Bugoff was not among the artists: %+v", artists.Artists)
+	}
+
+	if bugoff.AlbumCount != 1 {
+		t.Errorf("Expected Buggy Bugoff to have one album but it had %d",
+			bugoff.AlbumCount)
+	}
+
+	params := subsonicCredentials()
+	params.Set("id", bugoff.ID)
+	artist := subsonicGetJSON(t, "getArtist", params)
+
+	if artist.Artist == nil || len(artist.Artist.Albums) != 1 {
+		t.Fatalf("Expected Buggy Bugoff with one album but got %+v", artist.Artist)
+	}
+
+	album := artist.Artist.Albums[0]
+
+	if album.Name != "Return Of The Bugs" || album.ArtistID != bugoff.ID ||
+		album.SongCount != 1 {
+		t.Errorf("Wrong album of Buggy Bugoff: %+v", album)
+	}
+
+	params.Set("id", album.ID)
+	albumResp := subsonicGetJSON(t, "getAlbum", params)
+
+	if albumResp.Album == nil || len(albumResp.Album.Songs) != 1 {
+		t.Fatalf("Expected Return Of The Bugs with one song but got %+v",
+			albumResp.Album)
+	}
+
+	song := albumResp.Album.Songs[0]
+
+	if song.Title != "Payback" || song.AlbumID != album.ID ||
+		song.ArtistID != bugoff.ID {
+		t.Errorf("Wrong song in Return Of The Bugs: %+v", song)
+	}
+
+	if song.Suffix != "mp3" || song.Size != 17314 || song.Path !=
+		"Buggy Bugoff/Return Of The Bugs/third_file.mp3" {
+		t.Errorf("Wrong file information for Payback: %+v", song)
+	}
+
+	params.Set("id", song.ID)
+	songResp := subsonicGetJSON(t, "getSong", params)
+
+	if songResp.Song == nil || *songResp.Song != song {
+		t.Errorf("Expected getSong to return %+v but got %+v", song, songResp.Song)
+	}
+
+	for _, method := range []string{"getArtist", "getAlbum", "getSong"} {
+		params.Set("id", "666")
+		resp := subsonicGetJSON(t, method, params)
+
+		if resp.Error == nil || resp.Error.Code != 70 {
+			t.Errorf("Expected not found error for %s but got %+v", method, resp.Error)
+		}
+
+		params.Del("id")
+		resp = subsonicGetJSON(t, method, params)
+
+		if resp.Error == nil || resp.Error.Code != 10 {
+			t.Errorf("Expected missing parameter error for %s but got %+v", method,
+				resp.Error)
+		}
+	}
+}
+
+func TestSubsonicSearch(t *testing.T) {
+	srv, lib := getSubsonicServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	params := subsonicCredentials()
+	params.Set("query", "bugs")
+	resp := subsonicGetJSON(t, "search3", params)
+
+	if resp.SearchResult3 == nil {
+		t.Fatalf("There was no searchResult3 in the response")
+	}
+
+	result := resp.SearchResult3
+
+	if len(result.Artists) != 0 {
+		t.Errorf("Expected no artists named bugs but got %+v", result.Artists)
+	}
+
+	if len(result.Albums) != 1 || result.Albums[0].Name != "Return Of The Bugs" {
+		t.Errorf("Expected to find album Return Of The Bugs but got %+v",
+			result.Albums)
+	}
+
+	if len(result.Songs) != 1 || result.Songs[0].Title != "Payback" {
+		t.Errorf("Expected to find song Payback but got %+v", result.Songs)
+	}
+
+	params.Set("query", `""`)
+	params.Set("songCount", "2")
+	params.Set("songOffset", "1")
+	params.Set("albumCount", "0")
+	resp = subsonicGetJSON(t, "search3", params)
+
+	if len(resp.SearchResult3.Songs) != 2 {
+		t.Errorf("Expected two songs for an empty query but got %d",
+			len(resp.SearchResult3.Songs))
+	}
+
+	if len(resp.SearchResult3.Albums) != 0 {
+		t.Errorf("Expected no albums with albumCount=0 but got %d",
+			len(resp.SearchResult3.Albums))
+	}
+
+	if len(resp.SearchResult3.Artists) != 2 {
+		t.Errorf("Expected two artists for an empty query but got %d",
+			len(resp.SearchResult3.Artists))
+	}
+
+	params.Set("songCount", "many")
+	resp = subsonicGetJSON(t, "search3", params)
+
+	if resp.Status != "failed" {
+		t.Errorf("Expected search with malformed songCount to fail")
+	}
+}
+
+func TestSubsonicMediaFiles(t *testing.T) {
+	srv, lib := getSubsonicServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
+
+	if len(found) != 1 {
+		t.Fatalf("Problem finding Buggy Bugoff test track")
+	}
+
+	projRoot, _ := getProjectRoot()
+	original, err := ioutil.ReadFile(
+		filepath.Join(projRoot, "test_files", "library", "folder_one", "third_file.mp3"),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cover, err := ioutil.ReadFile(
+		filepath.Join(projRoot, "test_files", "library", "folder_one", "cover.png"),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method   string
+		id       string
+		expected []byte
+	}{
+		{"stream", subsonicID(subsonicTrackPrefix, found[0].ID), original},
+		{"stream.view", fmt.Sprintf("%d", found[0].ID), original},
+		{"download", subsonicID(subsonicTrackPrefix, found[0].ID), original},
+		{"getCoverArt", subsonicID(subsonicAlbumPrefix, found[0].AlbumID), cover},
+		{"getCoverArt", subsonicID(subsonicTrackPrefix, found[0].ID), cover},
+	}
+
+	for _, test := range tests {
+		params := subsonicCredentials()
+		params.Set("id", test.id)
+
+		// There is no transcoding profile so the original file is returned.
+		params.Set("format", "ogg")
+		params.Set("maxBitRate", "128")
+
+		_, body := subsonicGet(t, test.method, params)
+
+		if !bytes.Equal(body, test.expected) {
+			t.Errorf("Wrong body for %s with id %s. It was %d bytes instead of %d",
+				test.method, test.id, len(body), len(test.expected))
+		}
+	}
+
+	params := url.Values{"u": {"subsonic"}, "p": {"wrong"}, "id": {"1"}}
+	_, body := subsonicGet(t, "download", params)
+
+	if bytes.Equal(body, original) {
+		t.Errorf("A file was downloaded with a wrong password")
+	}
+}
+
+func TestSubsonicIndexNames(t *testing.T) {
+	tests := map[string]string{
+		"Buggy Bugoff":    "B",
+		"the Beatles":     "B",
+		"Los Lobos":       "L",
+		"Les":             "L",
+		"élan":            "É",
+		"Кино":            "К",
+		"2Pac":            "#",
+		"...And You Will": "#",
+	}
+
+	for name, expected := range tests {
+		if found := subsonicIndexName(name); found != expected {
+			t.Errorf("Expected %s to be in index %s but it was in %s", name, expected,
+				found)
+		}
+	}
+}
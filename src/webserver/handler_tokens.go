@@ -0,0 +1,190 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+// TokensHandler is a http.Handler which manages the API tokens of the authenticated
+// user. Headless clients send them in an "Authorization: Bearer" header instead of
+// the user's password. Its paths are relative to its mount point:
+//
+//	GET    /      - lists the tokens of the user
+//	POST   /      - creates a token, body: {"label": "Phone", "read_only": false}
+//	DELETE /{id}  - revokes a token
+//
+// The token itself is returned only in the response of the create request. Only
+// its hash is stored. Read-only tokens could be used only for GET and HEAD requests.
+// Tokens are managed only by users authenticated with their password or session.
+// Otherwise a stolen token could be used for creating new ones which would keep
+// working after it has been revoked.
+type TokensHandler struct {
+	library library.Library
+}
+
+// tokenRequest is the body of the requests which create API tokens.
+type tokenRequest struct {
+	Label    string `json:"label"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// createdToken is the response for a created API token.
+type createdToken struct {
+	library.APIToken
+	Token string `json:"token"`
+}
+
+// ServeHTTP is required by the http.Handler's interface
+func (th TokensHandler) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	InternalErrorOnErrorHandler(writer, req, th.serve)
+}
+
+// serve dispatches the request according to its path and method.
+func (th TokensHandler) serve(writer http.ResponseWriter, req *http.Request) error {
+	writer.Header().Add("Content-Type", "application/json; charset=utf-8")
+
+	user := requestUser(req)
+
+	if user == nil {
+		th.writeError(writer, http.StatusNotFound, "API tokens require authentication")
+		return nil
+	}
+
+	if requestAPIToken(req) != nil {
+		th.writeError(writer, http.StatusForbidden,
+			"API tokens could not be managed with an API token")
+		return nil
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+
+	if path == "" {
+		switch req.Method {
+		case http.MethodGet:
+			return th.list(writer, user)
+		case http.MethodPost:
+			return th.create(writer, req, user)
+		}
+		return th.methodNotAllowed(writer, "GET, POST")
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+
+	if err != nil {
+		th.writeError(writer, http.StatusNotFound, "Not found")
+		return nil
+	}
+
+	if req.Method != http.MethodDelete {
+		return th.methodNotAllowed(writer, "DELETE")
+	}
+
+	err = th.library.DeleteAPIToken(user.ID, id)
+
+	if err == library.ErrAPITokenNotFound {
+		th.writeError(writer, http.StatusNotFound, err.Error())
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (th TokensHandler) list(writer http.ResponseWriter, user *library.User) error {
+	tokens := th.library.GetAPITokens(user.ID)
+
+	if tokens == nil {
+		tokens = []library.APIToken{}
+	}
+
+	return th.writeJSON(writer, http.StatusOK, tokens)
+}
+
+func (th TokensHandler) create(
+	writer http.ResponseWriter,
+	req *http.Request,
+	user *library.User,
+) error {
+	var body tokenRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		th.writeError(writer, http.StatusBadRequest,
+			fmt.Sprintf("Malformed JSON body: %s", err))
+		return nil
+	}
+
+	if strings.TrimSpace(body.Label) == "" {
+		th.writeError(writer, http.StatusBadRequest, `"label" must not be empty`)
+		return nil
+	}
+
+	token, hash, err := auth.NewAPIToken()
+
+	if err != nil {
+		return err
+	}
+
+	created, err := th.library.CreateAPIToken(user.ID, body.Label, hash, body.ReadOnly)
+
+	if err != nil {
+		return err
+	}
+
+	return th.writeJSON(writer, http.StatusCreated, createdToken{
+		APIToken: *created,
+		Token:    token,
+	})
+}
+
+func (th TokensHandler) writeJSON(
+	writer http.ResponseWriter,
+	status int,
+	data interface{},
+) error {
+	marshalled, err := json.Marshal(data)
+
+	if err != nil {
+		return err
+	}
+
+	writer.WriteHeader(status)
+	_, err = writer.Write(marshalled)
+	return err
+}
+
+func (th TokensHandler) methodNotAllowed(writer http.ResponseWriter, allow string) error {
+	writer.Header().Set("Allow", allow)
+	th.writeError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+	return nil
+}
+
+func (th TokensHandler) writeError(writer http.ResponseWriter, status int, message string) {
+	writer.WriteHeader(status)
+	msgJSON, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{
+		Error: message,
+	})
+	if _, err := writer.Write(msgJSON); err != nil {
+		log.Printf("error writing body in tokens handler: %s", err)
+	}
+}
+
+// NewTokensHandler returns a new Tokens handler. It needs a library in which the
+// API tokens are stored.
+func NewTokensHandler(lib library.Library) *TokensHandler {
+	th := new(TokensHandler)
+	th.library = lib
+	return th
+}
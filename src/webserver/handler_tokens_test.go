@@ -0,0 +1,149 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ironsmile/httpms/src/auth"
+	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/library"
+)
+
+func TestAPITokens(t *testing.T) {
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{
+			User:     "testuser",
+			Password: "testpass",
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	passwords := map[string]string{
+		"alice": "alicepass",
+		"bob":   "bobpass",
+	}
+
+	for name, password := range passwords {
+		hash, _ := auth.HashPassword(password)
+
+		if _, err := lib.CreateUser(name, hash, library.RoleListener); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// request makes a request with a bearer token, with the password of bob when
+	// token is "bob" or with the password of alice when token is empty.
+	request := func(method, path, token, body string, expectedStatus int) []byte {
+		req, _ := http.NewRequest(method,
+			fmt.Sprintf("http://127.0.0.1:%d%s", TestPort, path),
+			strings.NewReader(body))
+
+		switch token {
+		case "":
+			req.SetBasicAuth("alice", passwords["alice"])
+		case "bob":
+			req.SetBasicAuth("bob", passwords["bob"])
+		default:
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		response, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected status %d for %s %s but got %d: %s", expectedStatus,
+				method, path, resp.StatusCode, response)
+		}
+
+		return response
+	}
+
+	create := func(label string, readOnly bool) createdToken {
+		var created createdToken
+		body := fmt.Sprintf(`{"label": %q, "read_only": %t}`, label, readOnly)
+
+		if err := json.Unmarshal(request("POST", "/tokens/", "", body,
+			http.StatusCreated), &created); err != nil {
+			t.Fatal(err)
+		}
+
+		if created.Token == "" || created.ID == 0 || created.Label != label ||
+			created.ReadOnly != readOnly {
+			t.Fatalf("Unexpected created token: %+v", created)
+		}
+
+		return created
+	}
+
+	request("POST", "/tokens/", "", `{"label": ""}`, http.StatusBadRequest)
+
+	phone := create("Phone", false)
+	speaker := create("Speaker", true)
+
+	request("GET", "/search/?q=Payback", phone.Token, "", http.StatusOK)
+	request("GET", "/search/?q=Payback", speaker.Token, "", http.StatusOK)
+	request("GET", "/search/?q=Payback", "wrong", "", http.StatusUnauthorized)
+
+	request("POST", "/playlists/", phone.Token, `{"name": "Mine"}`,
+		http.StatusCreated)
+	request("POST", "/playlists/", speaker.Token, `{"name": "Mine"}`,
+		http.StatusForbidden)
+
+	// Tokens cannot be managed with tokens. Otherwise a stolen token could be used
+	// for creating a new one which keeps working after the stolen one is revoked.
+	request("POST", "/tokens/", phone.Token, `{"label": "More"}`,
+		http.StatusForbidden)
+	request("POST", "/tokens/", speaker.Token, `{"label": "More"}`,
+		http.StatusForbidden)
+	request("GET", "/tokens/", phone.Token, "", http.StatusForbidden)
+	request("DELETE", fmt.Sprintf("/tokens/%d", speaker.ID), phone.Token, "",
+		http.StatusForbidden)
+
+	var tokens []library.APIToken
+
+	if err := json.Unmarshal(request("GET", "/tokens/", "", "",
+		http.StatusOK), &tokens); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 2 || tokens[0].Label != "Phone" || tokens[0].LastUsed == 0 ||
+		tokens[1].Label != "Speaker" {
+		t.Errorf("Expected the phone and speaker tokens but got %+v", tokens)
+	}
+
+	if strings.Contains(string(request("GET", "/tokens/", "", "", http.StatusOK)),
+		phone.Token) {
+		t.Errorf("Expected the tokens to be listed without their secrets")
+	}
+
+	// The tokens of other users cannot be revoked.
+	request("DELETE", fmt.Sprintf("/tokens/%d", phone.ID), "bob", "",
+		http.StatusNotFound)
+	request("DELETE", fmt.Sprintf("/tokens/%d", phone.ID), "", "",
+		http.StatusNoContent)
+	request("GET", "/search/?q=Payback", phone.Token, "", http.StatusUnauthorized)
+	request("GET", "/search/?q=Payback", speaker.Token, "", http.StatusOK)
+
+	if err := lib.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	request("GET", "/search/?q=Payback", speaker.Token, "", http.StatusUnauthorized)
+}
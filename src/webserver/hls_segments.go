@@ -0,0 +1,162 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// hlsSegmentCache stores the HLS segments cut by the encoder on disk so that a track
+// is transcoded only once no matter how many times it is played. Segments which
+// have not been requested for a while are removed.
+type hlsSegmentCache struct {
+
+	// dir is the directory in which segments are stored. When empty they are cut
+	// anew for every request.
+	dir string
+
+	// maxAge is the time after the last request for a segment after which it is
+	// removed.
+	maxAge time.Duration
+}
+
+// segmentKey identifies a single segment in the cache.
+type segmentKey struct {
+	trackID int64
+
+	// modTime is the modification time of the track's file. Segments of older
+	// versions of the file are never used.
+	modTime time.Time
+
+	bitrate         int
+	segmentDuration time.Duration
+	index           int
+}
+
+// get returns the stored segment. Every successful call postpones the removal of
+// the segment. Returns nil when the segment is not in the cache.
+func (sc hlsSegmentCache) get(key segmentKey) []byte {
+	if sc.dir == "" {
+		return nil
+	}
+
+	segmentPath := sc.path(key)
+	data, err := ioutil.ReadFile(segmentPath)
+
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(segmentPath, now, now); err != nil {
+		log.Printf("Error touching HLS segment %s: %s\n", segmentPath, err)
+	}
+
+	return data
+}
+
+// store saves the segment in the cache directory. The file is written under a
+// temporary name first so that concurrent requests never see it half-written.
+func (sc hlsSegmentCache) store(key segmentKey, data []byte) error {
+	if sc.dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(sc.dir, 0750); err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(sc.dir, "segment_")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(data)
+
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), sc.path(key))
+}
+
+// path returns the file path of a segment in the cache directory.
+func (sc hlsSegmentCache) path(key segmentKey) string {
+	return filepath.Join(sc.dir, fmt.Sprintf("%d-%d-%d-%d-%d.ts", key.trackID,
+		key.modTime.Unix(), key.bitrate, key.segmentDuration/time.Millisecond,
+		key.index))
+}
+
+// cleanUp removes all files in the cache directory which have not been used since
+// maxAge before now. Returns the number of removed files.
+func (sc hlsSegmentCache) cleanUp(now time.Time) (int, error) {
+	if sc.dir == "" || sc.maxAge <= 0 {
+		return 0, nil
+	}
+
+	entries, err := ioutil.ReadDir(sc.dir)
+
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || now.Sub(entry.ModTime()) < sc.maxAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(sc.dir, entry.Name())); err != nil {
+			log.Printf("Error removing old HLS segment: %s\n", err)
+			continue
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+// cleanUpRoutine calls cleanUp periodically until ctx is canceled.
+func (sc hlsSegmentCache) cleanUpRoutine(ctx context.Context) {
+	if sc.dir == "" || sc.maxAge <= 0 {
+		return
+	}
+
+	interval := sc.maxAge / 2
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			removed, err := sc.cleanUp(now)
+
+			if err != nil {
+				log.Printf("Error cleaning up HLS segments: %s\n", err)
+			} else if removed > 0 {
+				log.Printf("Removed %d old HLS segments\n", removed)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package webserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHLSSegmentCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpms_segments_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	cache := hlsSegmentCache{dir: filepath.Join(dir, "segments"), maxAge: time.Hour}
+	modTime := time.Now()
+
+	oldKey := segmentKey{trackID: 1, modTime: modTime, bitrate: 128,
+		segmentDuration: 10 * time.Second, index: 0}
+	newKey := oldKey
+	newKey.index = 1
+
+	if found := cache.get(oldKey); found != nil {
+		t.Errorf("Expected an empty cache but found %s", found)
+	}
+
+	for _, key := range []segmentKey{oldKey, newKey} {
+		if err := cache.store(key, []byte("segment")); err != nil {
+			t.Fatalf("Storing a segment failed: %s", err)
+		}
+	}
+
+	if found := cache.get(oldKey); !bytes.Equal(found, []byte("segment")) {
+		t.Errorf("Expected the stored segment but found `%s`", found)
+	}
+
+	changedKey := oldKey
+	changedKey.modTime = modTime.Add(time.Minute)
+
+	if found := cache.get(changedKey); found != nil {
+		t.Errorf("Segment of a changed file was found in the cache")
+	}
+
+	longAgo := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(cache.path(oldKey), longAgo, longAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := cache.cleanUp(time.Now())
+
+	if err != nil {
+		t.Fatalf("Cleaning up failed: %s", err)
+	}
+
+	if removed != 1 {
+		t.Errorf("Expected one segment to be removed but %d were", removed)
+	}
+
+	if found := cache.get(oldKey); found != nil {
+		t.Errorf("Old segment was not removed")
+	}
+
+	if found := cache.get(newKey); found == nil {
+		t.Errorf("Recently used segment was removed")
+	}
+
+	// Getting a segment postpones its removal.
+	if err := os.Chtimes(cache.path(newKey), longAgo, longAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.get(newKey)
+
+	if removed, _ := cache.cleanUp(time.Now()); removed != 0 {
+		t.Errorf("Expected the used segment to be kept but %d were removed", removed)
+	}
+}
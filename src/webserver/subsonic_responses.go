@@ -0,0 +1,158 @@
+package webserver
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// subsonicAPIVersion is the version of the Subsonic REST API which is implemented.
+const subsonicAPIVersion = "1.16.1"
+
+// Prefixes of the different kinds of IDs in the Subsonic API. Artists, albums and
+// tracks have separate IDs in the library so they are told apart by a prefix.
+const (
+	subsonicArtistPrefix = "ar-"
+	subsonicAlbumPrefix  = "al-"
+	subsonicTrackPrefix  = "tr-"
+)
+
+// Error codes from the Subsonic API specification.
+const (
+	subsonicErrGeneric         = 0
+	subsonicErrMissingParam    = 10
+	subsonicErrWrongCredential = 40
+	subsonicErrNotFound        = 70
+)
+
+// subsonicResponse is the root element of every response of the Subsonic API. It is
+// encoded both as XML and as JSON. Only one of the pointer fields is set for any
+// particular response.
+type subsonicResponse struct {
+	XMLName xml.Name `xml:"subsonic-response" json:"-"`
+	XMLNS   string   `xml:"xmlns,attr" json:"-"`
+	Status  string   `xml:"status,attr" json:"status"`
+	Version string   `xml:"version,attr" json:"version"`
+
+	Error         *subsonicError         `xml:"error,omitempty" json:"error,omitempty"`
+	MusicFolders  *subsonicMusicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Artists       *subsonicArtists       `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *subsonicArtist        `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *subsonicAlbum         `xml:"album,omitempty" json:"album,omitempty"`
+	Song          *subsonicSong          `xml:"song,omitempty" json:"song,omitempty"`
+	SearchResult3 *subsonicSearchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+}
+
+// newSubsonicResponse returns a successful response without any content.
+func newSubsonicResponse() *subsonicResponse {
+	return &subsonicResponse{
+		XMLNS:   "http://subsonic.org/restapi",
+		Status:  "ok",
+		Version: subsonicAPIVersion,
+	}
+}
+
+// subsonicError is both the error element of failed responses and an error which
+// could be returned by the Subsonic API methods.
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+// Error satisfies the error interface.
+func (se *subsonicError) Error() string {
+	return se.Message
+}
+
+// newSubsonicError returns an error with this Subsonic error code and message.
+func newSubsonicError(code int, format string, args ...interface{}) *subsonicError {
+	return &subsonicError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type subsonicMusicFolders struct {
+	Folders []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicArtists struct {
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Indexes         []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name    string           `xml:"name,attr" json:"name"`
+	Artists []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string          `xml:"id,attr" json:"id"`
+	Name       string          `xml:"name,attr" json:"name"`
+	AlbumCount int             `xml:"albumCount,attr" json:"albumCount"`
+	Albums     []subsonicAlbum `xml:"album,omitempty" json:"album,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID        string         `xml:"id,attr" json:"id"`
+	Name      string         `xml:"name,attr" json:"name"`
+	Artist    string         `xml:"artist,attr" json:"artist"`
+	ArtistID  string         `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string         `xml:"coverArt,attr" json:"coverArt"`
+	SongCount int64          `xml:"songCount,attr" json:"songCount"`
+	Duration  int64          `xml:"duration,attr" json:"duration"` // in seconds
+	Year      int64          `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Songs     []subsonicSong `xml:"song,omitempty" json:"song,omitempty"`
+}
+
+type subsonicSong struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr" json:"parent"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr" json:"album"`
+	Artist      string `xml:"artist,attr" json:"artist"`
+	Track       int64  `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year        int64  `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string `xml:"coverArt,attr" json:"coverArt"`
+	Size        int64  `xml:"size,attr" json:"size"`
+	ContentType string `xml:"contentType,attr" json:"contentType"`
+	Suffix      string `xml:"suffix,attr" json:"suffix"`
+	Duration    int64  `xml:"duration,attr" json:"duration"` // in seconds
+	Path        string `xml:"path,attr" json:"path"`
+	DiscNumber  int64  `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	AlbumID     string `xml:"albumId,attr" json:"albumId"`
+	ArtistID    string `xml:"artistId,attr" json:"artistId"`
+	Type        string `xml:"type,attr" json:"type"`
+}
+
+type subsonicSearchResult3 struct {
+	Artists []subsonicArtist `xml:"artist" json:"artist,omitempty"`
+	Albums  []subsonicAlbum  `xml:"album" json:"album,omitempty"`
+	Songs   []subsonicSong   `xml:"song" json:"song,omitempty"`
+}
+
+// subsonicID returns the Subsonic ID of a library artist, album or track. prefix is
+// one of the subsonic*Prefix constants.
+func subsonicID(prefix string, id int64) string {
+	return prefix + strconv.FormatInt(id, 10)
+}
+
+// parseSubsonicID returns the library ID from a Subsonic ID with this prefix. IDs
+// without any prefix are accepted as well since some clients construct them on
+// their own. ok is false for IDs of other kinds.
+func parseSubsonicID(prefix, subsonicID string) (id int64, ok bool) {
+	idText := strings.TrimPrefix(subsonicID, prefix)
+
+	id, err := strconv.ParseInt(idText, 10, 64)
+
+	if err != nil || id < 0 {
+		return 0, false
+	}
+
+	return id, true
+}
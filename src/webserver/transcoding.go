@@ -0,0 +1,106 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironsmile/httpms/src/config"
+)
+
+// transcoder is a running encoder process which converts a media file using a
+// transcoding profile. The converted file is read from it while the encoder is
+// still working.
+type transcoder struct {
+	cmd    *exec.Cmd
+	output io.ReadCloser
+	stderr bytes.Buffer
+}
+
+// transcodingJob describes what a transcoder should convert.
+type transcodingJob struct {
+	filePath string
+	bitrate  int // in kbps
+
+	// start and duration select only a part of the file. They are used for cutting
+	// HLS segments and are zero when the whole file is converted.
+	start    time.Duration
+	duration time.Duration
+}
+
+// startTranscoder starts the encoder command from the profile for the job. The
+// encoder is killed when ctx is canceled.
+func startTranscoder(
+	ctx context.Context,
+	profile config.TranscodingProfile,
+	job transcodingJob,
+) (*transcoder, error) {
+	replacer := strings.NewReplacer(
+		"{input}", job.filePath,
+		"{bitrate}", strconv.Itoa(job.bitrate),
+		"{start}", formatSeconds(job.start),
+		"{duration}", formatSeconds(job.duration),
+	)
+
+	args := make([]string, 0, len(profile.Command)-1)
+	for _, arg := range profile.Command[1:] {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	tr := &transcoder{
+		cmd: exec.CommandContext(ctx, profile.Command[0], args...),
+	}
+	tr.cmd.Stderr = &tr.stderr
+
+	output, err := tr.cmd.StdoutPipe()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tr.output = output
+
+	if err := tr.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return tr, nil
+}
+
+// Read reads from the encoder's output. Satisfies the io.Reader interface.
+func (tr *transcoder) Read(p []byte) (int, error) {
+	return tr.output.Read(p)
+}
+
+// Kill stops the encoder right away. It is used when its output is no longer needed,
+// for example when the client has gone away. Otherwise the encoder would block
+// forever writing to its full output pipe. Wait must still be called after it.
+func (tr *transcoder) Kill() {
+	if err := tr.cmd.Process.Kill(); err != nil {
+		log.Printf("Error stopping encoder %s: %s\n", tr.cmd.Path, err)
+	}
+}
+
+// Wait waits for the encoder to finish. The returned error contains what the encoder
+// has written to its standard error, if anything.
+func (tr *transcoder) Wait() error {
+	err := tr.cmd.Wait()
+
+	if err != nil && tr.stderr.Len() > 0 {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(tr.stderr.String()))
+	}
+
+	return err
+}
+
+// formatSeconds returns the duration in seconds with millisecond precision, e.g.
+// "12.500". This is understood by most encoders and by HLS playlists.
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
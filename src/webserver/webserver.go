@@ -8,13 +8,20 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/ironsmile/httpms/src/auth"
 	"github.com/ironsmile/httpms/src/config"
+	"github.com/ironsmile/httpms/src/dlna"
 	"github.com/ironsmile/httpms/src/library"
 )
 
+// defaultSessionLifetime is the lifetime of the sessions when there is none in the
+// configuration.
+const defaultSessionLifetime = 30 * 24 * time.Hour
+
 // Server represends our webserver. It will be controlled from here
 type Server struct {
 	// Used for server-wide stopping, cancelation and stuff
@@ -38,6 +45,18 @@ type Server struct {
 	// This server's library with media
 	library library.Library
 
+	// Checks the credentials of the users
+	authenticator *auth.Authenticator
+
+	// The sessions of the users who have logged in with the login form
+	sessions *auth.Sessions
+
+	// Slows down and locks out clients which guess passwords
+	throttle *auth.Throttle
+
+	// Signs the URLs which could be used without authentication
+	signer *auth.URLSigner
+
 	// Makes the server lockable. This lock should be used for accessing the
 	// listener
 	sync.Mutex
@@ -63,11 +82,51 @@ func (srv *Server) serveGoroutine() {
 	mux.Handle("/", srv.withBasicAuth(http.FileServer(http.Dir(srv.cfg.HTTPRoot))))
 	searchHandler := srv.withBasicAuth(NewSearchHandler(srv.library))
 	mux.Handle("/search/", http.StripPrefix("/search/", searchHandler))
-	mux.Handle("/file/", http.StripPrefix("/file/", NewFileHandler(srv.library)))
+	signer := srv.signer
+	fileHandler := NewFileHandler(srv.library, srv.cfg.Transcoding)
+	mux.Handle("/file/", srv.withSignedURLs(http.StripPrefix("/file/", fileHandler),
+		signer))
+	signHandler := srv.withBasicAuth(NewSignHandler(srv.library, signer))
+	mux.Handle("/sign/", http.StripPrefix("/sign/", signHandler))
 	albumHandler := srv.withBasicAuth(NewAlbumHandler(srv.library))
 	mux.Handle("/album/", http.StripPrefix("/album/", albumHandler))
 	browseHandler := srv.withBasicAuth(NewBrowseHandler(srv.library))
 	mux.Handle("/browse/", http.StripPrefix("/browse/", browseHandler))
+	coverHandler := NewCoverHandler(srv.library, srv.thumbnailsDir(), srv.cfg.Auth)
+	mux.Handle("/cover/", http.StripPrefix("/cover/", srv.withBasicAuth(coverHandler)))
+	playlistsHandler := srv.withBasicAuth(NewPlaylistsHandler(srv.library))
+	mux.Handle("/playlists/", http.StripPrefix("/playlists/", playlistsHandler))
+	rescanHandler := srv.withBasicAuth(AdminHandler{NewRescanHandler(srv.library)})
+	mux.Handle("/rescan", rescanHandler)
+	exportHandler := srv.withBasicAuth(NewExportHandler(srv.library,
+		srv.linksSigner(signer)))
+	mux.Handle("/export/", http.StripPrefix("/export/", exportHandler))
+	hlsHandler := NewHLSHandler(srv.library, srv.cfg.HLS, srv.cfg.Transcoding,
+		srv.hlsSegmentsDir())
+	mux.Handle("/hls/", http.StripPrefix("/hls/", srv.withBasicAuth(hlsHandler)))
+	go hlsHandler.segments.cleanUpRoutine(srv.ctx)
+	subsonicHandler := NewSubsonicHandler(srv.library, srv.subsonicAuth(),
+		srv.throttle, fileHandler, coverHandler)
+	mux.Handle("/rest/", http.StripPrefix("/rest/", subsonicHandler))
+
+	if srv.cfg.Auth {
+		secure := srv.cfg.SSL || srv.cfg.Sessions.SecureCookie
+		mux.Handle("/login", NewLoginHandler(srv.authenticator, srv.sessions,
+			srv.throttle, secure))
+		mux.Handle("/logout", NewLogoutHandler(srv.sessions, secure))
+		tokensHandler := srv.withBasicAuth(NewTokensHandler(srv.library))
+		mux.Handle("/tokens/", http.StripPrefix("/tokens/", tokensHandler))
+	}
+
+	if srv.cfg.DLNA.Enabled {
+		mediaServer := dlna.NewMediaServer(srv.library, srv.dlnaOptions(signer))
+		mux.Handle("/dlna/", http.StripPrefix("/dlna", mediaServer))
+		go func() {
+			if err := mediaServer.Advertise(srv.ctx); err != nil {
+				log.Printf("DLNA discovery stopped: %s\n", err)
+			}
+		}()
+	}
 
 	handler := NewTerryHandler(mux)
 
@@ -110,6 +169,67 @@ func (srv *Server) serveGoroutine() {
 	srv.cancelFunc()
 }
 
+// thumbnailsDir returns the directory in which cover thumbnails are stored. It is
+// empty when there is no user path in the configuration.
+func (srv *Server) thumbnailsDir() string {
+	if srv.cfg.UserPath == "" {
+		return ""
+	}
+	return filepath.Join(srv.cfg.UserPath, "thumbnails")
+}
+
+// hlsSegmentsDir returns the directory in which HLS segments are stored. It is empty
+// when there is no user path in the configuration.
+func (srv *Server) hlsSegmentsDir() string {
+	if srv.cfg.UserPath == "" {
+		return ""
+	}
+	return filepath.Join(srv.cfg.UserPath, "hls_segments")
+}
+
+// linksSigner returns the signer for the /file/ URLs which are given to external
+// players. It is nil when authentication is disabled since then the URLs work
+// without signatures.
+func (srv *Server) linksSigner(signer *auth.URLSigner) *auth.URLSigner {
+	if !srv.cfg.Auth {
+		return nil
+	}
+	return signer
+}
+
+// dlnaOptions returns the options of the DLNA media server from the configuration.
+func (srv *Server) dlnaOptions(signer *auth.URLSigner) dlna.Options {
+	opts := dlna.Options{
+		FriendlyName: srv.cfg.DLNA.FriendlyName,
+		UUID:         srv.cfg.DLNA.UUID,
+		BasePath:     "/dlna",
+		URLSigner:    srv.linksSigner(signer),
+	}
+
+	if opts.FriendlyName == "" {
+		opts.FriendlyName = "HTTPMS"
+	}
+
+	if opts.UUID == "" {
+		opts.UUID = dlna.DefaultUUID(opts.FriendlyName)
+	}
+
+	if _, port, err := net.SplitHostPort(srv.cfg.Listen); err == nil {
+		opts.HTTPPort, _ = net.LookupPort("tcp", port)
+	}
+
+	return opts
+}
+
+// subsonicAuth returns the authenticator for Subsonic clients. It is nil when
+// authentication is disabled.
+func (srv *Server) subsonicAuth() *auth.Authenticator {
+	if !srv.cfg.Auth {
+		return nil
+	}
+	return srv.authenticator
+}
+
 func (srv *Server) withBasicAuth(handler http.Handler) http.Handler {
 	if !srv.cfg.Auth {
 		return handler
@@ -117,8 +237,24 @@ func (srv *Server) withBasicAuth(handler http.Handler) http.Handler {
 
 	return BasicAuthHandler{
 		handler,
-		srv.cfg.Authenticate.User,
-		srv.cfg.Authenticate.Password,
+		srv.authenticator,
+		srv.sessions,
+		srv.throttle,
+	}
+}
+
+// withSignedURLs wraps handler so that it requires basic authentication unless the
+// request URL is signed by signer.
+func (srv *Server) withSignedURLs(handler http.Handler,
+	signer *auth.URLSigner) http.Handler {
+	if !srv.cfg.Auth {
+		return handler
+	}
+
+	return SignedURLHandler{
+		handler,
+		srv.withBasicAuth(handler),
+		signer,
 	}
 }
 
@@ -194,14 +330,66 @@ func (srv *Server) Wait() {
 	<-srv.ctx.Done()
 }
 
+// sessionLifetime returns the lifetime of sessions from their configuration. It is
+// defaultSessionLifetime when it is not configured.
+func sessionLifetime(cfg config.Sessions) time.Duration {
+	if cfg.Lifetime <= 0 {
+		return defaultSessionLifetime
+	}
+	return cfg.Lifetime
+}
+
+// Auth holds the authentication state which is shared between the webserver and
+// the other servers of the application. Sharing it means that failed logins are
+// counted together and URLs signed by one server are accepted by the others.
+type Auth struct {
+	Authenticator *auth.Authenticator
+	Throttle      *auth.Throttle
+	URLSigner     *auth.URLSigner
+}
+
+// NewAuth returns the authentication state for the configuration cfg. The key of
+// its URL signer is kept in the user path so that signed URLs keep working after
+// restarts.
+func NewAuth(cfg config.Config, lib library.Library) Auth {
+	keyFile := ""
+	if cfg.UserPath != "" {
+		keyFile = filepath.Join(cfg.UserPath, auth.SigningKeyFile)
+	}
+
+	signer, err := auth.LoadURLSigner(keyFile)
+
+	if err != nil {
+		log.Printf("Signed URLs will not survive restarts: %s\n", err)
+		signer, _ = auth.LoadURLSigner("")
+	}
+
+	return Auth{
+		Authenticator: auth.NewAuthenticator(cfg.Authenticate, lib),
+		Throttle:      auth.NewThrottle(cfg.AuthThrottling),
+		URLSigner:     signer,
+	}
+}
+
 // NewServer Returns a new Server using the supplied configuration cfg. The returned
 // server is ready and calling its Serve method will start it.
 func NewServer(ctx context.Context, cfg config.Config, lib library.Library) *Server {
+	return NewServerWithAuth(ctx, cfg, lib, NewAuth(cfg, lib))
+}
+
+// NewServerWithAuth is like NewServer but the server uses the authentication state
+// authState instead of creating its own.
+func NewServerWithAuth(ctx context.Context, cfg config.Config, lib library.Library,
+	authState Auth) *Server {
 	ctx, cancelCtx := context.WithCancel(ctx)
 	return &Server{
-		ctx:        ctx,
-		cancelFunc: cancelCtx,
-		cfg:        cfg,
-		library:    lib,
+		ctx:           ctx,
+		cancelFunc:    cancelCtx,
+		cfg:           cfg,
+		library:       lib,
+		authenticator: authState.Authenticator,
+		sessions:      auth.NewSessions(sessionLifetime(cfg.Sessions)),
+		throttle:      authState.Throttle,
+		signer:        authState.URLSigner,
 	}
 }
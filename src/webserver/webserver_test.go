@@ -11,9 +11,13 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -95,6 +99,15 @@ func getProjectRoot() (string, error) {
 }
 
 func getLibraryServer(t *testing.T) (*Server, library.Library) {
+	return getLibraryServerWithConfig(t, func(*config.Config) {})
+}
+
+// getLibraryServerWithConfig is like getLibraryServer but the server's configuration
+// could be changed by setUp before it is started.
+func getLibraryServerWithConfig(
+	t *testing.T,
+	setUp func(*config.Config),
+) (*Server, library.Library) {
 	projRoot, _ := getProjectRoot()
 
 	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
@@ -118,6 +131,7 @@ func getLibraryServer(t *testing.T) (*Server, library.Library) {
 	var wsCfg config.Config
 	wsCfg.Listen = fmt.Sprintf("127.0.0.1:%d", TestPort)
 	wsCfg.HTTPRoot = filepath.Join(projRoot, "test_files", TestRoot)
+	setUp(&wsCfg)
 
 	srv := NewServer(context.Background(), wsCfg, lib)
 	srv.Serve()
@@ -286,7 +300,7 @@ func TestUserAuthentication(t *testing.T) {
 		t.Errorf("Expected 401 but got: %d", resp.StatusCode)
 	}
 
-	// Make sure the is not HTTP Basic Auth for getting media files
+	// Make sure media files are protected as well
 	url = fmt.Sprintf("http://127.0.0.1:%d/file/1", TestPort)
 	resp, err = http.Get(url)
 
@@ -296,8 +310,169 @@ func TestUserAuthentication(t *testing.T) {
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode == 401 {
-		t.Errorf("Media files were protected with basic authentication")
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected 401 for media files but got: %d", resp.StatusCode)
+	}
+}
+
+func TestAuthThrottling(t *testing.T) {
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{
+			User:     "testuser",
+			Password: "testpass",
+		}
+		cfg.AuthThrottling = config.Throttling{
+			Enabled:        true,
+			MaxFailures:    3,
+			BaseDelay:      time.Minute,
+			Lockout:        time.Hour,
+			ResetAfter:     2 * time.Hour,
+			TrustedProxies: []string{"127.0.0.1"},
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	testURL := func(path string) string {
+		return fmt.Sprintf("http://127.0.0.1:%d%s", TestPort, path)
+	}
+
+	do := func(req *http.Request, expectedStatus int) *http.Response {
+		t.Helper()
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected status %d for %s %s but got %d", expectedStatus,
+				req.Method, req.URL, resp.StatusCode)
+		}
+
+		return resp
+	}
+
+	search := func(user, password, forwardedFor string) *http.Request {
+		req, _ := http.NewRequest("GET", testURL("/search/?q=Payback"), nil)
+		req.SetBasicAuth(user, password)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return req
+	}
+
+	do(search("testuser", "wrong", ""), http.StatusUnauthorized)
+
+	// The IP of the client must wait before trying again even with the right
+	// password.
+	resp := do(search("testuser", "testpass", ""), http.StatusTooManyRequests)
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Expected Retry-After of 60 seconds but got %q", retryAfter)
+	}
+
+	// The user name is blocked for clients with other IPs as well. The IPs are taken
+	// from X-Forwarded-For since the requests come from a trusted proxy.
+	do(search("testuser", "testpass", "198.51.100.1"), http.StatusTooManyRequests)
+	do(search("other", "wrong", "198.51.100.1"), http.StatusUnauthorized)
+	do(search("other", "wrong", "203.0.113.1, 198.51.100.1"),
+		http.StatusTooManyRequests)
+
+	form := url.Values{}
+	form.Set("username", "testuser")
+	form.Set("password", "testpass")
+
+	req, _ := http.NewRequest("POST", testURL("/login"),
+		strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-For", "198.51.100.2")
+	do(req, http.StatusTooManyRequests)
+
+	params := url.Values{}
+	params.Set("u", "testuser")
+	params.Set("p", "testpass")
+	params.Set("v", "1.16.1")
+	params.Set("c", "test")
+	params.Set("f", "json")
+
+	_, body := subsonicGet(t, "ping", params)
+
+	if !strings.Contains(string(body), "Too many failed authentication attempts") {
+		t.Errorf("Expected Subsonic clients to be blocked too but got %s", body)
+	}
+}
+
+// TestSharedAuth makes sure that the server uses the authentication state it is
+// given so that it is shared with the other servers of the application.
+func TestSharedAuth(t *testing.T) {
+	lib, err := library.NewLocalLibrary(context.TODO(), library.SQLiteMemoryFile)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lib.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer lib.Truncate()
+
+	var wsCfg config.Config
+	wsCfg.Listen = fmt.Sprintf("127.0.0.1:%d", TestPort)
+	wsCfg.Auth = true
+	wsCfg.Authenticate = config.Auth{
+		User:     "testuser",
+		Password: "testpass",
+	}
+	wsCfg.AuthThrottling = config.Throttling{
+		Enabled:     true,
+		MaxFailures: 3,
+		BaseDelay:   time.Minute,
+		Lockout:     time.Hour,
+		ResetAfter:  2 * time.Hour,
+	}
+
+	authState := NewAuth(wsCfg, lib)
+
+	srv := NewServerWithAuth(context.Background(), wsCfg, lib, authState)
+	srv.Serve()
+	defer tearDownServer(srv)
+
+	// A URL signed by another server must be accepted without credentials.
+	signed := authState.URLSigner.Sign("/file/1", time.Now().Add(time.Hour))
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", TestPort, signed))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		t.Errorf("Expected the signed URL to be accepted but got %d", resp.StatusCode)
+	}
+
+	// A failure seen by another server must slow down the client here too.
+	authState.Throttle.Failed("127.0.0.1", "testuser")
+
+	req, _ := http.NewRequest("GET",
+		fmt.Sprintf("http://127.0.0.1:%d/search/?q=Payback", TestPort), nil)
+	req.SetBasicAuth("testuser", "testpass")
+
+	resp, err = http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d but got %d", http.StatusTooManyRequests,
+			resp.StatusCode)
 	}
 }
 
@@ -417,12 +592,188 @@ func TestSearchUrl(t *testing.T) {
 	}
 }
 
+func TestSearchQueryLanguage(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	tests := []struct {
+		query   string
+		status  int
+		results int
+	}{
+		{`album:"Album Of Tests"`, http.StatusOK, 2},
+		{`album:"Album Of Tests" -artist:testoff`, http.StatusOK, 0},
+		{`artist:Buggy title:payback`, http.StatusOK, 1},
+		{`album:"Album Of Tests`, http.StatusBadRequest, 0},
+		{`year:199x`, http.StatusBadRequest, 0},
+		{`year:1999..1990`, http.StatusBadRequest, 0},
+		{`artist:`, http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		searchURL := fmt.Sprintf("http://127.0.0.1:%d/search/?q=%s", TestPort,
+			url.QueryEscape(test.query))
+
+		resp, err := http.Get(searchURL)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		responseBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != test.status {
+			t.Errorf("Expected status %d for `%s` but it was %d", test.status,
+				test.query, resp.StatusCode)
+			continue
+		}
+
+		if test.status == http.StatusBadRequest {
+			var errResponse struct {
+				Error string `json:"error"`
+			}
+
+			if err := json.Unmarshal(responseBody, &errResponse); err != nil {
+				t.Errorf("Error response for `%s` was not JSON: %s", test.query, err)
+			} else if errResponse.Error == "" {
+				t.Errorf("Error response for `%s` had no error message", test.query)
+			}
+
+			continue
+		}
+
+		var results []library.SearchResult
+
+		if err := json.Unmarshal(responseBody, &results); err != nil {
+			t.Error(err)
+		}
+
+		if len(results) != test.results {
+			t.Errorf("Expected %d results for `%s` but they were %d", test.results,
+				test.query, len(results))
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	type searchPage struct {
+		Data       []library.SearchResult `json:"data"`
+		Next       string                 `json:"next"`
+		Previous   string                 `json:"previous"`
+		PagesCount int                    `json:"pages_count"`
+	}
+
+	getPage := func(uri string) (searchPage, int) {
+		var page searchPage
+
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", TestPort, uri))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return page, resp.StatusCode
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("Decoding search page %s: %s", uri, err)
+		}
+
+		return page, resp.StatusCode
+	}
+
+	first, status := getPage("/search/?q=&per-page=2")
+
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected response status code: %d", status)
+	}
+
+	if len(first.Data) != 2 || first.PagesCount != 2 {
+		t.Errorf("Expected 2 results and 2 pages but got %d results and %d pages",
+			len(first.Data), first.PagesCount)
+	}
+
+	if first.Previous != "" {
+		t.Errorf("Expected no previous page but it was %s", first.Previous)
+	}
+
+	expectedNext := "/search/?q=&page=2&per-page=2"
+
+	if first.Next != expectedNext {
+		t.Fatalf("Expected next page to be %s but it was %s", expectedNext, first.Next)
+	}
+
+	second, status := getPage(first.Next)
+
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected response status code: %d", status)
+	}
+
+	if len(second.Data) != 1 || second.Next != "" {
+		t.Errorf("Expected one result and no next page but got %d results and `%s`",
+			len(second.Data), second.Next)
+	}
+
+	if second.Previous != "/search/?q=&page=1&per-page=2" {
+		t.Errorf("Wrong previous page: %s", second.Previous)
+	}
+
+	for _, result := range append(first.Data, second.Data...) {
+		if result.ID == 0 {
+			t.Errorf("Search result without an ID: %+v", result)
+		}
+	}
+
+	empty, _ := getPage("/search/?q=Not+There&page=1")
+
+	if empty.Data == nil || len(empty.Data) != 0 || empty.PagesCount != 0 {
+		t.Errorf("Expected empty data list and no pages but got %+v", empty)
+	}
+
+	capped, status := getPage(fmt.Sprintf("/search/?q=&page=2&per-page=%d",
+		maxPerPage+1))
+
+	if status != http.StatusOK {
+		t.Fatalf("Unexpected response status code: %d", status)
+	}
+
+	expectedPrevious := fmt.Sprintf("/search/?q=&page=1&per-page=%d", maxPerPage)
+
+	if capped.Previous != expectedPrevious {
+		t.Errorf("Expected per-page to be capped with previous page %s but it was %s",
+			expectedPrevious, capped.Previous)
+	}
+
+	for _, uri := range []string{
+		"/search/?q=&page=0",
+		"/search/?q=&per-page=-1",
+		"/search/?q=&page=two",
+	} {
+		if _, status := getPage(uri); status != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s but it was %d", uri, status)
+		}
+	}
+}
+
 func TestGetFileUrl(t *testing.T) {
 	srv, lib := getLibraryServer(t)
 	defer lib.Truncate()
 	defer tearDownServer(srv)
 
-	found := lib.Search("Buggy Bugoff")
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
 
 	if len(found) != 1 {
 		t.Fatalf("Problem finding Buggy Bugoff test track")
@@ -550,7 +901,7 @@ func TestFileNameHeaders(t *testing.T) {
 	defer lib.Truncate()
 	defer tearDownServer(srv)
 
-	found := lib.Search("Buggy Bugoff")
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
 
 	if len(found) != 1 {
 		t.Fatalf("Problem finding Buggy Bugoff test track")
@@ -580,58 +931,702 @@ func TestFileNameHeaders(t *testing.T) {
 	}
 }
 
-func TestAlbumHandlerOverHttp(t *testing.T) {
-	srv, lib := getLibraryServer(t)
+func TestFileTranscoding(t *testing.T) {
+	shellPath, err := exec.LookPath("sh")
+
+	if err != nil {
+		t.Skipf("A shell is needed for running the stub encoder: %s", err)
+	}
+
+	projRoot, _ := getProjectRoot()
+	stubEncoder := filepath.Join(projRoot, "test_files", "transcoder", "stub_encoder.sh")
+
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Transcoding = config.Transcoding{
+			"ogg": {
+				Command:        []string{shellPath, stubEncoder, "{bitrate}", "{input}"},
+				ContentType:    "audio/ogg",
+				DefaultBitrate: 160,
+			},
+			"mp3": {
+				Command:        []string{shellPath, stubEncoder, "{bitrate}", "{input}"},
+				ContentType:    "audio/mpeg",
+				DefaultBitrate: 192,
+			},
+			"opus": {
+				Command:        []string{"httpms-no-such-encoder", "{input}"},
+				ContentType:    "audio/ogg",
+				DefaultBitrate: 96,
+			},
+			"wav": {
+				Command:        []string{shellPath, "-c", "echo broken >&2; exit 1"},
+				ContentType:    "audio/wav",
+				DefaultBitrate: 1411,
+			},
+		}
+	})
 	defer lib.Truncate()
 	defer tearDownServer(srv)
 
-	albumPaths, err := lib.(*library.LocalLibrary).GetAlbumFSPathByName("Album Of Tests")
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
+
+	if len(found) != 1 {
+		t.Fatalf("Problem finding Buggy Bugoff test track")
+	}
+
+	original, err := ioutil.ReadFile(
+		filepath.Join(projRoot, "test_files", "library", "folder_one", "third_file.mp3"),
+	)
 
 	if err != nil {
-		t.Fatalf("Cannot get album path: %s", err)
+		t.Fatal(err)
 	}
 
-	albumID, _ := lib.(*library.LocalLibrary).GetAlbumID("Album Of Tests", albumPaths[0])
+	tests := []struct {
+		query       string
+		status      int
+		transcoded  bool
+		bitrate     int
+		contentType string
+		fileName    string
+	}{
+		{"", http.StatusOK, false, 0, "", "third_file.mp3"},
+		{"?format=ogg", http.StatusOK, true, 160, "audio/ogg", "third_file.ogg"},
+		{"?format=OGG&bitrate=64", http.StatusOK, true, 64, "audio/ogg",
+			"third_file.ogg"},
+		{"?bitrate=128", http.StatusOK, true, 128, "audio/mpeg", "third_file.mp3"},
+		{"?format=mp3", http.StatusOK, false, 0, "", "third_file.mp3"},
+		{"?format=flac", http.StatusOK, false, 0, "", "third_file.mp3"},
+		{"?format=opus", http.StatusOK, false, 0, "", "third_file.mp3"},
+		{"?format=wav", http.StatusInternalServerError, false, 0, "", ""},
+		{"?format=ogg&bitrate=fast", http.StatusBadRequest, false, 0, "", ""},
+		{"?format=ogg&bitrate=0", http.StatusBadRequest, false, 0, "", ""},
+		{"?format=ogg&bitrate=100000", http.StatusBadRequest, false, 0, "", ""},
+	}
 
-	albumURL := fmt.Sprintf("http://127.0.0.1:%d/album/%d", TestPort, albumID)
+	for _, test := range tests {
+		url := fmt.Sprintf("http://127.0.0.1:%d/file/%d%s", TestPort, found[0].ID,
+			test.query)
 
-	resp, err := http.Get(albumURL)
+		resp, err := http.Get(url)
 
-	if err != nil {
-		t.Fatal(err)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != test.status {
+			t.Errorf("Expected status %d for `%s` but got %d", test.status, test.query,
+				resp.StatusCode)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		expectedName := fmt.Sprintf("filename=\"%s\"", test.fileName)
+		if nameHeader := resp.Header.Get("Content-Disposition"); nameHeader != expectedName {
+			t.Errorf("Expected `%s` for `%s` but found `%s`", expectedName, test.query,
+				nameHeader)
+		}
+
+		if !test.transcoded {
+			if !bytes.Equal(body, original) {
+				t.Errorf("Expected the original file for `%s`", test.query)
+			}
+			continue
+		}
+
+		expected := append([]byte(fmt.Sprintf("bitrate: %d\n", test.bitrate)),
+			original...)
+
+		if !bytes.Equal(body, expected) {
+			t.Errorf("Wrong transcoded file for `%s`. It was %d bytes instead of %d",
+				test.query, len(body), len(expected))
+		}
+
+		if contentType := resp.Header.Get("Content-Type"); contentType != test.contentType {
+			t.Errorf("Expected content type %s for `%s` but it was %s",
+				test.contentType, test.query, contentType)
+		}
+
+		if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("Expected chunked transfer encoding for `%s` but it was %v",
+				test.query, resp.TransferEncoding)
+		}
 	}
+}
 
-	defer resp.Body.Close()
+// Encoders must be stopped when the client goes away in the middle of the stream.
+// Otherwise they would be stuck writing to their full output pipe forever.
+func TestFileTranscodingClientDisconnect(t *testing.T) {
+	shellPath, err := exec.LookPath("sh")
 
-	if resp.StatusCode != 200 {
-		t.Errorf("Unexpected response status code: %d", resp.StatusCode)
+	if err != nil {
+		t.Skipf("A shell is needed for running the stub encoder: %s", err)
 	}
 
-	headers := map[string]string{
-		"Content-Type":        "application/zip",
-		"Content-Disposition": `filename="Album Of Tests.zip"`,
+	pidDir, err := ioutil.TempDir("", "httpms_encoder_pid_")
+
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for header := range headers {
-		expected := headers[header]
-		found := resp.Header.Get(header)
-		if found != expected {
-			t.Errorf("Expected %s: %s but it was `%s`", header, expected, found)
+	defer os.RemoveAll(pidDir)
+
+	pidFile := filepath.Join(pidDir, "encoder.pid")
+
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Transcoding = config.Transcoding{
+			"ogg": {
+				// Never ends on its own.
+				Command: []string{shellPath, "-c",
+					fmt.Sprintf(`echo $$ > "%s"; exec yes`, pidFile)},
+				ContentType:    "audio/ogg",
+				DefaultBitrate: 160,
+			},
 		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
+
+	if len(found) != 1 {
+		t.Fatalf("Problem finding Buggy Bugoff test track")
 	}
 
-	albumURL = fmt.Sprintf("http://127.0.0.1:%d/album/666", TestPort)
+	url := fmt.Sprintf("http://127.0.0.1:%d/file/%d?format=ogg", TestPort,
+		found[0].ID)
 
-	resp, err = http.Get(albumURL)
+	resp, err := http.Get(url)
 
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	defer resp.Body.Close()
+	if _, err := io.ReadFull(resp.Body, make([]byte, 1024)); err != nil {
+		t.Fatalf("Reading the start of the stream: %s", err)
+	}
 
-	if resp.StatusCode != 404 {
-		t.Errorf("Unexpected response status code: %d", resp.StatusCode)
+	resp.Body.Close()
+
+	pidData, err := ioutil.ReadFile(pidFile)
+
+	if err != nil {
+		t.Fatalf("Reading the encoder PID: %s", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidData)))
+
+	if err != nil {
+		t.Fatalf("Wrong encoder PID %q: %s", pidData, err)
+	}
+
+	encoder, err := os.FindProcess(pid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for encoder.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			encoder.Kill()
+			t.Fatalf("Encoder was still running after the client went away")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestHLSStreaming(t *testing.T) {
+	shellPath, err := exec.LookPath("sh")
+
+	if err != nil {
+		t.Skipf("A shell is needed for running the stub encoder: %s", err)
+	}
+
+	userPath, err := ioutil.TempDir("", "httpms_hls_test_")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(userPath)
+
+	projRoot, _ := getProjectRoot()
+	stubEncoder := filepath.Join(projRoot, "test_files", "transcoder", "stub_encoder.sh")
+
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.UserPath = userPath
+		cfg.Transcoding = config.Transcoding{
+			"hls": {
+				Command: []string{shellPath, stubEncoder,
+					"{bitrate}/{start}/{duration}", "{input}"},
+				ContentType:    "video/mp2t",
+				DefaultBitrate: 128,
+			},
+		}
+		cfg.HLS = config.HLS{
+			Profile:         "hls",
+			SegmentDuration: 500 * time.Millisecond,
+			CacheMaxAge:     time.Hour,
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
+
+	if len(found) != 1 {
+		t.Fatalf("Problem finding Buggy Bugoff test track")
+	}
+
+	track := found[0]
+
+	if track.Duration <= 0 {
+		t.Fatalf("Buggy Bugoff test track had no duration")
+	}
+
+	original, err := ioutil.ReadFile(
+		filepath.Join(projRoot, "test_files", "library", "folder_one", "third_file.mp3"),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hlsURL := func(file string) string {
+		return fmt.Sprintf("http://127.0.0.1:%d/hls/%d/%s", TestPort, track.ID, file)
+	}
+
+	getBody := func(url string, expectedStatus int) (*http.Response, []byte) {
+		resp, err := http.Get(url)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != expectedStatus {
+			t.Fatalf("Expected status %d for %s but got %d", expectedStatus, url,
+				resp.StatusCode)
+		}
+
+		return resp, body
+	}
+
+	resp, playlist := getBody(hlsURL("index.m3u8?bitrate=64"), http.StatusOK)
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/vnd.apple.mpegurl" {
+		t.Errorf("Wrong playlist content type: %s", contentType)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(playlist)), "\n")
+
+	if lines[0] != "#EXTM3U" || lines[len(lines)-1] != "#EXT-X-ENDLIST" {
+		t.Fatalf("Malformed playlist:\n%s", playlist)
+	}
+
+	var (
+		segments      []string
+		totalDuration float64
+	)
+
+	for ind, line := range lines {
+		if !strings.HasPrefix(line, "#EXTINF:") {
+			continue
+		}
+
+		var duration float64
+		fmt.Sscanf(strings.TrimPrefix(line, "#EXTINF:"), "%f,", &duration)
+
+		if duration <= 0 || duration > 0.5 {
+			t.Errorf("Wrong segment duration in `%s`", line)
+		}
+
+		totalDuration += duration
+		segments = append(segments, lines[ind+1])
+	}
+
+	expectedSegments := int((track.Duration + 499) / 500)
+
+	if len(segments) != expectedSegments {
+		t.Fatalf("Expected %d segments but there were %d", expectedSegments,
+			len(segments))
+	}
+
+	if int64(totalDuration*1000+0.5) != track.Duration {
+		t.Errorf("Segments were %fs long in total but the track is %dms",
+			totalDuration, track.Duration)
+	}
+
+	if segments[1] != "segment-1.ts?bitrate=64" {
+		t.Errorf("Wrong second segment URI: %s", segments[1])
+	}
+
+	resp, segment := getBody(hlsURL(segments[1]), http.StatusOK)
+
+	expected := append([]byte("bitrate: 64/0.500/0.500\n"), original...)
+
+	if !bytes.Equal(segment, expected) {
+		t.Errorf("Wrong segment. It was %d bytes instead of %d", len(segment),
+			len(expected))
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "video/mp2t" {
+		t.Errorf("Wrong segment content type: %s", contentType)
+	}
+
+	_, segment = getBody(hlsURL("segment-0.ts"), http.StatusOK)
+
+	if !bytes.HasPrefix(segment, []byte("bitrate: 128/0.000/0.500\n")) {
+		t.Errorf("Segment without bitrate did not use the default one")
+	}
+
+	cached, _ := filepath.Glob(filepath.Join(userPath, "hls_segments", "*.ts"))
+
+	if len(cached) != 2 {
+		t.Errorf("Expected two cached segments but found %d", len(cached))
+	}
+
+	// The cached segment is served without running the encoder again.
+	if err := ioutil.WriteFile(cached[0], []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, first := getBody(hlsURL("segment-0.ts"), http.StatusOK)
+	_, second := getBody(hlsURL("segment-1.ts?bitrate=64"), http.StatusOK)
+
+	if string(first) != "cached" && string(second) != "cached" {
+		t.Errorf("Cached segment was not used")
+	}
+
+	getBody(hlsURL(fmt.Sprintf("segment-%d.ts", len(segments))), http.StatusNotFound)
+	getBody(hlsURL("segment-x.ts"), http.StatusNotFound)
+	getBody(hlsURL("index.m3u8?bitrate=fast"), http.StatusBadRequest)
+	getBody(fmt.Sprintf("http://127.0.0.1:%d/hls/666/index.m3u8", TestPort),
+		http.StatusNotFound)
+}
+
+func TestHLSIsDisabledWithoutProfile(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Buggy Bugoff"})
+
+	if len(found) != 1 {
+		t.Fatalf("Problem finding Buggy Bugoff test track")
+	}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/hls/%d/index.m3u8", TestPort, found[0].ID)
+	resp, err := http.Get(url)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for disabled HLS but got %d", resp.StatusCode)
+	}
+}
+
+func TestDLNAMediaServer(t *testing.T) {
+	descURL := fmt.Sprintf("http://127.0.0.1:%d/dlna/device.xml", TestPort)
+
+	srv, lib := getLibraryServer(t)
+	resp, err := http.Get(descURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+	lib.Truncate()
+	tearDownServer(srv)
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for disabled DLNA but got %d", resp.StatusCode)
+	}
+
+	srv, lib = getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.DLNA = config.DLNA{Enabled: true, FriendlyName: "Test Server"}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	resp, err = http.Get(descURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for the DLNA device description but got %d",
+			resp.StatusCode)
+	}
+
+	if !strings.Contains(string(body), "<friendlyName>Test Server</friendlyName>") {
+		t.Errorf("Friendly name not found in device description: %s", body)
+	}
+}
+
+func TestAlbumHandlerOverHttp(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	albumPaths, err := lib.(*library.LocalLibrary).GetAlbumFSPathByName("Album Of Tests")
+
+	if err != nil {
+		t.Fatalf("Cannot get album path: %s", err)
+	}
+
+	albumID, _ := lib.(*library.LocalLibrary).GetAlbumID("Album Of Tests", albumPaths[0])
+
+	albumURL := fmt.Sprintf("http://127.0.0.1:%d/album/%d", TestPort, albumID)
+
+	resp, err := http.Get(albumURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Unexpected response status code: %d", resp.StatusCode)
+	}
+
+	headers := map[string]string{
+		"Content-Type":        "application/zip",
+		"Content-Disposition": `filename="Album Of Tests.zip"`,
+	}
+
+	for header := range headers {
+		expected := headers[header]
+		found := resp.Header.Get(header)
+		if found != expected {
+			t.Errorf("Expected %s: %s but it was `%s`", header, expected, found)
+		}
+	}
+
+	albumURL = fmt.Sprintf("http://127.0.0.1:%d/album/666", TestPort)
+
+	resp, err = http.Get(albumURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 404 {
+		t.Errorf("Unexpected response status code: %d", resp.StatusCode)
+	}
+}
+
+func TestCoverHandler(t *testing.T) {
+	srv, lib := getLibraryServer(t)
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	projRoot, _ := getProjectRoot()
+	expectedCover, err := ioutil.ReadFile(
+		filepath.Join(projRoot, "test_files", "library", "folder_one", "cover.png"),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Return Of The Bugs"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one track in Return Of The Bugs but found %d", len(found))
+	}
+
+	coverURL := fmt.Sprintf("http://127.0.0.1:%d/cover/%d", TestPort, found[0].AlbumID)
+	resp, err := http.Get(coverURL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response status code: %d", resp.StatusCode)
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(responseBody, expectedCover) {
+		t.Errorf("The returned cover was different from the image file")
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("Wrong content-type: %s", contentType)
+	}
+
+	for _, header := range []string{"Cache-Control", "ETag", "Last-Modified"} {
+		if resp.Header.Get(header) == "" {
+			t.Errorf("Header %s was missing", header)
+		}
+	}
+
+	if cacheControl := resp.Header.Get("Cache-Control"); !strings.HasPrefix(
+		cacheControl, "public,") {
+		t.Errorf("Expected public Cache-Control without auth but got %q",
+			cacheControl)
+	}
+
+	req, err := http.NewRequest("GET", coverURL, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	cachedResp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cachedResp.Body.Close()
+
+	if cachedResp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status 304 for cached cover but it was %d",
+			cachedResp.StatusCode)
+	}
+
+	// The cover is smaller than the requested size so it is returned as is.
+	resp, err = http.Get(coverURL + "?size=100")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responseBody, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(responseBody, expectedCover) {
+		t.Errorf("Wrong cover thumbnail response. Status: %d", resp.StatusCode)
+	}
+
+	for _, size := range []string{"0", "-5", "big", "100000"} {
+		resp, err := http.Get(coverURL + "?size=" + size)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for size %s but it was %d", size,
+				resp.StatusCode)
+		}
+	}
+
+	found, _ = lib.Search(library.SearchArgs{Query: "Album Of Tests"})
+
+	if len(found) < 1 {
+		t.Fatalf("Tracks of Album Of Tests were not found")
+	}
+
+	for _, uri := range []string{
+		fmt.Sprintf("/cover/%d", found[0].AlbumID),
+		"/cover/666",
+		"/cover/not-a-number",
+	} {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", TestPort, uri))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s but it was %d", uri, resp.StatusCode)
+		}
+	}
+}
+
+// TestCoverHandlerPrivateCache makes sure that shared caches are not allowed to
+// store covers which require authentication.
+func TestCoverHandlerPrivateCache(t *testing.T) {
+	srv, lib := getLibraryServerWithConfig(t, func(cfg *config.Config) {
+		cfg.Auth = true
+		cfg.Authenticate = config.Auth{
+			User:     "testuser",
+			Password: "testpass",
+		}
+	})
+	defer lib.Truncate()
+	defer tearDownServer(srv)
+
+	found, _ := lib.Search(library.SearchArgs{Query: "Return Of The Bugs"})
+
+	if len(found) != 1 {
+		t.Fatalf("Expected one track in Return Of The Bugs but found %d", len(found))
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/cover/%d",
+		TestPort, found[0].AlbumID), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.SetBasicAuth("testuser", "testpass")
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response status code: %d", resp.StatusCode)
+	}
+
+	expected := fmt.Sprintf("private, max-age=%d", coverMaxAge)
+
+	if cacheControl := resp.Header.Get("Cache-Control"); cacheControl != expected {
+		t.Errorf("Expected Cache-Control %q but got %q", expected, cacheControl)
 	}
 }
 
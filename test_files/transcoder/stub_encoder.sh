@@ -0,0 +1,13 @@
+#!/bin/sh
+# Stands in for a real encoder in the tests. Writes the requested bitrate followed by
+# the contents of the input file to its standard output.
+#
+# Usage: stub_encoder.sh {bitrate} {input}
+
+if [ ! -f "$2" ]; then
+    echo "input file $2 not found" >&2
+    exit 1
+fi
+
+echo "bitrate: $1"
+cat "$2"